@@ -0,0 +1,283 @@
+package lllcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Batch compile request object.
+// All scripts in a batch are of the same language and share
+// one deduplicated set of includes, which is uploaded only once
+type BatchRequest struct {
+	Language string            `json:"language"`
+	Scripts  []*BatchScript    `json:"scripts"`
+	Includes map[string][]byte `json:"includes"` // hash => source code file bytes
+}
+
+// A single entry point in a batch request
+type BatchScript struct {
+	Name   string `json:"name"`
+	Script []byte `json:"script"` // source code file bytes
+}
+
+// Batch compile response object.
+// The server streams one of these back per script, as each finishes
+type BatchResponse struct {
+	Name     string `json:"name"`
+	Bytecode []byte `json:"bytecode"`
+	ABI      string `json:"abi"` // json encoded
+	Error    string `json:"error"`
+}
+
+// New BatchRequest object for a language and map of include files
+func NewBatchRequest(lang string, includes map[string][]byte) *BatchRequest {
+	if includes == nil {
+		includes = make(map[string][]byte)
+	}
+	return &BatchRequest{
+		Language: lang,
+		Scripts:  []*BatchScript{},
+		Includes: includes,
+	}
+}
+
+// Add a script to the batch
+func (b *BatchRequest) AddScript(name string, script []byte) {
+	b.Scripts = append(b.Scripts, &BatchScript{Name: name, Script: script})
+}
+
+// New batch response object from a name and a normal response
+func NewBatchResponse(name string, resp *Response) *BatchResponse {
+	return &BatchResponse{
+		Name:     name,
+		Bytecode: resp.Bytecode,
+		ABI:      resp.ABI,
+		Error:    resp.Error,
+	}
+}
+
+// The batch endpoint lives under the language's compile url
+func batchURL(url string) string {
+	return strings.TrimSuffix(url, "/") + "/batch"
+}
+
+// send a batch request and call f on each response as it streams in
+func requestBatchResponse(req *BatchRequest, f func(*BatchResponse)) error {
+	URL := batchURL(Languages[req.Language].URL)
+	logger.Infoln("lang/url for batch request:", req.Language, URL)
+	reqJ, err := json.Marshal(req)
+	if err != nil {
+		logger.Errorln("failed to marshal batch req obj", err)
+		return err
+	}
+	httpreq, err := http.NewRequest("POST", URL, bytes.NewBuffer(reqJ))
+	if err != nil {
+		logger.Errorln("failed to compose request:", err)
+		return err
+	}
+	httpreq.Header.Set("Content-Type", "application/json")
+	setRequestToken(httpreq, Languages[req.Language].Token)
+
+	client := &http.Client{}
+	resp, err := client.Do(httpreq)
+	if err != nil {
+		logger.Errorln("failed to send HTTP request", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 300 {
+		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+
+	// responses are a stream of json objects
+	dec := json.NewDecoder(resp.Body)
+	for {
+		respJ := new(BatchResponse)
+		if err := dec.Decode(respJ); err == io.EOF {
+			return nil
+		} else if err != nil {
+			logger.Errorln("failed to unmarshal", err)
+			return err
+		}
+		f(respJ)
+	}
+}
+
+// compile every script in the batch, calling f after each
+// used by the server and locally to mimic the server
+func compileBatchCore(req *BatchRequest, f func(*BatchResponse)) {
+	for _, s := range req.Scripts {
+		r := compileServerCore(NewRequest(s.Script, req.Includes, req.Language))
+		f(NewBatchResponse(s.Name, r))
+	}
+}
+
+// Http handler for batch requests.
+// Scripts are compiled in order and each result is flushed to
+// the client as soon as it's ready
+func BatchCompileHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	req := new(BatchRequest)
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		logger.Errorln("err on json unmarshal of batch request", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, ok := Languages[req.Language]; !ok {
+		http.Error(w, UnknownLang(req.Language).Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scripts) > MaxBatchScripts {
+		http.Error(w, fmt.Sprintf("too many scripts in batch (max %d)", MaxBatchScripts), http.StatusRequestEntityTooLarge)
+		return
+	}
+	// every script counts against the rate limits
+	if len(req.Scripts) > 1 && !Auth.Charge(r, len(req.Scripts)-1) {
+		http.Error(w, "Too many requests", statusTooManyRequests)
+		return
+	}
+
+	for _, s := range req.Scripts {
+		Auth.Audit(r, req.Language, s.Script)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	flusher, canFlush := w.(http.Flusher)
+	compileBatchCore(req, func(resp *BatchResponse) {
+		if err := enc.Encode(resp); err != nil {
+			logger.Errorln("failed to write batch response", err)
+			return
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+	})
+}
+
+// a file queued for compilation in CompileMany
+type batchEntry struct {
+	filename string
+	hash     string
+}
+
+// Compile many files, resolving their includes into one shared set
+// per language. Files with a full cache hit are returned straight away,
+// the rest are sent in a single batch request per language.
+// Responses are delivered on the returned channel as they finish,
+// and the channel is closed once every file has a response
+func CompileMany(filenames []string) (chan *BatchResponse, error) {
+	batches := make(map[string]*BatchRequest)
+	entries := make(map[string]map[string]*batchEntry) // lang => name => entry
+	clients := make(map[string]*CompileClient)
+	includeNames := make(map[string]map[string]string)
+	cached := []*BatchResponse{}
+
+	for _, filename := range filenames {
+		lang, err := LangFromFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := clients[lang]
+		if !ok {
+			if c, err = NewCompileClient(lang); err != nil {
+				return nil, err
+			}
+			clients[lang] = c
+			batches[lang] = NewBatchRequest(lang, nil)
+			entries[lang] = make(map[string]*batchEntry)
+			includeNames[lang] = make(map[string]string)
+		}
+		if _, ok := entries[lang][filename]; ok {
+			continue
+		}
+
+		code, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		code, err = c.replaceIncludes(code, path.Dir(filename), batches[lang].Includes, includeNames[lang])
+		if err != nil {
+			return nil, err
+		}
+
+		hash, isCached := c.checkCached(code, batches[lang].Includes)
+		if isCached {
+			if r, err := c.cachedResponse(hash); err == nil {
+				cached = append(cached, NewBatchResponse(filename, r))
+				entries[lang][filename] = nil
+				continue
+			}
+		}
+		entries[lang][filename] = &batchEntry{filename, hash}
+		batches[lang].AddScript(filename, code)
+	}
+
+	ch := make(chan *BatchResponse, len(filenames))
+	go func() {
+		defer close(ch)
+		for _, r := range cached {
+			ch <- r
+		}
+		for lang, req := range batches {
+			if len(req.Scripts) == 0 {
+				continue
+			}
+			c := clients[lang]
+			done := make(map[string]bool)
+			f := func(r *BatchResponse) {
+				if e, ok := entries[lang][r.Name]; ok && e != nil {
+					if err := c.cacheFile(r.Bytecode, e.hash); err != nil {
+						logger.Errorln("failed to cache", r.Name, err)
+					}
+					if err := c.cacheFile([]byte(r.ABI), e.hash+"-abi"); err != nil {
+						logger.Errorln("failed to cache abi", r.Name, err)
+					}
+				}
+				done[r.Name] = true
+				ch <- r
+			}
+
+			var err error
+			if c.config.Net {
+				logger.Warnln("compiling batch remotely...", batchURL(c.config.URL))
+				// the server takes at most MaxBatchScripts at a time
+				for i := 0; i < len(req.Scripts) && err == nil; i += MaxBatchScripts {
+					end := i + MaxBatchScripts
+					if end > len(req.Scripts) {
+						end = len(req.Scripts)
+					}
+					err = requestBatchResponse(&BatchRequest{req.Language, req.Scripts[i:end], req.Includes}, f)
+				}
+			} else {
+				logger.Warnln("compiling batch locally...")
+				compileBatchCore(req, f)
+			}
+
+			// make sure everything we asked for gets an answer
+			for _, s := range req.Scripts {
+				if done[s.Name] {
+					continue
+				}
+				if err == nil {
+					err = fmt.Errorf("No response from server")
+				}
+				ch <- NewBatchResponse(s.Name, NewResponse(nil, "", err))
+			}
+		}
+	}()
+	return ch, nil
+}
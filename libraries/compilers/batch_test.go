@@ -0,0 +1,132 @@
+package lllcserver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+// a fake language whose "compiler" just echoes hex
+func init() {
+	Languages["hex"] = LangConfig{
+		Extensions: []string{"hex"},
+		CompileCmd: []string{"cat", "_"},
+	}
+}
+
+var batchScripts = map[string]string{
+	"a.hex": "6000",
+	"b.hex": "60016002",
+	"c.hex": "600360046005",
+}
+
+func writeBatchScripts(t *testing.T) (string, []string) {
+	dir, err := ioutil.TempDir("", "lllc-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for name, code := range batchScripts {
+		f := path.Join(dir, name)
+		if err := ioutil.WriteFile(f, []byte(code), 0600); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	return dir, files
+}
+
+func testCompileMany(t *testing.T) {
+	ClearCaches()
+	dir, files := writeBatchScripts(t)
+	defer os.RemoveAll(dir)
+
+	ch, err := CompileMany(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := 0
+	for r := range ch {
+		if r.Error != "" {
+			t.Fatal(r.Name, r.Error)
+		}
+		expected := batchScripts[path.Base(r.Name)]
+		if hex.EncodeToString(r.Bytecode) != expected {
+			t.Fatalf("%s: got %x, expected %s", r.Name, r.Bytecode, expected)
+		}
+		got += 1
+	}
+	if got != len(files) {
+		t.Fatalf("got %d responses, expected %d", got, len(files))
+	}
+
+	// everything should now come out of the client cache
+	for _, f := range files {
+		b, _, err := Compile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != batchScripts[path.Base(f)] {
+			t.Fatalf("%s: cached result %x is wrong", f, b)
+		}
+	}
+}
+
+func TestCompileManyLocal(t *testing.T) {
+	SetLanguageNet("hex", false)
+	testCompileMany(t)
+}
+
+func TestCompileManyRemote(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compile/batch", BatchCompileHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	SetLanguageURL("hex", ts.URL+"/compile")
+	SetLanguageNet("hex", true)
+	testCompileMany(t)
+}
+
+// batches are capped, and each script counts against the rate limits
+func TestBatchLimits(t *testing.T) {
+	defer func(a *AuthConfig, max int) { Auth, MaxBatchScripts = a, max }(Auth, MaxBatchScripts)
+	Auth = &AuthConfig{IPRate: 4}
+	if err := Auth.Init(); err != nil {
+		t.Fatal(err)
+	}
+	MaxBatchScripts = 3
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		Auth.Handler(rec, r)
+		if rec.Code != http.StatusOK {
+			w.WriteHeader(rec.Code)
+			return
+		}
+		BatchCompileHandler(w, r)
+	}))
+	defer ts.Close()
+
+	batch := func(n int) int {
+		req := NewBatchRequest("hex", nil)
+		for i := 0; i < n; i++ {
+			req.AddScript("a.hex", []byte("6000"))
+		}
+		b, _ := json.Marshal(req)
+		return authPost(t, ts.URL, "", b)
+	}
+	if code := batch(4); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a batch over the cap to be refused, got %d", code)
+	}
+	if code := batch(3); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	// the refused batch and the three scripts used up the ip's 4 requests
+	if code := batch(1); code != statusTooManyRequests {
+		t.Fatalf("expected rate limit, got %d", code)
+	}
+}
@@ -37,17 +37,24 @@ func main() {
 		internalFlag,
 		logFlag,
 		hostFlag,
+		tokensFlag,
+		tokenRateFlag,
+		ipRateFlag,
+		maxBodyFlag,
+		maxBatchFlag,
+		auditLogFlag,
 	}
 
 	app.Commands = []cli.Command{
 		cli.Command{
 			Name:   "compile",
-			Usage:  "compile a contract",
+			Usage:  "compile a contract (or many, in one batch)",
 			Action: cliClient,
 			Flags: []cli.Flag{
 				hostFlag,
 				localFlag,
 				langFlag,
+				tokenFlag,
 				//logFlag,
 			},
 		},
@@ -89,10 +96,36 @@ func cliClient(c *cli.Context) {
 	}
 	logger.Debugln("language config:", lllcserver.Languages[lang])
 
+	if token := c.String("token"); token != "" {
+		// batches can span languages
+		for l := range lllcserver.Languages {
+			lllcserver.SetLanguageToken(l, token)
+		}
+	}
+
 	utils.InitDataDir(lllcserver.ClientCache)
-	logger.Infoln("compiling", tocompile)
 	if c.Bool("local") {
 		lllcserver.SetLanguageNet(lang, false)
+	}
+
+	// more than one contract goes through a single batch request
+	if len(c.Args()) > 1 {
+		logger.Infoln("compiling batch", c.Args())
+		ch, err := lllcserver.CompileMany(c.Args())
+		ifExit(err)
+		for r := range ch {
+			if r.Error != "" {
+				fmt.Println(r.Name, r.Error)
+				continue
+			}
+			logger.Warnln(r.Name, "bytecode:", hex.EncodeToString(r.Bytecode))
+			logger.Warnln(r.Name, "abi:", r.ABI)
+		}
+		return
+	}
+
+	logger.Infoln("compiling", tocompile)
+	if c.Bool("local") {
 		//b, err := lllcserver.CompileWrapper(tocompile, lang)
 		// force it through the compile pipeline so we get caching
 		b, abi, err := lllcserver.Compile(tocompile)
@@ -149,6 +182,30 @@ func cliServer(c *cli.Context) {
 
 	}
 
+	if c.Int("max-body") > 0 {
+		lllcserver.MaxBodySize = int64(c.Int("max-body")) << 10
+	}
+	if c.Int("max-batch") > 0 {
+		lllcserver.MaxBatchScripts = c.Int("max-batch")
+	}
+
+	// any of these turns on access control
+	tokensFile := c.String("tokens")
+	if tokensFile != "" || c.Int("token-rate") > 0 || c.Int("ip-rate") > 0 || c.String("audit-log") != "" {
+		auth := &lllcserver.AuthConfig{
+			TokenRate: c.Int("token-rate"),
+			IPRate:    c.Int("ip-rate"),
+			AuditLog:  c.String("audit-log"),
+		}
+		if tokensFile != "" {
+			tokens, err := lllcserver.LoadTokens(tokensFile)
+			ifExit(err)
+			auth.Tokens = tokens
+			logger.Infoln("Loaded api tokens:", len(tokens))
+		}
+		lllcserver.Auth = auth
+	}
+
 	lllcserver.StartServer(addrUnsecure, addrSecure, key, cert)
 }
 
@@ -229,6 +286,49 @@ var (
 		Usage: "only bind localhost (don't expose to internet)",
 	}
 
+	tokenFlag = cli.StringFlag{
+		Name:   "token",
+		Usage:  "api token to send to the server",
+		Value:  "",
+		EnvVar: "LLLC_TOKEN",
+	}
+
+	tokensFlag = cli.StringFlag{
+		Name:  "tokens",
+		Usage: "json file mapping client names to api tokens. if set, requests must carry a valid token",
+		Value: "",
+	}
+
+	tokenRateFlag = cli.IntFlag{
+		Name:  "token-rate",
+		Usage: "max compile requests per minute per token (0 for no limit)",
+		Value: 0,
+	}
+
+	ipRateFlag = cli.IntFlag{
+		Name:  "ip-rate",
+		Usage: "max compile requests per minute per ip (0 for no limit)",
+		Value: 0,
+	}
+
+	maxBodyFlag = cli.IntFlag{
+		Name:  "max-body",
+		Usage: "max size of a compile request in KB",
+		Value: 2048,
+	}
+
+	maxBatchFlag = cli.IntFlag{
+		Name:  "max-batch",
+		Usage: "max number of scripts in a batch compile request",
+		Value: 100,
+	}
+
+	auditLogFlag = cli.StringFlag{
+		Name:  "audit-log",
+		Usage: "log the source hash of every compile request to this file",
+		Value: "",
+	}
+
 	hostFlag = cli.StringFlag{
 		Name:  "host",
 		Usage: "set the server host (include http(s)://)",
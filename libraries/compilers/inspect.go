@@ -0,0 +1,375 @@
+package lllcserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/eris-ltd/lllc-server/Godeps/_workspace/src/github.com/eris-ltd/go-ethereum/crypto/sha3"
+	"net/http"
+	"strings"
+)
+
+// Names of the opcodes for a vm.
+// Chains with their own vm (eg. thelonious) can build one from their opcode table
+type OpCodes map[byte]string
+
+// Build the opcode names of a vm from the String method of its OpCode type.
+// The vendored vms (monkvm, go-ethereum/vm, tendermint/vm) all name the
+// opcodes they don't have "Missing opcode 0x..", and those are left out
+func VMOpCodes(name func(op byte) string) OpCodes {
+	ops := make(OpCodes)
+	for i := 0; i < 256; i++ {
+		if n := name(byte(i)); !strings.HasPrefix(n, "Missing opcode") {
+			ops[byte(i)] = n
+		}
+	}
+	return ops
+}
+
+// Opcodes of the ethereum (frontier) vm, as in go-ethereum/vm.
+// The compile server doesn't vendor a vm, so this is its own copy for
+// languages without opcodes. Clients that have a vm use VMOpCodes
+var EVMOpCodes = OpCodes{
+	0x00: "STOP", 0x01: "ADD", 0x02: "MUL", 0x03: "SUB", 0x04: "DIV", 0x05: "SDIV",
+	0x06: "MOD", 0x07: "SMOD", 0x08: "ADDMOD", 0x09: "MULMOD", 0x0a: "EXP", 0x0b: "SIGNEXTEND",
+
+	0x10: "LT", 0x11: "GT", 0x12: "SLT", 0x13: "SGT", 0x14: "EQ", 0x15: "ISZERO",
+	0x16: "AND", 0x17: "OR", 0x18: "XOR", 0x19: "NOT", 0x1a: "BYTE",
+
+	0x20: "SHA3",
+
+	0x30: "ADDRESS", 0x31: "BALANCE", 0x32: "ORIGIN", 0x33: "CALLER", 0x34: "CALLVALUE",
+	0x35: "CALLDATALOAD", 0x36: "CALLDATASIZE", 0x37: "CALLDATACOPY", 0x38: "CODESIZE",
+	0x39: "CODECOPY", 0x3a: "GASPRICE", 0x3b: "EXTCODESIZE", 0x3c: "EXTCODECOPY",
+
+	0x40: "BLOCKHASH", 0x41: "COINBASE", 0x42: "TIMESTAMP", 0x43: "NUMBER",
+	0x44: "DIFFICULTY", 0x45: "GASLIMIT",
+
+	0x50: "POP", 0x51: "MLOAD", 0x52: "MSTORE", 0x53: "MSTORE8", 0x54: "SLOAD",
+	0x55: "SSTORE", 0x56: "JUMP", 0x57: "JUMPI", 0x58: "PC", 0x59: "MSIZE",
+	0x5a: "GAS", 0x5b: "JUMPDEST",
+
+	0xf0: "CREATE", 0xf1: "CALL", 0xf2: "CALLCODE", 0xf3: "RETURN",
+	0xff: "SUICIDE",
+}
+
+func init() {
+	for i := 0; i < 32; i++ {
+		EVMOpCodes[byte(0x60+i)] = fmt.Sprintf("PUSH%d", i+1)
+	}
+	for i := 0; i < 16; i++ {
+		EVMOpCodes[byte(0x80+i)] = fmt.Sprintf("DUP%d", i+1)
+		EVMOpCodes[byte(0x90+i)] = fmt.Sprintf("SWAP%d", i+1)
+	}
+	for i := 0; i < 5; i++ {
+		EVMOpCodes[byte(0xa0+i)] = fmt.Sprintf("LOG%d", i)
+	}
+}
+
+// The opcode names for the vm a language compiles for
+func LangOpCodes(lang string) OpCodes {
+	if l, ok := Languages[lang]; ok && l.OpCodes != nil {
+		return l.OpCodes
+	}
+	return EVMOpCodes
+}
+
+// A single disassembled instruction
+type Instruction struct {
+	PC   int    `json:"pc"`
+	Op   byte   `json:"op"`
+	Name string `json:"name"`
+	Data []byte `json:"data,omitempty"` // push data
+}
+
+func (i *Instruction) String() string {
+	if i.Data != nil {
+		return fmt.Sprintf("%04d: %s 0x%x", i.PC, i.Name, i.Data)
+	}
+	return fmt.Sprintf("%04d: %s", i.PC, i.Name)
+}
+
+// Push instructions are 0x60-0x7f in every vm we know of
+func isPush(op byte) bool {
+	return op >= 0x60 && op <= 0x7f
+}
+
+// Disassemble bytecode with the given opcode names.
+// Unknown opcodes are reported as INVALID and truncated push data is kept as is
+func Disassemble(code []byte, ops OpCodes) []*Instruction {
+	instrs := []*Instruction{}
+	for pc := 0; pc < len(code); pc++ {
+		op := code[pc]
+		name, ok := ops[op]
+		if !ok {
+			name = fmt.Sprintf("INVALID(0x%02x)", op)
+		}
+		instr := &Instruction{PC: pc, Op: op, Name: name}
+		if isPush(op) {
+			n := int(op-0x60) + 1
+			end := pc + 1 + n
+			if end > len(code) {
+				end = len(code)
+			}
+			instr.Data = code[pc+1 : end]
+			pc = end - 1
+		}
+		instrs = append(instrs, instr)
+	}
+	return instrs
+}
+
+// A function selector found in bytecode
+type Selector struct {
+	Id     string `json:"id"`     // hex
+	Method string `json:"method"` // signature from the abi, "" if it isn't there
+}
+
+// The result of inspecting some bytecode
+type Inspection struct {
+	Size      int         `json:"size"`
+	Code      []string    `json:"code"`
+	Selectors []*Selector `json:"selectors"`
+}
+
+// Disassemble the code and find its function selectors,
+// naming them from the json abi if we have one
+func Inspect(code []byte, abi string, ops OpCodes) (*Inspection, error) {
+	instrs := Disassemble(code, ops)
+	asm := make([]string, len(instrs))
+	for i, instr := range instrs {
+		asm[i] = instr.String()
+	}
+
+	methods := make(map[string]string)
+	if abi != "" {
+		var err error
+		if methods, err = AbiSelectors(abi); err != nil {
+			return nil, err
+		}
+	}
+
+	selectors := []*Selector{}
+	for _, id := range FindSelectors(instrs) {
+		selectors = append(selectors, &Selector{id, methods[id]})
+	}
+	return &Inspection{
+		Size:      len(code),
+		Code:      asm,
+		Selectors: selectors,
+	}, nil
+}
+
+// Selectors are 4 byte pushes compared against the call data shortly after,
+// ie. PUSH4 <id> [DUPn|SWAPn] EQ. Selectors with a leading zero byte are
+// pushed with PUSH3
+func FindSelectors(instrs []*Instruction) []string {
+	seen := make(map[string]bool)
+	ids := []string{}
+	for i, instr := range instrs {
+		if !(instr.Op == 0x63 && len(instr.Data) == 4) && !(instr.Op == 0x62 && len(instr.Data) == 3) {
+			continue
+		}
+		for j := i + 1; j < len(instrs) && j <= i+2; j++ {
+			if instrs[j].Name == "EQ" {
+				id := hex.EncodeToString(append(make([]byte, 4-len(instr.Data)), instr.Data...))
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// Map the hex selector of every function in a json abi to its signature
+func AbiSelectors(abi string) (map[string]string, error) {
+	var methods []struct {
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+		Inputs []struct {
+			Type string `json:"type"`
+		} `json:"inputs"`
+	}
+	if err := json.Unmarshal([]byte(abi), &methods); err != nil {
+		return nil, fmt.Errorf("Invalid abi: %s", err.Error())
+	}
+
+	sels := make(map[string]string)
+	for _, m := range methods {
+		if m.Type != "" && m.Type != "function" {
+			continue
+		}
+		sig := m.Name
+		// serpent puts the full signature in the name
+		if !strings.Contains(sig, "(") {
+			types := make([]string, len(m.Inputs))
+			for i, in := range m.Inputs {
+				types[i] = canonicalType(in.Type)
+			}
+			sig += "(" + strings.Join(types, ",") + ")"
+		}
+		d := sha3.NewKeccak256()
+		d.Write([]byte(sig))
+		sels[hex.EncodeToString(d.Sum(nil)[:4])] = sig
+	}
+	return sels, nil
+}
+
+// int and uint are aliases for their 256 bit versions
+func canonicalType(t string) string {
+	for _, base := range []string{"int", "uint"} {
+		if t == base || strings.HasPrefix(t, base+"[") {
+			return base + "256" + t[len(base):]
+		}
+	}
+	return t
+}
+
+// Compare locally compiled code against code from the chain.
+// Compiled code includes the init code that returns the deployed code,
+// so it's a match if the chain code's instructions are all of the local
+// code's or a run of them (starting on an instruction, not in push data).
+// Otherwise return a diff of the disassembly
+func DiffCode(local, chain []byte, ops OpCodes) (bool, []string) {
+	l, c := Disassemble(local, ops), Disassemble(chain, ops)
+	if len(c) > 0 {
+		for i := 0; i+len(c) <= len(l); i++ {
+			if sameInstructions(l[i:i+len(c)], c) {
+				return true, nil
+			}
+		}
+	}
+	return false, diffLines(asmLines(l), asmLines(c))
+}
+
+// same ops with the same push data, wherever they are
+func sameInstructions(a, b []*Instruction) bool {
+	for i := range a {
+		if a[i].Op != b[i].Op || !bytes.Equal(a[i].Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// disassembly without the pcs, so an insertion doesn't change every line
+func asmLines(instrs []*Instruction) []string {
+	lines := make([]string, len(instrs))
+	for i, instr := range instrs {
+		lines[i] = strings.SplitN(instr.String(), ": ", 2)[1]
+	}
+	return lines
+}
+
+// The most cells of the lcs table diffLines fills in (8 bytes each)
+var MaxDiffCells = 1 << 22
+
+// line diff of a and b from their longest common subsequence.
+// Lines are prefixed with "-" if only in a, "+" if only in b.
+// The common start and end are left out of the lcs, and if the rest
+// is still too big for it the whole of it is reported as changed
+func diffLines(a, b []string) []string {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	diff := []string{}
+	for _, l := range a[:pre] {
+		diff = append(diff, "  "+l)
+	}
+	diff = append(diff, diffMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		diff = append(diff, "  "+l)
+	}
+	return diff
+}
+
+func diffMiddle(a, b []string) []string {
+	diff := []string{}
+	if (len(a)+1)*(len(b)+1) > MaxDiffCells {
+		for _, l := range a {
+			diff = append(diff, "- "+l)
+		}
+		for _, l := range b {
+			diff = append(diff, "+ "+l)
+		}
+		return diff
+	}
+
+	// lcs[i*w+j] is the lcs of a[i:] and b[j:]
+	w := len(b) + 1
+	lcs := make([]int, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			} else {
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, "  "+a[i])
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+ "+b[j])
+	}
+	return diff
+}
+
+// Inspect response object
+type InspectResponse struct {
+	*Inspection
+	Error string `json:"error"`
+}
+
+// Http handler to compile a request and inspect the result
+func InspectHandler(w http.ResponseWriter, r *http.Request) {
+	req, resp := compileResponse(w, r)
+	if resp == nil {
+		return
+	}
+	iresp := new(InspectResponse)
+	if resp.Error != "" {
+		iresp.Error = resp.Error
+	} else if ins, err := Inspect(resp.Bytecode, resp.ABI, LangOpCodes(req.Language)); err != nil {
+		iresp.Error = err.Error()
+	} else {
+		iresp.Inspection = ins
+	}
+
+	respJ, err := json.Marshal(iresp)
+	if err != nil {
+		logger.Errorln("failed to marshal", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(respJ)
+}
@@ -0,0 +1,139 @@
+package lllcserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// dispatch on transfer(address,uint256) and an unknown function
+const inspectCode = "60003560e060020a90048063a9059cbb1460215780631234567814602b57005b60016000f35b60026000f3"
+
+const inspectAbi = `[{"name":"transfer","type":"function","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint"}]}]`
+
+func TestDisassemble(t *testing.T) {
+	code, _ := hex.DecodeString("6001600263a9059cbb01")
+	instrs := Disassemble(code, EVMOpCodes)
+	expected := []string{"0000: PUSH1 0x01", "0002: PUSH1 0x02", "0004: PUSH4 0xa9059cbb", "0009: ADD"}
+	if len(instrs) != len(expected) {
+		t.Fatalf("got %d instructions, expected %d", len(instrs), len(expected))
+	}
+	for i, instr := range instrs {
+		if instr.String() != expected[i] {
+			t.Fatalf("got %s, expected %s", instr, expected[i])
+		}
+	}
+
+	// truncated push data
+	code, _ = hex.DecodeString("6300ff")
+	instrs = Disassemble(code, EVMOpCodes)
+	if len(instrs) != 1 || len(instrs[0].Data) != 2 {
+		t.Fatalf("bad truncated push: %v", instrs)
+	}
+}
+
+func TestInspectSelectors(t *testing.T) {
+	code, _ := hex.DecodeString(inspectCode)
+	ins, err := Inspect(code, inspectAbi, EVMOpCodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Size != len(code) {
+		t.Fatalf("bad size %d", ins.Size)
+	}
+	if len(ins.Selectors) != 2 {
+		t.Fatalf("expected 2 selectors, got %d", len(ins.Selectors))
+	}
+	if s := ins.Selectors[0]; s.Id != "a9059cbb" || s.Method != "transfer(address,uint256)" {
+		t.Fatalf("bad selector %v", s)
+	}
+	if s := ins.Selectors[1]; s.Id != "12345678" || s.Method != "" {
+		t.Fatalf("bad selector %v", s)
+	}
+}
+
+func TestInspectSelectorLeadingZero(t *testing.T) {
+	// PUSH3 0x00abcd... compared with EQ, as solc does for selector 0x00abcdef
+	code, _ := hex.DecodeString("62abcdef14600057")
+	ins, err := Inspect(code, "", EVMOpCodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ins.Selectors) != 1 || ins.Selectors[0].Id != "00abcdef" {
+		t.Fatalf("expected selector 00abcdef, got %v", ins.Selectors)
+	}
+}
+
+func TestVMOpCodes(t *testing.T) {
+	ops := VMOpCodes(func(op byte) string {
+		if op == 0x01 {
+			return "ADD"
+		}
+		return fmt.Sprintf("Missing opcode 0x%x", op)
+	})
+	if len(ops) != 1 || ops[0x01] != "ADD" {
+		t.Fatalf("got %v", ops)
+	}
+}
+
+func TestDiffCode(t *testing.T) {
+	code, _ := hex.DecodeString(inspectCode)
+	// init code wrapping the deployed code
+	initCode := append([]byte{0x60, 0x00, 0x56}, code...)
+	if same, _ := DiffCode(initCode, code, EVMOpCodes); !same {
+		t.Fatal("deployed code should match its init code")
+	}
+
+	other := append([]byte{}, code...)
+	other[len(other)-2] = 0x05
+	same, diff := DiffCode(code, other, EVMOpCodes)
+	if same {
+		t.Fatal("expected a difference")
+	}
+	changed := 0
+	for _, l := range diff {
+		if strings.HasPrefix(l, "-") || strings.HasPrefix(l, "+") {
+			changed += 1
+		}
+	}
+	if changed != 2 {
+		t.Fatalf("expected one line changed, got diff %v", diff)
+	}
+
+	// too big for the lcs: the changed middle is all reported
+	defer func(max int) { MaxDiffCells = max }(MaxDiffCells)
+	MaxDiffCells = 4
+	diff = diffLines([]string{"a", "b", "c", "d"}, []string{"a", "c", "b", "d"})
+	if strings.Join(diff, ",") != "  a,- b,- c,+ c,+ b,  d" {
+		t.Fatalf("got diff %v", diff)
+	}
+
+	// the bytes of PUSH1 0x01 STOP, but inside push data
+	local, _ := hex.DecodeString("6360010000")
+	chain, _ := hex.DecodeString("600100")
+	if same, _ := DiffCode(local, chain, EVMOpCodes); same {
+		t.Fatal("code in push data should not match")
+	}
+}
+
+func TestLangOpCodes(t *testing.T) {
+	if ops := LangOpCodes("lll"); ops[0x01] != "ADD" {
+		t.Fatal("expected the evm opcodes for lll")
+	}
+	l := Languages["hex"]
+	l.OpCodes = OpCodes{0x01: "PLUS"}
+	Languages["hex"] = l
+	defer func() {
+		l.OpCodes = nil
+		Languages["hex"] = l
+	}()
+	code, _ := hex.DecodeString("01")
+	ins, err := Inspect(code, "", LangOpCodes("hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Code[0] != "0000: PLUS" {
+		t.Fatalf("expected the language's opcodes, got %v", ins.Code)
+	}
+}
@@ -100,6 +100,7 @@ func requestResponse(req *Request) (*Response, error) {
 		return nil, err
 	}
 	httpreq.Header.Set("Content-Type", "application/json")
+	setRequestToken(httpreq, Languages[lang].Token)
 
 	client := &http.Client{}
 	resp, err := client.Do(httpreq)
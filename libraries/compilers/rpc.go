@@ -0,0 +1,394 @@
+package lllcserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/eris-ltd/lllc-server/Godeps/_workspace/src/github.com/gorilla/websocket"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// JSON RPC error codes
+const (
+	PARSE_ERROR      = -32700
+	INVALID_REQUEST  = -32600
+	METHOD_NOT_FOUND = -32601
+	INVALID_PARAMS   = -32602
+	INTERNAL_ERROR   = -32603
+
+	// application errors
+	COMPILE_ERROR    = -32000
+	UNKNOWN_LANGUAGE = -32001
+)
+
+// Basic json rpc structs
+type (
+	RPCRequest struct {
+		ID      *json.RawMessage `json:"id"`
+		JsonRpc string           `json:"jsonrpc"`
+		Method  string           `json:"method"`
+		Params  *json.RawMessage `json:"params"`
+	}
+
+	RPCResponse struct {
+		ID      *json.RawMessage `json:"id"`
+		JsonRpc string           `json:"jsonrpc"`
+		Result  interface{}      `json:"result,omitempty"`
+		Error   *RPCError        `json:"error,omitempty"`
+	}
+
+	RPCError struct {
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data,omitempty"`
+	}
+)
+
+// Params for compile, compileLiteral and getAbi.
+// Source is a filename, or code if Literal is set (always for compileLiteral)
+type RPCCompileParams struct {
+	Source   string `json:"source"`
+	Literal  bool   `json:"literal"`
+	Language string `json:"language"`
+}
+
+// Result of compile and compileLiteral
+type RPCCompileResult struct {
+	Bytecode string `json:"bytecode"` // hex
+	ABI      string `json:"abi"`      // json encoded
+}
+
+// An entry in the result of listLanguages
+type RPCLanguage struct {
+	Name       string   `json:"name"`
+	Extensions []string `json:"extensions"`
+	URL        string   `json:"url"`
+	Net        bool     `json:"net"`
+	Version    string   `json:"version,omitempty"`
+}
+
+// Handler function template.
+// Returns the result or an error object
+type RPCMethod func(params *json.RawMessage) (interface{}, *RPCError)
+
+// All the methods we serve
+var RPCMethods = map[string]RPCMethod{
+	"compile":        rpcCompile,
+	"compileLiteral": rpcCompileLiteral,
+	"listLanguages":  rpcListLanguages,
+	"getAbi":         rpcGetAbi,
+	"clearCache":     rpcClearCache,
+	"inspect":        rpcInspect,
+}
+
+// Get an error object
+func NewRPCError(code int, msg string, data interface{}) *RPCError {
+	return &RPCError{code, msg, data}
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+/***************************** Methods ********************************/
+
+func rpcCompile(params *json.RawMessage) (interface{}, *RPCError) {
+	p, rerr := compileParams(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	return compileResult(p)
+}
+
+func rpcCompileLiteral(params *json.RawMessage) (interface{}, *RPCError) {
+	p, rerr := compileParams(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	p.Literal = true
+	return compileResult(p)
+}
+
+func rpcGetAbi(params *json.RawMessage) (interface{}, *RPCError) {
+	p, rerr := compileParams(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	r, rerr := compileResult(p)
+	if rerr != nil {
+		return nil, rerr
+	}
+	return r.ABI, nil
+}
+
+func rpcListLanguages(params *json.RawMessage) (interface{}, *RPCError) {
+	names := []string{}
+	for name, _ := range Languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	langs := []*RPCLanguage{}
+	for _, name := range names {
+		l := Languages[name]
+		v, _ := CompilerVersion(name)
+		langs = append(langs, &RPCLanguage{
+			Name:       name,
+			Extensions: l.Extensions,
+			URL:        l.URL,
+			Net:        l.Net,
+			Version:    v,
+		})
+	}
+	return langs, nil
+}
+
+func rpcClearCache(params *json.RawMessage) (interface{}, *RPCError) {
+	if err := ClearCaches(); err != nil {
+		return nil, NewRPCError(INTERNAL_ERROR, err.Error(), nil)
+	}
+	return true, nil
+}
+
+func rpcInspect(params *json.RawMessage) (interface{}, *RPCError) {
+	p, rerr := compileParams(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	r, rerr := compileResult(p)
+	if rerr != nil {
+		return nil, rerr
+	}
+	code, _ := hex.DecodeString(r.Bytecode)
+	lang := p.Language
+	if !p.Literal {
+		lang, _ = LangFromFile(p.Source)
+	}
+	ins, err := Inspect(code, r.ABI, LangOpCodes(lang))
+	if err != nil {
+		return nil, NewRPCError(INTERNAL_ERROR, err.Error(), nil)
+	}
+	return ins, nil
+}
+
+/***********************************************************************/
+
+func compileParams(params *json.RawMessage) (*RPCCompileParams, *RPCError) {
+	p := new(RPCCompileParams)
+	if params == nil {
+		return nil, NewRPCError(INVALID_PARAMS, "Missing params", nil)
+	}
+	if err := json.Unmarshal(*params, p); err != nil {
+		return nil, NewRPCError(INVALID_PARAMS, err.Error(), nil)
+	}
+	if p.Source == "" {
+		return nil, NewRPCError(INVALID_PARAMS, "No source provided", nil)
+	}
+	return p, nil
+}
+
+// compile through the client, so we get its caching and include handling
+func compileResult(p *RPCCompileParams) (*RPCCompileResult, *RPCError) {
+	var (
+		code []byte
+		abi  string
+		err  error
+	)
+	if p.Literal {
+		if _, ok := Languages[p.Language]; !ok {
+			return nil, NewRPCError(UNKNOWN_LANGUAGE, UnknownLang(p.Language).Error(), p.Language)
+		}
+		code, abi, err = CompileLiteral(p.Source, p.Language)
+	} else {
+		if _, lerr := LangFromFile(p.Source); lerr != nil {
+			return nil, NewRPCError(UNKNOWN_LANGUAGE, lerr.Error(), p.Source)
+		}
+		code, abi, err = Compile(p.Source)
+	}
+	if err != nil {
+		return nil, NewRPCError(COMPILE_ERROR, err.Error(), p)
+	}
+	return &RPCCompileResult{hex.EncodeToString(code), abi}, nil
+}
+
+// Handle a single request.
+// Returns nil for notifications (no id)
+func handleRPCRequest(msg json.RawMessage) *RPCResponse {
+	req := new(RPCRequest)
+	if err := json.Unmarshal(msg, req); err != nil {
+		return rpcErrorResponse(nil, NewRPCError(INVALID_REQUEST, err.Error(), nil))
+	}
+	if req.JsonRpc != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, NewRPCError(INVALID_REQUEST, "Not a json rpc 2.0 request", nil))
+	}
+
+	var (
+		result interface{}
+		rerr   *RPCError
+	)
+	if method, ok := RPCMethods[req.Method]; !ok {
+		rerr = NewRPCError(METHOD_NOT_FOUND, "Method not found: "+req.Method, nil)
+	} else {
+		result, rerr = method(req.Params)
+	}
+	if req.ID == nil {
+		return nil
+	}
+	if rerr != nil {
+		return rpcErrorResponse(req.ID, rerr)
+	}
+	return &RPCResponse{
+		ID:      req.ID,
+		JsonRpc: "2.0",
+		Result:  result,
+	}
+}
+
+func rpcErrorResponse(id *json.RawMessage, rerr *RPCError) *RPCResponse {
+	return &RPCResponse{
+		ID:      id,
+		JsonRpc: "2.0",
+		Error:   rerr,
+	}
+}
+
+// Handle a request or batch of requests.
+// Returns the json to write back, or nil if there's nothing to say
+func handleRPC(body []byte) []byte {
+	var out interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		// batch
+		var msgs []json.RawMessage
+		if err := json.Unmarshal(body, &msgs); err != nil {
+			out = rpcErrorResponse(nil, NewRPCError(PARSE_ERROR, err.Error(), nil))
+		} else if len(msgs) == 0 {
+			out = rpcErrorResponse(nil, NewRPCError(INVALID_REQUEST, "Empty batch", nil))
+		} else {
+			resps := []*RPCResponse{}
+			for _, msg := range msgs {
+				if resp := handleRPCRequest(msg); resp != nil {
+					resps = append(resps, resp)
+				}
+			}
+			if len(resps) == 0 {
+				return nil
+			}
+			out = resps
+		}
+	} else {
+		var msg json.RawMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			out = rpcErrorResponse(nil, NewRPCError(PARSE_ERROR, err.Error(), nil))
+		} else if resp := handleRPCRequest(msg); resp != nil {
+			out = resp
+		} else {
+			return nil
+		}
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		logger.Errorln("failed to marshal rpc response", err)
+		b, _ = json.Marshal(rpcErrorResponse(nil, NewRPCError(INTERNAL_ERROR, err.Error(), nil)))
+	}
+	return b
+}
+
+// Http handler for json rpc requests
+func RPCHandler(w http.ResponseWriter, r *http.Request) {
+	// browsers only send json cross origin after asking (cors), which we don't answer
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	resp := handleRPC(body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  8192,
+	WriteBufferSize: 8192,
+	CheckOrigin:     checkOrigin,
+}
+
+// Browsers let any page open a websocket to us, sending the page's origin.
+// Let in clients that aren't browsers (no origin), pages served from this
+// host and pages on localhost
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Martini handler to turn away requests from pages on other hosts,
+// by the same rules as the websocket
+func CheckOrigin(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(r) {
+		logger.Warnln("rejected request from origin", r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+	}
+}
+
+// Websocket handler for json rpc requests.
+// Each text message is a request or batch, handled in order
+func RPCWsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Errorln("Failed to upgrade to websocket", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(MaxBodySize)
+
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			logger.Debugln("websocket closed", err)
+			return
+		}
+		if typ != websocket.TextMessage {
+			continue
+		}
+		resp := handleRPC(msg)
+		if resp == nil {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, resp); err != nil {
+			logger.Errorln("failed to write to websocket", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package lllcserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/eris-ltd/lllc-server/Godeps/_workspace/src/github.com/gorilla/websocket"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+type testRPCResponse struct {
+	ID     int              `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  *RPCError        `json:"error"`
+}
+
+func rpcPost(t *testing.T, url, body string) []byte {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRPCCompileLiteral(t *testing.T) {
+	ClearCaches()
+	SetLanguageNet("hex", false)
+	ts := httptest.NewServer(http.HandlerFunc(RPCHandler))
+	defer ts.Close()
+
+	b := rpcPost(t, ts.URL, `{"jsonrpc":"2.0","id":1,"method":"compileLiteral","params":{"source":"6005","language":"hex"}}`)
+	resp := new(testRPCResponse)
+	if err := json.Unmarshal(b, resp); err != nil {
+		t.Fatal(err, string(b))
+	}
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	result := new(RPCCompileResult)
+	json.Unmarshal(*resp.Result, result)
+	if result.Bytecode != "6005" {
+		t.Fatalf("got bytecode %s", result.Bytecode)
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	ClearCaches()
+	SetLanguageNet("hex", false)
+	dir, files := writeBatchScripts(t)
+	defer os.RemoveAll(dir)
+	ts := httptest.NewServer(http.HandlerFunc(RPCHandler))
+	defer ts.Close()
+
+	var file string
+	for _, f := range files {
+		if path.Base(f) == "b.hex" {
+			file = f
+		}
+	}
+
+	batch := `[
+		{"jsonrpc":"2.0","id":1,"method":"compile","params":{"source":"` + file + `"}},
+		{"jsonrpc":"2.0","id":2,"method":"nope"},
+		{"jsonrpc":"2.0","method":"clearCache"},
+		{"jsonrpc":"2.0","id":3,"method":"compileLiteral","params":{"source":"60","language":"nope"}},
+		{"jsonrpc":"2.0","id":4,"method":"listLanguages"}
+	]`
+	var resps []*testRPCResponse
+	b := rpcPost(t, ts.URL, batch)
+	if err := json.Unmarshal(b, &resps); err != nil {
+		t.Fatal(err, string(b))
+	}
+	if len(resps) != 4 {
+		t.Fatalf("expected 4 responses (one was a notification), got %d", len(resps))
+	}
+
+	byID := make(map[int]*testRPCResponse)
+	for _, r := range resps {
+		byID[r.ID] = r
+	}
+	if byID[1].Error != nil || !strings.Contains(string(*byID[1].Result), batchScripts["b.hex"]) {
+		t.Fatalf("bad compile response %s", b)
+	}
+	if byID[2].Error == nil || byID[2].Error.Code != METHOD_NOT_FOUND {
+		t.Fatalf("expected method not found, got %s", b)
+	}
+	if byID[3].Error == nil || byID[3].Error.Code != UNKNOWN_LANGUAGE {
+		t.Fatalf("expected unknown language, got %s", b)
+	}
+	var langs []*RPCLanguage
+	json.Unmarshal(*byID[4].Result, &langs)
+	found := false
+	for _, l := range langs {
+		if l.Name == "hex" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("hex missing from languages: %s", b)
+	}
+}
+
+func TestRPCParseError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(RPCHandler))
+	defer ts.Close()
+
+	resp := new(testRPCResponse)
+	b := rpcPost(t, ts.URL, `{"jsonrpc":"2.0","id":1,"method"`)
+	if err := json.Unmarshal(b, resp); err != nil {
+		t.Fatal(err, string(b))
+	}
+	if resp.Error == nil || resp.Error.Code != PARSE_ERROR {
+		t.Fatalf("expected parse error, got %s", b)
+	}
+}
+
+func TestRPCWebsocket(t *testing.T) {
+	ClearCaches()
+	SetLanguageNet("hex", false)
+	ts := httptest.NewServer(http.HandlerFunc(RPCWsHandler))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i, code := range []string{"6001", "6002"} {
+		req := `{"jsonrpc":"2.0","id":1,"method":"getAbi","params":{"source":"` + code + `","literal":true,"language":"hex"}}`
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := new(testRPCResponse)
+		if err := json.Unmarshal(b, resp); err != nil {
+			t.Fatal(err, string(b))
+		}
+		if resp.Error != nil || resp.Result == nil {
+			t.Fatalf("request %d failed: %s", i, b)
+		}
+	}
+}
+
+func TestRPCWebsocketOrigin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(RPCWsHandler))
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	for origin, ok := range map[string]bool{
+		"":                      true,
+		ts.URL:                  true,
+		"http://localhost:3000": true,
+		"http://127.0.0.1:8080": true,
+		"http://[::1]":          true,
+		"http://evil.com":       false,
+		"http://localhost.evil": false,
+		"null":                  false,
+	} {
+		h := http.Header{}
+		if origin != "" {
+			h.Set("Origin", origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, h)
+		if ok != (err == nil) {
+			t.Fatalf("origin %q: expected ok=%v, got %v", origin, ok, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+// pages on other hosts can't use the rpc over http either
+func TestRPCPostOrigin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		CheckOrigin(rec, r)
+		if rec.Code != http.StatusOK {
+			w.WriteHeader(rec.Code)
+			return
+		}
+		RPCHandler(w, r)
+	}))
+	defer ts.Close()
+
+	post := func(origin, contentType string) int {
+		req, _ := http.NewRequest("POST", ts.URL, bytes.NewBufferString(`{"jsonrpc":"2.0","id":1,"method":"listLanguages"}`))
+		req.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, c := range []struct {
+		origin, contentType string
+		code                int
+	}{
+		{"", "application/json", http.StatusOK},
+		{"http://localhost:3000", "application/json; charset=utf-8", http.StatusOK},
+		{"", "text/plain", http.StatusUnsupportedMediaType},
+		{"http://evil.com", "application/json", http.StatusForbidden},
+		{"http://evil.com", "text/plain", http.StatusForbidden},
+	} {
+		if code := post(c.origin, c.contentType); code != c.code {
+			t.Fatalf("origin %q, %s: got %d, expected %d", c.origin, c.contentType, code, c.code)
+		}
+	}
+}
@@ -43,18 +43,17 @@ func homeDir() string {
 var ServerCache = path.Join(utils.Lllc, "server")
 
 // Handler for proxy requests (ie. a compile request from langauge other than go)
+// Deprecated: use the json rpc methods in RPCMethods
 func ProxyHandler(w http.ResponseWriter, r *http.Request) {
 	// read the request body
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		logger.Errorln("err on read http request body", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	body, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
 	fmt.Println("body:", string(body))
 	req := new(ProxyReq)
-	err = json.Unmarshal(body, req)
+	err := json.Unmarshal(body, req)
 	if err != nil {
 		logger.Errorln("err on read http request body", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -81,7 +80,7 @@ func ProxyHandler(w http.ResponseWriter, r *http.Request) {
 // Main http request handler
 // Read request, compile, build response object, write
 func CompileHandler(w http.ResponseWriter, r *http.Request) {
-	resp := compileResponse(w, r)
+	_, resp := compileResponse(w, r)
 	if resp == nil {
 		return
 	}
@@ -96,7 +95,7 @@ func CompileHandler(w http.ResponseWriter, r *http.Request) {
 
 // Convenience wrapper for javascript frontend
 func CompileHandlerJs(w http.ResponseWriter, r *http.Request) {
-	resp := compileResponse(w, r)
+	_, resp := compileResponse(w, r)
 	if resp == nil {
 		return
 	}
@@ -106,24 +105,23 @@ func CompileHandlerJs(w http.ResponseWriter, r *http.Request) {
 }
 
 // read in the files from the request, compile them
-func compileResponse(w http.ResponseWriter, r *http.Request) *Response {
+func compileResponse(w http.ResponseWriter, r *http.Request) (*Request, *Response) {
 	// read the request body
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		logger.Errorln("err on read http request body", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return nil
+	body, ok := readBody(w, r)
+	if !ok {
+		return nil, nil
 	}
 
 	// unmarshall body into req struct
 	req := new(Request)
-	err = json.Unmarshal(body, req)
+	err := json.Unmarshal(body, req)
 	if err != nil {
 		logger.Errorln("err on json unmarshal of request", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return nil
+		return nil, nil
 	}
 
+	Auth.Audit(r, req.Language, req.Script)
 	resp := compileServerCore(req)
 
 	// track
@@ -131,7 +129,7 @@ func compileResponse(w http.ResponseWriter, r *http.Request) *Response {
 		informSegment(req.Language, r)
 	}
 
-	return resp
+	return req, resp
 }
 
 // core compile functionality. used by the server and locally to mimic the server
@@ -252,26 +250,19 @@ func CompileWrapper(filename string, lang string) ([]byte, string, error) {
 		os.Chdir(cur)
 	}()
 
-	tokens := Languages[lang].Cmd(filename)
-	hexCode, err := commandWrapper(tokens...)
+	compiler := Languages[lang].Backend()
+	b, err := compiler.Compile(filename)
 	if err != nil {
 		logger.Errorln("Couldn't compile!!", err)
 		return nil, "", err
 	}
 
-	tokens = Languages[lang].Abi(filename)
-	jsonAbi, err := commandWrapper(tokens...)
+	jsonAbi, err := compiler.Abi(filename)
 	if err != nil {
 		logger.Errorln("Couldn't produce abi doc!!", err)
 		// we swallow this error, but maybe we shouldnt...
 	}
 
-	b, err := hex.DecodeString(hexCode)
-	if err != nil {
-
-		return nil, "", err
-	}
-
 	return b, jsonAbi, nil
 }
 
@@ -290,8 +281,21 @@ func StartServer(addrUnsecure, addrSecure, key, cert string) {
 	srv.MapTo(r, (*martini.Routes)(nil))
 	srv.Action(r.Handle)
 
-	r.Post("/compile", CompileHandler)
-	r.Post("/compile2", CompileHandlerJs)
+	// everything that runs a compiler is size limited,
+	// and authenticated and rate limited if so configured
+	guard := LimitBody
+	if Auth != nil {
+		if err := Auth.Init(); err != nil {
+			logger.Errorln("Cannot set up auth: ", err)
+			os.Exit(1)
+		}
+		guard = Auth.Handler
+	}
+
+	r.Post("/compile", guard, CompileHandler)
+	r.Post("/compile2", guard, CompileHandlerJs)
+	r.Post("/compile/batch", guard, BatchCompileHandler)
+	r.Post("/inspect", guard, InspectHandler)
 
 	// new relic for error reporting
 	if NEWRELIC_KEY != "" {
@@ -331,9 +335,12 @@ func StartServer(addrUnsecure, addrSecure, key, cert string) {
 }
 
 // Start the proxy server
-// Dead simple json-rpc so we can compile code from languages other than go
+// Json rpc over http and websockets so we can compile code from languages other than go
+// The old single request api is still served at /proxy
 func StartProxy(addr string) {
 	srv := martini.Classic()
-	srv.Post("/", ProxyHandler)
+	srv.Post("/", CheckOrigin, LimitBody, RPCHandler)
+	srv.Get("/ws", RPCWsHandler)
+	srv.Post("/proxy", CheckOrigin, LimitBody, ProxyHandler)
 	srv.RunOnAddr(addr)
 }
@@ -0,0 +1,108 @@
+// Tool for publishers to create keys and sign dapp packages.
+//
+//	dappsign keygen <keyfile>                  - create a key, print the public key for the trust store
+//	dappsign sign <keyfile> <publisher> <dir>  - write and sign the manifest of a dapp
+//	dappsign verify <trustfile> <dir>          - verify a dapp against a trust store file
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/eris-ltd/decerver/dappmanager"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "keygen":
+		if len(args) != 1 {
+			usage()
+		}
+		err = keygen(args[0])
+	case "sign":
+		if len(args) != 3 {
+			usage()
+		}
+		err = sign(args[0], args[1], args[2])
+	case "verify":
+		if len(args) != 2 {
+			usage()
+		}
+		err = verify(args[0], args[1])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  dappsign keygen <keyfile>")
+	fmt.Println("  dappsign sign <keyfile> <publisher> <dapp dir>")
+	fmt.Println("  dappsign verify <trust store file> <dapp dir>")
+	os.Exit(1)
+}
+
+func keygen(keyFile string) error {
+	key, err := dappmanager.GenerateKey()
+	if err != nil {
+		return err
+	}
+	priv, err := dappmanager.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	pub, err := dappmanager.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, []byte(priv), 0600); err != nil {
+		return err
+	}
+	fmt.Println("Public key (add it to the trust store):")
+	fmt.Println(pub)
+	return nil
+}
+
+func sign(keyFile, publisher, dir string) error {
+	bts, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := dappmanager.ParsePrivateKey(strings.TrimSpace(string(bts)))
+	if err != nil {
+		return err
+	}
+	if err := dappmanager.SignDapp(dir, publisher, key); err != nil {
+		return err
+	}
+	fmt.Println("Signed: " + dir)
+	return nil
+}
+
+func verify(trustFile, dir string) error {
+	bts, err := ioutil.ReadFile(trustFile)
+	if err != nil {
+		return err
+	}
+	ts := make(dappmanager.TrustStore)
+	if err := json.Unmarshal(bts, &ts); err != nil {
+		return err
+	}
+	publisher, err := dappmanager.VerifyDapp(dir, ts)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Verified. Publisher: " + publisher)
+	return nil
+}
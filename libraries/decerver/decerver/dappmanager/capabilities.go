@@ -0,0 +1,74 @@
+package dappmanager
+
+import (
+	"github.com/eris-ltd/decerver/interfaces/dapps"
+	"github.com/eris-ltd/decerver/interfaces/files"
+	"os"
+)
+
+// The file (in the decerver root directory) where the operator can restrict
+// the capabilities of dapps. It is a json object with dapp ids as keys, and
+// the list of capabilities each dapp may have as values. Dapps that are not
+// in the file get what they ask for.
+const CAPABILITIES_FILE_NAME = "dapp_capabilities"
+
+type CapabilityOverrides map[string][]string
+
+// Load the capability overrides. If there is no file, there are no overrides.
+func LoadCapabilityOverrides(fio files.FileIO) (CapabilityOverrides, error) {
+	co := make(CapabilityOverrides)
+	err := fio.UnmarshalJsonFromFile(fio.Root(), CAPABILITIES_FILE_NAME, &co)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return co, nil
+}
+
+// Get the capabilities of a dapp: the ones in its package file, minus
+// the ones the operator does not allow (which are returned as 'denied').
+// A dapp that does not list any gets none.
+func (dm *DappManager) capabilities(dapp dapps.Dapp) (caps, denied []string, err error) {
+	declared := dapp.PackageFile().Capabilities
+	if declared == nil {
+		declared = []string{}
+	}
+	co, err := LoadCapabilityOverrides(dm.fio)
+	if err != nil {
+		// Don't hand out anything if the operator's limits can't be read.
+		return []string{}, declared, err
+	}
+	allowed, ok := co[dapp.PackageFile().Id]
+	if !ok {
+		return declared, nil, nil
+	}
+
+	isAllowed := make(map[string]bool)
+	for _, c := range allowed {
+		isAllowed[c] = true
+	}
+	caps = []string{}
+	for _, c := range declared {
+		if isAllowed[c] {
+			caps = append(caps, c)
+		} else {
+			denied = append(denied, c)
+		}
+	}
+	return caps, denied, nil
+}
+
+// Same as capabilities, but logs the denials. Used when a runtime is created.
+func (dm *DappManager) grantCapabilities(dapp dapps.Dapp) []string {
+	id := dapp.PackageFile().Id
+	caps, denied, err := dm.capabilities(dapp)
+	if err != nil {
+		logger.Printf("Error reading '%s': %s. Dapp '%s' gets no capabilities.\n", CAPABILITIES_FILE_NAME, err.Error(), id)
+	}
+	for _, c := range denied {
+		logger.Printf("Capability denied: dapp '%s' asks for '%s', which the operator does not allow.\n", id, c)
+	}
+	if len(dapp.PackageFile().Capabilities) == 0 {
+		logger.Printf("Dapp '%s' does not list any capabilities. It gets none.\n", id)
+	}
+	return caps
+}
@@ -1,12 +1,9 @@
 package dappmanager
 
 import (
-	// "path/filepath"
-	// "bytes"
-	// "crypto/sha1"
-	// "encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/eris-ltd/decerver/interfaces/dapps"
 	"github.com/eris-ltd/decerver/interfaces/decerver"
 	"github.com/eris-ltd/decerver/interfaces/files"
@@ -16,16 +13,16 @@ import (
 	"github.com/eris-ltd/decerver/interfaces/scripting"
 	"github.com/eris-ltd/epm-go/chains"
 	"github.com/eris-ltd/epm-go/utils"
-	// "github.com/syndtr/goleveldb/leveldb"
+	"github.com/robertkrimen/otto/parser"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	//"time"
-	"github.com/robertkrimen/otto/parser"
 )
 
 var logger *log.Logger = logging.NewLogger("Dapp Manager")
@@ -36,12 +33,20 @@ type Dapp struct {
 	models      []string
 	path        string
 	packageFile *dapps.PackageFile
+	publisher   string
+	sandboxed   bool
+	// Hashes of the files that were verified, from the manifest.
+	files map[string]string
 }
 
 func (dapp *Dapp) Models() []string {
 	return dapp.models
 }
 
+func (dapp *Dapp) VerifiedFiles() map[string]string {
+	return dapp.files
+}
+
 func (dapp *Dapp) Path() string {
 	return dapp.path
 }
@@ -50,39 +55,59 @@ func (dapp *Dapp) PackageFile() *dapps.PackageFile {
 	return dapp.packageFile
 }
 
+func (dapp *Dapp) Publisher() string {
+	return dapp.publisher
+}
+
+func (dapp *Dapp) Sandboxed() bool {
+	return dapp.sandboxed
+}
+
 func newDapp() *Dapp {
 	dapp := &Dapp{}
 	return dapp
 }
 
+// The mutex guards the maps. Loading, reloading and unloading a dapp runs
+// its models and calls its modules, so that is done under the dapp's own
+// lock instead, and one slow dapp doesn't hold up the others. The chain
+// mutex guards the chain of the monk module and the dapps that use it.
 type DappManager struct {
 	mutex       *sync.Mutex
 	keys        map[string]string
 	dapps       map[string]dapps.Dapp
 	rm          scripting.RuntimeManager
 	server      network.Server
-	runningDapp dapps.Dapp
+	loaded      map[string]*loadedDapp
+	watchers    map[string]*dappWatcher
+	dappLocks   map[string]*sync.Mutex
+	chainMutex  *sync.Mutex
+	activeChain *chainConfig
+	chainUsers  map[string]bool
 	mm          modules.ModuleManager
 	fio         files.FileIO
-	//	hashDB *leveldb.DB
+	config      func() *decerver.DCConfig
 }
 
 func NewDappManager(dc decerver.Decerver) dapps.DappManager {
 	dm := &DappManager{}
 	dm.keys = make(map[string]string)
 	dm.dapps = make(map[string]dapps.Dapp)
+	dm.loaded = make(map[string]*loadedDapp)
+	dm.watchers = make(map[string]*dappWatcher)
+	dm.dappLocks = make(map[string]*sync.Mutex)
+	dm.chainUsers = make(map[string]bool)
 	dm.mutex = &sync.Mutex{}
+	dm.chainMutex = &sync.Mutex{}
 	dm.rm = dc.RuntimeManager()
 	dm.mm = dc.ModuleManager()
 	dm.server = dc.Server()
 	dm.fio = dc.FileIO()
+	dm.config = dc.Config
 	return dm
 }
 
 func (dm *DappManager) RegisterDapps(directory, dbDir string) error {
-	//	dbDir = path.Join(dbDir,"dapp_stored_hashes")
-	//	dc.hashDB, _ = leveldb.OpenFile(dbDir,nil)
-	//	defer dc.hashDB.Close()
 	logger.Println("Registering dapps")
 	files, err := ioutil.ReadDir(directory)
 
@@ -106,121 +131,116 @@ func (dm *DappManager) RegisterDapps(directory, dbDir string) error {
 }
 
 func (dm *DappManager) RegisterDapp(dir string) {
-
-	pkDir := path.Join(dir, dapps.PACKAGE_FILE_NAME)
-	_, errPfn := os.Stat(pkDir)
-	if errPfn != nil {
-		logger.Printf("Error loading 'package.json' for dapp '%s'. Skipping...\n", dir)
-		logger.Println(errPfn.Error())
+	dapp, err := readDapp(dir)
+	if err != nil {
+		logger.Println(err.Error())
+		logger.Println("Skipping dapp: " + dir)
 		return
 	}
+	if err := dm.verifyDapp(dapp); err != nil {
+		logger.Println(err.Error())
+		logger.Println("Skipping dapp: " + dir)
+		return
+	}
+	id := dapp.packageFile.Id
 
+	// Reload the dapp when its models change.
+	dw := newDappWatcher(dm, id, dir)
+
+	dm.mutex.Lock()
+	dm.dapps[id] = dapp
+	if old, ok := dm.watchers[id]; ok {
+		old.Stop()
+	}
+	dm.watchers[id] = dw
+	dm.mutex.Unlock()
+
+	// Register the handlers right away.
+	dm.server.RegisterDapp(id)
+	go dw.run()
+}
+
+// The lock for loading, reloading and unloading a dapp.
+func (dm *DappManager) dappLock(dappId string) *sync.Mutex {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	l, ok := dm.dappLocks[dappId]
+	if !ok {
+		l = &sync.Mutex{}
+		dm.dappLocks[dappId] = l
+	}
+	return l
+}
+
+// Read and parse the package file and models of a dapp.
+func readDapp(dir string) (*Dapp, error) {
+
+	pkDir := path.Join(dir, dapps.PACKAGE_FILE_NAME)
 	pkBts, errP := ioutil.ReadFile(pkDir)
 
 	if errP != nil {
-		logger.Printf("Error loading 'package.json' for dapp '%s'. Skipping...\n", dir)
-		logger.Println(errP.Error())
-		return
+		return nil, fmt.Errorf("Error loading 'package.json' for dapp '%s': %s", dir, errP.Error())
 	}
 
 	packageFile := &dapps.PackageFile{}
 	pkUnmErr := json.Unmarshal(pkBts, packageFile)
 
 	if pkUnmErr != nil {
-		logger.Printf("The 'package.json' file for dapp '%s' is corrupted. Skipping...\n", dir)
-		logger.Println(pkUnmErr.Error())
-		return
+		return nil, fmt.Errorf("The 'package.json' file for dapp '%s' is corrupted: %s", dir, pkUnmErr.Error())
 	}
 
 	idxDir := path.Join(dir, dapps.INDEX_FILE_NAME)
 	_, errIf := os.Stat(idxDir)
 
 	if errIf != nil {
-		logger.Printf("Cannot find an 'index.html' file for dapp '%s'. Skipping...\n", dir)
-		logger.Println(errIf.Error())
-		return
+		return nil, fmt.Errorf("Cannot find an 'index.html' file for dapp '%s': %s", dir, errIf.Error())
 	}
 
 	modelDir := path.Join(dir, dapps.MODELS_FOLDER_NAME)
 
 	modelFi, errMfi := os.Stat(modelDir)
-	logger.Print("## Registering dapp: " + packageFile.Name + " ##")
+	logger.Print("## Reading dapp: " + packageFile.Name + " ##")
 	if errMfi != nil {
-		logger.Printf("Error loading 'models' directory for dapp '%s'. Skipping.\n", dir)
-		logger.Println(errMfi.Error())
-		return
+		return nil, fmt.Errorf("Error loading 'models' directory for dapp '%s': %s", dir, errMfi.Error())
 	}
 
 	if !modelFi.IsDir() {
-		logger.Printf("Error loading 'models' directory for dapp '%s': Not a directory.\n", dir)
-		return
-	}
-
-	files, err := ioutil.ReadDir(modelDir)
-	if err != nil {
-		logger.Printf("Error loading 'models' directory for dapp '%s': Not a directory.\n", dir)
-		return
+		return nil, fmt.Errorf("Error loading 'models' directory for dapp '%s': Not a directory.", dir)
 	}
 
-	if len(files) == 0 {
-		logger.Printf("No models in model dir for app '%s', skipping.\n", dir)
-		return
-	}
-
-	// Look for a config.json where loding order is defined. If there
-	// is no such file, we just load them alphabetically.
-
+	// Look for a config.json where loding order is defined.
 	loConf := path.Join(modelDir, dapps.LOADING_ORDER_FILE_NAME)
-	_, errLoc := os.Stat(loConf)
-	if errLoc != nil {
-		logger.Printf("Error loading 'config.json' for dapp '%s' models js loading. Skipping...\n", dir)
-		logger.Println(errLoc.Error())
-		return
-	}
-
 	locBts, errL := ioutil.ReadFile(loConf)
 
 	if errL != nil {
-		logger.Printf("Error loading 'config.json' for dapp '%s' models js loading. Skipping...\n", dir)
-		logger.Println(errL.Error())
-		return
+		return nil, fmt.Errorf("Error loading 'config.json' for dapp '%s' models js loading: %s", dir, errL.Error())
 	}
 
 	loadConf := &dapps.LoadOrderConfig{}
 	lcUnmErr := json.Unmarshal(locBts, loadConf)
 
 	if lcUnmErr != nil {
-		logger.Printf("The 'config.json' file for dapp '%s' model loading is corrupted. Skipping...\n", dir)
-		logger.Println(pkUnmErr.Error())
-		return
+		return nil, fmt.Errorf("The 'config.json' file for dapp '%s' model loading is corrupted: %s", dir, lcUnmErr.Error())
 	}
 
 	if len(loadConf.LoadingOrder) == 0 {
-		logger.Printf("The loading order file list in the 'config.json' file for dapp '%s' model loading contains no files. Skipping...\n", dir)
-		return
+		return nil, fmt.Errorf("The loading order file list in the 'config.json' file for dapp '%s' model loading contains no files.", dir)
 	}
 
 	models := make([]string, 0)
 
-	// TODO recursively and perhaps also a require.js type load file
-	// to ensure the proper loading order.
+	// These are run in order. Other files can be loaded from them
+	// with 'require'.
 	for _, mfName := range loadConf.LoadingOrder {
 		fp := path.Join(modelDir, mfName)
-		/* if fileInfo.IsDir() {
-			logger.Println("Action models cannot be loaded recursively (yet). Skipping directory: " + fp)
-			// Skip for now.
-			continue
-		}
-		*/
 
 		if strings.ToLower(path.Ext(fp)) != ".js" {
-			//fmt.Println("[Dapp Registry] Skipping non .js file: " + fp)
 			continue
 		}
 
 		fileBts, errFile := ioutil.ReadFile(fp)
 		if errFile != nil {
-			logger.Println("Error reading javascript file: " + fp)
+			return nil, fmt.Errorf("Error reading javascript file '%s': %s", fp, errFile.Error())
 		}
 
 		jsFile := string(fileBts)
@@ -229,15 +249,12 @@ func (dm *DappManager) RegisterDapp(dir string) {
 		_, errParse := parser.ParseFile(nil, "", jsFile, 0)
 
 		if errParse != nil {
-			logger.Printf("Error parsing javascript file '%s'. DUMP: %s\nError: %s\n", mfName, jsFile, errParse.Error())
-			logger.Println("Skipping dapp: " + dir)
-			return
+			return nil, fmt.Errorf("Error parsing javascript file '%s': %s", mfName, errParse.Error())
 		}
 
 		logger.Printf("Loaded javascript file '%s'\n", path.Base(fp))
 
 		models = append(models, jsFile)
-
 	}
 
 	// Create the dapp object and set it up.
@@ -246,198 +263,384 @@ func (dm *DappManager) RegisterDapp(dir string) {
 	dapp.packageFile = packageFile
 	dapp.models = models
 
-	/*
+	return dapp, nil
+}
 
-		TODO: Dapp verification has been postponed. Leaving this in, but
+// Re-read a dapp after its files changed. If it is loaded, its runtime is
+// rebuilt from the new models and its websocket sessions are moved over to
+// the new runtime. If the new version can't be read the old one keeps running.
+func (dm *DappManager) ReloadDapp(dappId string) error {
+	lock := dm.dappLock(dappId)
+	lock.Lock()
+	defer lock.Unlock()
 
+	dm.mutex.Lock()
+	old, ok := dm.dapps[dappId]
+	ld, running := dm.loaded[dappId]
+	dm.mutex.Unlock()
+	if !ok {
+		return errors.New("Error reloading dapp: " + dappId + ". No dapp with that name has been registered.")
+	}
 
-		// Hash the dapp files and check.
-		hash := dc.HashApp(modelDir)
+	dapp, err := readDapp(old.Path())
+	if err != nil {
+		return fmt.Errorf("Error reloading dapp: %s. Keeping the previous version. %s", dappId, err.Error())
+	}
+	if dapp.packageFile.Id != dappId {
+		return fmt.Errorf("Error reloading dapp: %s. The id in the package file changed to '%s' (restart the decerver to change it).", dappId, dapp.packageFile.Id)
+	}
+	if err := dm.verifyDapp(dapp); err != nil {
+		return fmt.Errorf("Error reloading dapp: %s. Keeping the previous version. %s", dappId, err.Error())
+	}
 
-		if hash == nil {
-			logger.Println("Failed to get hash of dapp files, skipping. Dapp: " + dir)
-			return
-		}
+	if !running {
+		dm.mutex.Lock()
+		dm.dapps[dappId] = dapp
+		dm.mutex.Unlock()
+		logger.Println("Reloaded dapp: " + dappId)
+		return nil
+	}
 
-		oldHash, errH := dc.hashDB.Get([]byte(dapp.path), nil)
+	chain, err := chainFor(dapp)
+	if err != nil {
+		return fmt.Errorf("Error reloading dapp: %s. Keeping the previous version. %s", dappId, err.Error())
+	}
+	if chain != nil && !dm.chainPerDapp() && (ld.chain == nil || !chain.sameChain(ld.chain)) {
+		return errors.New("Error reloading dapp: " + dappId + ". Its chain has changed, unload and load it again to switch chains.")
+	}
 
-		if errH != nil {
-			verify(dapp.path);
-			logger.Printf("Adding new hash '%s' to folder '%s'.\n",hex.EncodeToString(hash),dir)
-			dc.hashDB.Put([]byte(dapp.path),hash,nil)
-		}
+	deps, err := dm.resolveDependencies(dapp)
+	if err != nil {
+		return fmt.Errorf("Error reloading dapp: %s. Keeping the previous version. %s", dappId, err.Error())
+	}
+	// Modules are given the new config before the old runtime is removed,
+	// so a module that refuses it leaves the old version running. The
+	// modules that only the new version uses let go of the dapp again.
+	objs, err := configureModules(dappId, deps)
+	if err != nil {
+		releaseModules(dappId, deps, ld.deps)
+		configureModules(dappId, ld.deps)
+		return fmt.Errorf("Error reloading dapp: %s. Keeping the previous version. %s", dappId, err.Error())
+	}
+	releaseModules(dappId, ld.deps, deps)
+	if chain == nil && ld.chain != nil {
+		dm.releaseChain(dappId)
+	}
 
-		if errH == nil && !bytes.Equal(hash,oldHash) {
-			// TODO this is an old but updated dapp.
-			logger.Printf("Hash mismatch: New: '%s', Old: '%s'.\n",hex.EncodeToString(hash),hex.EncodeToString(oldHash))
-			verify(dapp.path);
-			dc.hashDB.Put([]byte(dapp.path),hash,nil)
-			dc.hashDB.Delete(oldHash,nil)
-		} else {
-			logger.Printf("Hash of '%s' matches the stored value: '%s'.\n", dir, hex.EncodeToString(hash))
-		}
-	*/
+	// Removing the runtime removes all its event subscriptions. The new
+	// models make their own when they are run.
+	dm.rm.RemoveRuntime(dappId)
+	rt := dm.createRuntime(dapp, chain, objs)
 
-	dm.dapps[packageFile.Id] = dapp
+	dm.mutex.Lock()
+	dm.dapps[dappId] = dapp
+	dm.loaded[dappId] = &loadedDapp{dapp, rt, deps, chain}
+	dm.mutex.Unlock()
 
-	// Register the handlers right away.
-	dm.server.RegisterDapp(dapp.packageFile.Id)
+	dm.server.ReloadSessions(dappId)
+	logger.Println("Reloaded dapp: " + dappId)
+	return nil
+}
+
+// A dapp that is loaded and running in its own runtime.
+type loadedDapp struct {
+	dapp  dapps.Dapp
+	rt    scripting.Runtime
+	deps  []*moduleDependency
+	chain *chainConfig
+}
 
-	return
+// The chain a dapp runs on, taken from its monk module dependency.
+type chainConfig struct {
+	ChainId      string
+	RootDir      string
+	RemoteHost   string
+	RemotePort   int
+	RootContract string
+}
+
+// Dapps on the same chain can share the monk module.
+func (cc *chainConfig) sameChain(other *chainConfig) bool {
+	return cc.ChainId == other.ChainId && cc.RootDir == other.RootDir &&
+		cc.RemoteHost == other.RemoteHost && cc.RemotePort == other.RemotePort
 }
 
-// TODO check dependencies.
 func (dm *DappManager) LoadDapp(dappId string) error {
+	lock := dm.dappLock(dappId)
+	lock.Lock()
+	defer lock.Unlock()
 
 	dm.mutex.Lock()
-	defer dm.mutex.Unlock()
 	dapp, ok := dm.dapps[dappId]
+	_, running := dm.loaded[dappId]
+	dm.mutex.Unlock()
 	if !ok {
 		return errors.New("Error loading dapp: " + dappId + ". No dapp with that name has been registered.")
 	}
 
-	if dm.runningDapp != nil {
-		if dm.runningDapp.PackageFile().Id == dappId {
-			return errors.New("Error loading dapp - already running: " + dappId)
-		}
-		dm.UnloadDapp()
+	if running {
+		return errors.New("Error loading dapp - already running: " + dappId)
+	}
+
+	deps, err := dm.resolveDependencies(dapp)
+	if err != nil {
+		return fmt.Errorf("Error loading dapp: %s. %s", dappId, err.Error())
+	}
+	chain, err := chainFor(dapp)
+	if err != nil {
+		return fmt.Errorf("Error loading dapp: %s. %s", dappId, err.Error())
 	}
 
 	logger.Println("Loading dapp: " + dappId)
 
-	rt := dm.rm.CreateRuntime(dappId)
+	if chain != nil {
+		if err := dm.useChain(dappId, chain); err != nil {
+			return fmt.Errorf("Error loading dapp: %s. %s", dappId, err.Error())
+		}
+	}
 
-	// Monk hack until we script
-	deps := dapp.PackageFile().ModuleDependencies
+	objs, err := configureModules(dappId, deps)
+	if err != nil {
+		releaseModules(dappId, deps, nil)
+		if chain != nil {
+			dm.releaseChain(dappId)
+		}
+		return fmt.Errorf("Error loading dapp: %s. %s", dappId, err.Error())
+	}
+
+	rt := dm.createRuntime(dapp, chain, objs)
+	dm.mutex.Lock()
+	dm.loaded[dappId] = &loadedDapp{dapp, rt, deps, chain}
+	dm.mutex.Unlock()
+	return nil
+}
 
-	// TODO This module-implementation dependent block is only temporary. Once the
-	// package.json file includes paths to config files, it will be possible to just
-	// add the config file to the module when restarting it. No need to worry about
-	// specific field names (or their types).
-	if deps != nil {
-		for _, d := range deps {
-			if d.Name == "monk" {
-				mData := d.Data
-				if mData != nil {
-					monkData := &dapps.MonkData{}
-					err := json.Unmarshal(*mData, monkData)
-					if err != nil {
-						logger.Fatal("Blockchain will not work. Chain data for monk not available in dapp package file: " + dapp.PackageFile().Name)
-					}
-					monkMod, ok := dm.mm.Modules()["monk"]
-					if !ok {
-						logger.Fatal("Blockchain will not work. There is no Monk module.")
-					}
-					psAddr := monkData.PeerServerAddress
-					addAndPort := strings.Split(psAddr, ":")
-					if len(addAndPort) != 2 {
-						logger.Fatal("Blockchain will not work. Malformed peerserver url: " + psAddr)
-					}
-
-					port, pErr := strconv.Atoi(addAndPort[1])
-					if pErr != nil {
-						logger.Fatal("Blockchain will not work. Malformed peerserver url (port not an integer)")
-					}
-
-					chainId := utils.StripHex(monkData.ChainId)
-					monkMod.SetProperty("RootDir", chains.ComposeRoot("thelonious", chainId))
-					monkMod.SetProperty("RemoteHost", addAndPort[0])
-					monkMod.SetProperty("RemotePort", port)
-					monkMod.SetProperty("ChainId", monkData.ChainId)
-					
-					monkMod.Restart()
-					rc := monkData.RootContract
-					if(len(rc) > 2){
-						if(rc[1] != 'x'){
-							rc = "0x" + rc;
-						}
-						logger.Println("Root contract: " + rc )
-						rt.BindScriptObject("RootContract", rc)	
-					}
-				} else {
-					logger.Fatal("Blockchain will not work. Chain data for monk not available in dapp package file: " + dapp.PackageFile().Name)
-				}
+// A monk module that is configurable runs each dapp on its own chain: it
+// gets the chain data of the dapp in ConfigureDapp, and gives the dapp its
+// own objects for it. A plain monk module runs one chain for all dapps.
+func (dm *DappManager) chainPerDapp() bool {
+	_, ok := dm.mm.Modules()["monk"].(modules.ConfigurableModule)
+	return ok
+}
+
+// Switch the monk module to the chain of a dapp. If the module runs one
+// chain for all dapps, the loaded dapps that use it must be on the same
+// chain.
+func (dm *DappManager) useChain(dappId string, chain *chainConfig) error {
+	monkMod, ok := dm.mm.Modules()["monk"]
+	if !ok {
+		return errors.New("Blockchain will not work. There is no Monk module.")
+	}
+	if dm.chainPerDapp() {
+		return nil
+	}
+
+	dm.chainMutex.Lock()
+	defer dm.chainMutex.Unlock()
+
+	if dm.activeChain != nil && !dm.activeChain.sameChain(chain) {
+		for id, _ := range dm.chainUsers {
+			if id != dappId {
+				return errors.New("Dapp '" + id + "' is running on a different chain (" + dm.activeChain.ChainId + "), and the monk module can only run one chain at a time.")
 			}
 		}
 	}
 
-	for _, js := range dapp.Models() {
-		rt.AddScript(js)
-	}
+	if dm.activeChain == nil || !dm.activeChain.sameChain(chain) {
+		monkMod.SetProperty("RootDir", chain.RootDir)
+		monkMod.SetProperty("RemoteHost", chain.RemoteHost)
+		monkMod.SetProperty("RemotePort", chain.RemotePort)
+		monkMod.SetProperty("ChainId", chain.ChainId)
 
-	dm.runningDapp = dapp
+		if err := monkMod.Restart(); err != nil {
+			dm.activeChain = nil
+			return errors.New("Failed to switch the monk module to chain " + chain.ChainId + ": " + err.Error())
+		}
+		dm.activeChain = chain
+	}
+	dm.chainUsers[dappId] = true
 	return nil
 }
 
-func (dm *DappManager) UnloadDapp() {
-	// TODO cleanup
-	dappId := dm.runningDapp.PackageFile().Id
-	logger.Println("Unregistering dapp: " + dappId)
-	dm.rm.RemoveRuntime(dappId)
-	dm.runningDapp = nil
+// The dapp no longer uses the chain of the monk module.
+func (dm *DappManager) releaseChain(dappId string) {
+	dm.chainMutex.Lock()
+	defer dm.chainMutex.Unlock()
+	delete(dm.chainUsers, dappId)
 }
 
-/*
-func (dc *DappRegistry) HashApp(dir string) []byte {
-	logger.Println("Hashing models folder: " + dir)
-	hashes := dc.HashDir(dir)
-	if hashes == nil {
-		return nil
+// Create a runtime for the dapp, with the objects from its modules and
+// the api objects that its capabilities grant, and run its models.
+func (dm *DappManager) createRuntime(dapp dapps.Dapp, chain *chainConfig, objs map[string]interface{}) scripting.Runtime {
+	if chain != nil && chain.RootContract != "" {
+		logger.Println("Root contract: " + chain.RootContract)
+		objs["RootContract"] = chain.RootContract
+	}
+
+	var rt scripting.Runtime
+	if dapp.Sandboxed() {
+		rt = dm.rm.CreateSandboxedRuntime(dapp.PackageFile().Id)
+	} else {
+		rt = dm.rm.CreateRestrictedRuntime(dapp.PackageFile().Id, dm.grantCapabilities(dapp), objs)
+	}
+
+	// Models can 'require' other files in the models directory. If the dapp
+	// was verified, only the files that were.
+	rt.SetBaseDir(path.Join(dapp.Path(), dapps.MODELS_FOLDER_NAME))
+	if files := dapp.VerifiedFiles(); files != nil {
+		verified := make(map[string]string)
+		for name, hash := range files {
+			verified[filepath.Join(dapp.Path(), filepath.FromSlash(name))] = hash
+		}
+		rt.SetVerifiedFiles(verified)
+	}
+	for _, js := range dapp.Models() {
+		rt.AddScript(js)
 	}
-	hash := sha1.Sum(hashes)
-	return hash[:]
+	return rt
 }
-*/
 
-/*
-func (dc *DappRegistry) HashDir(directory string) []byte {
-	files, err := ioutil.ReadDir(directory)
-	if err != nil {
-		logger.Println(err.Error())
-		return nil
+// Sandboxed dapps don't get a chain.
+func chainFor(dapp dapps.Dapp) (*chainConfig, error) {
+	if dapp.Sandboxed() {
+		return nil, nil
 	}
-	if len(files) == 0 {
-		logger.Println("No files in directory: " + directory)
-		return nil
+	return monkConfig(dapp)
+}
+
+// Check the dapp against the trust store. If it can't be verified the
+// dapp policy decides what happens; an error means the dapp is refused.
+func (dm *DappManager) verifyDapp(dapp *Dapp) error {
+	id := dapp.packageFile.Id
+	ts, err := LoadTrustStore(dm.fio)
+	if err == nil {
+		var mf *dapps.Manifest
+		mf, err = verifyManifest(dapp.path, ts)
+		if err == nil {
+			logger.Printf("Dapp '%s' verified. Publisher: %s\n", id, mf.Publisher)
+			dapp.publisher = mf.Publisher
+			dapp.files = mf.Files
+			return nil
+		}
 	}
-	hashes := make([]byte, 0)
-	for _, fileInfo := range files {
-		if fileInfo.IsDir() {
-			// This is a dapp
-			pth := path.Join(directory, fileInfo.Name())
-			hs := dc.HashDir(pth)
-			if hs != nil {
-				hashes = append(hashes, hs...)
-			} else {
-				return nil
-			}
-		} else {
-			fBts, errF := ioutil.ReadFile(path.Join(directory, fileInfo.Name()))
-			if errF != nil {
-				logger.Printf(" Error loading '%s', skipping...\n", fileInfo.Name())
-				logger.Println(errF.Error())
-				return nil
+
+	switch dm.config().DappPolicy {
+	case decerver.DAPP_POLICY_REFUSE:
+		return fmt.Errorf("Dapp '%s' could not be verified: %s", id, err.Error())
+	case decerver.DAPP_POLICY_ALLOW:
+		logger.Printf("WARNING: Dapp '%s' could not be verified: %s. Running it anyway.\n", id, err.Error())
+	default:
+		logger.Printf("Dapp '%s' could not be verified: %s. It will be sandboxed.\n", id, err.Error())
+		dapp.sandboxed = true
+	}
+	return nil
+}
+
+// Get the chain config from the monk dependency in the package file.
+// Returns nil if the dapp does not depend on monk.
+func monkConfig(dapp dapps.Dapp) (*chainConfig, error) {
+	deps := dapp.PackageFile().ModuleDependencies
+	for _, d := range deps {
+		if d.Name != "monk" {
+			continue
+		}
+		mData := d.Data
+		if mData == nil {
+			return nil, errors.New("Blockchain will not work. Chain data for monk not available in dapp package file: " + dapp.PackageFile().Name)
+		}
+		monkData := &dapps.MonkData{}
+		err := json.Unmarshal(*mData, monkData)
+		if err != nil {
+			return nil, errors.New("Blockchain will not work. Chain data for monk not available in dapp package file: " + dapp.PackageFile().Name)
+		}
+		psAddr := monkData.PeerServerAddress
+		addAndPort := strings.Split(psAddr, ":")
+		if len(addAndPort) != 2 {
+			return nil, errors.New("Blockchain will not work. Malformed peerserver url: " + psAddr)
+		}
+
+		port, pErr := strconv.Atoi(addAndPort[1])
+		if pErr != nil {
+			return nil, errors.New("Blockchain will not work. Malformed peerserver url (port not an integer)")
+		}
+
+		chainId := utils.StripHex(monkData.ChainId)
+		cc := &chainConfig{
+			ChainId:    monkData.ChainId,
+			RootDir:    chains.ComposeRoot("thelonious", chainId),
+			RemoteHost: addAndPort[0],
+			RemotePort: port,
+		}
+		rc := monkData.RootContract
+		if len(rc) > 2 {
+			if rc[1] != 'x' {
+				rc = "0x" + rc
 			}
-			hash := sha1.Sum(fBts)
-			hashes = append(hashes, hash[:]...)
+			cc.RootContract = rc
 		}
+		return cc, nil
 	}
-	return hashes
+	return nil, nil
+}
+
+// Unload a dapp. Its websocket sessions are closed and its runtime
+// (along with its event subscriptions) is removed.
+func (dm *DappManager) UnloadDapp(dappId string) error {
+	lock := dm.dappLock(dappId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	dm.mutex.Lock()
+	ld, ok := dm.loaded[dappId]
+	delete(dm.loaded, dappId)
+	dm.mutex.Unlock()
+	if !ok {
+		return errors.New("Error unloading dapp - not running: " + dappId)
+	}
+	logger.Println("Unregistering dapp: " + dappId)
+	dm.server.CloseSessions(dappId)
+	dm.rm.RemoveRuntime(dappId)
+	releaseModules(dappId, ld.deps, nil)
+	if ld.chain != nil {
+		dm.releaseChain(dappId)
+	}
+	return nil
+}
+
+func (dm *DappManager) LoadedDapps() []string {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	ids := make([]string, 0, len(dm.loaded))
+	for id, _ := range dm.loaded {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (dm *DappManager) IsLoaded(dappId string) bool {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	_, ok := dm.loaded[dappId]
+	return ok
 }
-*/
 
 func (dm *DappManager) DappList() []*dapps.DappInfo {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
 	arr := make([]*dapps.DappInfo, len(dm.dapps))
 	ctr := 0
-	for _, dapp := range dm.dapps {
+	for id, dapp := range dm.dapps {
 		arr[ctr] = dapps.DappInfoFromPackageFile(dapp.PackageFile())
+		_, arr[ctr].Loaded = dm.loaded[id]
+		arr[ctr].Publisher = dapp.Publisher()
+		arr[ctr].Sandboxed = dapp.Sandboxed()
+		if dapp.Sandboxed() {
+			arr[ctr].Capabilities = []string{}
+		} else {
+			arr[ctr].Capabilities, _, _ = dm.capabilities(dapp)
+		}
 		ctr++
 	}
 	return arr
 }
-
-/*
-func getVerification(string dappName) bool {
-
-}
-*/
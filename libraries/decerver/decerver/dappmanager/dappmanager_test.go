@@ -0,0 +1,302 @@
+package dappmanager
+
+import (
+	"github.com/eris-ltd/decerver/fileio"
+	"github.com/eris-ltd/decerver/interfaces/dapps"
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"github.com/eris-ltd/decerver/interfaces/events"
+	"github.com/eris-ltd/decerver/interfaces/files"
+	"github.com/eris-ltd/decerver/interfaces/network"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"github.com/eris-ltd/decerver/modulemanager"
+	"github.com/eris-ltd/decerver/runtimemanager"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	network.Server
+}
+
+func (s *testServer) RegisterDapp(dappId string)   {}
+func (s *testServer) CloseSessions(dappId string)  {}
+func (s *testServer) ReloadSessions(dappId string) {}
+
+// Runtimes for the dapp 'slow' hang running their models until released.
+type testRuntimeManager struct {
+	scripting.RuntimeManager
+	release chan struct{}
+}
+
+func (rm *testRuntimeManager) CreateSandboxedRuntime(id string) scripting.Runtime {
+	return &testRuntime{id: id, release: rm.release}
+}
+
+func (rm *testRuntimeManager) RemoveRuntime(id string) {}
+
+type testRuntime struct {
+	scripting.Runtime
+	id      string
+	release chan struct{}
+}
+
+func (rt *testRuntime) SetBaseDir(dir string) {}
+
+func (rt *testRuntime) AddScript(script string) error {
+	if rt.id == "slow" {
+		<-rt.release
+	}
+	return nil
+}
+
+// Enough of a decerver to make a runtime manager.
+type testDecerver struct {
+	decerver.Decerver
+	fio files.FileIO
+}
+
+func (dc *testDecerver) Config() *decerver.DCConfig            { return &decerver.DCConfig{} }
+func (dc *testDecerver) FileIO() files.FileIO                  { return dc.fio }
+func (dc *testDecerver) EventProcessor() events.EventProcessor { return nil }
+
+func newTestDappManager(t *testing.T) *DappManager {
+	root, err := ioutil.TempDir("", "decerver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &decerver.DCConfig{}
+	return &DappManager{
+		mutex:      &sync.Mutex{},
+		keys:       make(map[string]string),
+		dapps:      make(map[string]dapps.Dapp),
+		loaded:     make(map[string]*loadedDapp),
+		watchers:   make(map[string]*dappWatcher),
+		dappLocks:  make(map[string]*sync.Mutex),
+		chainMutex: &sync.Mutex{},
+		chainUsers: make(map[string]bool),
+		rm:         &testRuntimeManager{release: make(chan struct{})},
+		server:     &testServer{},
+		mm:         modulemanager.NewModuleManager(),
+		fio:        fileio.NewFileIO(root),
+		config:     func() *decerver.DCConfig { return cfg },
+	}
+}
+
+func writeIdDapp(t *testing.T, id string) string {
+	dir := writeTestDapp(t)
+	if err := ioutil.WriteFile(path.Join(dir, "package.json"), []byte(`{"id":"`+id+`"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// A dapp whose models hang doesn't hold up the others.
+func TestLoadDappsIndependently(t *testing.T) {
+	dm := newTestDappManager(t)
+	defer os.RemoveAll(dm.fio.Root())
+	for _, id := range []string{"slow", "fast"} {
+		dir := writeIdDapp(t, id)
+		defer os.RemoveAll(dir)
+		dm.RegisterDapp(dir)
+		defer dm.watchers[id].Stop()
+	}
+
+	slowDone := make(chan error)
+	go func() { slowDone <- dm.LoadDapp("slow") }()
+	time.Sleep(10 * time.Millisecond)
+
+	fastDone := make(chan error)
+	go func() { fastDone <- dm.LoadDapp("fast") }()
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("loading a dapp was blocked by another dapp's models")
+	}
+	if !dm.IsLoaded("fast") || dm.IsLoaded("slow") {
+		t.Fatalf("unexpected loaded dapps: %v", dm.LoadedDapps())
+	}
+
+	close(dm.rm.(*testRuntimeManager).release)
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+	if !dm.IsLoaded("slow") {
+		t.Fatal("slow dapp was not loaded")
+	}
+}
+
+// Registering a dapp again replaces its watcher.
+func TestRegisterDappStopsWatcher(t *testing.T) {
+	dm := newTestDappManager(t)
+	defer os.RemoveAll(dm.fio.Root())
+	dir := writeIdDapp(t, "dapp")
+	defer os.RemoveAll(dir)
+
+	dm.RegisterDapp(dir)
+	old := dm.watchers["dapp"]
+	dm.RegisterDapp(dir)
+	defer dm.watchers["dapp"].Stop()
+
+	if dm.watchers["dapp"] == old {
+		t.Fatal("watcher was not replaced")
+	}
+	select {
+	case <-old.stop:
+	default:
+		t.Fatal("old watcher was not stopped")
+	}
+}
+
+// A reload that a module refuses releases the modules that only the new
+// version uses, and keeps the old ones.
+func TestReloadReleasesModules(t *testing.T) {
+	dm := newTestDappManager(t)
+	defer os.RemoveAll(dm.fio.Root())
+	dm.config().DappPolicy = decerver.DAPP_POLICY_ALLOW
+	mods := make(map[string]*testModule)
+	for _, name := range []string{"a", "b", "bad"} {
+		mods[name] = &testModule{name: name, version: "1.0.0", configured: make(map[string]map[string]interface{})}
+		dm.mm.Add(mods[name])
+	}
+	mods["bad"].fail = true
+
+	dir := writeIdDapp(t, "test")
+	defer os.RemoveAll(dir)
+	old := testDapp(`[{"name":"a","version":"1"}]`)
+	old.path = dir
+	deps, err := dm.resolveDependencies(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureModules("test", deps)
+	dm.dapps["test"] = old
+	dm.loaded["test"] = &loadedDapp{old, nil, deps, nil}
+
+	pkg := `{"id":"test","module_dependencies":[{"name":"a","version":"1"},{"name":"b","version":"1"},{"name":"bad","version":"1"}]}`
+	if err := ioutil.WriteFile(path.Join(dir, "package.json"), []byte(pkg), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.ReloadDapp("test"); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("expected module 'bad' to refuse the reload, got %v", err)
+	}
+	if _, ok := mods["b"].configured["test"]; ok {
+		t.Fatal("module of the failed version was not released")
+	}
+	if _, ok := mods["a"].configured["test"]; !ok {
+		t.Fatal("module of the running version was released")
+	}
+	if dm.dapps["test"] != old {
+		t.Fatal("the old version was replaced")
+	}
+}
+
+// Dapps share the chain of the monk module, and it is switched when no
+// loaded dapp uses it.
+func TestUseChain(t *testing.T) {
+	dm := newTestDappManager(t)
+	defer os.RemoveAll(dm.fio.Root())
+	monk := &plainModule{name: "monk", props: make(map[string]interface{})}
+	dm.mm.Add(monk)
+
+	x := &chainConfig{ChainId: "x", RemoteHost: "localhost", RemotePort: 30303}
+	y := &chainConfig{ChainId: "y", RemoteHost: "localhost", RemotePort: 30303}
+	if err := dm.useChain("a", x); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.useChain("b", &chainConfig{ChainId: "x", RemoteHost: "localhost", RemotePort: 30303}); err != nil {
+		t.Fatal(err)
+	}
+	if monk.restarts != 1 || monk.props["ChainId"] != "x" {
+		t.Fatalf("expected one switch to chain x, got %d restarts and %v", monk.restarts, monk.props)
+	}
+	if err := dm.useChain("c", y); err == nil {
+		t.Fatal("expected the chain to be in use")
+	}
+
+	dm.releaseChain("a")
+	dm.releaseChain("b")
+	if err := dm.useChain("c", y); err != nil {
+		t.Fatal(err)
+	}
+	if monk.restarts != 2 || monk.props["ChainId"] != "y" {
+		t.Fatalf("expected a switch to chain y, got %d restarts and %v", monk.restarts, monk.props)
+	}
+}
+
+// A configurable monk module runs each dapp on its own chain, so dapps on
+// different chains can be loaded together.
+func TestUseChainPerDapp(t *testing.T) {
+	dm := newTestDappManager(t)
+	defer os.RemoveAll(dm.fio.Root())
+	dm.mm.Add(&testModule{name: "monk", version: "1.0.0", configured: make(map[string]map[string]interface{})})
+
+	x := &chainConfig{ChainId: "x", RemoteHost: "localhost", RemotePort: 30303}
+	y := &chainConfig{ChainId: "y", RemoteHost: "localhost", RemotePort: 30303}
+	if err := dm.useChain("a", x); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.useChain("b", y); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Dapps only get the capabilities they list, and the operator can take
+// them away.
+func TestCapabilities(t *testing.T) {
+	dm := newTestDappManager(t)
+	defer os.RemoveAll(dm.fio.Root())
+
+	caps, _, err := dm.capabilities(testDapp(`[]`))
+	if err != nil || caps == nil || len(caps) != 0 {
+		t.Fatalf("expected no capabilities, got %v (%v)", caps, err)
+	}
+
+	dapp := testDapp(`[]`)
+	dapp.packageFile.Capabilities = []string{"blockchain.read", "blockchain.transact"}
+	overrides := `{"test": ["blockchain.read"]}`
+	if err := ioutil.WriteFile(path.Join(dm.fio.Root(), CAPABILITIES_FILE_NAME), []byte(overrides), 0600); err != nil {
+		t.Fatal(err)
+	}
+	caps, denied, err := dm.capabilities(dapp)
+	if err != nil || len(caps) != 1 || caps[0] != "blockchain.read" || len(denied) != 1 {
+		t.Fatalf("expected only blockchain.read, got %v, denied %v (%v)", caps, denied, err)
+	}
+	caps, _, _ = dm.capabilities(testDapp(`[]`))
+	if len(caps) != 0 {
+		t.Fatalf("override gave capabilities the dapp does not list: %v", caps)
+	}
+}
+
+// A dapp that isn't sandboxed gets the root contract of its chain, though no
+// capability grants it.
+func TestRootContract(t *testing.T) {
+	dm := newTestDappManager(t)
+	defer os.RemoveAll(dm.fio.Root())
+	if err := dm.fio.InitPaths(); err != nil {
+		t.Fatal(err)
+	}
+	dm.rm = runtimemanager.NewRuntimeManager(&testDecerver{fio: dm.fio})
+	defer dm.rm.ShutdownRuntimes()
+
+	dapp := testDapp(`[{"name":"monk","data":{"root_contract":"abcd","blockchain_id":"x","peer_server_address":"localhost:30303"}}]`)
+	chain, err := chainFor(dapp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := dm.createRuntime(dapp, chain, make(map[string]interface{}))
+	if err := rt.AddScript("function rootContract() { return RootContract; }"); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := rt.CallFunc("rootContract")
+	if err != nil || rc != "0xabcd" {
+		t.Fatalf("expected the root contract 0xabcd, got %v (%v)", rc, err)
+	}
+}
@@ -0,0 +1,104 @@
+package dappmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/dapps"
+	"github.com/eris-ltd/decerver/interfaces/modules"
+	"github.com/eris-ltd/decerver/util"
+	"strings"
+)
+
+// A module dependency of a dapp that has been checked against the
+// module, along with the config data the dapp has for it.
+type moduleDependency struct {
+	module modules.Module
+	config map[string]interface{}
+}
+
+// Check the module dependencies in the package file of a dapp. Every module
+// must be loaded. For configurable modules, the version must be in the range
+// the dapp asks for, and the config data must match the config schema of the
+// module (other modules have no version, so the ranges for them are only
+// warned about). Sandboxed dapps have no access to the modules, so they have no
+// dependencies.
+func (dm *DappManager) resolveDependencies(dapp dapps.Dapp) ([]*moduleDependency, error) {
+	if dapp.Sandboxed() {
+		return nil, nil
+	}
+	deps := []*moduleDependency{}
+	for _, d := range dapp.PackageFile().ModuleDependencies {
+		m, ok := dm.mm.Modules()[d.Name]
+		if !ok {
+			return nil, fmt.Errorf("Missing module dependency: %s", d.Name)
+		}
+		mod, ok := m.(modules.ConfigurableModule)
+		if !ok {
+			// Its config data is not checked, and can be read from the
+			// package file (like the chain data for monk).
+			if v := strings.TrimSpace(d.Version); v != "" && v != "*" {
+				logger.Printf("WARNING: Module '%s' does not have a version, so the dapp's requirement (%s) can't be checked.\n", d.Name, v)
+			}
+			deps = append(deps, &moduleDependency{m, nil})
+			continue
+		}
+
+		ok, err := util.SatisfiesRange(mod.Version(), d.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Bad version for module dependency '%s': %s", d.Name, err.Error())
+		}
+		if !ok {
+			return nil, fmt.Errorf("Module '%s' has version %s, the dapp requires %s.", d.Name, mod.Version(), d.Version)
+		}
+
+		config := make(map[string]interface{})
+		if d.Data != nil {
+			if err := json.Unmarshal(*d.Data, &config); err != nil {
+				return nil, fmt.Errorf("Config data for module '%s' is not a json object: %s", d.Name, err.Error())
+			}
+		}
+		schema := mod.ConfigSchema()
+		if schema == nil {
+			if len(config) != 0 {
+				return nil, fmt.Errorf("Module '%s' does not take any config data.", d.Name)
+			}
+		} else if err := schema.Validate(config); err != nil {
+			return nil, fmt.Errorf("Bad config data for module '%s': %s", d.Name, err.Error())
+		}
+		deps = append(deps, &moduleDependency{mod, config})
+	}
+	return deps, nil
+}
+
+// Pass the config data of a dapp to its modules. Returns the objects
+// that the modules want bound in the runtime of the dapp.
+func configureModules(dappId string, deps []*moduleDependency) (map[string]interface{}, error) {
+	objs := make(map[string]interface{})
+	for _, d := range deps {
+		mod, ok := d.module.(modules.ConfigurableModule)
+		if !ok {
+			continue
+		}
+		modObjs, err := mod.ConfigureDapp(dappId, d.config)
+		if err != nil {
+			return nil, fmt.Errorf("Module '%s' failed to configure the dapp: %s", d.module.Name(), err.Error())
+		}
+		for name, obj := range modObjs {
+			objs[name] = obj
+		}
+	}
+	return objs, nil
+}
+
+// Release the dapp from the modules in 'deps' that are not in 'keep'.
+func releaseModules(dappId string, deps, keep []*moduleDependency) {
+	kept := make(map[string]bool)
+	for _, d := range keep {
+		kept[d.module.Name()] = true
+	}
+	for _, d := range deps {
+		if mod, ok := d.module.(modules.ConfigurableModule); ok && !kept[d.module.Name()] {
+			mod.ReleaseDapp(dappId)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package dappmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/eris-ltd/decerver/interfaces/dapps"
+	"github.com/eris-ltd/decerver/interfaces/modules"
+	"github.com/eris-ltd/decerver/modulemanager"
+	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
+	"strings"
+	"testing"
+)
+
+type testModule struct {
+	name       string
+	version    string
+	schema     *modules.ConfigSchema
+	configured map[string]map[string]interface{}
+	fail       bool
+}
+
+// A module that is not configurable, like the ones built before
+// modules had versions.
+type plainModule struct {
+	modules.Module
+	name     string
+	props    map[string]interface{}
+	restarts int
+}
+
+func (m *plainModule) Name() string                              { return m.name }
+func (m *plainModule) SetProperty(name string, data interface{}) { m.props[name] = data }
+func (m *plainModule) Restart() error {
+	m.restarts++
+	return nil
+}
+
+func (m *testModule) Register(dc modules.DecerverModuleApi) error { return nil }
+func (m *testModule) Init() error                                 { return nil }
+func (m *testModule) Start() error                                { return nil }
+func (m *testModule) Restart() error                              { return nil }
+func (m *testModule) Shutdown() error                             { return nil }
+func (m *testModule) Name() string                                { return m.name }
+func (m *testModule) Version() string                             { return m.version }
+func (m *testModule) ConfigSchema() *modules.ConfigSchema         { return m.schema }
+func (m *testModule) ModuleConfigSchema() *modules.ConfigSchema   { return nil }
+func (m *testModule) ReleaseDapp(dappId string)                   { delete(m.configured, dappId) }
+func (m *testModule) UnSubscribe(name string)                     {}
+func (m *testModule) SetProperty(name string, data interface{})   {}
+func (m *testModule) Property(name string) interface{}            { return nil }
+func (m *testModule) Subscribe(name, event, target string) chan types.Event {
+	return nil
+}
+
+func (m *testModule) ConfigureDapp(dappId string, config map[string]interface{}) (map[string]interface{}, error) {
+	if m.fail {
+		return nil, errors.New("refused")
+	}
+	m.configured[dappId] = config
+	return map[string]interface{}{"Root": config["root"]}, nil
+}
+
+func testDapp(deps string) *Dapp {
+	dapp := newDapp()
+	dapp.packageFile = &dapps.PackageFile{Id: "test"}
+	json.Unmarshal([]byte(deps), &dapp.packageFile.ModuleDependencies)
+	return dapp
+}
+
+func TestResolveDependencies(t *testing.T) {
+	mod := &testModule{
+		name:    "chain",
+		version: "1.2.0",
+		schema: &modules.ConfigSchema{Fields: []*modules.ConfigField{
+			{Name: "root", Type: modules.CONFIG_STRING, Required: true},
+			{Name: "port", Type: modules.CONFIG_NUMBER},
+		}},
+		configured: make(map[string]map[string]interface{}),
+	}
+	dm := &DappManager{mm: modulemanager.NewModuleManager()}
+	dm.mm.Add(mod)
+	dm.mm.Add(&plainModule{name: "plain"})
+
+	errs := map[string]string{
+		`[{"name":"ipfs","version":"1.0.0"}]`:                                  "Missing module",
+		`[{"name":"chain","version":"^2.0.0","data":{"root":"0x1"}}]`:          "requires ^2.0.0",
+		`[{"name":"chain","version":"^1.0.0"}]`:                                "Missing required field: root",
+		`[{"name":"chain","version":"^1.0.0","data":{"root":1}}]`:              "should be of type",
+		`[{"name":"chain","version":"^1.0.0","data":{"root":"0x1","a":true}}]`: "Unknown field",
+	}
+	for deps, msg := range errs {
+		_, err := dm.resolveDependencies(testDapp(deps))
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: expected error '%s', got %v", deps, msg, err)
+		}
+	}
+
+	// Modules that are not configurable take any version and data.
+	resolved, err := dm.resolveDependencies(testDapp(`[{"name":"chain","version":"~1.2","data":{"root":"0x1","port":30303}},{"name":"plain","version":"9.0.0","data":{"a":1}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := configureModules("test", resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objs["Root"] != "0x1" || mod.configured["test"]["port"] != float64(30303) {
+		t.Fatalf("module not configured: %v %v", objs, mod.configured)
+	}
+	releaseModules("test", resolved, nil)
+	if _, ok := mod.configured["test"]; ok {
+		t.Fatal("dapp not released")
+	}
+}
@@ -0,0 +1,227 @@
+package dappmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/dapps"
+	"github.com/eris-ltd/decerver/interfaces/files"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// Dapp packages are verified using a manifest with the hashes of all the files
+// in the dapp, signed by the publisher of the dapp (ECDSA, P-256). Publishers
+// are trusted by adding their public key to the trust store.
+
+// The trust store file in the decerver root.
+const TRUST_STORE_FILE_NAME = "trusted_publishers"
+
+// Publisher name -> hex encoded public key (PKIX, DER).
+type TrustStore map[string]string
+
+// Load the trust store from the decerver root. A missing file means
+// that no publishers are trusted.
+func LoadTrustStore(fio files.FileIO) (TrustStore, error) {
+	ts := make(TrustStore)
+	err := fio.UnmarshalJsonFromFile(fio.Root(), TRUST_STORE_FILE_NAME, &ts)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return ts, nil
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// Hex encoded private key (SEC 1, DER).
+func MarshalPrivateKey(key *ecdsa.PrivateKey) (string, error) {
+	bts, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bts), nil
+}
+
+func ParsePrivateKey(hexKey string) (*ecdsa.PrivateKey, error) {
+	bts, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseECPrivateKey(bts)
+}
+
+// Hex encoded public key (PKIX, DER). This is the format used in the trust store.
+func MarshalPublicKey(pub *ecdsa.PublicKey) (string, error) {
+	bts, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bts), nil
+}
+
+func ParsePublicKey(hexKey string) (*ecdsa.PublicKey, error) {
+	bts, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(bts)
+	if err != nil {
+		return nil, err
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Not an ecdsa public key.")
+	}
+	return ecPub, nil
+}
+
+// Get the sha256 hash (hex) of every file in the dapp directory, keyed by
+// the path relative to it. The manifest and its signature are left out.
+func HashDir(dir string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := filepath.Walk(dir, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, fp)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == dapps.MANIFEST_FILE_NAME || rel == dapps.SIGNATURE_FILE_NAME {
+			return nil
+		}
+		bts, err := ioutil.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(bts)
+		hashes[rel] = hex.EncodeToString(hash[:])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// Write a manifest for the dapp in 'dir' and sign it with the publishers key.
+func SignDapp(dir, publisher string, key *ecdsa.PrivateKey) error {
+	hashes, err := HashDir(dir)
+	if err != nil {
+		return err
+	}
+	mf := &dapps.Manifest{Publisher: publisher, Files: hashes}
+	mfBts, err := json.MarshalIndent(mf, "", "    ")
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(mfBts)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return err
+	}
+	sig, err := asn1.Marshal(ecdsaSignature{r, s})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, dapps.MANIFEST_FILE_NAME), mfBts, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, dapps.SIGNATURE_FILE_NAME), []byte(hex.EncodeToString(sig)), 0600)
+}
+
+// Verify the dapp in 'dir'. The manifest must be signed by a publisher
+// in the trust store, and the files must match it exactly. Returns the
+// name of the publisher.
+func VerifyDapp(dir string, ts TrustStore) (string, error) {
+	mf, err := verifyManifest(dir, ts)
+	if err != nil {
+		return "", err
+	}
+	return mf.Publisher, nil
+}
+
+// Verify the dapp in 'dir' and return its manifest.
+func verifyManifest(dir string, ts TrustStore) (*dapps.Manifest, error) {
+	mfBts, err := ioutil.ReadFile(path.Join(dir, dapps.MANIFEST_FILE_NAME))
+	if err != nil {
+		return nil, fmt.Errorf("No manifest: %s", err.Error())
+	}
+	sigBts, err := ioutil.ReadFile(path.Join(dir, dapps.SIGNATURE_FILE_NAME))
+	if err != nil {
+		return nil, fmt.Errorf("No manifest signature: %s", err.Error())
+	}
+
+	mf := &dapps.Manifest{}
+	if err := json.Unmarshal(mfBts, mf); err != nil {
+		return nil, fmt.Errorf("The manifest is corrupted: %s", err.Error())
+	}
+
+	hexKey, ok := ts[mf.Publisher]
+	if !ok {
+		return nil, fmt.Errorf("Publisher '%s' is not in the trust store.", mf.Publisher)
+	}
+	pub, err := ParsePublicKey(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("Bad key for publisher '%s' in the trust store: %s", mf.Publisher, err.Error())
+	}
+
+	derSig, err := hex.DecodeString(string(sigBts))
+	if err != nil {
+		return nil, fmt.Errorf("The manifest signature is corrupted: %s", err.Error())
+	}
+	sig := &ecdsaSignature{}
+	if _, err := asn1.Unmarshal(derSig, sig); err != nil {
+		return nil, fmt.Errorf("The manifest signature is corrupted: %s", err.Error())
+	}
+	hash := sha256.Sum256(mfBts)
+	if !ecdsa.Verify(pub, hash[:], sig.R, sig.S) {
+		return nil, fmt.Errorf("Bad manifest signature for publisher '%s'.", mf.Publisher)
+	}
+
+	hashes, err := HashDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(hashes))
+	for name, _ := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		expected, ok := mf.Files[name]
+		if !ok {
+			return nil, fmt.Errorf("File not in manifest: %s", name)
+		}
+		if expected != hashes[name] {
+			return nil, fmt.Errorf("Hash mismatch for file: %s", name)
+		}
+	}
+	for name, _ := range mf.Files {
+		if _, ok := hashes[name]; !ok {
+			return nil, fmt.Errorf("File in manifest is missing: %s", name)
+		}
+	}
+	return mf, nil
+}
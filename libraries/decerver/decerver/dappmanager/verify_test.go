@@ -0,0 +1,86 @@
+package dappmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestDapp(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(path.Join(dir, "models"), 0700)
+	files := map[string]string{
+		"package.json":       `{"id":"test"}`,
+		"index.html":         "<html></html>",
+		"models/config.json": `{"loading_order":["a.js"]}`,
+		"models/a.js":        "var a = 5;",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestVerifyDapp(t *testing.T) {
+	dir := writeTestDapp(t)
+	defer os.RemoveAll(dir)
+
+	key, _ := GenerateKey()
+	if err := SignDapp(dir, "eris", key); err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := MarshalPublicKey(&key.PublicKey)
+
+	if _, err := VerifyDapp(dir, TrustStore{}); err == nil {
+		t.Fatal("verified a dapp from an untrusted publisher")
+	}
+
+	publisher, err := VerifyDapp(dir, TrustStore{"eris": pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publisher != "eris" {
+		t.Fatalf("got publisher %s", publisher)
+	}
+
+	other, _ := GenerateKey()
+	otherPub, _ := MarshalPublicKey(&other.PublicKey)
+	if _, err := VerifyDapp(dir, TrustStore{"eris": otherPub}); err == nil {
+		t.Fatal("verified a signature with the wrong key")
+	}
+}
+
+func TestVerifyDappTampered(t *testing.T) {
+	key, _ := GenerateKey()
+	pub, _ := MarshalPublicKey(&key.PublicKey)
+	ts := TrustStore{"eris": pub}
+
+	tamper := map[string]func(dir string){
+		"changed": func(dir string) {
+			ioutil.WriteFile(path.Join(dir, "models/a.js"), []byte("var a = 6;"), 0600)
+		},
+		"added": func(dir string) {
+			ioutil.WriteFile(path.Join(dir, "models/b.js"), []byte("var b = 1;"), 0600)
+		},
+		"removed": func(dir string) {
+			os.Remove(path.Join(dir, "index.html"))
+		},
+	}
+	for name, fn := range tamper {
+		dir := writeTestDapp(t)
+		if err := SignDapp(dir, "eris", key); err != nil {
+			t.Fatal(err)
+		}
+		fn(dir)
+		if _, err := VerifyDapp(dir, ts); err == nil {
+			t.Fatalf("verified a dapp with a file %s", name)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,94 @@
+package dappmanager
+
+import (
+	"github.com/eris-ltd/decerver/interfaces/dapps"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// How often the dapp directories are checked for changes.
+const WATCH_INTERVAL = time.Second
+
+// A file's modification time and size. If either changes, so did the file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watches the package file and the models of a dapp, and reloads
+// the dapp when any of them are added, removed or changed.
+type dappWatcher struct {
+	dm     *DappManager
+	dappId string
+	dir    string
+	stamps map[string]fileStamp
+	stop   chan struct{}
+}
+
+func newDappWatcher(dm *DappManager, dappId, dir string) *dappWatcher {
+	dw := &dappWatcher{}
+	dw.dm = dm
+	dw.dappId = dappId
+	dw.dir = dir
+	dw.stamps = dw.snapshot()
+	dw.stop = make(chan struct{})
+	return dw
+}
+
+func (dw *dappWatcher) run() {
+	ticker := time.NewTicker(WATCH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stamps := dw.snapshot()
+			if sameStamps(dw.stamps, stamps) {
+				continue
+			}
+			dw.stamps = stamps
+			logger.Printf("Files changed in dapp '%s', reloading.\n", dw.dappId)
+			if err := dw.dm.ReloadDapp(dw.dappId); err != nil {
+				logger.Println(err.Error())
+			}
+		case <-dw.stop:
+			return
+		}
+	}
+}
+
+func (dw *dappWatcher) Stop() {
+	close(dw.stop)
+}
+
+// Stamp the package file, the manifest and everything in the models folder.
+func (dw *dappWatcher) snapshot() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, name := range []string{dapps.PACKAGE_FILE_NAME, dapps.MANIFEST_FILE_NAME, dapps.SIGNATURE_FILE_NAME} {
+		fp := path.Join(dw.dir, name)
+		if fi, err := os.Stat(fp); err == nil {
+			stamps[fp] = fileStamp{fi.ModTime(), fi.Size()}
+		}
+	}
+	filepath.Walk(path.Join(dw.dir, dapps.MODELS_FOLDER_NAME), func(fp string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		stamps[fp] = fileStamp{fi.ModTime(), fi.Size()}
+		return nil
+	})
+	return stamps
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for fp, st := range a {
+		if other, ok := b[fp]; !ok || !other.modTime.Equal(st.modTime) || other.size != st.size {
+			return false
+		}
+	}
+	return true
+}
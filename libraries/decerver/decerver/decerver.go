@@ -20,6 +20,9 @@ import (
 	"os/signal"
 	"os/user"
 	"path"
+	"reflect"
+	"strings"
+	"sync"
 )
 
 const version = "1.0.0"
@@ -33,6 +36,7 @@ var DefaultConfig = &decerver.DCConfig{
 	Hostname:      "localhost",
 	Port:          3000,
 	DebugMode:     true,
+	DappPolicy:    decerver.DAPP_POLICY_SANDBOX,
 }
 
 type DeCerver struct {
@@ -45,10 +49,12 @@ type DeCerver struct {
 	moduleManager modules.ModuleManager
 	dappManager   dapps.DappManager
 	isStarted     bool
+	configMutex   *sync.Mutex
 }
 
 func NewDeCerver() *DeCerver {
 	dc := &DeCerver{}
+	dc.configMutex = &sync.Mutex{}
 	logger.Println("Starting decerver bootstrapping sequence.")
 	dc.createFileIO()
 	dc.loadConfig()
@@ -102,6 +108,10 @@ func (dc *DeCerver) Start() error {
 		return err
 	}
 
+	if err := dc.ep.StartEventLog(); err != nil {
+		logger.Println("Error starting the event log: " + err.Error())
+	}
+
 	// Now everything is registered.
 	dc.isStarted = true
 
@@ -116,6 +126,9 @@ func (dc *DeCerver) Start() error {
 // TODO stuff
 func (dc *DeCerver) Shutdown() error {
 	dc.moduleManager.Shutdown()
+	if err := dc.ep.Shutdown(); err != nil {
+		logger.Println("Error closing the event log: " + err.Error())
+	}
 	logger.Println("Bye.")
 	return nil
 }
@@ -169,10 +182,55 @@ func (dc *DeCerver) initDapps() {
 	}
 }
 
+// The config is never changed, ApplyConfig replaces it.
 func (dc *DeCerver) Config() *decerver.DCConfig {
+	dc.configMutex.Lock()
+	defer dc.configMutex.Unlock()
 	return dc.config
 }
 
+func (dc *DeCerver) ApplyConfig(cfg *decerver.DCConfig) ([]string, []string, error) {
+	if err := decerver.ValidateConfig(cfg); err != nil {
+		return nil, nil, err
+	}
+	dc.configMutex.Lock()
+	defer dc.configMutex.Unlock()
+
+	restart := make(map[string]bool)
+	for _, f := range decerver.RESTART_FIELDS {
+		restart[f] = true
+	}
+	applied := []string{}
+	restartRequired := []string{}
+	updated := *dc.config
+	active := reflect.ValueOf(&updated).Elem()
+	next := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < active.NumField(); i++ {
+		name := strings.Split(active.Type().Field(i).Tag.Get("json"), ",")[0]
+		if reflect.DeepEqual(active.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+		if restart[name] {
+			restartRequired = append(restartRequired, name)
+			continue
+		}
+		active.Field(i).Set(next.Field(i))
+		applied = append(applied, name)
+	}
+
+	dc.config = &updated
+
+	// Runtimes that are already running keep their limits.
+	dc.rm.SetLimits(runtimemanager.LimitsFromConfig(dc.config))
+	if len(applied) > 0 {
+		logger.Printf("Config applied: %s\n", strings.Join(applied, ", "))
+	}
+	if len(restartRequired) > 0 {
+		logger.Printf("Config changes that need a restart: %s\n", strings.Join(restartRequired, ", "))
+	}
+	return applied, restartRequired, nil
+}
+
 func (dc *DeCerver) FileIO() files.FileIO {
 	return dc.fileIO
 }
@@ -216,6 +274,10 @@ func (dma *DecerverModuleApi) RegisterRuntimeObject(name string, obj interface{}
 	dma.rm.RegisterApiObject(name, obj)
 }
 
+func (dma *DecerverModuleApi) RegisterRuntimeCapability(name, object string, methods ...string) {
+	dma.rm.RegisterCapability(name, object, methods...)
+}
+
 func (dma *DecerverModuleApi) RegisterRuntimeScript(script string) {
 	dma.rm.RegisterApiScript(script)
 }
@@ -0,0 +1,48 @@
+package decerver
+
+import (
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"sync"
+	"testing"
+)
+
+type testRuntimeManager struct {
+	scripting.RuntimeManager
+}
+
+func (rm *testRuntimeManager) SetLimits(limits scripting.RuntimeLimits) {}
+
+// Configs that were handed out are not changed by later updates (readers
+// don't take the config lock).
+func TestApplyConfig(t *testing.T) {
+	dc := &DeCerver{config: &decerver.DCConfig{Port: 3000}, configMutex: &sync.Mutex{}, rm: &testRuntimeManager{}}
+	old := dc.Config()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, _, err := dc.ApplyConfig(&decerver.DCConfig{Port: 3000, AdminToken: fmt.Sprint(i)}); err != nil {
+				t.Error(err)
+			}
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		_ = dc.Config().AdminToken
+		_ = old.AdminToken
+	}
+	<-done
+
+	if old.AdminToken != "" {
+		t.Fatal("the config was changed in place")
+	}
+	if cfg := dc.Config(); cfg.AdminToken != "99" || cfg.Port != 3000 {
+		t.Fatalf("config not applied: %v", cfg)
+	}
+	applied, restart, _ := dc.ApplyConfig(&decerver.DCConfig{Port: 4000, AdminToken: "99"})
+	if len(applied) != 0 || len(restart) != 1 || dc.Config().Port != 3000 {
+		t.Fatalf("port should need a restart: %v %v", applied, restart)
+	}
+}
@@ -0,0 +1,192 @@
+package eventprocessor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/eris-ltd/decerver/interfaces/events"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
+	"strings"
+	"time"
+)
+
+// The name of the event log database directory (in the system directory).
+const EVENT_LOG_DIR_NAME = "eventlog"
+
+// The fields of an event resource that hold the block height.
+var heightFields = []string{"height", "Height", "number", "Number"}
+
+// The event log is an append-only log of events, stored in leveldb. The
+// keys are the sequence numbers (big endian, so they are in order), and
+// the values are the json encoded log entries. Only the event processor
+// loop uses it. Entries are synced to disk as they are added, so a logged
+// event survives a crash.
+type eventLog struct {
+	db *leveldb.DB
+	// The logged event types, as 'source:event'.
+	types map[string]bool
+	// The max number of entries (0 means no limit), and the max age.
+	size   int
+	maxAge time.Duration
+	// The sequence numbers of the oldest and the newest entry. The log is
+	// empty if first > last.
+	first  uint64
+	last   uint64
+	height uint64
+}
+
+func openEventLog(dir string, logged []string, size, maxAgeHours int) (*eventLog, error) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	el := &eventLog{db: db, types: make(map[string]bool), first: 1}
+	for _, t := range logged {
+		el.types[t] = true
+	}
+	if size == 0 {
+		size = events.DEFAULT_EVENT_LOG_SIZE
+	} else if size < 0 {
+		size = 0
+	}
+	el.size = size
+	el.maxAge = time.Duration(maxAgeHours) * time.Hour
+
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	if it.First() {
+		el.first = binary.BigEndian.Uint64(it.Key())
+	}
+	if it.Last() {
+		entry := &events.LogEntry{}
+		if err := json.Unmarshal(it.Value(), entry); err != nil {
+			db.Close()
+			return nil, err
+		}
+		el.last = entry.Seq
+		el.height = entry.Height
+	}
+	if err := it.Error(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	el.prune()
+	return el, nil
+}
+
+func (el *eventLog) close() error {
+	return el.db.Close()
+}
+
+func logType(source, event string) string {
+	return source + ":" + event
+}
+
+// The types that are logged, as source and event.
+func (el *eventLog) logged() [][2]string {
+	lt := [][2]string{}
+	for t, _ := range el.types {
+		parts := strings.SplitN(t, ":", 2)
+		lt = append(lt, [2]string{parts[0], parts[1]})
+	}
+	return lt
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Add an event to the log.
+func (el *eventLog) append(e types.Event) (*events.LogEntry, error) {
+	if h, ok := resourceHeight(e.Resource); ok {
+		el.height = h
+	}
+	entry := &events.LogEntry{Seq: el.last + 1, Height: el.height, Time: time.Now(), Event: e}
+	bts, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := el.db.Put(seqKey(entry.Seq), bts, &opt.WriteOptions{Sync: true}); err != nil {
+		return nil, err
+	}
+	el.last = entry.Seq
+	el.prune()
+	return entry, nil
+}
+
+// Remove the entries that are too many or too old.
+func (el *eventLog) prune() {
+	batch := new(leveldb.Batch)
+	first := el.first
+	if el.size > 0 && el.last >= first && el.last-first+1 > uint64(el.size) {
+		for ; el.last-first+1 > uint64(el.size); first++ {
+			batch.Delete(seqKey(first))
+		}
+	}
+	if el.maxAge > 0 {
+		cutoff := time.Now().Add(-el.maxAge)
+		it := el.db.NewIterator(&util.Range{Start: seqKey(first)}, nil)
+		for it.Next() {
+			entry := &events.LogEntry{}
+			if json.Unmarshal(it.Value(), entry) == nil && entry.Time.After(cutoff) {
+				break
+			}
+			batch.Delete(seqKey(first))
+			first++
+		}
+		it.Release()
+	}
+	if first == el.first {
+		return
+	}
+	if err := el.db.Write(batch, nil); err != nil {
+		logger.Println("Failed to prune the event log: " + err.Error())
+		return
+	}
+	el.first = first
+}
+
+// Call fn for every entry from the replay position on, oldest first.
+func (el *eventLog) since(r *events.Replay, fn func(*events.LogEntry)) error {
+	start := r.Seq + 1
+	if r.Height > 0 || start < el.first {
+		start = el.first
+	}
+	it := el.db.NewIterator(&util.Range{Start: seqKey(start)}, nil)
+	defer it.Release()
+	for it.Next() {
+		entry := &events.LogEntry{}
+		if err := json.Unmarshal(it.Value(), entry); err != nil {
+			return err
+		}
+		if entry.Height < r.Height {
+			continue
+		}
+		fn(entry)
+	}
+	return it.Error()
+}
+
+// Get the block height from an event resource, if it has one.
+func resourceHeight(resource interface{}) (uint64, bool) {
+	fields, ok := resource.(map[string]interface{})
+	if !ok {
+		bts, err := json.Marshal(resource)
+		if err != nil || json.Unmarshal(bts, &fields) != nil {
+			return 0, false
+		}
+	}
+	for _, f := range heightFields {
+		if n, ok := fields[f].(float64); ok && n >= 0 {
+			return uint64(n), true
+		}
+		if n, ok := fields[f].(int); ok && n >= 0 {
+			return uint64(n), true
+		}
+	}
+	return 0, false
+}
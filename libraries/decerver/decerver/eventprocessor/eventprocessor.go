@@ -3,14 +3,17 @@
 package eventprocessor
 
 import (
-	"fmt"
 	"encoding/json"
+	"errors"
 	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
 	"github.com/eris-ltd/decerver/interfaces/decerver"
 	"github.com/eris-ltd/decerver/interfaces/events"
 	"github.com/eris-ltd/decerver/interfaces/logging"
 	"github.com/eris-ltd/decerver/interfaces/modules"
 	"log"
+	"path"
+	"strings"
+	"sync"
 )
 
 var logger *log.Logger = logging.NewLogger("Event Processor")
@@ -69,6 +72,10 @@ type trafficData struct {
 	// This happens when there are subs to the source, but not
 	// to the event type that's posted.
 	EventsNoEvtSubs map[string]map[string]uint64 `json:"events_no_event_type_subs"`
+	// Events that were dropped because the queue of a subscriber was full.
+	EventsDropped      uint64            `json:"events_dropped"`
+	EventsDroppedBySub map[string]uint64 `json:"events_dropped_by_subscriber"`
+	mutex              *sync.Mutex
 }
 
 func newTrafficData() *trafficData {
@@ -77,10 +84,14 @@ func newTrafficData() *trafficData {
 	td.EventsSubReceivedBySource = make(map[string]uint64)
 	td.EventsNoSourceSubsBySource = make(map[string]uint64)
 	td.EventsNoEvtSubs = make(map[string]map[string]uint64)
+	td.EventsDroppedBySub = make(map[string]uint64)
+	td.mutex = &sync.Mutex{}
 	return td
 }
 
 func (td *trafficData) incPosted(src string) {
+	td.mutex.Lock()
+	defer td.mutex.Unlock()
 	td.EventsPosted++
 	if _, ok := td.EventsPostedBySource[src]; !ok {
 		td.EventsPostedBySource[src] = uint64(1)
@@ -90,6 +101,8 @@ func (td *trafficData) incPosted(src string) {
 }
 
 func (td *trafficData) incNoSubBySource(src string) {
+	td.mutex.Lock()
+	defer td.mutex.Unlock()
 	if _, ok := td.EventsNoSourceSubsBySource[src]; !ok {
 		td.EventsNoSourceSubsBySource[src] = uint64(1)
 	} else {
@@ -98,6 +111,8 @@ func (td *trafficData) incNoSubBySource(src string) {
 }
 
 func (td *trafficData) incNoEvtSub(src, evt string) {
+	td.mutex.Lock()
+	defer td.mutex.Unlock()
 	if _, ok := td.EventsNoEvtSubs[src]; !ok {
 		newMap := make(map[string]uint64)
 		newMap[evt] = uint64(1)
@@ -108,6 +123,8 @@ func (td *trafficData) incNoEvtSub(src, evt string) {
 }
 
 func (td *trafficData) incrementReceived(src string) {
+	td.mutex.Lock()
+	defer td.mutex.Unlock()
 	if _, ok := td.EventsSubReceivedBySource[src]; !ok {
 		td.EventsSubReceivedBySource[src] = uint64(1)
 	} else {
@@ -115,6 +132,13 @@ func (td *trafficData) incrementReceived(src string) {
 	}
 }
 
+func (td *trafficData) incDropped(subId string) {
+	td.mutex.Lock()
+	defer td.mutex.Unlock()
+	td.EventsDropped++
+	td.EventsDroppedBySub[subId]++
+}
+
 // The event processor handles subscribers and events.
 type EventProcessor struct {
 	// Store subscribers by source (which module they're subscribing to)
@@ -125,46 +149,84 @@ type EventProcessor struct {
 	// subscription info to modules in case they want to filter their outgoing
 	// events, or do other stuff.
 	moduleManager modules.ModuleManager
+	// The queues of the subscribers, by id.
+	queues map[string]*subQueue
+	// The event log (nil if no events are logged), the ids of the
+	// subscriptions it gets its events from, and the subscribers that
+	// get their events from it (by log type).
+	log     *eventLog
+	logIds  map[string]bool
+	logSubs map[string][]events.Subscriber
 	// Traffic data
 	td *trafficData
 	// Whether or not we're in debugging mode.
 	debug bool
 	// Main event channel
-	mainEvts chan types.Event
+	mainEvts chan *subEvent
 	mainClose chan interface{}
-	subChan chan events.Subscriber
+	subChan chan *subRequest
 	unsubChan chan string
 	incomingChans map[string]chan types.Event
 	closeChan chan interface{}
+	// Closed when the processor loop has stopped.
+	stopped   chan struct{}
+	closeOnce *sync.Once
+	closeErr  error
+}
+
+// An event, and the id of the subscription it came in on.
+type subEvent struct {
+	subId string
+	e     types.Event
 }
 
 func NewEventProcessor(dc decerver.Decerver) events.EventProcessor {
+	ep := newEventProcessor(dc.ModuleManager(), dc.Config().DebugMode)
+	cfg := dc.Config()
+	if len(cfg.EventLog) > 0 {
+		el, err := openEventLog(path.Join(dc.FileIO().System(), EVENT_LOG_DIR_NAME), cfg.EventLog, cfg.EventLogSize, cfg.EventLogMaxAge)
+		if err != nil {
+			logger.Println("Failed to open the event log: " + err.Error())
+		} else {
+			ep.log = el
+		}
+	}
+	return ep
+}
+
+func newEventProcessor(mm modules.ModuleManager, debug bool) *EventProcessor {
 	ep := &EventProcessor{}
 
 	ep.subs = make(map[string]SubMap)
 	ep.byId = make(map[string]events.Subscriber)
-	ep.moduleManager = dc.ModuleManager()
-	ep.debug = dc.Config().DebugMode
-	if ep.debug {
-		ep.td = newTrafficData()
-	}
-	ep.mainEvts = make(chan types.Event)
-	ep.subChan = make(chan events.Subscriber)
+	ep.moduleManager = mm
+	ep.debug = debug
+	ep.queues = make(map[string]*subQueue)
+	ep.logIds = make(map[string]bool)
+	ep.logSubs = make(map[string][]events.Subscriber)
+	ep.td = newTrafficData()
+	ep.mainEvts = make(chan *subEvent, events.DEFAULT_QUEUE_SIZE)
+	ep.subChan = make(chan *subRequest)
 	ep.unsubChan = make(chan string)
 	ep.incomingChans = make(map[string]chan types.Event)
 	ep.closeChan = make(chan interface{})
+	ep.stopped = make(chan struct{})
+	ep.closeOnce = &sync.Once{}
 
 	go func(ep *EventProcessor){
 		for {
 			select{
-				case evt := <- ep.mainEvts:
-					fmt.Printf("Event: %v\n", evt)
-					ep.post(evt)
-				case sub := <- ep.subChan:
-					ep.subscribe(sub)
+				case se := <- ep.mainEvts:
+					ep.post(se.subId, se.e)
+				case req := <- ep.subChan:
+					req.err <- ep.subscribe(req.sub)
 				case id := <- ep.unsubChan:
 					ep.unsubscribe(id)
 				case _ = <- ep.closeChan:
+					if ep.log != nil {
+						ep.closeErr = ep.log.close()
+					}
+					close(ep.stopped)
 					return
 			}
 		}
@@ -174,49 +236,91 @@ func NewEventProcessor(dc decerver.Decerver) events.EventProcessor {
 	return ep
 }
 
+// Events are passed on to the subscription they came in on. Modules give
+// each subscription its own channel, so passing them on to every matching
+// subscriber would deliver them more than once.
 // TODO Not sure what the error is supposed to do yet
-func (ep *EventProcessor) post(e types.Event) error {
+func (ep *EventProcessor) post(subId string, e types.Event) error {
 	src := e.Source
 	ee := e.Event
+	ep.td.incPosted(src)
 	if ep.debug {
-		ep.td.incPosted(src)
 		logger.Println("Receiving event '" + ee + "' from '" + src + "'.")
 	}
 
-	sourceSubs := ep.subs[src]
-	if sourceSubs == nil || len(sourceSubs) == 0 {
-		if ep.debug {
-			logger.Println("No subscribers to events published by: " + src + ". Skipping.")
-			ep.td.incNoEvtSub(src,ee)
+	if ep.logIds[subId] {
+		entry, err := ep.log.append(e)
+		if err != nil {
+			logger.Printf("Failed to log event '%s' from '%s': %s\n", ee, src, err.Error())
+			return err
+		}
+		for _, sub := range ep.logSubs[logType(src, ee)] {
+			ep.deliver(sub, &queued{e, entry})
 		}
 		return nil
 	}
 
-	eeSubs := sourceSubs[ee]
-	if eeSubs == nil || len(eeSubs.srs) == 0 {
-		if ep.debug {
-			logger.Println("No subscribers to events of type '" + ee + "' published by '" + src + "'. Skipping.")
+	sub, ok := ep.byId[subId]
+	if !ok {
+		// The subscriber is gone.
+		if len(ep.subs[src]) == 0 {
+			ep.td.incNoSubBySource(src)
+		} else {
 			ep.td.incNoEvtSub(src, ee)
 		}
 		return nil
 	}
+	ep.deliver(sub, &queued{e, nil})
+	return nil
+}
+
+// Put an event in the queue of a subscriber, if it matches.
+func (ep *EventProcessor) deliver(sub events.Subscriber, q *queued) {
+	if !events.MatchTarget(sub.Target(), q.e.Target) {
+		return
+	}
+	if fs, ok := sub.(events.FilteredSubscriber); ok && !fs.Accept(q.e) {
+		return
+	}
+	ep.td.incrementReceived(q.e.Source)
+	if !ep.queues[sub.Id()].push(q) {
+		ep.td.incDropped(sub.Id())
+		if ep.debug {
+			logger.Println("Queue full, event dropped for subscriber: " + sub.Id())
+		}
+	}
+}
 
-	for _, sub := range eeSubs.srs {
-		if sub.Target() == e.Target {
-			if ep.debug {
-				logger.Println("Found subscriber")
-				logger.Printf("Chan: %v\n", sub)
-				ep.td.incrementReceived(src)
+// Pass the events from a module channel on to the processor loop.
+func (ep *EventProcessor) forward(subId string, ch chan types.Event) {
+	for {
+		evt, ok := <- ch
+		if !ok {
+			return
+		} else {
+			select {
+			case ep.mainEvts <- &subEvent{subId, evt}:
+			case <-ep.stopped:
+				return
 			}
-			sub.Post(e)
 		}
 	}
-	return nil
+}
+
+// A subscription, and the channel to send back the result on.
+type subRequest struct {
+	sub events.Subscriber
+	err chan error
 }
 
 func (ep *EventProcessor) Subscribe(sub events.Subscriber) error {
-	ep.subChan <- sub
-	return nil
+	req := &subRequest{sub, make(chan error, 1)}
+	select {
+	case ep.subChan <- req:
+	case <-ep.stopped:
+		return errors.New("The event processor is shut down.")
+	}
+	return <-req.err
 }
 
 func (ep *EventProcessor) subscribe(sub events.Subscriber) error {
@@ -224,6 +328,47 @@ func (ep *EventProcessor) subscribe(sub events.Subscriber) error {
 	if ep.debug {
 		logger.Println("New subscription registering: " + src)
 	}
+	mod, ok := ep.moduleManager.Modules()[src]
+	if !ok {
+		logger.Printf("Subscriber '%s' refused: no module with name '%s'.\n", sub.Id(), src)
+		return errors.New("No module with name: " + src)
+	}
+	if ls, ok := sub.(*logSubscriber); ok {
+		if ep.logIds[ls.Id()] {
+			return nil
+		}
+		ep.logIds[ls.Id()] = true
+		go ep.forward(ls.Id(), mod.Subscribe(ls.Id(), ls.Event(), ""))
+		logger.Printf("Logging events: %s (%s)\n", ls.Source(), ls.Event())
+		return nil
+	}
+	if _, ok := ep.byId[sub.Id()]; ok {
+		logger.Println("Subscriber refused, the id is taken: " + sub.Id())
+		return errors.New("Subscriber id is taken: " + sub.Id())
+	}
+
+	// Subscribers that replay get the logged events, and then the new
+	// ones from the log (so none are missed or delivered twice).
+	var replayed []*queued
+	rs, replay := sub.(events.ReplaySubscriber)
+	if replay && rs.Replay() != nil {
+		lt := logType(src, sub.Event())
+		if ep.log == nil || !ep.log.types[lt] {
+			logger.Printf("Subscriber '%s' refused: '%s' events are not logged.\n", sub.Id(), lt)
+			return errors.New("Events are not logged, so they can't be replayed: " + lt)
+		}
+		err := ep.log.since(rs.Replay(), func(entry *events.LogEntry) {
+			if entry.Event.Source == src && entry.Event.Event == sub.Event() {
+				replayed = append(replayed, &queued{entry.Event, entry})
+			}
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		replay = false
+	}
+
 	srcSubs, okSrc := ep.subs[src]
 	if !okSrc {
 		srcSubs = make(SubMap)
@@ -240,27 +385,37 @@ func (ep *EventProcessor) subscribe(sub events.Subscriber) error {
 
 	evts.add(sub)
 	ep.byId[sub.Id()] = sub
+	ep.queues[sub.Id()] = newSubQueue(sub, len(replayed))
 
-	// Call subscribe on module.
-	eChan := ep.moduleManager.Modules()[src].Subscribe(sub.Id(), sub.Event(), sub.Target())
-	ep.incomingChans[sub.Id()] = eChan
-	go func(ch chan types.Event){
-		for {
-			evt, ok := <- ch
-			if !ok {
-				return
-			} else {
-				ep.mainEvts <- evt
-			}
+	if replay {
+		for _, q := range replayed {
+			ep.deliver(sub, q)
 		}
-	}(eChan)
+		lt := logType(src, evt)
+		ep.logSubs[lt] = append(ep.logSubs[lt], sub)
+		logger.Printf("New subscriber added to: %s (%s), replaying from the event log\n", src, evt)
+		return nil
+	}
+
+	// Call subscribe on module. Target patterns are matched here, so the
+	// module gets all targets.
+	target := sub.Target()
+	if strings.Contains(target, "*") {
+		target = ""
+	}
+	eChan := mod.Subscribe(sub.Id(), evt, target)
+	ep.incomingChans[sub.Id()] = eChan
+	go ep.forward(sub.Id(), eChan)
 	logger.Printf("New subscriber added to: %s (%s)\n", sub.Source(), sub.Event())
 	return nil
 }
 
 // TODO not sure what the error is supposed to do yet
 func (ep *EventProcessor) Unsubscribe(id string) error {
-	ep.unsubChan <- id
+	select {
+	case ep.unsubChan <- id:
+	case <-ep.stopped:
+	}
 	return nil
 }
 
@@ -271,26 +426,66 @@ func (ep *EventProcessor) unsubscribe(id string) error {
 		logger.Println("No subscriber with id: " + id)
 		return nil
 	}
-	ep.moduleManager.Modules()[sub.Source()].UnSubscribe(sub.Id())
-	// This is the crux. If module closes automatically, then it's wrong. No good way of checking.
-	// close(ep.incomingChans[id])
-	delete(ep.incomingChans,id)
+	if _, ok := ep.incomingChans[id]; ok {
+		ep.moduleManager.Modules()[sub.Source()].UnSubscribe(sub.Id())
+		// This is the crux. If module closes automatically, then it's wrong. No good way of checking.
+		// close(ep.incomingChans[id])
+		delete(ep.incomingChans,id)
+	} else {
+		lt := logType(sub.Source(), sub.Event())
+		ls := ep.logSubs[lt]
+		for i, s := range ls {
+			if s.Id() == id {
+				ep.logSubs[lt] = append(ls[:i], ls[i+1:]...)
+				break
+			}
+		}
+	}
 	// Clean out the sub (both from subs and from the map that stores by id)
 	// TODO this is temporary but otherwise store the channel in the subById? Make a struct?
 	ep.subs[sub.Source()][sub.Event()].remove(id)
 	delete(ep.byId, id)
+	ep.queues[id].stop()
+	delete(ep.queues, id)
 	return nil
 }
 
-func (ep *EventProcessor) TrafficData() string {
-	if ep.debug {
-		bts, _ := json.MarshalIndent(ep.td, "", "\t")
-		str := string(bts)
-		// TODO remove
-		logger.Println(str)
-		return str
-	} else {
-		logger.Println("Event Traffic Data not available (debug mode must be enabled).")
-		return "N/A"
+// The event log gets its events through subscriptions of its own.
+type logSubscriber struct {
+	source, event string
+}
+
+func (ls *logSubscriber) Post(e types.Event) {}
+func (ls *logSubscriber) Source() string     { return ls.source }
+func (ls *logSubscriber) Id() string         { return "eventlog:" + logType(ls.source, ls.event) }
+func (ls *logSubscriber) Event() string      { return ls.event }
+func (ls *logSubscriber) Target() string     { return "" }
+
+func (ep *EventProcessor) StartEventLog() error {
+	if ep.log == nil {
+		return nil
+	}
+	var err error
+	for _, lt := range ep.log.logged() {
+		if e := ep.Subscribe(&logSubscriber{lt[0], lt[1]}); e != nil {
+			logger.Printf("Failed to log '%s' events: %s\n", logType(lt[0], lt[1]), e.Error())
+			err = e
+		}
 	}
+	return err
+}
+
+// Events that arrive after this are dropped. The event log is closed once
+// the events before them are written.
+func (ep *EventProcessor) Shutdown() error {
+	ep.closeOnce.Do(func() { close(ep.closeChan) })
+	<-ep.stopped
+	return ep.closeErr
+}
+
+func (ep *EventProcessor) TrafficData() string {
+	ep.td.mutex.Lock()
+	defer ep.td.mutex.Unlock()
+	bts, _ := json.MarshalIndent(ep.td, "", "\t")
+	return string(bts)
 }
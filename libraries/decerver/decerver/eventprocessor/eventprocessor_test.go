@@ -0,0 +1,310 @@
+package eventprocessor
+
+import (
+	"encoding/json"
+	"github.com/eris-ltd/decerver/interfaces/events"
+	"github.com/eris-ltd/decerver/interfaces/modules"
+	"github.com/eris-ltd/decerver/modulemanager"
+	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A module that publishes events to every subscription, on a channel per
+// subscription (like the real modules do).
+type testModule struct {
+	names []string
+	chans map[string]chan types.Event
+	mutex *sync.Mutex
+}
+
+func (m *testModule) publish(e types.Event) {
+	m.mutex.Lock()
+	chans := []chan types.Event{}
+	for _, name := range m.names {
+		chans = append(chans, m.chans[name])
+	}
+	m.mutex.Unlock()
+	for _, ch := range chans {
+		ch <- e
+	}
+}
+
+func (m *testModule) Register(dc modules.DecerverModuleApi) error { return nil }
+func (m *testModule) Init() error                                 { return nil }
+func (m *testModule) Start() error                                { return nil }
+func (m *testModule) Restart() error                              { return nil }
+func (m *testModule) Shutdown() error                             { return nil }
+func (m *testModule) Name() string                                { return "chain" }
+func (m *testModule) Version() string                             { return "1.0.0" }
+func (m *testModule) ConfigSchema() *modules.ConfigSchema         { return nil }
+func (m *testModule) ModuleConfigSchema() *modules.ConfigSchema   { return nil }
+func (m *testModule) ReleaseDapp(dappId string)                   {}
+func (m *testModule) SetProperty(name string, data interface{})   {}
+func (m *testModule) Property(name string) interface{}            { return nil }
+func (m *testModule) Subscribe(name, event, target string) chan types.Event {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ch := make(chan types.Event)
+	m.names = append(m.names, name)
+	m.chans[name] = ch
+	return ch
+}
+func (m *testModule) UnSubscribe(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, n := range m.names {
+		if n == name {
+			m.names = append(m.names[:i], m.names[i+1:]...)
+			break
+		}
+	}
+	delete(m.chans, name)
+}
+func (m *testModule) ConfigureDapp(dappId string, config map[string]interface{}) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+type testSub struct {
+	id, target string
+	filter     events.ResourceFilter
+	queue      events.QueueConfig
+	posted     chan types.Event
+	// Post waits for this before returning, if it's not nil.
+	wait chan struct{}
+}
+
+func (s *testSub) Source() string            { return "chain" }
+func (s *testSub) Id() string                { return s.id }
+func (s *testSub) Event() string             { return "newBlock" }
+func (s *testSub) Target() string            { return s.target }
+func (s *testSub) Queue() events.QueueConfig { return s.queue }
+func (s *testSub) Accept(e types.Event) bool { return s.filter.Match(e.Resource) }
+func (s *testSub) Post(e types.Event) {
+	if s.wait != nil {
+		<-s.wait
+	}
+	s.posted <- e
+}
+
+func newTestSub(id, target string) *testSub {
+	return &testSub{id: id, target: target, queue: events.DefaultQueueConfig, posted: make(chan types.Event, 100)}
+}
+
+func newTestProcessor() (*EventProcessor, *testModule) {
+	mod := &testModule{chans: make(map[string]chan types.Event), mutex: &sync.Mutex{}}
+	mm := modulemanager.NewModuleManager()
+	mm.Add(mod)
+	return newEventProcessor(mm, false), mod
+}
+
+func expectEvents(t *testing.T, sub *testSub, n int) []types.Event {
+	evts := []types.Event{}
+	for i := 0; i < n; i++ {
+		select {
+		case e := <-sub.posted:
+			evts = append(evts, e)
+		case <-time.After(time.Second):
+			t.Fatalf("%s: got %d events, expected %d", sub.id, len(evts), n)
+		}
+	}
+	select {
+	case e := <-sub.posted:
+		t.Fatalf("%s: unexpected event %v", sub.id, e)
+	case <-time.After(20 * time.Millisecond):
+	}
+	return evts
+}
+
+func TestFilters(t *testing.T) {
+	ep, mod := newTestProcessor()
+	any := newTestSub("any", "*")
+	prefix := newTestSub("prefix", "abc*")
+	exact := newTestSub("exact", "abcd")
+	field := newTestSub("field", "*")
+	field.filter = events.ResourceFilter{"height": 2}
+	for _, s := range []*testSub{any, prefix, exact, field} {
+		if err := ep.Subscribe(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mod.publish(types.Event{Source: "chain", Event: "newBlock", Target: "abcd", Resource: map[string]interface{}{"height": 1}})
+	mod.publish(types.Event{Source: "chain", Event: "newBlock", Target: "abce", Resource: map[string]interface{}{"height": 2}})
+	mod.publish(types.Event{Source: "chain", Event: "newBlock", Target: "x", Resource: map[string]interface{}{"height": 3}})
+
+	expectEvents(t, any, 3)
+	expectEvents(t, prefix, 2)
+	expectEvents(t, exact, 1)
+	if e := expectEvents(t, field, 1); e[0].Target != "abce" {
+		t.Fatalf("got %v", e[0])
+	}
+}
+
+func TestQueuePolicies(t *testing.T) {
+	ep, mod := newTestProcessor()
+	wait := make(chan struct{})
+	drop := newTestSub("drop", "*")
+	drop.queue = events.QueueConfig{Size: 1, Policy: events.QUEUE_DROP}
+	drop.wait = wait
+	oldest := newTestSub("oldest", "*")
+	oldest.queue = events.QueueConfig{Size: 1, Policy: events.QUEUE_DROP_OLDEST}
+	oldest.wait = wait
+	fast := newTestSub("fast", "*")
+	for _, s := range []*testSub{drop, oldest, fast} {
+		if err := ep.Subscribe(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The slow subscribers take the first event and block on it, the
+	// second fills their queues, and the rest are dropped.
+	for i := 0; i < 5; i++ {
+		mod.publish(types.Event{Source: "chain", Event: "newBlock", Target: "t", Resource: i})
+	}
+	// The fast subscriber is not held up.
+	expectEvents(t, fast, 5)
+	close(wait)
+
+	if e := expectEvents(t, drop, 2); e[1].Resource != 1 {
+		t.Fatalf("drop: got %v", e[1].Resource)
+	}
+	if e := expectEvents(t, oldest, 2); e[1].Resource != 4 {
+		t.Fatalf("drop_oldest: got %v", e[1].Resource)
+	}
+
+	td := &trafficData{}
+	json.Unmarshal([]byte(ep.TrafficData()), td)
+	if td.EventsDropped != 6 || td.EventsDroppedBySub["drop"] != 3 {
+		t.Fatalf("bad drop counts: %s", ep.TrafficData())
+	}
+}
+
+func TestSubscribeUnknownModule(t *testing.T) {
+	ep, _ := newTestProcessor()
+	if err := ep.Subscribe(&otherSourceSub{newTestSub("x", "*")}); err == nil {
+		t.Fatal("subscribed to a module that does not exist")
+	}
+}
+
+type otherSourceSub struct {
+	*testSub
+}
+
+func (s *otherSourceSub) Source() string { return "nothing" }
+
+type replaySub struct {
+	*testSub
+	since   *events.Replay
+	entries chan *events.LogEntry
+}
+
+func (s *replaySub) Replay() *events.Replay { return s.since }
+func (s *replaySub) PostEntry(entry *events.LogEntry) {
+	s.entries <- entry
+}
+
+func newReplaySub(id string, since *events.Replay) *replaySub {
+	return &replaySub{newTestSub(id, "*"), since, make(chan *events.LogEntry, 100)}
+}
+
+func expectEntries(t *testing.T, sub *replaySub, seqs ...uint64) {
+	for _, seq := range seqs {
+		select {
+		case entry := <-sub.entries:
+			if entry.Seq != seq || entry.Height != seq {
+				t.Fatalf("%s: got entry %d at height %d, expected %d", sub.id, entry.Seq, entry.Height, seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: no entry, expected %d", sub.id, seq)
+		}
+	}
+	select {
+	case entry := <-sub.entries:
+		t.Fatalf("%s: unexpected entry %d", sub.id, entry.Seq)
+	case e := <-sub.posted:
+		t.Fatalf("%s: unexpected event %v", sub.id, e)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ep, mod := newTestProcessor()
+	el, err := openEventLog(dir, []string{"chain:newBlock"}, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep.log = el
+	if err := ep.StartEventLog(); err != nil {
+		t.Fatal(err)
+	}
+	block := func(h int) {
+		mod.publish(types.Event{Source: "chain", Event: "newBlock", Resource: map[string]interface{}{"height": h}})
+	}
+
+	all := newReplaySub("all", &events.Replay{})
+	if err := ep.Subscribe(all); err != nil {
+		t.Fatal(err)
+	}
+	for h := 1; h <= 5; h++ {
+		block(h)
+	}
+	expectEntries(t, all, 1, 2, 3, 4, 5)
+
+	// Replayed, then live.
+	bySeq := newReplaySub("seq", &events.Replay{Seq: 3})
+	if err := ep.Subscribe(bySeq); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, bySeq, 4, 5)
+	block(6)
+	expectEntries(t, bySeq, 6)
+	expectEntries(t, all, 6)
+
+	byHeight := newReplaySub("height", &events.Replay{Height: 5})
+	if err := ep.Subscribe(byHeight); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, byHeight, 5, 6)
+
+	// Only the last 3 are kept.
+	old := newReplaySub("old", &events.Replay{})
+	if err := ep.Subscribe(old); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, old, 4, 5, 6)
+
+	if err := ep.Subscribe(&notLoggedSub{newReplaySub("other", &events.Replay{})}); err == nil {
+		t.Fatal("replaying events that are not logged")
+	}
+
+	if err := ep.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Subscribe(newReplaySub("late", nil)); err == nil {
+		t.Fatal("subscribed after shutdown")
+	}
+	el, err = openEventLog(dir, []string{"chain:newBlock"}, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer el.close()
+	if el.first != 4 || el.last != 6 || el.height != 6 {
+		t.Fatalf("reopened log: first %d, last %d, height %d", el.first, el.last, el.height)
+	}
+}
+
+type notLoggedSub struct {
+	*replaySub
+}
+
+func (s *notLoggedSub) Event() string { return "newTx" }
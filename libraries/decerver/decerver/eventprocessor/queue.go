@@ -0,0 +1,90 @@
+package eventprocessor
+
+import (
+	"github.com/eris-ltd/decerver/interfaces/events"
+	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
+)
+
+// An event in a queue. Subscribers that replay from the event log get
+// the log entry.
+type queued struct {
+	e     types.Event
+	entry *events.LogEntry
+}
+
+// The queue of a subscriber. Events are pushed from the event processor
+// loop and posted to the subscriber from its own goroutine.
+type subQueue struct {
+	sub    events.Subscriber
+	ch     chan *queued
+	policy string
+	// Closed when the subscriber is removed.
+	quit chan struct{}
+}
+
+// 'extra' is room for the events that are replayed when the subscriber
+// is added, on top of the configured size.
+func newSubQueue(sub events.Subscriber, extra int) *subQueue {
+	cfg := events.DefaultQueueConfig
+	if qs, ok := sub.(events.QueuedSubscriber); ok {
+		cfg = qs.Queue()
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = events.DEFAULT_QUEUE_SIZE
+	}
+	switch cfg.Policy {
+	case events.QUEUE_DROP, events.QUEUE_DROP_OLDEST, events.QUEUE_BLOCK:
+	default:
+		cfg.Policy = events.DefaultQueueConfig.Policy
+	}
+	sq := &subQueue{sub, make(chan *queued, cfg.Size+extra), cfg.Policy, make(chan struct{})}
+	go sq.run()
+	return sq
+}
+
+func (sq *subQueue) run() {
+	for {
+		select {
+		case q := <-sq.ch:
+			if rs, ok := sq.sub.(events.ReplaySubscriber); ok && q.entry != nil {
+				rs.PostEntry(q.entry)
+			} else {
+				sq.sub.Post(q.e)
+			}
+		case <-sq.quit:
+			return
+		}
+	}
+}
+
+// Add an event to the queue. Returns false if an event was dropped.
+func (sq *subQueue) push(e *queued) bool {
+	select {
+	case sq.ch <- e:
+		return true
+	default:
+	}
+
+	switch sq.policy {
+	case events.QUEUE_BLOCK:
+		select {
+		case sq.ch <- e:
+		case <-sq.quit:
+		}
+		return true
+	case events.QUEUE_DROP_OLDEST:
+		select {
+		case <-sq.ch:
+		default:
+		}
+		select {
+		case sq.ch <- e:
+		default:
+		}
+	}
+	return false
+}
+
+func (sq *subQueue) stop() {
+	close(sq.quit)
+}
@@ -9,12 +9,31 @@ const (
 	INDEX_FILE_NAME         = "index.html"
 	MODELS_FOLDER_NAME      = "models"
 	LOADING_ORDER_FILE_NAME = "config.json"
+	MANIFEST_FILE_NAME      = "manifest.json"
+	SIGNATURE_FILE_NAME     = "manifest.sig"
 )
 
 type Dapp interface {
 	Models() []string
 	Path() string
 	PackageFile() *PackageFile
+	// The name of the trusted publisher that signed the dapp, or
+	// the empty string if it could not be verified.
+	Publisher() string
+	// The sha256 hashes (hex) of the files of a verified dapp, by path
+	// relative to the dapp directory. Nil if it could not be verified.
+	VerifiedFiles() map[string]string
+	// Sandboxed dapps have no access to the modules.
+	Sandboxed() bool
+}
+
+// The manifest of a dapp package. It has the sha256 hash (hex) of every
+// file in the dapp directory (except the manifest and its signature), keyed
+// by the path relative to the dapp directory. The signature of the manifest
+// file is in 'manifest.sig'.
+type Manifest struct {
+	Publisher string            `json:"publisher"`
+	Files     map[string]string `json:"files"`
 }
 
 // Structs that are mapped to the package file.
@@ -30,6 +49,9 @@ type (
 		Bugs               *Bugs               `json:"bugs"`
 		Licence            *Licence            `json:"licence"`
 		ModuleDependencies []*ModuleDependency `json:"module_dependencies"`
+		// The runtime capabilities the dapp needs, like 'blockchain.read' or
+		// 'fs.temp'. If left out, the dapp gets none.
+		Capabilities       []string            `json:"capabilities"`
 	}
 
 	Author struct {
@@ -54,9 +76,10 @@ type (
 	ModuleDependency struct {
 		Name    string          `json:"name"`
 		Version string          `json:"version"`
-		Data    *json.RawMessage `json:data`
+		Data    *json.RawMessage `json:"data"`
 	}
 
+	// The config data dapps pass to the monk module. It sets the chain.
 	MonkData struct {
 		RootContract      string `json:"root_contract"`
 		ChainId           string `json:"blockchain_id"`
@@ -74,6 +97,11 @@ type DappInfo struct {
 	Repository *Repository `json:"repository"`
 	Bugs       *Bugs       `json:"bugs"`
 	Licence    *Licence    `json:"licence"`
+	Loaded     bool        `json:"loaded"`
+	Publisher  string      `json:"publisher"`
+	Sandboxed  bool        `json:"sandboxed"`
+	// The capabilities the dapp is granted.
+	Capabilities []string  `json:"capabilities"`
 }
 
 type LoadOrderConfig struct {
@@ -103,8 +131,16 @@ func NewPackageFileFromJson(pfJson []byte) (*PackageFile, error) {
 	return pf, nil
 }
 
+// Any number of dapps can be loaded at the same time. Each loaded
+// dapp gets its own runtime, which is keyed by the dapp id.
 type DappManager interface {
 	DappList() []*DappInfo
 	LoadDapp(dappId string) error
+	UnloadDapp(dappId string) error
+	// Re-read the dapp files and, if it is loaded, rebuild its runtime.
+	ReloadDapp(dappId string) error
+	// Ids of the dapps that are currently loaded.
+	LoadedDapps() []string
+	IsLoaded(dappId string) bool
 	RegisterDapps(string, string) error
 }
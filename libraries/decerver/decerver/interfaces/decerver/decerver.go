@@ -1,6 +1,9 @@
 package decerver
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
 	"github.com/eris-ltd/decerver/interfaces/scripting"
 	"github.com/eris-ltd/decerver/interfaces/dapps"
 	"github.com/eris-ltd/decerver/interfaces/events"
@@ -16,14 +19,117 @@ type DCConfig struct {
 	Hostname   string `json:"hostname"`
 	Port       int    `json:"port"`
 	DebugMode  bool   `json:"debug_mode"`
+	// What to do with dapps that are not signed by a trusted publisher
+	// (see the DAPP_POLICY constants). Defaults to sandbox.
+	DappPolicy string `json:"dapp_policy"`
+	// Limits for calls into the dapp runtimes. Zero means the default
+	// (see scripting.DefaultRuntimeLimits), and a negative value no limit.
+	ScriptTimeout    int `json:"script_timeout"` // milliseconds
+	ScriptStackDepth int `json:"script_stack_depth"`
+	ScriptMaxMemory  int `json:"script_max_memory"` // megabytes
+	ScriptMaxSteps   int `json:"script_max_steps"`
+	// Serve over https. Both are pem files.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// Admin API requests must carry the admin token, or a client certificate
+	// signed by the admin CA (a pem file, needs https). If neither is set,
+	// only requests from localhost are accepted.
+	AdminToken    string `json:"admin_token"`
+	AdminClientCA string `json:"admin_client_ca"`
+	// Accept admin requests from other hosts than localhost.
+	AdminAllowRemote bool `json:"admin_allow_remote"`
+	// Origins (like 'https://example.com') that browsers may make admin
+	// requests from, besides the decerver itself.
+	AdminOrigins []string `json:"admin_origins"`
+	// Events to keep in the event log, as 'source:event' (like
+	// 'monk:newBlock'). Subscribers can replay logged events.
+	EventLog []string `json:"event_log"`
+	// How many events the log keeps. Zero means the default
+	// (events.DEFAULT_EVENT_LOG_SIZE), and a negative value no limit.
+	EventLogSize int `json:"event_log_size"`
+	// How long events are kept, in hours. Zero means no limit.
+	EventLogMaxAge int `json:"event_log_max_age"`
 }
 
+const (
+	// Unverified dapps are not registered.
+	DAPP_POLICY_REFUSE = "refuse"
+	// Unverified dapps are run without access to the modules.
+	DAPP_POLICY_SANDBOX = "sandbox"
+	// Unverified dapps are run like any other (development only).
+	DAPP_POLICY_ALLOW = "allow"
+)
+
+// The schema of the config file.
+var ConfigSchema = &modules.ConfigSchema{Fields: []*modules.ConfigField{
+	{Name: "logfile", Type: modules.CONFIG_STRING},
+	{Name: "max_clients", Type: modules.CONFIG_NUMBER},
+	{Name: "hostname", Type: modules.CONFIG_STRING},
+	{Name: "port", Type: modules.CONFIG_NUMBER},
+	{Name: "debug_mode", Type: modules.CONFIG_BOOL},
+	{Name: "dapp_policy", Type: modules.CONFIG_STRING, Description: "refuse, sandbox or allow"},
+	{Name: "script_timeout", Type: modules.CONFIG_NUMBER, Description: "milliseconds"},
+	{Name: "script_stack_depth", Type: modules.CONFIG_NUMBER},
+	{Name: "script_max_memory", Type: modules.CONFIG_NUMBER, Description: "megabytes"},
+	{Name: "script_max_steps", Type: modules.CONFIG_NUMBER},
+	{Name: "tls_cert", Type: modules.CONFIG_STRING},
+	{Name: "tls_key", Type: modules.CONFIG_STRING},
+	{Name: "admin_token", Type: modules.CONFIG_STRING},
+	{Name: "admin_client_ca", Type: modules.CONFIG_STRING},
+	{Name: "admin_allow_remote", Type: modules.CONFIG_BOOL},
+	{Name: "admin_origins", Type: modules.CONFIG_ARRAY},
+	{Name: "event_log", Type: modules.CONFIG_ARRAY, Description: "source:event"},
+	{Name: "event_log_size", Type: modules.CONFIG_NUMBER},
+	{Name: "event_log_max_age", Type: modules.CONFIG_NUMBER, Description: "hours"},
+}}
 
+// Config fields (by json name) that only take effect when the decerver
+// is restarted. The others are applied right away.
+var RESTART_FIELDS = []string{"logfile", "max_clients", "hostname", "port", "tls_cert", "tls_key", "admin_client_ca",
+	"event_log", "event_log_size", "event_log_max_age"}
+
+// Check the values in a config (the schema only checks the types).
+func ValidateConfig(cfg *DCConfig) error {
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("Invalid port: %d", cfg.Port)
+	}
+	if cfg.MaxClients < 0 {
+		return fmt.Errorf("Invalid max_clients: %d", cfg.MaxClients)
+	}
+	switch cfg.DappPolicy {
+	case "", DAPP_POLICY_REFUSE, DAPP_POLICY_SANDBOX, DAPP_POLICY_ALLOW:
+	default:
+		return fmt.Errorf("Invalid dapp_policy: %s", cfg.DappPolicy)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("Both tls_cert and tls_key must be set to enable https.")
+	}
+	for _, e := range cfg.EventLog {
+		if parts := strings.Split(e, ":"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("Invalid event log entry (should be 'source:event'): %s", e)
+		}
+	}
+	if cfg.EventLogMaxAge < 0 {
+		return fmt.Errorf("Invalid event_log_max_age: %d", cfg.EventLogMaxAge)
+	}
+	for _, o := range cfg.AdminOrigins {
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Invalid admin origin: %s", o)
+		}
+	}
+	return nil
+}
 
 // The decerver interface.
 type Decerver interface {
-	// Get the config file.
+	// Get the config file. It must not be modified, and since ApplyConfig
+	// replaces it, it should be got again rather than kept.
 	Config() *DCConfig
+	// Validate a new config and apply it to the running decerver. Returns
+	// the (json) names of the fields that were changed, and of the ones that
+	// need a restart to take effect.
+	ApplyConfig(cfg *DCConfig) (applied, restartRequired []string, err error)
 	// Is the decerver started?
 	IsStarted() bool
 	// Get the runtime manager.
@@ -4,6 +4,8 @@ package events
 // interface. The event system is pub/sub. If you want an object to subscribe to events, make sure
 // it implements the Subscriber interface and pass it to the event system.
 import (
+	"encoding/json"
+	"strings"
 	"time"
 	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
 )
@@ -13,6 +15,12 @@ type EventProcessor interface {
 	Subscribe(sub Subscriber) error
 	Unsubscribe(id string) error
 	TrafficData() string
+	// Start recording the events that are kept in the event log. Called
+	// once the modules are started.
+	StartEventLog() error
+	// Stop processing events and close the event log. Called once the
+	// modules are shut down.
+	Shutdown() error
 }
 
 // A default object that implements 'Event'
@@ -34,6 +42,129 @@ type Subscriber interface {
 	Id() string
 	// The type of event it subscribes to.
 	Event() string
-	// The target (if any).
+	// The target (if any). Can be a pattern (see MatchTarget).
 	Target() string
 }
+
+// What happens when the queue of a subscriber is full.
+const (
+	// The new event is dropped.
+	QUEUE_DROP = "drop"
+	// The oldest event in the queue is dropped.
+	QUEUE_DROP_OLDEST = "drop_oldest"
+	// Delivery of all events waits until there is room in the queue.
+	QUEUE_BLOCK = "block"
+)
+
+const DEFAULT_QUEUE_SIZE = 256
+
+// Each subscriber gets its own queue, and events are posted to it from a
+// separate goroutine, so a slow subscriber doesn't hold up the others.
+type QueueConfig struct {
+	Size   int    `json:"size"`
+	Policy string `json:"policy"`
+}
+
+var DefaultQueueConfig = QueueConfig{DEFAULT_QUEUE_SIZE, QUEUE_DROP_OLDEST}
+
+// Subscribers can implement this to set the size and policy of their queue.
+type QueuedSubscriber interface {
+	Subscriber
+	Queue() QueueConfig
+}
+
+// Subscribers can implement this to filter events further, after
+// source, type and target.
+type FilteredSubscriber interface {
+	Subscriber
+	Accept(types.Event) bool
+}
+
+// Match an event target against the target of a subscription. '*' matches
+// any target, and a target that ends with '*' matches by prefix. Otherwise
+// they must be the same.
+func MatchTarget(pattern, target string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(target, pattern[:len(pattern)-1])
+	}
+	return pattern == target
+}
+
+// Fields that the resource of an event must have (with the given values).
+// The resource is compared in its json form, so struct resources are matched
+// by their json field names.
+type ResourceFilter map[string]interface{}
+
+func (rf ResourceFilter) Match(resource interface{}) bool {
+	if len(rf) == 0 {
+		return true
+	}
+	fields, ok := resource.(map[string]interface{})
+	if !ok {
+		bts, err := json.Marshal(resource)
+		if err != nil || json.Unmarshal(bts, &fields) != nil {
+			return false
+		}
+	}
+	for k, v := range rf {
+		fv, ok := fields[k]
+		if !ok || !jsonEqual(fv, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ab) == string(bb)
+}
+
+// A subscription filter, for subscribers that take theirs from users (like
+// dapp runtimes and event streams).
+type Filter struct {
+	Source   string         `json:"source"`
+	Event    string         `json:"event"`
+	Target   string         `json:"target"`
+	Resource ResourceFilter `json:"resource"`
+}
+
+func (f *Filter) Match(e types.Event) bool {
+	return e.Source == f.Source && e.Event == f.Event && MatchTarget(f.Target, e.Target) && f.Resource.Match(e.Resource)
+}
+
+// How many events the event log keeps by default.
+const DEFAULT_EVENT_LOG_SIZE = 10000
+
+// An event in the event log. Sequence numbers start at 1 and go up by one
+// for each logged event. The height is the block height the event was
+// logged at, taken from the 'height' or 'number' field of the resource
+// of the last event that had one.
+type LogEntry struct {
+	Seq    uint64      `json:"seq"`
+	Height uint64      `json:"height"`
+	Time   time.Time   `json:"time"`
+	Event  types.Event `json:"event"`
+}
+
+// Where a replay starts: the events after sequence number Seq or, if Height
+// is set, the events from block height Height on.
+type Replay struct {
+	Seq    uint64 `json:"seq"`
+	Height uint64 `json:"height"`
+}
+
+// Subscribers that implement this and return a replay position get the
+// logged events from that position first, then the new ones as they are
+// logged. They get log entries instead of events, so they know where to
+// replay from next time. Only events that are kept in the log can be
+// replayed.
+type ReplaySubscriber interface {
+	Subscriber
+	Replay() *Replay
+	PostEntry(*LogEntry)
+}
@@ -1,6 +1,7 @@
 package modules
 
 import (
+	"fmt"
 	"github.com/eris-ltd/decerver/interfaces/files"
 	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
 )
@@ -36,6 +37,30 @@ type (
 		Property(name string) interface{}
 	}
 
+	// Modules can implement this as well to have their version and config
+	// checked, and to be told about the dapps that depend on them. Modules
+	// that don't are used by dapps as they are.
+	ConfigurableModule interface {
+		Module
+		// The module version (semver). Dapps depend on ranges of it.
+		Version() string
+		// The schema of the config data that dapps can pass to the module
+		// in their package file. Nil if the module takes no config.
+		ConfigSchema() *ConfigSchema
+		// The schema of the module's own config (the 'config' file in the
+		// module directory). Nil if it is not checked.
+		ModuleConfigSchema() *ConfigSchema
+		// Called when a dapp that depends on the module is loaded (or reloaded),
+		// with the config data from its package file. Returns objects that
+		// should be bound in the runtime of the dapp (may be nil). A monk
+		// module that implements this runs each dapp on the chain in its
+		// config data (see dapps.MonkData), with its own objects; a plain
+		// one runs the same chain for every dapp.
+		ConfigureDapp(dappId string, config map[string]interface{}) (map[string]interface{}, error)
+		// Called when a dapp that depends on the module is unloaded.
+		ReleaseDapp(dappId string)
+	}
+
 	// Interface for the module manager.
 	ModuleManager interface {
 		Modules() map[string]Module
@@ -44,16 +69,91 @@ type (
 		Init() error
 		Start() error
 		Shutdown() error
+		// Set the config properties of a running module and restart it. If
+		// the restart fails the previous properties are restored.
+		Configure(name string, config map[string]interface{}) error
 	}
 
 	// This is the functionality that decerver exports to modules
 	// when they register.
 	DecerverModuleApi interface {
 		// register an object with the script runtime manager (Atë).
-		RegisterRuntimeObject(string,interface{})
+		RegisterRuntimeObject(string, interface{})
+		// Register a capability that dapps can ask for in their package file.
+		// It grants the given methods of a runtime object, or all of it if no
+		// methods are given (see scripting.RuntimeManager).
+		RegisterRuntimeCapability(name, object string, methods ...string)
 		// Register script in the form of a string
 		RegisterRuntimeScript(string)
 		// File and folder management tool.
 		FileIO() files.FileIO
 	}
-)
\ No newline at end of file
+)
+
+// Config field types
+const (
+	CONFIG_STRING = "string"
+	CONFIG_NUMBER = "number"
+	CONFIG_BOOL   = "bool"
+	CONFIG_OBJECT = "object"
+	CONFIG_ARRAY  = "array"
+)
+
+type (
+	ConfigField struct {
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		Required    bool   `json:"required"`
+		Description string `json:"description"`
+	}
+
+	ConfigSchema struct {
+		Fields []*ConfigField `json:"fields"`
+	}
+)
+
+// The error for config data that does not match a schema.
+type ConfigError struct {
+	Msg string
+}
+
+func (ce *ConfigError) Error() string {
+	return ce.Msg
+}
+
+// Check config data (as decoded from json) against the schema. Fields
+// that are not in the schema are not allowed.
+func (cs *ConfigSchema) Validate(config map[string]interface{}) error {
+	fields := make(map[string]*ConfigField)
+	for _, f := range cs.Fields {
+		fields[f.Name] = f
+		if _, ok := config[f.Name]; !ok && f.Required {
+			return fmt.Errorf("Missing required field: %s", f.Name)
+		}
+	}
+	for name, val := range config {
+		f, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("Unknown field: %s", name)
+		}
+		okType := false
+		switch val.(type) {
+		case string:
+			okType = f.Type == CONFIG_STRING
+		case float64:
+			okType = f.Type == CONFIG_NUMBER
+		case bool:
+			okType = f.Type == CONFIG_BOOL
+		case map[string]interface{}:
+			okType = f.Type == CONFIG_OBJECT
+		case []interface{}:
+			okType = f.Type == CONFIG_ARRAY
+		case nil:
+			okType = !f.Required
+		}
+		if !okType {
+			return fmt.Errorf("Field '%s' should be of type: %s", name, f.Type)
+		}
+	}
+	return nil
+}
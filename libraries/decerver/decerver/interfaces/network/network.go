@@ -15,5 +15,10 @@ type WsSession interface {
 type Server interface {
 	AddDappManager(dapps.DappManager)
 	RegisterDapp(dappId string)
+	// Close the websocket sessions of a dapp (when it is unloaded).
+	CloseSessions(dappId string)
+	// Move the websocket sessions of a dapp over to its new runtime,
+	// and tell the clients that the dapp was reloaded.
+	ReloadSessions(dappId string)
 	Start() error
 }
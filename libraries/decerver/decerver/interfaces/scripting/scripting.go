@@ -1,7 +1,9 @@
 package scripting
 
 import (
-	"github.com/eris-ltd/decerver/interfaces/types"	
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/types"
+	"time"
 )
 
 const (
@@ -19,10 +21,28 @@ type(
 	RuntimeManager interface {
 		GetRuntime(string) Runtime
 		CreateRuntime(string) Runtime
+		// Create a runtime without the api objects and scripts that
+		// the modules have registered.
+		CreateSandboxedRuntime(string) Runtime
+		// Create a runtime that only gets the api objects (or the methods of
+		// them) that the given capabilities grant. A nil capability list
+		// grants everything. The objects in 'objs' belong to the dapp (like
+		// its root contract) and are always bound.
+		CreateRestrictedRuntime(name string, caps []string, objs map[string]interface{}) Runtime
 		RemoveRuntime(string)
 		RegisterApiObject(string, interface{})
 		RegisterApiScript(string)
+		// Register a capability that grants access to an api object, or only
+		// to the given methods of it. Every api object is also granted in full
+		// by a capability with the same name as the object.
+		RegisterCapability(name, object string, methods ...string)
+		// The names of all registered capabilities.
+		Capabilities() []string
 		ShutdownRuntimes()
+		// Set the limits for runtimes created from now on.
+		SetLimits(limits RuntimeLimits)
+		// Call metrics for all runtimes, by runtime id.
+		Metrics() map[string]RuntimeMetrics
 	}
 
 	// This is the interface for a javascript runtime.
@@ -32,14 +52,100 @@ type(
 		// This is normally the same as the dapp id when running decerver.
 		Id() string
 		BindScriptObject(name string, val interface{}) error
+		// Set the directory that relative 'require' paths in scripts are
+		// resolved from. Other paths are looked up in the shared library
+		// directory (in the modules directory).
+		SetBaseDir(dir string)
+		// Only let 'require' load the files in the base directory that
+		// have these sha256 hashes (hex), by file name. Used for verified
+		// dapps, so that files changed after they were checked are not run.
+		SetVerifiedFiles(hashes map[string]string)
 		LoadScriptFile(fileName string) error
 		LoadScriptFiles(fileName ...string) error
 		AddScript(script string) error
 		CallFunc(funcName string, param ...interface{}) (interface{}, error)
 		CallFuncOnObj(objName, funcName string, param ...interface{}) (interface{}, error)
+		Metrics() RuntimeMetrics
 	}
 )
 
+// Capabilities for the built-in runtime functions.
+const (
+	// WriteTempFile and ReadTempFile.
+	CAP_FS_TEMP = "fs.temp"
+	// GetUserHome.
+	CAP_FS_HOME = "fs.home"
+	// The key-value store of the dapp (the 'kv' object).
+	CAP_STORAGE_KV = "storage.kv"
+	// Reading accounts, storage and blocks (the 'monk' object).
+	CAP_BLOCKCHAIN_READ = "blockchain.read"
+	// Sending transactions and managing the active address (the 'monk' object).
+	CAP_BLOCKCHAIN_TRANSACT = "blockchain.transact"
+	// Getting files and blocks from ipfs (the 'ipfs' object).
+	CAP_IPFS_READ = "ipfs.read"
+	// Adding files and blocks to ipfs (the 'ipfs' object).
+	CAP_IPFS_WRITE = "ipfs.write"
+)
+
+// The error thrown in javascript when a runtime uses something it has no
+// capability for.
+const CAPABILITY_ERROR = "CapabilityError"
+
+// The limits a call can break.
+const (
+	LIMIT_TIMEOUT = "timeout"
+	LIMIT_STACK   = "stack_depth"
+	LIMIT_MEMORY  = "memory"
+	LIMIT_STEPS   = "steps"
+)
+
+// Limits for each call into a runtime (running a script or calling a function).
+// Zero means no limit.
+type RuntimeLimits struct {
+	// Wall-clock time.
+	Timeout time.Duration
+	// Depth of the javascript call stack.
+	StackDepth int
+	// How much the heap may grow during a call, in bytes. This is
+	// approximate, since the heap is shared with the rest of the decerver.
+	MaxMemory uint64
+	// Statements and expressions that are evaluated. This stops runaway
+	// loops before the timeout does, but makes calls about three times
+	// slower, so it is off by default.
+	MaxSteps uint64
+}
+
+var DefaultRuntimeLimits = RuntimeLimits{
+	Timeout:    5 * time.Second,
+	StackDepth: 1000,
+	MaxMemory:  256 << 20,
+}
+
+// The error returned from a runtime call that was stopped because it went
+// over one of the limits.
+type LimitError struct {
+	Limit   string `json:"limit"`
+	Runtime string `json:"runtime"`
+	Call    string `json:"call"`
+}
+
+func (le *LimitError) Error() string {
+	return fmt.Sprintf("Call to '%s' in runtime '%s' was stopped (%s limit exceeded).", le.Call, le.Runtime, le.Limit)
+}
+
+// Call statistics for a runtime.
+type RuntimeMetrics struct {
+	Calls          uint64 `json:"calls"`
+	Errors         uint64 `json:"errors"`
+	Timeouts       uint64 `json:"timeouts"`
+	StackOverflows uint64 `json:"stack_overflows"`
+	MemoryLimits   uint64 `json:"memory_limits"`
+	StepLimits     uint64 `json:"step_limits"`
+	// Total and longest call time, in milliseconds.
+	CallTime    int64 `json:"call_time"`
+	MaxCallTime int64 `json:"max_call_time"`
+}
+
 // Converts a data and an error values into a javascript ready object. If an error occurs, 
 // the status will be set as such:
 // STATUS_NORMAL - if data is non-nil and error is nil, or if both are nil.
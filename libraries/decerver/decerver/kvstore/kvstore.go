@@ -0,0 +1,185 @@
+package kvstore
+
+// Persistent key-value storage for dapps. All dapps share one leveldb
+// database, and each dapp gets its own namespace (the keys are prefixed
+// with the length of the dapp id and the id). Values are json.
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The name of the database directory (in the system directory).
+const DB_DIR_NAME = "kvstore"
+
+// Batch operations.
+const (
+	OP_PUT    = "put"
+	OP_DELETE = "delete"
+)
+
+type Store struct {
+	db *leveldb.DB
+}
+
+func Open(dir string) (*Store, error) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get the store of a dapp.
+func (s *Store) Dapp(dappId string) *DappStore {
+	// The id is length-prefixed, so one namespace can't be a prefix of
+	// another whatever bytes the ids have in them.
+	prefix := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(dappId))
+	prefix = append(prefix[:binary.PutUvarint(prefix, uint64(len(dappId)))], dappId...)
+	return &DappStore{s.db, prefix}
+}
+
+// The key-value store of a dapp. This is the object that is bound in
+// the runtime of the dapp.
+type DappStore struct {
+	db     *leveldb.DB
+	prefix []byte
+}
+
+// An operation in a batch.
+type BatchOp struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// A key and its value, as returned by List.
+type Entry struct {
+	Key   string
+	Value interface{}
+}
+
+func (ds *DappStore) key(key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("Empty key.")
+	}
+	return append(append([]byte{}, ds.prefix...), key...), nil
+}
+
+// Get a value. The data is null if there is no value for the key.
+func (ds *DappStore) Get(key string) scripting.SObject {
+	k, err := ds.key(key)
+	if err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	bts, err := ds.db.Get(k, nil)
+	if err == leveldb.ErrNotFound {
+		return scripting.JsReturnVal(nil, nil)
+	} else if err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	var val interface{}
+	if err := json.Unmarshal(bts, &val); err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	return scripting.JsReturnVal(val, nil)
+}
+
+func (ds *DappStore) Has(key string) scripting.SObject {
+	k, err := ds.key(key)
+	if err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	ok, err := ds.db.Has(k, nil)
+	if err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	return scripting.JsReturnVal(ok, nil)
+}
+
+// Store a value (anything that can be turned into json).
+func (ds *DappStore) Put(key string, value json.RawMessage) scripting.SObject {
+	k, err := ds.key(key)
+	if err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	if err := checkValue(value); err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	return scripting.JsReturnVal(nil, ds.db.Put(k, value, nil))
+}
+
+func (ds *DappStore) Delete(key string) scripting.SObject {
+	k, err := ds.key(key)
+	if err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	return scripting.JsReturnVal(nil, ds.db.Delete(k, nil))
+}
+
+// Get the entries with keys that start with 'prefix', in key order. If
+// limit is larger than zero, at most that many entries are returned.
+func (ds *DappStore) List(prefix string, limit int) scripting.SObject {
+	p := append(append([]byte{}, ds.prefix...), prefix...)
+	it := ds.db.NewIterator(util.BytesPrefix(p), nil)
+	defer it.Release()
+	entries := []*Entry{}
+	for it.Next() {
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+		var val interface{}
+		if err := json.Unmarshal(it.Value(), &val); err != nil {
+			return scripting.JsReturnVal(nil, err)
+		}
+		entries = append(entries, &Entry{string(it.Key()[len(ds.prefix):]), val})
+	}
+	if err := it.Error(); err != nil {
+		return scripting.JsReturnVal(nil, err)
+	}
+	return scripting.JsReturnVal(entries, nil)
+}
+
+// Run a list of put and delete operations (see BatchOp). Either all
+// of them are done, or none.
+func (ds *DappStore) Batch(ops json.RawMessage) scripting.SObject {
+	bops := []*BatchOp{}
+	if err := json.Unmarshal(ops, &bops); err != nil {
+		return scripting.JsReturnVal(nil, fmt.Errorf("Batch is not a list of operations: %s", err.Error()))
+	}
+	batch := new(leveldb.Batch)
+	for i, op := range bops {
+		k, err := ds.key(op.Key)
+		if err != nil {
+			return scripting.JsReturnVal(nil, fmt.Errorf("Operation %d: %s", i, err.Error()))
+		}
+		switch op.Op {
+		case OP_PUT:
+			if err := checkValue(op.Value); err != nil {
+				return scripting.JsReturnVal(nil, fmt.Errorf("Operation %d: %s", i, err.Error()))
+			}
+			batch.Put(k, op.Value)
+		case OP_DELETE:
+			batch.Delete(k)
+		default:
+			return scripting.JsReturnVal(nil, fmt.Errorf("Operation %d: unknown operation '%s'.", i, op.Op))
+		}
+	}
+	return scripting.JsReturnVal(nil, ds.db.Write(batch, nil))
+}
+
+func checkValue(value json.RawMessage) error {
+	var v interface{}
+	if len(value) == 0 || json.Unmarshal(value, &v) != nil {
+		return errors.New("Value is not valid json.")
+	}
+	return nil
+}
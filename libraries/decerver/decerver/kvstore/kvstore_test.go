@@ -0,0 +1,93 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func openTestStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func checkOk(t *testing.T, ret scripting.SObject) interface{} {
+	if ret["Status"] != scripting.STATUS_NORMAL {
+		t.Fatalf("error: %v", ret["Error"])
+	}
+	return ret["Data"]
+}
+
+func TestDappStore(t *testing.T) {
+	s, done := openTestStore(t)
+	defer done()
+	a := s.Dapp("a")
+	b := s.Dapp("ab")
+
+	checkOk(t, a.Put("x", json.RawMessage(`{"n": 5}`)))
+	val := checkOk(t, a.Get("x")).(map[string]interface{})
+	if val["n"] != float64(5) {
+		t.Fatalf("got %v", val)
+	}
+	// Namespaces don't overlap.
+	if checkOk(t, b.Get("x")) != nil {
+		t.Fatal("value visible in another dapp")
+	}
+	checkOk(t, s.Dapp("a\x00b").Put("z", json.RawMessage(`1`)))
+	if checkOk(t, a.Get("b\x00z")) != nil || len(checkOk(t, a.List("", 0)).([]interface{})) != 1 {
+		t.Fatal("value visible in a dapp whose id is a prefix of the owner's")
+	}
+	if a.Put("y", json.RawMessage(`{bad`))["Status"] != scripting.STATUS_ERROR {
+		t.Fatal("stored invalid json")
+	}
+
+	checkOk(t, a.Delete("x"))
+	if checkOk(t, a.Has("x")) != false {
+		t.Fatal("value not deleted")
+	}
+}
+
+func TestDappStoreBatchAndList(t *testing.T) {
+	s, done := openTestStore(t)
+	defer done()
+	a := s.Dapp("a")
+	s.Dapp("b").Put("idx/1", json.RawMessage(`1`))
+
+	checkOk(t, a.Batch(json.RawMessage(`[
+		{"op": "put", "key": "idx/2", "value": 2},
+		{"op": "put", "key": "idx/1", "value": 1},
+		{"op": "put", "key": "other", "value": "x"}
+	]`)))
+	entries := checkOk(t, a.List("idx/", 0)).([]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("got %v", entries)
+	}
+	first := entries[0].(map[string]interface{})
+	if first["Key"] != "idx/1" || first["Value"] != float64(1) {
+		t.Fatalf("got %v", first)
+	}
+	if len(checkOk(t, a.List("", 1)).([]interface{})) != 1 {
+		t.Fatal("limit not applied")
+	}
+
+	// A bad operation means nothing is written.
+	ret := a.Batch(json.RawMessage(`[{"op": "delete", "key": "other"}, {"op": "bad", "key": "x"}]`))
+	if ret["Status"] != scripting.STATUS_ERROR {
+		t.Fatal("batch with a bad operation succeeded")
+	}
+	if checkOk(t, a.Has("other")) != true {
+		t.Fatal("part of a failed batch was written")
+	}
+}
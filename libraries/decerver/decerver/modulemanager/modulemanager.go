@@ -66,3 +66,37 @@ func (mm *ModuleManager) Shutdown() error {
 	}
 	return nil
 }
+
+func (mm *ModuleManager) Configure(name string, config map[string]interface{}) error {
+	mod, ok := mm.modules[name]
+	if !ok {
+		return fmt.Errorf("No module with name: %s", name)
+	}
+	if cm, ok := mod.(modules.ConfigurableModule); ok {
+		if schema := cm.ModuleConfigSchema(); schema != nil {
+			if err := schema.Validate(config); err != nil {
+				return &modules.ConfigError{Msg: err.Error()}
+			}
+		}
+	}
+
+	old := make(map[string]interface{})
+	for k, _ := range config {
+		old[k] = mod.Property(k)
+	}
+	for k, v := range config {
+		mod.SetProperty(k, v)
+	}
+	err := mod.Restart()
+	if err == nil {
+		return nil
+	}
+
+	for k, v := range old {
+		mod.SetProperty(k, v)
+	}
+	if rbErr := mod.Restart(); rbErr != nil {
+		return fmt.Errorf("Module '%s' failed to restart with the new config (%s), and again with the old config: %s", name, err.Error(), rbErr.Error())
+	}
+	return fmt.Errorf("Module '%s' failed to restart with the new config, the old config was restored: %s", name, err.Error())
+}
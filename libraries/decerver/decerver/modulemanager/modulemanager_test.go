@@ -0,0 +1,71 @@
+package modulemanager
+
+import (
+	"errors"
+	"github.com/eris-ltd/decerver/interfaces/modules"
+	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
+	"testing"
+)
+
+// A module that fails to restart when its port is 0.
+type testModule struct {
+	props    map[string]interface{}
+	restarts int
+}
+
+func (m *testModule) Register(dc modules.DecerverModuleApi) error { return nil }
+func (m *testModule) Init() error                                 { return nil }
+func (m *testModule) Start() error                                { return nil }
+func (m *testModule) Shutdown() error                             { return nil }
+func (m *testModule) Name() string                                { return "test" }
+func (m *testModule) Version() string                             { return "1.0.0" }
+func (m *testModule) ConfigSchema() *modules.ConfigSchema         { return nil }
+func (m *testModule) ReleaseDapp(dappId string)                   {}
+func (m *testModule) UnSubscribe(name string)                     {}
+func (m *testModule) SetProperty(name string, data interface{})   { m.props[name] = data }
+func (m *testModule) Property(name string) interface{}            { return m.props[name] }
+func (m *testModule) Subscribe(name, event, target string) chan types.Event {
+	return nil
+}
+func (m *testModule) ConfigureDapp(dappId string, config map[string]interface{}) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (m *testModule) ModuleConfigSchema() *modules.ConfigSchema {
+	return &modules.ConfigSchema{Fields: []*modules.ConfigField{
+		{Name: "port", Type: modules.CONFIG_NUMBER},
+	}}
+}
+
+func (m *testModule) Restart() error {
+	m.restarts++
+	if m.props["port"] == float64(0) {
+		return errors.New("bad port")
+	}
+	return nil
+}
+
+func TestConfigure(t *testing.T) {
+	mod := &testModule{props: map[string]interface{}{"port": float64(30303)}}
+	mm := NewModuleManager()
+	mm.Add(mod)
+
+	if err := mm.Configure("test", map[string]interface{}{"port": float64(30304)}); err != nil {
+		t.Fatal(err)
+	}
+	if mod.props["port"] != float64(30304) || mod.restarts != 1 {
+		t.Fatalf("config not applied: %v", mod.props)
+	}
+
+	err := mm.Configure("test", map[string]interface{}{"port": "abc"})
+	if _, ok := err.(*modules.ConfigError); !ok {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+
+	if err := mm.Configure("test", map[string]interface{}{"port": float64(0)}); err == nil {
+		t.Fatal("expected the restart to fail")
+	}
+	if mod.props["port"] != float64(30304) || mod.restarts != 3 {
+		t.Fatalf("config not rolled back: %v", mod.props)
+	}
+}
@@ -0,0 +1,207 @@
+package runtimemanager
+
+import (
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"github.com/robertkrimen/otto"
+	"reflect"
+	"sort"
+)
+
+// Something a capability grants: the given methods of an api object, or
+// all of it if there are no methods. An empty object name means global
+// functions (the methods are the function names).
+type capability struct {
+	object  string
+	methods []string
+}
+
+// The capabilities of the built-in functions and of the objects the standard
+// modules bind. Other modules can register their own.
+func builtinCapabilities() map[string][]*capability {
+	return map[string][]*capability{
+		scripting.CAP_FS_TEMP:    {{"", []string{"WriteTempFile", "ReadTempFile"}}},
+		scripting.CAP_FS_HOME:    {{"", []string{"GetUserHome"}}},
+		scripting.CAP_STORAGE_KV: {{KV_OBJECT_NAME, nil}},
+		scripting.CAP_BLOCKCHAIN_READ: {{"monk", []string{"WorldState", "State", "Storage", "Account",
+			"StorageAt", "BlockCount", "LatestBlock", "Block", "IsScript", "Call", "ActiveAddress",
+			"Address", "AddressCount"}}},
+		scripting.CAP_BLOCKCHAIN_TRANSACT: {{"monk", []string{"Tx", "Msg", "Script", "Transact",
+			"Commit", "AutoCommit", "IsAutocommit", "SetAddress", "SetAddressN", "NewAddress"}}},
+		scripting.CAP_IPFS_READ: {{"ipfs", []string{"GetBlock", "GetFile", "GetStream", "GetTree"}}},
+		scripting.CAP_IPFS_WRITE: {{"ipfs", []string{"PushBlock", "PushBlockString", "PushFile",
+			"PushTree"}}},
+	}
+}
+
+// What a runtime may use of an object.
+type grant struct {
+	all     bool
+	methods map[string]bool
+}
+
+func (g *grant) allows(method string) bool {
+	return g != nil && (g.all || g.methods[method])
+}
+
+func (rm *RuntimeManager) RegisterCapability(name, object string, methods ...string) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	rm.caps[name] = append(rm.caps[name], &capability{object, methods})
+}
+
+func (rm *RuntimeManager) Capabilities() []string {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	names := make([]string, 0, len(rm.caps))
+	for name, _ := range rm.caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collect what the capabilities grant, by object name. Returns nil (meaning
+// everything) if caps is nil. The rm mutex must be held.
+func (rm *RuntimeManager) grants(runtime string, caps []string) map[string]*grant {
+	if caps == nil {
+		return nil
+	}
+	grants := make(map[string]*grant)
+	for _, name := range caps {
+		cs, ok := rm.caps[name]
+		if !ok {
+			logger.Printf("Runtime '%s' asks for unknown capability: %s\n", runtime, name)
+			continue
+		}
+		for _, c := range cs {
+			g, ok := grants[c.object]
+			if !ok {
+				g = &grant{false, make(map[string]bool)}
+				grants[c.object] = g
+			}
+			if len(c.methods) == 0 {
+				g.all = true
+			}
+			for _, m := range c.methods {
+				g.methods[m] = true
+			}
+		}
+	}
+	return grants
+}
+
+// The name of a capability that grants a method of an object, or the
+// empty string if there is none. The rm mutex must be held.
+func (rm *RuntimeManager) required(object, method string) string {
+	names := make([]string, 0, len(rm.caps))
+	for name, _ := range rm.caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, c := range rm.caps[name] {
+			if c.object != object {
+				continue
+			}
+			if len(c.methods) == 0 {
+				return name
+			}
+			for _, m := range c.methods {
+				if m == method {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Replace the built-in global functions that are not granted with ones that
+// throw a capability error. The rm mutex must be held.
+func (rm *RuntimeManager) restrictBuiltins(rt *Runtime, grants map[string]*grant) {
+	if grants == nil {
+		return
+	}
+	for _, cs := range rm.caps {
+		for _, c := range cs {
+			if c.object != "" {
+				continue
+			}
+			for _, fn := range c.methods {
+				if !grants[""].allows(fn) {
+					rt.vm.Set(fn, rt.denied(fn, rm.required("", fn)))
+				}
+			}
+		}
+	}
+}
+
+// Bind an api object in the runtime. If the runtime is only granted some
+// of its methods, it gets an object that has those methods, and the rest
+// throw a capability error. Objects that are not granted at all are not
+// bound. The rm mutex must be held.
+func (rm *RuntimeManager) bindObject(rt *Runtime, name string, obj interface{}, grants map[string]*grant) error {
+	g := grants[name]
+	if grants == nil || (g != nil && g.all) {
+		return rt.BindScriptObject(name, obj)
+	}
+	if g == nil {
+		return nil
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	full, err := rt.vm.ToValue(obj)
+	if err != nil {
+		return err
+	}
+	restricted, err := rt.vm.Object("({})")
+	if err != nil {
+		return err
+	}
+	for _, m := range memberNames(obj) {
+		if g.allows(m) {
+			val, err := full.Object().Get(m)
+			if err != nil {
+				return err
+			}
+			restricted.Set(m, val)
+		} else {
+			restricted.Set(m, rt.denied(name+"."+m, rm.required(name, m)))
+		}
+	}
+	return rt.vm.Set(name, restricted)
+}
+
+// The exported methods of an object, or the keys if it is a map.
+func memberNames(obj interface{}) []string {
+	names := []string{}
+	val := reflect.ValueOf(obj)
+	if val.Kind() == reflect.Map && val.Type().Key().Kind() == reflect.String {
+		for _, k := range val.MapKeys() {
+			names = append(names, k.String())
+		}
+		sort.Strings(names)
+		return names
+	}
+	tp := val.Type()
+	for i := 0; i < tp.NumMethod(); i++ {
+		names = append(names, tp.Method(i).Name)
+	}
+	return names
+}
+
+// A javascript function that logs the denial and throws a capability error.
+func (rt *Runtime) denied(fn, capability string) func(otto.FunctionCall) otto.Value {
+	var msg string
+	if capability == "" {
+		msg = fmt.Sprintf("'%s' is not available in runtime '%s'.", fn, rt.name)
+	} else {
+		msg = fmt.Sprintf("'%s' requires the '%s' capability, which runtime '%s' does not have.", fn, capability, rt.name)
+	}
+	return func(call otto.FunctionCall) otto.Value {
+		logger.Println("Capability denied: " + msg)
+		panic(rt.vm.MakeCustomError(scripting.CAPABILITY_ERROR, msg))
+	}
+}
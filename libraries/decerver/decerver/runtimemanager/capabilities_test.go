@@ -0,0 +1,135 @@
+package runtimemanager
+
+import (
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"github.com/eris-ltd/decerver/kvstore"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testChain struct{}
+
+func (tc *testChain) Read() int     { return 1 }
+func (tc *testChain) Transact() int { return 2 }
+
+func newTestManager() *RuntimeManager {
+	rm := &RuntimeManager{
+		runtimes: make(map[string]scripting.Runtime),
+		mutex:    &sync.Mutex{},
+		caps:     builtinCapabilities(),
+	}
+	rm.RegisterApiObject("chain", &testChain{})
+	rm.RegisterCapability("blockchain.read", "chain", "Read")
+	rm.RegisterCapability("blockchain.transact", "chain", "Transact")
+	return rm
+}
+
+func TestRestrictedRuntime(t *testing.T) {
+	rm := newTestManager()
+	rt := rm.CreateRestrictedRuntime("test", []string{"blockchain.read"}, nil)
+
+	ret, err := rt.CallFuncOnObj("chain", "Read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ret) != "1" {
+		t.Fatalf("got %v", ret)
+	}
+
+	_, err = rt.CallFuncOnObj("chain", "Transact")
+	if err == nil || !strings.Contains(err.Error(), "blockchain.transact") {
+		t.Fatalf("expected a capability error, got %v", err)
+	}
+
+	err = rt.AddScript("WriteTempFile('a', 'b');")
+	if err == nil || !strings.Contains(err.Error(), scripting.CAP_FS_TEMP) {
+		t.Fatalf("expected a capability error, got %v", err)
+	}
+}
+
+type testMonk struct{}
+
+func (tm *testMonk) Account() int { return 1 }
+func (tm *testMonk) Tx() int      { return 2 }
+
+func TestStandardCapabilities(t *testing.T) {
+	rm := newTestManager()
+	rm.RegisterApiObject("monk", &testMonk{})
+	rt := rm.CreateRestrictedRuntime("test", []string{scripting.CAP_BLOCKCHAIN_READ}, nil)
+
+	if _, err := rt.CallFuncOnObj("monk", "Account"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := rt.CallFuncOnObj("monk", "Tx")
+	if err == nil || !strings.Contains(err.Error(), scripting.CAP_BLOCKCHAIN_TRANSACT) {
+		t.Fatalf("expected a capability error, got %v", err)
+	}
+}
+
+// Objects that no capability of the runtime grants are not there at all.
+func TestUndeclaredObject(t *testing.T) {
+	rm := newTestManager()
+	rm.RegisterApiObject("monk", &testMonk{})
+	rt := rm.CreateRestrictedRuntime("test", []string{scripting.CAP_FS_TEMP}, nil)
+	rt.AddScript("var obj = {types: function(){ return typeof(monk) + ' ' + typeof(chain); }};")
+
+	ret, err := rt.CallFuncOnObj("obj", "types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != "undefined undefined" {
+		t.Fatalf("undeclared objects are bound: %v", ret)
+	}
+}
+
+func TestUnrestrictedRuntime(t *testing.T) {
+	rm := newTestManager()
+	rt := rm.CreateRestrictedRuntime("test", nil, nil)
+	if _, err := rt.CallFuncOnObj("chain", "Transact"); err != nil {
+		t.Fatal(err)
+	}
+
+	rt = rm.CreateRestrictedRuntime("test2", []string{"chain"}, nil)
+	if _, err := rt.CallFuncOnObj("chain", "Transact"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rm := newTestManager()
+	rm.store, err = kvstore.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rm.store.Close()
+
+	rt := rm.CreateRestrictedRuntime("test", []string{scripting.CAP_STORAGE_KV}, nil)
+	err = rt.AddScript(`
+		kv.Put("idx", {"blocks": [1, 2], "name": "a"});
+		var obj = {get: function(){ return kv.Get("idx").Data.blocks[1]; }};
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := rt.CallFuncOnObj("obj", "get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ret) != "2" {
+		t.Fatalf("got %v", ret)
+	}
+
+	rt = rm.CreateRestrictedRuntime("test2", []string{}, nil)
+	if err := rt.AddScript(`kv.Get("idx");`); err == nil {
+		t.Fatal("used the store without the capability")
+	}
+}
@@ -750,8 +750,16 @@ func bindEvents(vm *otto.Otto) {
 		 *                comes in.
 		 *  uid         - usually the session id as a string. Used to make the id unique.
 		 *                Uid needs to be a string.
+		 *  options     - optional. {resource: {field: value, ...}, queue: {size: n, policy: p}}
+		 *                'resource' are fields the event resource must have. The queue policy
+		 *                is 'drop' or 'drop_oldest' (default).
+		 *                'since' replays logged events first: {seq: n} replays the events
+		 *                after sequence number n, {height: h} the ones from block height h.
+		 *                Replayed and later events then have 'Seq' and 'Height' fields.
+		 *
+		 *  The target can be '*' (any target) or end with '*' (prefix match).
 		 */
-		events.subscribe = function(eventSource, eventType, eventTarget, callbackFn, uid){
+		events.subscribe = function(eventSource, eventType, eventTarget, callbackFn, uid, options){
 			Println("Subscribing");
 			if(typeof(callbackFn) !== "function"){
 				throw new Error("Trying to register a non callback function as callback.");
@@ -759,7 +767,11 @@ func bindEvents(vm *otto.Otto) {
 			var eventId = events.generateId(eventSource,eventType, uid);
 			Println("Adding sub: " + eventId);
 			// The jsr_events object has the go bindings to actually subscribe.
-			events_subscribe(eventSource, eventType, eventTarget, eventId);
+			if(typeof(options) === "object"){
+				events_subscribe(eventSource, eventType, eventTarget, eventId, JSON.stringify(options));
+			} else {
+				events_subscribe(eventSource, eventType, eventTarget, eventId);
+			}
 			this.callbacks[eventId] = callbackFn;
 		}
 
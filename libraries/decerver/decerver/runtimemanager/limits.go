@@ -0,0 +1,192 @@
+package runtimemanager
+
+import (
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"github.com/robertkrimen/otto"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the heap is checked during a call when there is a memory limit.
+const MEMORY_CHECK_INTERVAL = 100 * time.Millisecond
+
+// Get the runtime limits from the decerver config.
+func LimitsFromConfig(cfg *decerver.DCConfig) scripting.RuntimeLimits {
+	limits := scripting.DefaultRuntimeLimits
+	if cfg.ScriptTimeout > 0 {
+		limits.Timeout = time.Duration(cfg.ScriptTimeout) * time.Millisecond
+	} else if cfg.ScriptTimeout < 0 {
+		limits.Timeout = 0
+	}
+	if cfg.ScriptStackDepth > 0 {
+		limits.StackDepth = cfg.ScriptStackDepth
+	} else if cfg.ScriptStackDepth < 0 {
+		limits.StackDepth = 0
+	}
+	if cfg.ScriptMaxMemory > 0 {
+		limits.MaxMemory = uint64(cfg.ScriptMaxMemory) << 20
+	} else if cfg.ScriptMaxMemory < 0 {
+		limits.MaxMemory = 0
+	}
+	if cfg.ScriptMaxSteps > 0 {
+		limits.MaxSteps = uint64(cfg.ScriptMaxSteps)
+	} else if cfg.ScriptMaxSteps < 0 {
+		limits.MaxSteps = 0
+	}
+	return limits
+}
+
+// Panicked inside the vm (through the interrupt channel) to stop a call.
+type halt struct {
+	limit string
+}
+
+// Keeps the call metrics of a runtime.
+type metrics struct {
+	m     scripting.RuntimeMetrics
+	mutex sync.Mutex
+}
+
+func (ms *metrics) record(d time.Duration, err error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.m.Calls++
+	ms.m.CallTime += int64(d / time.Millisecond)
+	if t := int64(d / time.Millisecond); t > ms.m.MaxCallTime {
+		ms.m.MaxCallTime = t
+	}
+	if err == nil {
+		return
+	}
+	ms.m.Errors++
+	if le, ok := err.(*scripting.LimitError); ok {
+		switch le.Limit {
+		case scripting.LIMIT_TIMEOUT:
+			ms.m.Timeouts++
+		case scripting.LIMIT_STACK:
+			ms.m.StackOverflows++
+		case scripting.LIMIT_MEMORY:
+			ms.m.MemoryLimits++
+		case scripting.LIMIT_STEPS:
+			ms.m.StepLimits++
+		}
+	}
+}
+
+func (ms *metrics) get() scripting.RuntimeMetrics {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.m
+}
+
+// Run a call in the vm with the limits of the runtime. The runtime
+// mutex must be held.
+func (rt *Runtime) run(call string, fn func() (otto.Value, error)) (val otto.Value, err error) {
+	start := time.Now()
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go rt.watch(done, exited)
+
+	defer func() {
+		close(done)
+		// Make sure an interrupt sent after the call finished does not
+		// stop the next call.
+		<-exited
+		select {
+		case <-rt.vm.Interrupt:
+		default:
+		}
+
+		if caught := recover(); caught != nil {
+			h, ok := caught.(*halt)
+			if !ok {
+				panic(caught)
+			}
+			val = otto.UndefinedValue()
+			err = &scripting.LimitError{Limit: h.limit, Runtime: rt.name, Call: call}
+		}
+		if err != nil {
+			if le, ok := err.(*scripting.LimitError); ok {
+				logger.Println(le.Error())
+			}
+		}
+		rt.metrics.record(time.Since(start), err)
+	}()
+
+	val, err = fn()
+	// Stack overflows are thrown as javascript range errors.
+	if err != nil && strings.Contains(err.Error(), "Maximum call stack size exceeded") {
+		err = &scripting.LimitError{Limit: scripting.LIMIT_STACK, Runtime: rt.name, Call: call}
+	}
+	return
+}
+
+// Interrupt the vm if the call goes over the time, memory or step limit.
+// Steps are counted by keeping a counting function in the interrupt
+// channel, which the vm runs before each statement and expression.
+func (rt *Runtime) watch(done, exited chan struct{}) {
+	defer close(exited)
+	limits := rt.limits
+
+	var steps chan<- func()
+	var step func()
+	if limits.MaxSteps > 0 {
+		steps = rt.vm.Interrupt
+		var n uint64
+		step = func() {
+			n++
+			if n > limits.MaxSteps {
+				panic(&halt{scripting.LIMIT_STEPS})
+			}
+		}
+	}
+
+	var timeout <-chan time.Time
+	if limits.Timeout > 0 {
+		timer := time.NewTimer(limits.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var tick <-chan time.Time
+	var heapStart uint64
+	if limits.MaxMemory > 0 {
+		heapStart = heapAlloc()
+		ticker := time.NewTicker(MEMORY_CHECK_INTERVAL)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timeout:
+			rt.interrupt(scripting.LIMIT_TIMEOUT, done)
+			return
+		case <-tick:
+			if heap := heapAlloc(); heap > heapStart && heap-heapStart > limits.MaxMemory {
+				rt.interrupt(scripting.LIMIT_MEMORY, done)
+				return
+			}
+		case steps <- step:
+		}
+	}
+}
+
+// Waits for the vm to take the interrupt, or for the call to end.
+func (rt *Runtime) interrupt(limit string, done chan struct{}) {
+	select {
+	case rt.vm.Interrupt <- func() { panic(&halt{limit}) }:
+	case <-done:
+	}
+}
+
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
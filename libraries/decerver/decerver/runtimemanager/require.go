@@ -0,0 +1,187 @@
+package runtimemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The directory (in the decerver modules directory) with the javascript
+// libraries that every dapp can require by name.
+const LIB_DIR_NAME = "lib"
+
+// The error thrown in javascript when a module can't be required.
+const REQUIRE_ERROR = "RequireError"
+
+// A module loaded with 'require'. It is cached by its file name, and is
+// not done until the module code has run.
+type jsModule struct {
+	exports otto.Value
+	done    bool
+}
+
+// The 'require' function for a module in directory 'dir'. Relative paths
+// are resolved from 'dir', and can't go outside of 'root' (which is either
+// the base directory of the runtime or the shared library directory). An
+// empty 'dir' means the base directory.
+func (rt *Runtime) requireFunc(dir, root string) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		id, _ := call.Argument(0).ToString()
+		d, r := dir, root
+		if d == "" {
+			d, r = rt.baseDir, rt.baseDir
+		}
+		exports, err := rt.require(id, d, r)
+		if err != nil {
+			panic(rt.vm.MakeCustomError(REQUIRE_ERROR, err.Error()))
+		}
+		return exports
+	}
+}
+
+func (rt *Runtime) require(id, dir, root string) (otto.Value, error) {
+	file, root, err := rt.resolveModule(id, dir, root)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	if m, ok := rt.modules[file]; ok {
+		if !m.done {
+			return otto.UndefinedValue(), fmt.Errorf("Circular require: %s -> %s", strings.Join(rt.requireStack, " -> "), file)
+		}
+		return m.exports, nil
+	}
+
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	if err := rt.checkVerified(file, root, src); err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	m := &jsModule{}
+	rt.modules[file] = m
+	rt.requireStack = append(rt.requireStack, file)
+	defer func() {
+		rt.requireStack = rt.requireStack[:len(rt.requireStack)-1]
+		if !m.done {
+			// Let it be required again after the error is fixed.
+			delete(rt.modules, file)
+		}
+	}()
+
+	if strings.ToLower(filepath.Ext(file)) == ".json" {
+		var obj interface{}
+		if err := json.Unmarshal(src, &obj); err != nil {
+			return otto.UndefinedValue(), fmt.Errorf("Error parsing '%s': %s", file, err.Error())
+		}
+		m.exports, err = rt.vm.ToValue(obj)
+		if err != nil {
+			return otto.UndefinedValue(), err
+		}
+		m.done = true
+		return m.exports, nil
+	}
+
+	// Wrap the code the same way node does, so that the module has its
+	// own scope and its own 'require'.
+	wrapped := "(function (exports, require, module, __filename, __dirname) {" + string(src) + "\n})"
+	script, err := rt.vm.Compile(file, wrapped)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	fn, err := rt.vm.Run(script)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	module, err := rt.vm.Object("({exports: {}})")
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	exports, _ := module.Get("exports")
+	modDir := filepath.Dir(file)
+	_, err = fn.Call(otto.UndefinedValue(), exports, rt.requireFunc(modDir, root), module, file, modDir)
+	if err != nil {
+		return otto.UndefinedValue(), fmt.Errorf("Error in module '%s': %s", file, err.Error())
+	}
+
+	// The module may have replaced 'module.exports'.
+	m.exports, _ = module.Get("exports")
+	m.done = true
+	return m.exports, nil
+}
+
+// Files in the base directory of a verified dapp must be the ones that
+// were verified. Files in the shared library directory are not checked.
+func (rt *Runtime) checkVerified(file, root string, src []byte) error {
+	if rt.verified == nil || root != rt.baseDir {
+		return nil
+	}
+	hash := sha256.Sum256(src)
+	if rt.verified[file] != hex.EncodeToString(hash[:]) {
+		return fmt.Errorf("Cannot require '%s': it has changed since the dapp was verified.", file)
+	}
+	return nil
+}
+
+// Get the file of a module. Ids that start with './' or '../' are relative
+// to 'dir', other ids are looked up in the shared library directory. Returns
+// the file and the root directory it is in.
+func (rt *Runtime) resolveModule(id, dir, root string) (string, string, error) {
+	if id == "" {
+		return "", "", fmt.Errorf("Missing module id.")
+	}
+	var p string
+	if strings.HasPrefix(id, "./") || strings.HasPrefix(id, "../") {
+		if root == "" {
+			return "", "", fmt.Errorf("Cannot require '%s': the runtime has no base directory.", id)
+		}
+		p = filepath.Join(dir, id)
+	} else if filepath.IsAbs(id) {
+		return "", "", fmt.Errorf("Cannot require '%s': absolute paths are not allowed.", id)
+	} else {
+		if rt.fio == nil {
+			return "", "", fmt.Errorf("Cannot require '%s': there is no shared library directory.", id)
+		}
+		root = filepath.Join(rt.fio.Modules(), LIB_DIR_NAME)
+		p = filepath.Join(root, id)
+	}
+
+	if rel, err := filepath.Rel(root, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("Cannot require '%s': it is outside of '%s'.", id, root)
+	}
+
+	for _, f := range []string{p, p + ".js", p + ".json"} {
+		if fi, err := os.Stat(f); err == nil && !fi.IsDir() {
+			return f, root, nil
+		}
+	}
+
+	// Directories can have a package file that names the main file, otherwise
+	// it's 'index.js'.
+	main := "index.js"
+	pkg := struct {
+		Main string `json:"main"`
+	}{}
+	if bts, err := ioutil.ReadFile(filepath.Join(p, "package.json")); err == nil {
+		if json.Unmarshal(bts, &pkg) == nil && pkg.Main != "" {
+			main = pkg.Main
+		}
+	}
+	f := filepath.Join(p, main)
+	if rel, err := filepath.Rel(root, f); err == nil && !strings.HasPrefix(rel, "..") {
+		for _, cand := range []string{f, f + ".js"} {
+			if fi, err := os.Stat(cand); err == nil && !fi.IsDir() {
+				return cand, root, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("Cannot find module '%s'.", id)
+}
@@ -0,0 +1,111 @@
+package runtimemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writeModules(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		os.MkdirAll(path.Dir(path.Join(dir, name)), 0700)
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRequire(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"counter.js":    "var n = 0; exports.next = function(){ return ++n; };",
+		"util/index.js": "var c = require('../counter'); module.exports = function(){ return c.next(); };",
+		"data.json":     `{"value": 7}`,
+		"../outside.js": "exports.x = 1;",
+	})
+	defer os.RemoveAll(dir)
+	defer os.Remove(path.Join(path.Dir(dir), "outside.js"))
+
+	rt := newTestRuntime(scripting.DefaultRuntimeLimits)
+	rt.SetBaseDir(dir)
+	err := rt.AddScript(`
+		var next = require('./util');
+		var counter = require('./counter.js');
+		counter.next();
+		var obj = {n: function(){ return next(); }, value: function(){ return require('./data').value; }};
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The counter module is only run once.
+	ret, err := rt.CallFuncOnObj("obj", "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ret) != "2" {
+		t.Fatalf("got %v", ret)
+	}
+	ret, err = rt.CallFuncOnObj("obj", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ret) != "7" {
+		t.Fatalf("got %v", ret)
+	}
+
+	if err := rt.AddScript("require('../outside');"); err == nil {
+		t.Fatal("required a file outside of the base directory")
+	}
+	if err := rt.AddScript("require('./missing');"); err == nil {
+		t.Fatal("required a missing file")
+	}
+}
+
+func TestRequireCycle(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"a.js": "require('./b');",
+		"b.js": "require('./a');",
+	})
+	defer os.RemoveAll(dir)
+
+	rt := newTestRuntime(scripting.DefaultRuntimeLimits)
+	rt.SetBaseDir(dir)
+	err := rt.AddScript("require('./a');")
+	if err == nil || !strings.Contains(err.Error(), "Circular require") {
+		t.Fatalf("expected a circular require error, got %v", err)
+	}
+}
+
+// A verified dapp can only require the files that were verified.
+func TestRequireVerified(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"a.js": "exports.x = 1;",
+		"b.js": "exports.x = 2;",
+	})
+	defer os.RemoveAll(dir)
+
+	rt := newTestRuntime(scripting.DefaultRuntimeLimits)
+	rt.SetBaseDir(dir)
+	hash := sha256.Sum256([]byte("exports.x = 1;"))
+	rt.SetVerifiedFiles(map[string]string{
+		path.Join(dir, "a.js"): hex.EncodeToString(hash[:]),
+		path.Join(dir, "b.js"): hex.EncodeToString(hash[:]),
+	})
+	if err := rt.AddScript("require('./a');"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.AddScript("require('./b');"); err == nil || !strings.Contains(err.Error(), "changed since the dapp was verified") {
+		t.Fatalf("expected a changed file to be refused, got %v", err)
+	}
+}
@@ -1,6 +1,81 @@
 package runtimemanager
 
 import (
-
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"strings"
+	"testing"
+	"time"
 )
 
+func newTestRuntime(limits scripting.RuntimeLimits) scripting.Runtime {
+	rt := newRuntime("test", nil, nil, limits)
+	rt.Init("test")
+	return rt
+}
+
+func checkLimitError(t *testing.T, err error, limit string) {
+	le, ok := err.(*scripting.LimitError)
+	if !ok {
+		t.Fatalf("expected a limit error, got %v", err)
+	}
+	if le.Limit != limit {
+		t.Fatalf("expected limit %s, got %s", limit, le.Limit)
+	}
+}
+
+func TestRuntimeTimeout(t *testing.T) {
+	rt := newTestRuntime(scripting.RuntimeLimits{Timeout: 100 * time.Millisecond})
+	rt.AddScript("var obj = {loop: function(){ while(true){} }, ok: function(){ return 5; }};")
+
+	_, err := rt.CallFuncOnObj("obj", "loop")
+	checkLimitError(t, err, scripting.LIMIT_TIMEOUT)
+
+	// The runtime can still be used.
+	ret, err := rt.CallFuncOnObj("obj", "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != int64(5) && ret != float64(5) {
+		t.Fatalf("got %v", ret)
+	}
+
+	ms := rt.Metrics()
+	if ms.Calls != 3 || ms.Timeouts != 1 || ms.Errors != 1 {
+		t.Fatalf("bad metrics %v", ms)
+	}
+}
+
+func TestRuntimeStackDepth(t *testing.T) {
+	rt := newTestRuntime(scripting.RuntimeLimits{StackDepth: 50})
+	err := rt.AddScript("function f(n){ return f(n+1); }; f(0);")
+	checkLimitError(t, err, scripting.LIMIT_STACK)
+	if rt.Metrics().StackOverflows != 1 {
+		t.Fatal("stack overflow not counted")
+	}
+}
+
+func TestRuntimeSteps(t *testing.T) {
+	rt := newTestRuntime(scripting.RuntimeLimits{MaxSteps: 10000})
+	rt.AddScript("var obj = {loop: function(){ for(var i = 0; i < 1000000; i++){} }, ok: function(){ for(var i = 0; i < 100; i++){} return 5; }};")
+
+	_, err := rt.CallFuncOnObj("obj", "loop")
+	checkLimitError(t, err, scripting.LIMIT_STEPS)
+
+	// Each call gets its own count.
+	for i := 0; i < 3; i++ {
+		if _, err := rt.CallFuncOnObj("obj", "ok"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ms := rt.Metrics(); ms.StepLimits != 1 || ms.Errors != 1 {
+		t.Fatalf("bad metrics %v", ms)
+	}
+}
+
+func TestRuntimeSubscribeBlock(t *testing.T) {
+	rt := newTestRuntime(scripting.RuntimeLimits{})
+	err := rt.AddScript(`events.subscribe("monk", "newBlock", "*", function(){}, "1", {queue: {policy: "block"}});`)
+	if err == nil || !strings.Contains(err.Error(), "'block' queue policy") {
+		t.Fatalf("expected the block policy to be refused, got %v", err)
+	}
+}
@@ -1,12 +1,14 @@
 package runtimemanager
 
 import (
+	"errors"
 	"fmt"
 	"github.com/eris-ltd/decerver/interfaces/decerver"
 	"github.com/eris-ltd/decerver/interfaces/events"
 	"github.com/eris-ltd/decerver/interfaces/files"
 	"github.com/eris-ltd/decerver/interfaces/logging"
 	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"github.com/eris-ltd/decerver/kvstore"
 	mtypes "github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
 	"github.com/robertkrimen/otto"
 	"io/ioutil"
@@ -34,6 +36,11 @@ type RuntimeManager struct {
 	apiScript []string
 	ep        events.EventProcessor
 	fio		  files.FileIO
+	mutex     *sync.Mutex
+	limits    scripting.RuntimeLimits
+	caps      map[string][]*capability
+	// The key-value stores of the runtimes. Nil if it could not be opened.
+	store     *kvstore.Store
 }
 
 func NewRuntimeManager(dc decerver.Decerver) scripting.RuntimeManager {
@@ -43,26 +50,84 @@ func NewRuntimeManager(dc decerver.Decerver) scripting.RuntimeManager {
 		make([]string, 0),
 		dc.EventProcessor(),
 		dc.FileIO(),
+		&sync.Mutex{},
+		LimitsFromConfig(dc.Config()),
+		builtinCapabilities(),
+		openStore(dc.FileIO()),
 	}
 }
 
+// The name of the key-value store object in the runtimes.
+const KV_OBJECT_NAME = "kv"
+
+func openStore(fio files.FileIO) *kvstore.Store {
+	store, err := kvstore.Open(path.Join(fio.System(), kvstore.DB_DIR_NAME))
+	if err != nil {
+		logger.Println("Failed to open the key-value store, dapps will not have one: " + err.Error())
+		return nil
+	}
+	return store
+}
+
 func (rm *RuntimeManager) ShutdownRuntimes() {
-	for _, rt := range rm.runtimes {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	for name, rt := range rm.runtimes {
+		delete(rm.runtimes, name)
 		rt.Shutdown()
 	}
+	if rm.store != nil {
+		rm.store.Close()
+		rm.store = nil
+	}
 }
 
+// Several runtimes can be running at the same time (one per loaded dapp).
 func (rm *RuntimeManager) CreateRuntime(name string) scripting.Runtime {
-	rt := newRuntime(name, rm.ep, rm.fio)
+	return rm.createRuntime(name, nil, nil, false)
+}
+
+func (rm *RuntimeManager) CreateSandboxedRuntime(name string) scripting.Runtime {
+	return rm.createRuntime(name, []string{}, nil, true)
+}
+
+func (rm *RuntimeManager) CreateRestrictedRuntime(name string, caps []string, objs map[string]interface{}) scripting.Runtime {
+	return rm.createRuntime(name, caps, objs, false)
+}
+
+func (rm *RuntimeManager) createRuntime(name string, caps []string, objs map[string]interface{}, sandboxed bool) scripting.Runtime {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	rt := newRuntime(name, rm.ep, rm.fio, rm.limits)
 	rm.runtimes[name] = rt
 
 	rt.Init(name)
+	grants := rm.grants(name, caps)
+	rm.restrictBuiltins(rt, grants)
+	if sandboxed {
+		logger.Printf("Creating new sandboxed runtime: %s\n", name)
+		return rt
+	}
 	for _, jo := range rm.apiObjs {
-		err := rt.BindScriptObject(jo.Name, jo.Object)
+		err := rm.bindObject(rt, jo.Name, jo.Object, grants)
 		if err != nil {
 			fmt.Println(err.Error())
 		}
 	}
+	if rm.store != nil {
+		err := rm.bindObject(rt, KV_OBJECT_NAME, rm.store.Dapp(name), grants)
+		if err != nil {
+			logger.Printf("Failed to bind the key-value store in runtime '%s': %s\n", name, err.Error())
+		}
+	}
+	// the dapp's own objects (its root contract and what the modules it
+	// depends on give it) are not api objects, and no capability covers them
+	for oName, obj := range objs {
+		err := rt.BindScriptObject(oName, obj)
+		if err != nil {
+			logger.Printf("Failed to bind '%s' in runtime '%s': %s\n", oName, name, err.Error())
+		}
+	}
 	for _, s := range rm.apiScript {
 		err := rt.AddScript(s)
 		if err != nil {
@@ -77,6 +142,8 @@ func (rm *RuntimeManager) CreateRuntime(name string) scripting.Runtime {
 }
 
 func (rm *RuntimeManager) GetRuntime(name string) scripting.Runtime {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
 	rt, ok := rm.runtimes[name]
 	if ok {
 		return rt
@@ -86,6 +153,8 @@ func (rm *RuntimeManager) GetRuntime(name string) scripting.Runtime {
 }
 
 func (rm *RuntimeManager) RemoveRuntime(name string) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
 	rt, ok := rm.runtimes[name]
 	if ok {
 		delete(rm.runtimes, name)
@@ -93,8 +162,27 @@ func (rm *RuntimeManager) RemoveRuntime(name string) {
 	}
 }
 
+func (rm *RuntimeManager) SetLimits(limits scripting.RuntimeLimits) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	rm.limits = limits
+}
+
+func (rm *RuntimeManager) Metrics() map[string]scripting.RuntimeMetrics {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	ms := make(map[string]scripting.RuntimeMetrics)
+	for name, rt := range rm.runtimes {
+		ms[name] = rt.Metrics()
+	}
+	return ms
+}
+
 func (rm *RuntimeManager) RegisterApiObject(objectname string, api interface{}) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
 	rm.apiObjs = append(rm.apiObjs, &JsObj{objectname, api})
+	rm.caps[objectname] = append(rm.caps[objectname], &capability{objectname, nil})
 }
 
 func (rm *RuntimeManager) RegisterApiScript(script string) {
@@ -108,10 +196,24 @@ type Runtime struct {
 	fio		      files.FileIO
 	name          string
 	mutex         *sync.Mutex
+	// Ids of the event subscriptions made by this runtime, so they
+	// can be removed when it is shut down.
+	subs          map[string]bool
+	subMutex      *sync.Mutex
+	limits        scripting.RuntimeLimits
+	metrics       *metrics
+	// Relative 'require' paths in scripts are resolved from here.
+	baseDir       string
+	// The hashes of the files 'require' may load from the base directory.
+	// Nil if they are not checked.
+	verified      map[string]string
+	// Modules loaded with 'require', by file name.
+	modules       map[string]*jsModule
+	requireStack  []string
 }
 
 // Package private
-func newRuntime(name string, ep events.EventProcessor, fio files.FileIO) scripting.Runtime {
+func newRuntime(name string, ep events.EventProcessor, fio files.FileIO, limits scripting.RuntimeLimits) *Runtime {
 	vm := otto.New()
 	rt := &Runtime{}
 	rt.vm = vm
@@ -119,24 +221,60 @@ func newRuntime(name string, ep events.EventProcessor, fio files.FileIO) scripti
 	rt.name = name
 	rt.fio = fio
 	rt.mutex = &sync.Mutex{}
+	rt.subs = make(map[string]bool)
+	rt.subMutex = &sync.Mutex{}
+	rt.limits = limits
+	rt.metrics = &metrics{}
+	rt.modules = make(map[string]*jsModule)
 	return rt
 }
 
+// Removes all event subscriptions made by the runtime.
 func (rt *Runtime) Shutdown() {
+	rt.subMutex.Lock()
+	subs := rt.subs
+	rt.subs = make(map[string]bool)
+	rt.subMutex.Unlock()
+	for id, _ := range subs {
+		rt.ep.Unsubscribe(id)
+	}
 	logger.Println("Runtime shut down: " + rt.name)
-	// TODO implement
 }
 
 func (rt *Runtime) Id() string {
 	return rt.name
 }
 
-// TODO add an interrupt channel.
+func (rt *Runtime) Metrics() scripting.RuntimeMetrics {
+	return rt.metrics.get()
+}
+
+func (rt *Runtime) SetBaseDir(dir string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.baseDir = dir
+}
+
+func (rt *Runtime) SetVerifiedFiles(hashes map[string]string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.verified = hashes
+}
+
 func (rt *Runtime) Init(name string) {
 
+	// Calls are interrupted through this when they go over the limits.
+	rt.vm.Interrupt = make(chan func(), 1)
+	if rt.limits.StackDepth > 0 {
+		rt.vm.SetStackDepthLimit(rt.limits.StackDepth)
+	}
+
 	// Bind the runtime id (it's name)
 	rt.vm.Set("RuntimeId", name)
 
+	// CommonJS style module loading.
+	rt.vm.Set("require", rt.requireFunc("", ""))
+
 	// Bind an event subscribe function to otto
 	rt.vm.Set("events_subscribe", func(call otto.FunctionCall) otto.Value {
 	    // TODO Error checking
@@ -145,12 +283,33 @@ func (rt *Runtime) Init(name string) {
 		target, _ := call.Argument(2).ToString()
 		id, _ := call.Argument(3).ToString()
 		rtSub := newRuntimeSub(source,tpe,target,id, rt)
-		rt.ep.Subscribe(rtSub)
+		// Optional filter and queue settings, as json.
+		if opts := call.Argument(4); opts.IsString() {
+			optsJson, _ := opts.ToString()
+			if err := json.Unmarshal([]byte(optsJson), &rtSub.opts); err != nil {
+				panic(rt.vm.MakeTypeError("Bad subscription options: " + err.Error()))
+			}
+			if err := rtSub.opts.validate(); err != nil {
+				panic(rt.vm.MakeTypeError("Bad subscription options: " + err.Error()))
+			}
+		}
+		rt.subMutex.Lock()
+		rt.subs[id] = true
+		rt.subMutex.Unlock()
+		if err := rt.ep.Subscribe(rtSub); err != nil {
+			rt.subMutex.Lock()
+			delete(rt.subs, id)
+			rt.subMutex.Unlock()
+			panic(rt.vm.MakeCustomError("SubscriptionError", err.Error()))
+		}
 	    return otto.Value{}
 	})
 	// Bind an event unsubscribe function to otto
 	rt.vm.Set("events_unsubscribe", func(call otto.FunctionCall) otto.Value {
 	    id, _ := call.Argument(0).ToString()
+	    rt.subMutex.Lock()
+	    delete(rt.subs, id)
+	    rt.subMutex.Unlock()
 	    rt.ep.Unsubscribe(id)
 	    return otto.Value{}
 	})
@@ -208,7 +367,9 @@ func (rt *Runtime) LoadScriptFile(fileName string) error {
 	if err != nil {
 		return err
 	}
-	_, err = rt.vm.Run(bytes)
+	_, err = rt.run(path.Base(fileName), func() (otto.Value, error) {
+		return rt.vm.Run(bytes)
+	})
 	return err
 }
 
@@ -232,7 +393,9 @@ func (rt *Runtime) BindScriptObject(name string, val interface{}) error {
 func (rt *Runtime) AddScript(script string) error {
 	rt.mutex.Lock()
 	defer rt.mutex.Unlock()
-	_, err := rt.vm.Run(script)
+	_, err := rt.run("script", func() (otto.Value, error) {
+		return rt.vm.Run(script)
+	})
 	return err
 }
 
@@ -245,11 +408,13 @@ func (rt *Runtime) CallFuncOnObj(objName, funcName string, param ...interface{})
 		return nil, err
 	}
 
-	val, callErr := ob.Object().Call(funcName, param...)
+	val, callErr := rt.run(objName+"."+funcName, func() (otto.Value, error) {
+		return ob.Object().Call(funcName, param...)
+	})
 
 	if callErr != nil {
 		fmt.Println(callErr.Error())
-		return nil, err
+		return nil, callErr
 	}
 
 	// Take the result and turn it into a go value.
@@ -264,7 +429,9 @@ func (rt *Runtime) CallFuncOnObj(objName, funcName string, param ...interface{})
 func (rt *Runtime) CallFunc(funcName string, param ...interface{}) (interface{}, error) {
 	rt.mutex.Lock()
 	defer rt.mutex.Unlock()
-	val, callErr := rt.vm.Call(funcName, nil, param)
+	val, callErr := rt.run(funcName, func() (otto.Value, error) {
+		return rt.vm.Call(funcName, nil, param)
+	})
 
 	if callErr != nil {
 		fmt.Println(callErr.Error())
@@ -290,6 +457,25 @@ type RuntimeSub struct {
 	tgt       string
 	id        string
 	rt        scripting.Runtime
+	opts      RuntimeSubOptions
+}
+
+// Options for a runtime subscription.
+type RuntimeSubOptions struct {
+	// Fields that the event resource must have.
+	Resource events.ResourceFilter `json:"resource"`
+	Queue    *events.QueueConfig   `json:"queue"`
+	// Replay the logged events from here before the new ones.
+	Since *events.Replay `json:"since"`
+}
+
+// Runtimes can't block the event processor. Their calls can take until
+// the runtime timeout, and they can subscribe from inside a callback.
+func (opts *RuntimeSubOptions) validate() error {
+	if opts.Queue != nil && opts.Queue.Policy == events.QUEUE_BLOCK {
+		return errors.New("The 'block' queue policy can't be used in a runtime.")
+	}
+	return nil
 }
 
 func newRuntimeSub(eventSource, eventType, eventTarget, subId string, rt scripting.Runtime) *RuntimeSub {
@@ -318,8 +504,33 @@ func (rs *RuntimeSub) Event() string {
 	return rs.tpe
 }
 
+func (rs *RuntimeSub) Accept(e mtypes.Event) bool {
+	return rs.opts.Resource.Match(e.Resource)
+}
+
+func (rs *RuntimeSub) Queue() events.QueueConfig {
+	if rs.opts.Queue == nil {
+		return events.DefaultQueueConfig
+	}
+	return *rs.opts.Queue
+}
+
+func (rs *RuntimeSub) Replay() *events.Replay {
+	return rs.opts.Since
+}
+
 // Passing along the sub ID means the right callback is used.
 func (rs *RuntimeSub) Post(e mtypes.Event) {
 	bts, _ := json.Marshal(e)
 	rs.rt.CallFuncOnObj("events", "post", rs.id, string(bts) )
 }
+
+// Logged events are posted with their sequence number and block height.
+func (rs *RuntimeSub) PostEntry(entry *events.LogEntry) {
+	bts, _ := json.Marshal(struct {
+		mtypes.Event
+		Seq    uint64
+		Height uint64
+	}{entry.Event, entry.Seq, entry.Height})
+	rs.rt.CallFuncOnObj("events", "post", rs.id, string(bts) )
+}
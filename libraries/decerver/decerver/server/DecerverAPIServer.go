@@ -3,21 +3,22 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/eris-ltd/decerver/interfaces/decerver"
 	"github.com/eris-ltd/decerver/interfaces/dapps"
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"github.com/eris-ltd/decerver/interfaces/modules"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path"
 	"strings"
 )
 
-type SwitchName struct {
-	Name string `json:"name"`
-}
+// What secrets in the config are replaced with when it is sent out.
+const REDACTED = "********"
 
 type DecerverAPIServer struct {
 	dc decerver.Decerver
-	dm  dapps.DappManager
+	dm dapps.DappManager
 }
 
 func NewDecerverAPIServer(dc decerver.Decerver, dm dapps.DappManager) *DecerverAPIServer {
@@ -46,156 +47,283 @@ func (das *DecerverAPIServer) handleReadyGET(w http.ResponseWriter, r *http.Requ
 	fmt.Fprint(w, jsn)
 }
 
+// The running config and the one in the config file. They differ when
+// some changes need a restart to take effect.
+type ConfigResp struct {
+	Active    interface{} `json:"active"`
+	Persisted interface{} `json:"persisted"`
+}
+
+// The changes made by a config update.
+type ConfigUpdateResp struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+func redactConfig(cfg decerver.DCConfig) *decerver.DCConfig {
+	if cfg.AdminToken != "" {
+		cfg.AdminToken = REDACTED
+	}
+	return &cfg
+}
+
 func (das *DecerverAPIServer) handleDecerverGET(w http.ResponseWriter, r *http.Request) {
 	logger.Println("GET decerver config")
-	cfg := das.dc.Config()
-	bts, err := json.Marshal(cfg)
+	resp := &ConfigResp{}
+	resp.Active = redactConfig(*das.dc.Config())
 
-	if err != nil {
-		das.writeError(w, 500, err.Error())
-		return
+	fio := das.dc.FileIO()
+	persisted := &decerver.DCConfig{}
+	if err := fio.UnmarshalJsonFromFile(fio.Root(), "config", persisted); err == nil {
+		resp.Persisted = redactConfig(*persisted)
 	}
-	w.WriteHeader(200)
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprint(w, string(bts))
+	das.writeJson(w, resp)
 }
 
 func (das *DecerverAPIServer) handleDecerverPOST(w http.ResponseWriter, r *http.Request) {
 	logger.Println("POST decerver config")
-	contentType := r.Header.Get("Content-Type")
-
-	idx := strings.Index(contentType, ";")
-	if idx != -1 {
-		contentType = contentType[:idx]
+	bts, ok := das.readJsonBody(w, r)
+	if !ok {
+		return
 	}
-	ct := strings.ToLower(contentType)
 
-	if ct != "application/json" {
-		das.writeError(w, 415, "unrecognized Content-Type: "+ contentType)
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(bts, &raw); err != nil {
+		das.writeError(w, 422, err.Error())
 		return
 	}
-
-	bts, err := ioutil.ReadAll(r.Body)
-
+	if err := decerver.ConfigSchema.Validate(raw); err != nil {
+		das.writeError(w, 422, err.Error())
+		return
+	}
+	// The fields that are left out keep their value: the one in the config
+	// file if it's there (it has the changes waiting for a restart), or else
+	// the active one.
+	fio := das.dc.FileIO()
+	merged := make(map[string]interface{})
+	active, err := json.Marshal(das.dc.Config())
+	if err == nil {
+		err = json.Unmarshal(active, &merged)
+	}
 	if err != nil {
-		das.writeError(w, 400, err.Error())
+		das.writeError(w, 500, err.Error())
+		return
+	}
+	persisted := make(map[string]interface{})
+	if err := fio.UnmarshalJsonFromFile(fio.Root(), "config", &persisted); err == nil {
+		for k, v := range persisted {
+			merged[k] = v
+		}
+	}
+	for k, v := range raw {
+		merged[k] = v
+	}
+	if bts, err = json.Marshal(merged); err != nil {
+		das.writeError(w, 500, err.Error())
 		return
 	}
 	cfg := &decerver.DCConfig{}
-	fmt.Print(string(bts))
-	err = json.Unmarshal(bts, cfg)
+	if err := json.Unmarshal(bts, cfg); err != nil {
+		das.writeError(w, 422, err.Error())
+		return
+	}
+
+	// The token is not sent out, so it comes back redacted.
+	if cfg.AdminToken == REDACTED {
+		cfg.AdminToken = das.dc.Config().AdminToken
+	}
 
+	applied, restart, err := das.dc.ApplyConfig(cfg)
 	if err != nil {
 		das.writeError(w, 422, err.Error())
 		return
 	}
-	fio := das.dc.FileIO()
-	fio.MarshalJsonToFile(fio.Root(),"config",cfg)
-
-	w.WriteHeader(204)
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := fio.MarshalJsonToFile(fio.Root(), "config", cfg); err != nil {
+		das.writeError(w, 500, "Config applied but not saved: "+err.Error())
+		return
+	}
+	das.writeJson(w, &ConfigUpdateResp{applied, restart})
 }
 
 // Modules
 func (das *DecerverAPIServer) handleModuleGET(w http.ResponseWriter, r *http.Request) {
-	url := r.URL.String()
-	mName := path.Base(url)
-	if mName == "." || mName == "/" {
-		das.writeError(w, 404, "Malformed URL")
+	mName := path.Base(r.URL.Path)
+	mod, ok := das.dc.ModuleManager().Modules()[mName]
+	if !ok {
+		das.writeError(w, 404, "No module with name: "+mName)
 		return
 	}
+	logger.Printf("GET %s config\n", mName)
 
 	fio := das.dc.FileIO()
+	resp := &ConfigResp{}
+	persisted := make(map[string]interface{})
+	keys := []string{}
+	if err := fio.UnmarshalJsonFromFile(path.Join(fio.Modules(), mName), "config", &persisted); err == nil {
+		resp.Persisted = persisted
+		for k, _ := range persisted {
+			keys = append(keys, k)
+		}
+	}
+	if cm, ok := mod.(modules.ConfigurableModule); ok && cm.ModuleConfigSchema() != nil {
+		for _, f := range cm.ModuleConfigSchema().Fields {
+			if _, ok := persisted[f.Name]; !ok {
+				keys = append(keys, f.Name)
+			}
+		}
+	}
+	active := make(map[string]interface{})
+	for _, k := range keys {
+		active[k] = mod.Property(k)
+	}
+	resp.Active = active
+	das.writeJson(w, resp)
+}
 
-	pt := fio.Modules() + "/" + mName
-	logger.Printf("GET %s config\n", mName)
+func (das *DecerverAPIServer) handleModulePOST(w http.ResponseWriter, r *http.Request) {
+	mName := path.Base(r.URL.Path)
+	if _, ok := das.dc.ModuleManager().Modules()[mName]; !ok {
+		das.writeError(w, 404, "No module with name: "+mName)
+		return
+	}
+	logger.Printf(" POST %s config\n", mName)
 
-	bts, err := fio.ReadFile(pt, "config")
+	bts, ok := das.readJsonBody(w, r)
+	if !ok {
+		return
+	}
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(bts, &config); err != nil {
+		das.writeError(w, 400, err.Error())
+		return
+	}
 
+	if err := das.dc.ModuleManager().Configure(mName, config); err != nil {
+		if _, ok := err.(*modules.ConfigError); ok {
+			das.writeError(w, 422, err.Error())
+		} else {
+			das.writeError(w, 500, err.Error())
+		}
+		return
+	}
+
+	// Only the posted keys were changed, the rest of the file stays.
+	fio := das.dc.FileIO()
+	persisted := make(map[string]interface{})
+	if err := fio.UnmarshalJsonFromFile(path.Join(fio.Modules(), mName), "config", &persisted); err != nil && !os.IsNotExist(err) {
+		das.writeError(w, 500, "Config applied but not saved: "+err.Error())
+		return
+	}
+	for k, v := range config {
+		persisted[k] = v
+	}
+	bts, err := json.MarshalIndent(persisted, "", "    ")
 	if err != nil {
 		das.writeError(w, 500, err.Error())
 		return
 	}
-
-	w.WriteHeader(200)
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprint(w, string(bts))
+	if err := fio.WriteFile(path.Join(fio.Modules(), mName), "config", bts); err != nil {
+		das.writeError(w, 500, "Config applied but not saved: "+err.Error())
+		return
+	}
+	w.WriteHeader(204)
 }
 
-func (das *DecerverAPIServer) handleModulePOST(w http.ResponseWriter, r *http.Request) {
-
+// Read the body of a request that must be json. Writes the error
+// and returns false if it's not.
+func (das *DecerverAPIServer) readJsonBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
 	contentType := r.Header.Get("Content-Type")
 	idx := strings.Index(contentType, ";")
 	if idx != -1 {
 		contentType = contentType[:idx]
 	}
-	ct := strings.ToLower(contentType)
-
-	if ct != "application/json" {
+	if strings.ToLower(contentType) != "application/json" {
 		das.writeError(w, 415, "unrecognized Content-Type: "+contentType)
-		return
+		return nil, false
 	}
+	bts, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		das.writeError(w, 400, err.Error())
+		return nil, false
+	}
+	return bts, true
+}
 
-	url := r.URL.String()
-	mName := path.Base(url)
-	if mName == "." || mName == "/" {
-		das.writeError(w, 404, "Malformed URL")
+func (das *DecerverAPIServer) writeJson(w http.ResponseWriter, obj interface{}) {
+	bts, err := json.Marshal(obj)
+	if err != nil {
+		das.writeError(w, 500, err.Error())
 		return
 	}
-	logger.Printf(" POST %s config\n", mName)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprint(w, string(bts))
+}
 
-	bts, err := ioutil.ReadAll(r.Body)
+// Call metrics of the dapp runtimes.
+func (das *DecerverAPIServer) handleRuntimesGET(w http.ResponseWriter, r *http.Request) {
+	bts, err := json.Marshal(das.dc.RuntimeManager().Metrics())
 	if err != nil {
-		das.writeError(w, 400, err.Error())
+		das.writeError(w, 500, err.Error())
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprint(w, string(bts))
+}
 
-	fio := das.dc.FileIO()
-	pt := fio.Modules() + "/" + mName
+// Dapps
 
-	var tmp_int interface{}
-	err = json.Unmarshal(bts, &tmp_int)
+// Get the ids of the loaded dapps.
+func (das *DecerverAPIServer) handleDappsLoadedGET(w http.ResponseWriter, r *http.Request) {
+	bts, err := json.Marshal(das.dm.LoadedDapps())
 	if err != nil {
-		das.writeError(w, 400, err.Error())
+		das.writeError(w, 500, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprint(w, string(bts))
+}
+
+func (das *DecerverAPIServer) handleDappLoad(w http.ResponseWriter, r *http.Request) {
+	dappId := path.Base(r.URL.Path)
+	if dappId == "." || dappId == "/" || dappId == "" {
+		das.writeError(w, 404, "Malformed URL")
 		return
 	}
+	logger.Println("Loading dapp: ", dappId)
+	err := das.dm.LoadDapp(dappId)
 
-	bts, err = json.MarshalIndent(tmp_int, "", "    ")
 	if err != nil {
 		das.writeError(w, 400, err.Error())
 		return
 	}
-
-	fio.WriteFile(pt, "config", bts)
-	w.WriteHeader(204)
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+	fmt.Fprint(w, "success")
 }
 
-func (das *DecerverAPIServer) handleDappSwitch(w http.ResponseWriter, r *http.Request) {
-	url := r.URL.String()
-	mName := path.Base(url)
-	fmt.Println("Url: " + url)
-	if mName == "." || mName == "/" || mName == "" {
+func (das *DecerverAPIServer) handleDappUnload(w http.ResponseWriter, r *http.Request) {
+	dappId := path.Base(r.URL.Path)
+	if dappId == "." || dappId == "/" || dappId == "" {
 		das.writeError(w, 404, "Malformed URL")
 		return
 	}
-	logger.Println("Switching to dapp: ", mName)
-	err := das.dm.LoadDapp(mName)
+	logger.Println("Unloading dapp: ", dappId)
+	err := das.dm.UnloadDapp(dappId)
 
 	if err != nil {
 		das.writeError(w, 400, err.Error())
 		return
 	}
-
-	w.WriteHeader(200)
-
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	// Whatever...
+	w.WriteHeader(200)
 	fmt.Fprint(w, "success")
 }
 
 func (das *DecerverAPIServer) handleFoF(w http.ResponseWriter, r *http.Request) {
-	das.writeError(w, 400, "The route not open (the dapp is not in focus).")
+	das.writeError(w, 400, "The route not open (the dapp is not loaded).")
 }
 
 func (das *DecerverAPIServer) writeError(w http.ResponseWriter, status int, msg string) {
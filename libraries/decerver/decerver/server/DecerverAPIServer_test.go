@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/eris-ltd/decerver/fileio"
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"github.com/eris-ltd/decerver/interfaces/files"
+	"github.com/eris-ltd/decerver/interfaces/modules"
+	"github.com/eris-ltd/decerver/modulemanager"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// A decerver that takes every config it is given.
+type testDecerver struct {
+	decerver.Decerver
+	config *decerver.DCConfig
+	fio    files.FileIO
+	mm     modules.ModuleManager
+}
+
+func (dc *testDecerver) Config() *decerver.DCConfig           { return dc.config }
+func (dc *testDecerver) FileIO() files.FileIO                 { return dc.fio }
+func (dc *testDecerver) ModuleManager() modules.ModuleManager { return dc.mm }
+func (dc *testDecerver) ApplyConfig(cfg *decerver.DCConfig) ([]string, []string, error) {
+	dc.config = cfg
+	return nil, nil, nil
+}
+
+type testModule struct {
+	modules.Module
+	props map[string]interface{}
+}
+
+func (m *testModule) Name() string                              { return "test" }
+func (m *testModule) SetProperty(name string, data interface{}) { m.props[name] = data }
+func (m *testModule) Property(name string) interface{}          { return m.props[name] }
+func (m *testModule) Restart() error                            { return nil }
+
+func newTestDecerver(t *testing.T) *testDecerver {
+	root, err := ioutil.TempDir("", "decerver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fio := fileio.NewFileIO(root)
+	if err := fio.InitPaths(); err != nil {
+		t.Fatal(err)
+	}
+	mm := modulemanager.NewModuleManager()
+	mm.Add(&testModule{props: make(map[string]interface{})})
+	return &testDecerver{fio: fio, mm: mm}
+}
+
+func postJson(handler http.HandlerFunc, url, body string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// Fields that are left out of a config update keep their value.
+func TestDecerverConfigPartialUpdate(t *testing.T) {
+	dc := newTestDecerver(t)
+	defer os.RemoveAll(dc.fio.Root())
+	dc.config = &decerver.DCConfig{AdminToken: "secret", AdminOrigins: []string{"https://a.com"}, DappPolicy: "refuse", Port: 3000}
+	if err := dc.fio.MarshalJsonToFile(dc.fio.Root(), "config", &decerver.DCConfig{AdminToken: "secret", DappPolicy: "refuse", Port: 4000}); err != nil {
+		t.Fatal(err)
+	}
+	das := NewDecerverAPIServer(dc, nil)
+
+	if w := postJson(das.handleDecerverPOST, "/admin/decerver", `{"debug_mode":true}`); w.Code != 200 {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	cfg := dc.config
+	if !cfg.DebugMode || cfg.AdminToken != "secret" || cfg.DappPolicy != "refuse" || cfg.Port != 4000 {
+		t.Fatalf("config update dropped fields: %+v", cfg)
+	}
+	saved := &decerver.DCConfig{}
+	if err := dc.fio.UnmarshalJsonFromFile(dc.fio.Root(), "config", saved); err != nil {
+		t.Fatal(err)
+	}
+	if !saved.DebugMode || saved.AdminToken != "secret" || saved.Port != 4000 {
+		t.Fatalf("saved config dropped fields: %+v", saved)
+	}
+}
+
+// A module config update keeps the settings in the file that weren't posted.
+func TestModuleConfigMerge(t *testing.T) {
+	dc := newTestDecerver(t)
+	defer os.RemoveAll(dc.fio.Root())
+	dir := path.Join(dc.fio.Modules(), "test")
+	os.MkdirAll(dir, 0700)
+	if err := dc.fio.WriteFile(dir, "config", []byte(`{"a": 1, "b": 2}`)); err != nil {
+		t.Fatal(err)
+	}
+	das := NewDecerverAPIServer(dc, nil)
+
+	if w := postJson(das.handleModulePOST, "/admin/modules/test", `{"b": 3}`); w.Code != 204 {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	saved := make(map[string]interface{})
+	if err := dc.fio.UnmarshalJsonFromFile(dir, "config", &saved); err != nil {
+		t.Fatal(err)
+	}
+	bts, _ := json.Marshal(saved)
+	if string(bts) != `{"a":1,"b":3}` {
+		t.Fatalf("expected the posted keys merged into the file, got %s", bts)
+	}
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/events"
+	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Websocket clients send these to manage their subscriptions.
+const (
+	EVENTS_OP_SUBSCRIBE   = "subscribe"
+	EVENTS_OP_UNSUBSCRIBE = "unsubscribe"
+)
+
+// How often an idle event stream sends something, so proxies don't close it.
+const eventsKeepAlive = 30 * time.Second
+
+// Serves module events at '/events', as server-sent events or over a
+// websocket. Clients subscribe with the same filters as the dapps do
+// (source, event type, target and resource fields), and can replay
+// logged events.
+//
+// Server-sent event streams have one subscription, given in the query:
+// source, event, target, resource (json), since (a sequence number) and
+// since_height. Websocket clients can give one in the query as well, and
+// send EventsRequests to add and remove others.
+type EventsAPIServer struct {
+	ep      events.EventProcessor
+	aa      *AdminAuth
+	streams uint64
+}
+
+func NewEventsAPIServer(ep events.EventProcessor, aa *AdminAuth) *EventsAPIServer {
+	return &EventsAPIServer{ep: ep, aa: aa}
+}
+
+// A subscription request. The id is chosen by the client, and is on all
+// the messages for the subscription.
+type EventsRequest struct {
+	Op     string         `json:"op"`
+	Id     string         `json:"id"`
+	Filter events.Filter  `json:"filter"`
+	Since  *events.Replay `json:"since"`
+}
+
+// A message to the client: an event, or an error. Logged events have their
+// sequence number and block height.
+type EventsMessage struct {
+	Id     string       `json:"id"`
+	Seq    uint64       `json:"seq,omitempty"`
+	Height uint64       `json:"height,omitempty"`
+	Event  *types.Event `json:"event,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+func (eas *EventsAPIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if strings.ToLower(r.Header.Get("Upgrade")) == "websocket" {
+		eas.handleWebsocket(w, r)
+	} else {
+		eas.handleSSE(w, r)
+	}
+}
+
+func (eas *EventsAPIServer) handleSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, okF := w.(http.Flusher)
+	notifier, okN := w.(http.CloseNotifier)
+	if !okF || !okN {
+		http.Error(w, "Streaming is not supported.", 500)
+		return
+	}
+	req, err := requestFromQuery(r.URL.Query())
+	if err == nil && req == nil {
+		err = fmt.Errorf("Missing subscription (source and event).")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	// Browsers send the id of the last event they got when they reconnect.
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		if seq, err := strconv.ParseUint(last, 10, 64); err == nil {
+			req.Since = &events.Replay{Seq: seq}
+		}
+	}
+
+	es := eas.newStream(r)
+	defer es.close()
+	if err := es.subscribe(req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	closed := notifier.CloseNotify()
+	for {
+		select {
+		case msg := <-es.out:
+			bts, err := json.Marshal(msg)
+			if err != nil {
+				logger.Println("Failed to encode event: " + err.Error())
+				continue
+			}
+			if msg.Seq > 0 {
+				fmt.Fprintf(w, "id: %d\n", msg.Seq)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", bts)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (eas *EventsAPIServer) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	req, err := requestFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	up := websocket.Upgrader{
+		ReadBufferSize:  upgrader.ReadBufferSize,
+		WriteBufferSize: upgrader.WriteBufferSize,
+		CheckOrigin:     eas.checkOrigin,
+	}
+	conn, err := up.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Println("Event stream upgrade failed: " + err.Error())
+		return
+	}
+	es := eas.newStream(r)
+	defer conn.Close()
+	defer es.close()
+
+	// Only this goroutine writes to the connection.
+	go func() {
+		ticker := time.NewTicker(pingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case msg := <-es.out:
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteJSON(msg); err != nil {
+					conn.Close()
+					return
+				}
+			case <-ticker.C:
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					conn.Close()
+					return
+				}
+			case <-es.done:
+				return
+			}
+		}
+	}()
+
+	if req != nil {
+		if err := es.subscribe(req); err != nil {
+			es.send(&EventsMessage{Id: req.Id, Error: err.Error()})
+		}
+	}
+
+	conn.SetReadLimit(maxMessageSize)
+	for {
+		req := &EventsRequest{}
+		if err := conn.ReadJSON(req); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				es.send(&EventsMessage{Error: "Bad request: " + err.Error()})
+				continue
+			}
+			return
+		}
+		switch req.Op {
+		case EVENTS_OP_SUBSCRIBE:
+			err = es.subscribe(req)
+		case EVENTS_OP_UNSUBSCRIBE:
+			err = es.unsubscribe(req.Id)
+		default:
+			err = fmt.Errorf("Unknown op: %s", req.Op)
+		}
+		if err != nil {
+			es.send(&EventsMessage{Id: req.Id, Error: err.Error()})
+		}
+	}
+}
+
+// Websockets are not covered by the same-origin policy, so they must come
+// from the decerver itself or from an allowed admin origin.
+func (eas *EventsAPIServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && u.Host == r.Host {
+		return true
+	}
+	return eas.aa.allowedOrigin(eas.aa.config(), origin)
+}
+
+// Get the subscription in the query of a request. Returns nil if there is
+// none.
+func requestFromQuery(q url.Values) (*EventsRequest, error) {
+	if q.Get("source") == "" && q.Get("event") == "" {
+		return nil, nil
+	}
+	req := &EventsRequest{Op: EVENTS_OP_SUBSCRIBE, Id: q.Get("id")}
+	req.Filter.Source = q.Get("source")
+	req.Filter.Event = q.Get("event")
+	req.Filter.Target = q.Get("target")
+	if res := q.Get("resource"); res != "" {
+		if err := json.Unmarshal([]byte(res), &req.Filter.Resource); err != nil {
+			return nil, fmt.Errorf("Bad resource filter: %s", err.Error())
+		}
+	}
+	if since := q.Get("since"); since != "" {
+		seq, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Bad sequence number: %s", since)
+		}
+		req.Since = &events.Replay{Seq: seq}
+	}
+	if since := q.Get("since_height"); since != "" {
+		height, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Bad block height: %s", since)
+		}
+		req.Since = &events.Replay{Height: height}
+	}
+	return req, nil
+}
+
+// The subscriptions of one client connection. Events for all of them are
+// sent on 'out', until the stream is closed.
+type eventStream struct {
+	ep     events.EventProcessor
+	prefix string
+	out    chan *EventsMessage
+	done   chan struct{}
+	subs   map[string]*streamSub
+	mutex  *sync.Mutex
+}
+
+func (eas *EventsAPIServer) newStream(r *http.Request) *eventStream {
+	n := atomic.AddUint64(&eas.streams, 1)
+	logger.Printf("Event stream opened by %s\n", r.RemoteAddr)
+	return &eventStream{
+		ep:     eas.ep,
+		prefix: fmt.Sprintf("events_%d_", n),
+		out:    make(chan *EventsMessage),
+		done:   make(chan struct{}),
+		subs:   make(map[string]*streamSub),
+		mutex:  &sync.Mutex{},
+	}
+}
+
+func (es *eventStream) subscribe(req *EventsRequest) error {
+	f := req.Filter
+	if f.Source == "" || f.Event == "" {
+		return fmt.Errorf("A subscription needs a source and an event type.")
+	}
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if _, ok := es.subs[req.Id]; ok {
+		return fmt.Errorf("Subscription id is taken: %s", req.Id)
+	}
+	sub := &streamSub{es.prefix + req.Id, req.Id, f, req.Since, es}
+	if err := es.ep.Subscribe(sub); err != nil {
+		return err
+	}
+	es.subs[req.Id] = sub
+	return nil
+}
+
+func (es *eventStream) unsubscribe(id string) error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	sub, ok := es.subs[id]
+	if !ok {
+		return fmt.Errorf("No subscription with id: %s", id)
+	}
+	delete(es.subs, id)
+	return es.ep.Unsubscribe(sub.id)
+}
+
+// Send a message to the client. Gives up if the stream is closed.
+func (es *eventStream) send(msg *EventsMessage) {
+	select {
+	case es.out <- msg:
+	case <-es.done:
+	}
+}
+
+func (es *eventStream) close() {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	for id, sub := range es.subs {
+		es.ep.Unsubscribe(sub.id)
+		delete(es.subs, id)
+	}
+	close(es.done)
+	logger.Println("Event stream closed.")
+}
+
+// A subscription of an event stream.
+type streamSub struct {
+	id       string
+	clientId string
+	filter   events.Filter
+	since    *events.Replay
+	es       *eventStream
+}
+
+func (ss *streamSub) Source() string            { return ss.filter.Source }
+func (ss *streamSub) Id() string                { return ss.id }
+func (ss *streamSub) Event() string             { return ss.filter.Event }
+func (ss *streamSub) Target() string            { return ss.filter.Target }
+func (ss *streamSub) Accept(e types.Event) bool { return ss.filter.Resource.Match(e.Resource) }
+func (ss *streamSub) Queue() events.QueueConfig { return events.DefaultQueueConfig }
+func (ss *streamSub) Replay() *events.Replay    { return ss.since }
+
+func (ss *streamSub) Post(e types.Event) {
+	ss.es.send(&EventsMessage{Id: ss.clientId, Event: &e})
+}
+
+func (ss *streamSub) PostEntry(entry *events.LogEntry) {
+	ss.es.send(&EventsMessage{Id: ss.clientId, Seq: entry.Seq, Height: entry.Height, Event: &entry.Event})
+}
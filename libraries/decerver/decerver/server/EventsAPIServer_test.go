@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"github.com/eris-ltd/decerver/interfaces/events"
+	"github.com/eris-ltd/thelonious/Godeps/_workspace/src/github.com/eris-ltd/modules/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// An event processor that hands the subscribers to the test.
+type testEventProcessor struct {
+	subs  chan events.Subscriber
+	unsub chan string
+}
+
+func (ep *testEventProcessor) Subscribe(sub events.Subscriber) error {
+	if sub.Source() != "monk" {
+		return errors.New("No module with name: " + sub.Source())
+	}
+	ep.subs <- sub
+	return nil
+}
+func (ep *testEventProcessor) Unsubscribe(id string) error { ep.unsub <- id; return nil }
+func (ep *testEventProcessor) TrafficData() string         { return "" }
+func (ep *testEventProcessor) StartEventLog() error        { return nil }
+func (ep *testEventProcessor) Shutdown() error             { return nil }
+
+func TestRequestFromQuery(t *testing.T) {
+	req, err := requestFromQuery(map[string][]string{
+		"source":   {"monk"},
+		"event":    {"newBlock"},
+		"target":   {"ab*"},
+		"resource": {`{"height": 3}`},
+		"since":    {"12"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := req.Filter
+	if f.Source != "monk" || f.Event != "newBlock" || f.Target != "ab*" || req.Since.Seq != 12 {
+		t.Fatalf("got %v", req)
+	}
+	if !f.Resource.Match(map[string]interface{}{"height": 3}) || f.Resource.Match(map[string]interface{}{"height": 4}) {
+		t.Fatal("bad resource filter")
+	}
+	if req, err := requestFromQuery(map[string][]string{}); req != nil || err != nil {
+		t.Fatal("got a subscription from an empty query")
+	}
+	if _, err := requestFromQuery(map[string][]string{"source": {"monk"}, "since_height": {"x"}}); err == nil {
+		t.Fatal("accepted a bad height")
+	}
+}
+
+func TestEventsSSE(t *testing.T) {
+	ep := &testEventProcessor{make(chan events.Subscriber, 1), make(chan string, 1)}
+	eas := NewEventsAPIServer(ep, &AdminAuth{config: staticConfig(&decerver.DCConfig{})})
+	srv := httptest.NewServer(http.HandlerFunc(eas.handleEvents))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events?source=nothing&event=newBlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Fatalf("subscribing to an unknown module: got status %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/events?source=monk&event=newBlock&target=*", nil)
+	req.Header.Set("Last-Event-ID", "7")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := (<-ep.subs).(*streamSub)
+	if sub.Replay() == nil || sub.Replay().Seq != 7 {
+		t.Fatal("Last-Event-ID was not used to replay")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sub.Post(types.Event{Source: "monk", Event: "newBlock", Target: "x"})
+		sub.PostEntry(&events.LogEntry{Seq: 8, Height: 2, Event: types.Event{Source: "monk", Event: "newBlock"}})
+	}()
+
+	rd := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for len(lines) < 5 {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	wg.Wait()
+	msg := &EventsMessage{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[0], "data: ")), msg); err != nil || msg.Event.Target != "x" || msg.Seq != 0 {
+		t.Fatalf("bad first event: %s", lines[0])
+	}
+	if lines[2] != "id: 8" {
+		t.Fatalf("expected the sequence number as the event id, got: %s", lines[2])
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[3], "data: ")), msg); err != nil || msg.Seq != 8 || msg.Height != 2 {
+		t.Fatalf("bad logged event: %s", lines[3])
+	}
+
+	resp.Body.Close()
+	if id := <-ep.unsub; id != sub.Id() {
+		t.Fatalf("unsubscribed %s", id)
+	}
+}
+
+func TestStreamToken(t *testing.T) {
+	aa := &AdminAuth{config: staticConfig(&decerver.DCConfig{Hostname: "localhost", AdminToken: "secret"}), port: 3000}
+	r := newTestRequest("GET", "127.0.0.1:5000", "localhost:3000", nil)
+	r.URL.RawQuery = "token=secret"
+	if _, err := aa.check(r); err == nil {
+		t.Fatal("admin requests accepted the token in the query")
+	}
+	if _, err := aa.authenticate(r, true); err != nil {
+		t.Fatal(err)
+	}
+}
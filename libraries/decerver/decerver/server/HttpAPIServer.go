@@ -65,11 +65,11 @@ func (has *HttpAPIServer) handleHttp(w http.ResponseWriter, r *http.Request) {
 	caller := strings.Split(strings.TrimLeft(p,"/"),"/")[1];
 
 	rt := has.rm.GetRuntime(caller)
-	// TODO Update this. It's basically how we check if dapp is ready now.
+	// Each loaded dapp has a runtime with the same id.
 	if rt == nil {
 		w.WriteHeader(400)
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		fmt.Fprint(w, "Dapp not in focus")
+		fmt.Fprint(w, "Dapp not loaded: "+caller)
 		return
 	}
 
@@ -93,6 +93,10 @@ func (has *HttpAPIServer) handleHttp(w http.ResponseWriter, r *http.Request) {
 	ret, err := rt.CallFuncOnObj("network", "handleIncomingHttp", string(bts))
 
 	if err != nil {
+		if le, ok := err.(*scripting.LimitError); ok {
+			has.writeLimitError(w, le)
+			return
+		}
 		has.writeError(w, 500, err.Error())
 		return
 	}
@@ -123,6 +127,19 @@ func (has *HttpAPIServer) writeReq(resp *HttpResp, w http.ResponseWriter) {
 	w.Write([]byte(resp.Body))
 }
 
+// The body of the response when a call into the runtime goes over a limit.
+type LimitErrorResp struct {
+	Error string `json:"error"`
+	*scripting.LimitError
+}
+
+func (has *HttpAPIServer) writeLimitError(w http.ResponseWriter, le *scripting.LimitError) {
+	bts, _ := json.Marshal(&LimitErrorResp{le.Error(), le})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(503)
+	w.Write(bts)
+}
+
 func (has *HttpAPIServer) writeError(w http.ResponseWriter, status int, msg string) {
 	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -1,12 +1,15 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/eris-ltd/decerver/interfaces/scripting"
 	"github.com/eris-ltd/decerver/util"
 	"github.com/gorilla/websocket"
 	"net/http"
 	"path"
+	"sync"
+	"time"
 )
 
 // The websocket server handles connections.
@@ -16,11 +19,13 @@ type WsAPIServer struct {
 	maxConnections    uint32
 	idPool            *util.IdPool
 	sessions          map[uint32]*Session
+	mutex             *sync.Mutex
 }
 
 func NewWsAPIServer(rm scripting.RuntimeManager, maxConnections uint32) *WsAPIServer {
 	srv := &WsAPIServer{}
 	srv.sessions = make(map[uint32]*Session)
+	srv.mutex = &sync.Mutex{}
 	srv.maxConnections = maxConnections
 	srv.idPool = util.NewIdPool(maxConnections)
 	srv.rm = rm
@@ -35,7 +40,13 @@ func (srv *WsAPIServer) MaxConnections() uint32 {
 	return srv.maxConnections
 }
 
+// Removing a session that is already removed does nothing.
 func (srv *WsAPIServer) RemoveSession(ss *Session) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.sessions[ss.wsConn.SessionId()] != ss {
+		return
+	}
 	srv.activeConnections--
 	srv.idPool.ReleaseId(ss.wsConn.SessionId())
 	delete(srv.sessions, ss.wsConn.SessionId())
@@ -47,6 +58,8 @@ func (srv *WsAPIServer) CreateSession(caller string, rt scripting.Runtime, wsCon
 	ss.server = srv
 	ss.caller = caller
 	ss.runtime = rt
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
 	srv.activeConnections++
 	id := srv.idPool.GetId()
 	ss.wsConn.sessionId = id
@@ -54,6 +67,63 @@ func (srv *WsAPIServer) CreateSession(caller string, rt scripting.Runtime, wsCon
 	return ss
 }
 
+// The sessions opened by a dapp. Sending to a session can block, so it's
+// done with these rather than under the lock.
+func (srv *WsAPIServer) callerSessions(caller string) []*Session {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	sessions := []*Session{}
+	for _, ss := range srv.sessions {
+		if ss.caller == caller {
+			sessions = append(sessions, ss)
+		}
+	}
+	return sessions
+}
+
+// Close all sessions that were opened by a dapp.
+func (srv *WsAPIServer) CloseSessions(caller string) {
+	for _, ss := range srv.callerSessions(caller) {
+		logger.Printf("Closing session %d (dapp '%s' unloaded)\n", ss.SessionId(), caller)
+		ss.wsConn.writeMsgChannel <- GetCloseMessage()
+		// Don't wait forever for the client to answer.
+		if ss.wsConn.conn != nil {
+			ss.wsConn.conn.SetReadDeadline(time.Now().Add(writeWait))
+		}
+	}
+}
+
+// Attach the sessions of a dapp to its new runtime after a reload, and
+// send the clients a 'reload' message.
+func (srv *WsAPIServer) ReloadSessions(caller string) {
+	rt := srv.rm.GetRuntime(caller)
+	if rt == nil {
+		srv.CloseSessions(caller)
+		return
+	}
+	msg, _ := json.Marshal(map[string]interface{}{
+		"Protocol": "EWSMP1",
+		"Method":   "reload",
+		"Result":   caller,
+		"Error":    "",
+		"Time":     "",
+		"Id":       "",
+	})
+	for _, ss := range srv.callerSessions(caller) {
+		ss.setRuntime(rt)
+		if err := attachSession(ss, rt); err != nil {
+			logger.Printf("Failed to attach session %d to the new runtime: %s\n", ss.SessionId(), err.Error())
+			// The reader stops when the connection is closed.
+			srv.RemoveSession(ss)
+			if ss.wsConn.conn != nil {
+				ss.wsConn.conn.Close()
+			}
+			continue
+		}
+		ss.wsConn.WriteJsonMsg(msg)
+	}
+}
+
 // This is passed to the Martini server.
 // Find out what endpoint they called and create a session based on that.
 func (srv *WsAPIServer) handleWs(w http.ResponseWriter, r *http.Request) {
@@ -65,11 +135,11 @@ func (srv *WsAPIServer) handleWs(w http.ResponseWriter, r *http.Request) {
 	p := u.Path
 	caller := path.Base(p)
 	rt := srv.rm.GetRuntime(caller)
-	// TODO Update this. It's basically how we check if dapp is ready now.
+	// Each loaded dapp has a runtime with the same id.
 	if rt == nil {
 		w.WriteHeader(400)
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		fmt.Fprint(w, "Dapp not in focus")
+		fmt.Fprint(w, "Dapp not loaded: "+caller)
 		return
 	}
 
@@ -87,27 +157,51 @@ func (srv *WsAPIServer) handleWs(w http.ResponseWriter, r *http.Request) {
 
 	ss := srv.CreateSession(caller, rt, wsConn)
 	// We add this session to the callers (dapps) runtime.
-	err = rt.BindScriptObject("tempObj", NewSessionJs(ss))
+	err = attachSession(ss, rt)
 
 	if err != nil {
-		panic(err.Error())
+		logger.Printf("Failed to attach session %d to dapp '%s' (%s)\n", ss.SessionId(), caller, err.Error())
+		srv.RemoveSession(ss)
+		conn.Close()
+		return
 	}
 
-	// TODO fix...
-	rt.AddScript("tempObj.sessionId = function(){return this.SessionId()};tempObj.writeJson = function(data){return this.WriteJson(data)};network.newWsSession(tempObj); tempObj = null;")
-	//rt.CallFuncOnObj("network", "newWsSession", val)
 	go writer(ss)
 	reader(ss)
 	ss.wsConn.writeMsgChannel <- &Message{Data: nil}
 	ss.Close()
 }
 
+// Add the session to the runtime of its dapp.
+func attachSession(ss *Session, rt scripting.Runtime) error {
+	err := rt.BindScriptObject("tempObj", NewSessionJs(ss))
+	if err != nil {
+		return err
+	}
+	// TODO fix...
+	return rt.AddScript("tempObj.sessionId = function(){return this.SessionId()};tempObj.writeJson = function(data){return this.WriteJson(data)};network.newWsSession(tempObj); tempObj = null;")
+}
+
 type Session struct {
 	caller    string
 	runtime   scripting.Runtime
 	server    *WsAPIServer
 	wsConn    *WsConn
 	sessionJs *SessionJs
+	// The runtime is replaced when the dapp is reloaded.
+	rtMutex sync.Mutex
+}
+
+func (ss *Session) getRuntime() scripting.Runtime {
+	ss.rtMutex.Lock()
+	defer ss.rtMutex.Unlock()
+	return ss.runtime
+}
+
+func (ss *Session) setRuntime(rt scripting.Runtime) {
+	ss.rtMutex.Lock()
+	ss.runtime = rt
+	ss.rtMutex.Unlock()
 }
 
 func (ss *Session) SessionId() uint32 {
@@ -122,7 +216,7 @@ func (ss *Session) Close() {
 	logger.Printf("CLOSING SESSION: %d\n", ss.wsConn.sessionId)
 	// Deregister ourselves.
 	ss.server.RemoveSession(ss)
-	ss.runtime.CallFuncOnObj("network", "deleteWsSession", int(ss.SessionId()))
+	ss.getRuntime().CallFuncOnObj("network", "deleteWsSession", int(ss.SessionId()))
 	if ss.wsConn.conn != nil {
 		err := ss.wsConn.conn.Close()
 		if err != nil {
@@ -133,8 +227,13 @@ func (ss *Session) Close() {
 
 func (ss *Session) handleRequest(rpcReq string) {
 	logger.Println("RPC Message: " + rpcReq)
-	ret, err := ss.runtime.CallFuncOnObj("network", "incomingWsMsg", int(ss.wsConn.sessionId), rpcReq)
+	ret, err := ss.getRuntime().CallFuncOnObj("network", "incomingWsMsg", int(ss.wsConn.sessionId), rpcReq)
 
+	if le, ok := err.(*scripting.LimitError); ok {
+		// The session can still be used.
+		ss.wsConn.writeMsgChannel <- &Message{Data: limitErrorMsg(rpcReq, le), Type: websocket.TextMessage}
+		return
+	}
 	if err != nil {
 		logger.Printf("Js runtime error, could not pass message. Closing socket. (sesion: %d)\nMessage dump: %s\n", ss.SessionId(), rpcReq)
 		ss.wsConn.writeCloseChannel <- GetCloseMessage()
@@ -148,6 +247,31 @@ func (ss *Session) handleRequest(rpcReq string) {
 	ss.wsConn.writeMsgChannel <- &Message{Data: []byte(retStr), Type: websocket.TextMessage}
 }
 
+// Error code for calls that went over a runtime limit (ESRPC server error).
+const E_LIMIT = -32000
+
+// An error response for a request that was stopped by a runtime limit.
+func limitErrorMsg(rpcReq string, le *scripting.LimitError) []byte {
+	req := &struct {
+		Method string
+		Id     interface{}
+	}{}
+	json.Unmarshal([]byte(rpcReq), req)
+	bts, _ := json.Marshal(map[string]interface{}{
+		"Protocol": "EWSMP1",
+		"Method":   req.Method,
+		"Result":   "",
+		"Time":     "",
+		"Id":       req.Id,
+		"Error": map[string]interface{}{
+			"Code":    E_LIMIT,
+			"Message": le.Error(),
+			"Data":    le,
+		},
+	})
+	return bts
+}
+
 type SessionJs struct {
 	session *Session
 }
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"github.com/eris-ltd/decerver/interfaces/scripting"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A runtime manager with a single runtime that sessions can't be added to.
+type testRuntimeManager struct {
+	scripting.RuntimeManager
+	rt scripting.Runtime
+}
+
+func (rm *testRuntimeManager) GetRuntime(id string) scripting.Runtime { return rm.rt }
+
+type testRuntime struct {
+	scripting.Runtime
+}
+
+func (rt *testRuntime) BindScriptObject(name string, val interface{}) error {
+	return errors.New("no network object")
+}
+
+func TestWsAttachFailure(t *testing.T) {
+	srv := NewWsAPIServer(&testRuntimeManager{rt: &testRuntime{}}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(srv.handleWs))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/dapp", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.activeConnections != 0 || len(srv.sessions) != 0 {
+		t.Fatalf("session was not removed: %d active, %d sessions", srv.activeConnections, len(srv.sessions))
+	}
+}
+
+// Sessions that can't be added to the new runtime of a reloaded dapp are
+// removed.
+func TestWsReloadAttachFailure(t *testing.T) {
+	srv := NewWsAPIServer(&testRuntimeManager{rt: &testRuntime{}}, 10)
+	ss := srv.CreateSession("dapp", nil, &WsConn{})
+	srv.ReloadSessions("dapp")
+
+	srv.mutex.Lock()
+	if srv.activeConnections != 0 || len(srv.sessions) != 0 {
+		t.Fatalf("session was not removed: %d active, %d sessions", srv.activeConnections, len(srv.sessions))
+	}
+	srv.mutex.Unlock()
+	// it is removed again when its reader stops
+	srv.RemoveSession(ss)
+	if srv.activeConnections != 0 {
+		t.Fatalf("session was removed twice: %d active", srv.activeConnections)
+	}
+}
+
+// A client that isn't reading doesn't hold up the other sessions.
+func TestWsCloseSessionsUnlocked(t *testing.T) {
+	srv := NewWsAPIServer(&testRuntimeManager{}, 10)
+	stuck := srv.CreateSession("dapp", nil, &WsConn{writeMsgChannel: make(chan *Message)})
+
+	go srv.CloseSessions("dapp")
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		other := srv.CreateSession("other", nil, &WsConn{})
+		srv.RemoveSession(other)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sessions are blocked while closing a dapp's sessions")
+	}
+	<-stuck.wsConn.writeMsgChannel
+	srv.RemoveSession(stuck)
+}
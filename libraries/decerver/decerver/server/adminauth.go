@@ -0,0 +1,250 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"github.com/eris-ltd/decerver/interfaces/files"
+	"github.com/go-martini/martini"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The admin audit log file (in the log directory).
+const ADMIN_AUDIT_LOG = "admin_audit.log"
+
+// The header admin tokens are sent in. 'Authorization: Bearer <token>'
+// works as well.
+const ADMIN_TOKEN_HEADER = "X-Decerver-Token"
+
+// How admin requests were authenticated.
+const (
+	AUTH_TOKEN     = "token"
+	AUTH_CERT      = "client_cert"
+	AUTH_LOCALHOST = "localhost"
+)
+
+// Checks admin requests, and writes the ones that change something (and
+// the ones that are refused) to the audit log.
+type AdminAuth struct {
+	config     func() *decerver.DCConfig
+	port       int
+	audit      string
+	auditMutex *sync.Mutex
+}
+
+// The config is got for each request, so config updates apply right away.
+func NewAdminAuth(config func() *decerver.DCConfig, fio files.FileIO, port int) *AdminAuth {
+	aa := &AdminAuth{}
+	aa.config = config
+	aa.port = port
+	aa.audit = path.Join(fio.Log(), ADMIN_AUDIT_LOG)
+	aa.auditMutex = &sync.Mutex{}
+	if cfg := config(); cfg.AdminToken == "" && cfg.AdminClientCA == "" {
+		logger.Println("No admin token or client CA set. Admin requests are only accepted from localhost.")
+	}
+	return aa
+}
+
+// The martini handler that goes in front of the admin handlers.
+func (aa *AdminAuth) Handler(w http.ResponseWriter, r *http.Request, c martini.Context) {
+	method, err := aa.check(r)
+	if err != nil {
+		logger.Printf("Admin request refused: %s %s from %s: %s\n", r.Method, r.URL.Path, r.RemoteAddr, err.Error())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(403)
+		fmt.Fprint(w, err.Error())
+		aa.log(r, "", 403, err.Error())
+		return
+	}
+	c.Next()
+	if r.Method != "GET" && r.Method != "HEAD" {
+		status := 200
+		if rw, ok := w.(martini.ResponseWriter); ok && rw.Status() != 0 {
+			status = rw.Status()
+		}
+		aa.log(r, method, status, "")
+	}
+}
+
+// The martini handler that goes in front of the event streams. They are
+// authorised like admin requests, but browsers can't set headers on event
+// sources and websockets, so the token can be passed in the 'token' query
+// parameter as well. Streams don't change anything, so they're not audited.
+func (aa *AdminAuth) StreamHandler(w http.ResponseWriter, r *http.Request, c martini.Context) {
+	if _, err := aa.authenticate(r, true); err != nil {
+		logger.Printf("Event stream refused: %s from %s: %s\n", r.URL.Path, r.RemoteAddr, err.Error())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(403)
+		fmt.Fprint(w, err.Error())
+		aa.log(r, "", 403, err.Error())
+		return
+	}
+	c.Next()
+}
+
+// Check a request. Returns how it was authenticated.
+func (aa *AdminAuth) check(r *http.Request) (string, error) {
+	return aa.authenticate(r, false)
+}
+
+func (aa *AdminAuth) authenticate(r *http.Request, queryToken bool) (string, error) {
+	cfg := aa.config()
+	local := isLocalhost(r.RemoteAddr)
+	if !local && !cfg.AdminAllowRemote {
+		return "", errors.New("Admin requests are only accepted from localhost.")
+	}
+
+	// Stops dns rebinding (where an attacker's host name resolves to
+	// the decerver).
+	if !aa.allowedHost(cfg, r.Host) {
+		return "", fmt.Errorf("Host not allowed: %s", r.Host)
+	}
+
+	// Stops other sites from making requests through the browser.
+	if r.Method != "GET" && r.Method != "HEAD" {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			origin = r.Header.Get("Referer")
+		}
+		if origin != "" && !aa.allowedOrigin(cfg, origin) {
+			return "", fmt.Errorf("Origin not allowed: %s", origin)
+		}
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return AUTH_CERT, nil
+	}
+	if cfg.AdminToken != "" {
+		token := r.Header.Get(ADMIN_TOKEN_HEADER)
+		if auth := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if token == "" && queryToken {
+			token = r.URL.Query().Get("token")
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1 {
+			return AUTH_TOKEN, nil
+		}
+	}
+	if cfg.AdminToken == "" && cfg.AdminClientCA == "" && local {
+		return AUTH_LOCALHOST, nil
+	}
+	return "", errors.New("Not authenticated.")
+}
+
+// The host names the decerver can be reached by.
+func (aa *AdminAuth) allowedHost(cfg *decerver.DCConfig, host string) bool {
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		h = host
+	}
+	h = strings.Trim(h, "[]")
+	if h == "localhost" || h == cfg.Hostname || net.ParseIP(h) != nil {
+		return true
+	}
+	for _, o := range cfg.AdminOrigins {
+		if u, err := url.Parse(o); err == nil && u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (aa *AdminAuth) allowedOrigin(cfg *decerver.DCConfig, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, o := range cfg.AdminOrigins {
+		if ou, err := url.Parse(o); err == nil && ou.Scheme == u.Scheme && ou.Host == u.Host {
+			return true
+		}
+	}
+	port := fmt.Sprintf("%d", aa.port)
+	h, p, err := net.SplitHostPort(u.Host)
+	if err != nil || p != port {
+		return false
+	}
+	h = strings.Trim(h, "[]")
+	return h == "localhost" || h == cfg.Hostname || h == "127.0.0.1" || h == "::1"
+}
+
+func isLocalhost(remoteAddr string) bool {
+	h, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(h)
+	return ip != nil && ip.IsLoopback()
+}
+
+// An entry in the audit log (one json object per line).
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Auth   string    `json:"auth"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (aa *AdminAuth) log(r *http.Request, auth string, status int, msg string) {
+	entry := &AuditEntry{time.Now(), r.RemoteAddr, auth, r.Method, r.URL.Path, status, msg}
+	bts, err := json.Marshal(entry)
+	if err != nil {
+		logger.Println("Failed to write audit log: " + err.Error())
+		return
+	}
+	aa.auditMutex.Lock()
+	defer aa.auditMutex.Unlock()
+	f, err := os.OpenFile(aa.audit, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logger.Println("Failed to write audit log: " + err.Error())
+		return
+	}
+	defer f.Close()
+	f.Write(append(bts, '\n'))
+}
+
+// The tls config for the server, if https is enabled. Client certificates
+// are checked against the admin CA, but they are only needed for admin
+// requests (the dapps don't use them).
+func tlsConfig(cfg *decerver.DCConfig) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		if cfg.AdminClientCA != "" {
+			logger.Println("WARNING: 'admin_client_ca' is set but https is not enabled. Client certificates will not work.")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if cfg.AdminClientCA != "" {
+		pem, err := ioutil.ReadFile(cfg.AdminClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in: %s", cfg.AdminClientCA)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
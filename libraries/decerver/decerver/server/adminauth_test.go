@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/eris-ltd/decerver/interfaces/decerver"
+	"net/http"
+	"testing"
+)
+
+func newTestRequest(method, remote, host string, headers map[string]string) *http.Request {
+	r, _ := http.NewRequest(method, "http://"+host+"/admin/decerver", nil)
+	r.RemoteAddr = remote
+	r.Host = host
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func staticConfig(cfg *decerver.DCConfig) func() *decerver.DCConfig {
+	return func() *decerver.DCConfig { return cfg }
+}
+
+func TestAdminAuthLocalhost(t *testing.T) {
+	aa := &AdminAuth{config: staticConfig(&decerver.DCConfig{Hostname: "localhost"}), port: 3000}
+
+	if _, err := aa.check(newTestRequest("POST", "127.0.0.1:5000", "localhost:3000", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aa.check(newTestRequest("POST", "10.0.0.2:5000", "localhost:3000", nil)); err == nil {
+		t.Fatal("accepted a remote request")
+	}
+	if _, err := aa.check(newTestRequest("POST", "127.0.0.1:5000", "evil.com:3000", nil)); err == nil {
+		t.Fatal("accepted a request for another host")
+	}
+	headers := map[string]string{"Origin": "http://evil.com"}
+	if _, err := aa.check(newTestRequest("POST", "127.0.0.1:5000", "localhost:3000", headers)); err == nil {
+		t.Fatal("accepted a request from another origin")
+	}
+	headers = map[string]string{"Origin": "http://localhost:3000"}
+	if _, err := aa.check(newTestRequest("POST", "127.0.0.1:5000", "localhost:3000", headers)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminAuthToken(t *testing.T) {
+	cfg := &decerver.DCConfig{Hostname: "decerver.local", AdminToken: "secret", AdminAllowRemote: true}
+	aa := &AdminAuth{config: staticConfig(cfg), port: 3000}
+
+	if _, err := aa.check(newTestRequest("GET", "127.0.0.1:5000", "localhost:3000", nil)); err == nil {
+		t.Fatal("accepted a request without the token")
+	}
+	headers := map[string]string{"Authorization": "Bearer wrong"}
+	if _, err := aa.check(newTestRequest("GET", "10.0.0.2:5000", "decerver.local:3000", headers)); err == nil {
+		t.Fatal("accepted the wrong token")
+	}
+	headers = map[string]string{ADMIN_TOKEN_HEADER: "secret"}
+	method, err := aa.check(newTestRequest("GET", "10.0.0.2:5000", "decerver.local:3000", headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != AUTH_TOKEN {
+		t.Fatalf("got auth method %s", method)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/eris-ltd/decerver/interfaces/dapps"
 	"github.com/go-martini/martini"
 	"log"
+	"net/http"
 )
 
 const DEFAULT_PORT = 3000  // For communicating with dapps (the atom browser).
@@ -49,6 +50,15 @@ func NewWebServer(dc decerver.Decerver) *WebServer {
 func (ws *WebServer) RegisterDapp(dappId string) {
 	fmt.Println("Registering path: " + dappId + "/(.*)")
 	ws.webServer.Any("/apis/" + dappId + "/(.*)", ws.has.handleHttp)
+	ws.webServer.Get("/ws/" + dappId, ws.has.was.handleWs)
+}
+
+func (ws *WebServer) CloseSessions(dappId string) {
+	ws.has.was.CloseSessions(dappId)
+}
+
+func (ws *WebServer) ReloadSessions(dappId string) {
+	ws.has.was.ReloadSessions(dappId)
 }
 
 func (ws *WebServer) AddDappManager(dm dapps.DappManager) {
@@ -60,25 +70,56 @@ func (ws *WebServer) Start() error {
 	ws.webServer.Use(martini.Static(ws.dc.FileIO().Dapps()))
 
 	das := NewDecerverAPIServer(ws.dc, ws.dm)
+	// Every admin route goes through this first.
+	auth := NewAdminAuth(ws.dc.Config, ws.dc.FileIO(), ws.port)
+	aa := auth.Handler
+
+	// Module events, for clients that don't go through a dapp.
+	eas := NewEventsAPIServer(ws.dc.EventProcessor(), auth)
+	ws.webServer.Get("/events", auth.StreamHandler, eas.handleEvents)
 
 	// Decerver ready
-	ws.webServer.Get("/admin/ready", das.handleReadyGET)
+	ws.webServer.Get("/admin/ready", aa, das.handleReadyGET)
 
 	// Decerver configuration
-	ws.webServer.Get("/admin/decerver", das.handleDecerverGET)
-	ws.webServer.Post("/admin/decerver", das.handleDecerverPOST)
+	ws.webServer.Get("/admin/decerver", aa, das.handleDecerverGET)
+	ws.webServer.Post("/admin/decerver", aa, das.handleDecerverPOST)
 
 	// Module configuration
-	ws.webServer.Get("/admin/modules/(.*)", das.handleModuleGET)
-	ws.webServer.Post("/admin/modules/(.*)", das.handleModulePOST)
+	ws.webServer.Get("/admin/modules/(.*)", aa, das.handleModuleGET)
+	ws.webServer.Post("/admin/modules/(.*)", aa, das.handleModulePOST)
 
-	// Decerver configuration
-	ws.webServer.Get("/admin/switch/(.*)", das.handleDappSwitch)
+	// Runtime metrics
+	ws.webServer.Get("/admin/runtimes", aa, das.handleRuntimesGET)
+
+	// Dapps. Any number of them can be loaded at once.
+	ws.webServer.Get("/admin/dapps", aa, das.handleDappsLoadedGET)
+	ws.webServer.Post("/admin/dapps/load/(.*)", aa, das.handleDappLoad)
+	ws.webServer.Post("/admin/dapps/unload/(.*)", aa, das.handleDappUnload)
+
+	cfg := ws.dc.Config()
+	tc, err := tlsConfig(cfg)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:      ws.host + ":" + fmt.Sprintf("%d", ws.port),
+		Handler:   ws.webServer,
+		TLSConfig: tc,
+	}
 
 	// TODO Close down properly. Removed that third party stuff since 
 	// it was a mess.
 	go func() {
-		ws.webServer.RunOnAddr(ws.host + ":" + fmt.Sprintf("%d", ws.port))
+		var err error
+		if tc != nil {
+			logger.Println("Listening (https) on " + srv.Addr)
+			err = srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			logger.Println("Listening on " + srv.Addr)
+			err = srv.ListenAndServe()
+		}
+		logger.Println("Server stopped: " + err.Error())
 	}()
 	
 	return nil
@@ -0,0 +1,228 @@
+package util
+
+// Semantic versions and version ranges, as used for the module
+// dependencies in dapp package files.
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A major.minor.patch version. Pre-release and build suffixes are ignored.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+func ParseVersion(v string) (*Version, error) {
+	nums, err := parseParts(v)
+	if err != nil {
+		return nil, err
+	}
+	for i, n := range nums {
+		if n < 0 {
+			return nil, fmt.Errorf("Invalid version '%s': wildcard in part %d.", v, i+1)
+		}
+	}
+	return &Version{nums[0], nums[1], nums[2]}, nil
+}
+
+func (v *Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Returns -1, 0 or 1.
+func (v *Version) Compare(o *Version) int {
+	a := []int{v.Major, v.Minor, v.Patch}
+	b := []int{o.Major, o.Minor, o.Patch}
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		} else if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Parse the parts of a (possibly partial) version. Missing and wildcard
+// (x, X, *) parts are -1.
+func parseParts(v string) ([]int, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if idx := strings.IndexAny(v, "-+"); idx != -1 {
+		v = v[:idx]
+	}
+	nums := []int{-1, -1, -1}
+	if v == "" {
+		return nil, fmt.Errorf("Empty version.")
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("Invalid version '%s': too many parts.", v)
+	}
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid version '%s'.", v)
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// A single comparison, like '>=1.2.0'.
+type comparator struct {
+	op string
+	v  *Version
+}
+
+func (c *comparator) match(v *Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+// A version range. It is a list of sets separated by '||', where each set
+// is a list of comparators that must all match. Supported comparators are
+// '1.2.3', '=1.2.3', '<', '<=', '>', '>=', '~1.2.3', '^1.2.3' and wildcards
+// like '1.2.x' or '*'.
+type VersionRange struct {
+	sets [][]*comparator
+}
+
+func ParseVersionRange(r string) (*VersionRange, error) {
+	vr := &VersionRange{}
+	for _, set := range strings.Split(r, "||") {
+		cmps := []*comparator{}
+		for _, field := range strings.Fields(set) {
+			c, err := parseComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid version range '%s': %s", r, err.Error())
+			}
+			cmps = append(cmps, c...)
+		}
+		vr.sets = append(vr.sets, cmps)
+	}
+	return vr, nil
+}
+
+// An empty set matches any version.
+func (vr *VersionRange) Match(v *Version) bool {
+	for _, set := range vr.sets {
+		ok := true
+		for _, c := range set {
+			if !c.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if version v satisfies range r.
+func SatisfiesRange(v, r string) (bool, error) {
+	ver, err := ParseVersion(v)
+	if err != nil {
+		return false, err
+	}
+	vr, err := ParseVersionRange(r)
+	if err != nil {
+		return false, err
+	}
+	return vr.Match(ver), nil
+}
+
+func parseComparator(s string) ([]*comparator, error) {
+	op := ""
+	for _, o := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	nums, err := parseParts(s[len(op):])
+	if err != nil {
+		return nil, err
+	}
+	// Fill in the wildcards with zeros for the lower bound.
+	lo := &Version{}
+	wild := 3
+	for i := 2; i >= 0; i-- {
+		if nums[i] < 0 {
+			wild = i
+		}
+	}
+	parts := []*int{&lo.Major, &lo.Minor, &lo.Patch}
+	for i := 0; i < wild; i++ {
+		*parts[i] = nums[i]
+	}
+
+	switch op {
+	case "<", "<=", ">", ">=":
+		if wild == 0 {
+			// '>=*' and '<=*' match any version, '>*' and '<*' none.
+			if op == ">=" || op == "<=" {
+				return []*comparator{}, nil
+			}
+			return []*comparator{{"<", &Version{}}}, nil
+		}
+		// A partial version stands for all the versions it covers, so
+		// '>1.2' means '>=1.3.0' and '<=1.2' means '<1.3.0'.
+		next := &Version{lo.Major + 1, 0, 0}
+		if wild == 2 {
+			next = &Version{lo.Major, lo.Minor + 1, 0}
+		}
+		switch {
+		case wild < 3 && op == ">":
+			return []*comparator{{">=", next}}, nil
+		case wild < 3 && op == "<=":
+			return []*comparator{{"<", next}}, nil
+		}
+		return []*comparator{{op, lo}}, nil
+	case "~":
+		// Patch changes are ok if the minor version is given, otherwise minor changes.
+		if wild > 1 {
+			return rangeBetween(lo, &Version{lo.Major, lo.Minor + 1, 0}), nil
+		}
+		return rangeBetween(lo, &Version{lo.Major + 1, 0, 0}), nil
+	case "^":
+		// Changes that don't modify the left-most non-zero part are ok.
+		switch {
+		case lo.Major > 0 || wild <= 1:
+			return rangeBetween(lo, &Version{lo.Major + 1, 0, 0}), nil
+		case lo.Minor > 0 || wild == 2:
+			return rangeBetween(lo, &Version{0, lo.Minor + 1, 0}), nil
+		}
+		return rangeBetween(lo, &Version{0, 0, lo.Patch + 1}), nil
+	}
+	// Plain versions (with or without '='). Wildcards give a range.
+	switch wild {
+	case 0:
+		return []*comparator{}, nil
+	case 1:
+		return rangeBetween(lo, &Version{lo.Major + 1, 0, 0}), nil
+	case 2:
+		return rangeBetween(lo, &Version{lo.Major, lo.Minor + 1, 0}), nil
+	}
+	return []*comparator{{"=", lo}}, nil
+}
+
+// lo <= v < hi
+func rangeBetween(lo, hi *Version) []*comparator {
+	return []*comparator{{">=", lo}, {"<", hi}}
+}
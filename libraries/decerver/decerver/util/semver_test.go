@@ -0,0 +1,61 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestSatisfiesRange(t *testing.T) {
+	tests := []struct {
+		v, r string
+		ok   bool
+	}{
+		{"0.0.1", "0.0.1", true},
+		{"0.0.2", "0.0.1", false},
+		{"1.2.3", "*", true},
+		{"1.2.3", "", true},
+		{"1.2.3", "1.x", true},
+		{"2.0.0", "1.x", false},
+		{"1.2.9", "1.2.x", true},
+		{"1.3.0", "~1.2.3", false},
+		{"1.2.5", "~1.2.3", true},
+		{"1.2.2", "~1.2.3", false},
+		{"1.9.0", "^1.2.3", true},
+		{"2.0.0", "^1.2.3", false},
+		{"0.2.5", "^0.2.3", true},
+		{"0.3.0", "^0.2.3", false},
+		{"0.0.4", "^0.0.3", false},
+		{"1.5.0", ">=1.2.0 <2.0.0", true},
+		{"2.0.0", ">=1.2.0 <2.0.0", false},
+		{"3.1.0", "1.x || >=3.0.0", true},
+		{"2.1.0", "1.x || >=3.0.0", false},
+		{"v1.0.0-beta", "1.0.0", true},
+		{"1.2.9", ">1.2", false},
+		{"1.3.0", ">1.2", true},
+		{"1.9.0", ">1", false},
+		{"1.2.9", "<=1.2", true},
+		{"1.3.0", "<=1.2", false},
+		{"1.2.0", ">=1.2", true},
+		{"1.1.9", "<1.2", true},
+		{"1.2.0", "<1.2", false},
+		{"1.2.3", ">*", false},
+		{"1.2.3", "<=*", true},
+	}
+	for _, test := range tests {
+		ok, err := SatisfiesRange(test.v, test.r)
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.v, test.r, err.Error())
+		}
+		if ok != test.ok {
+			t.Fatalf("%s %s: expected %v", test.v, test.r, test.ok)
+		}
+	}
+}
+
+func TestBadVersions(t *testing.T) {
+	if _, err := ParseVersion("1.x"); err == nil {
+		t.Fatal("expected error for wildcard version")
+	}
+	if _, err := ParseVersionRange(">=one"); err == nil {
+		t.Fatal("expected error for bad range")
+	}
+}
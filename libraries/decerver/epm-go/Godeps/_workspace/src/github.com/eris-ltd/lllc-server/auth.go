@@ -0,0 +1,262 @@
+package lllcserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// not in net/http until go1.6
+const statusTooManyRequests = 429
+
+// Maximum size in bytes of a compile request body.
+// Overwritten by cmd/lllc-server
+var MaxBodySize int64 = 2 << 20
+
+// Maximum number of scripts in a batch compile request.
+// Overwritten by cmd/lllc-server
+var MaxBatchScripts = 100
+
+// Access control for a compile server.
+// A nil AuthConfig leaves the server open to anyone (except for the body size limit)
+type AuthConfig struct {
+	Tokens    map[string]string // token => name (the name is what gets logged)
+	TokenRate int               // requests per minute per token. 0 for no limit
+	IPRate    int               // requests per minute per ip. 0 for no limit
+	AuditLog  string            // file to log compiled hashes to. "" for none
+
+	tokenLimiter *rateLimiter
+	ipLimiter    *rateLimiter
+	audit        *log.Logger
+}
+
+// Global auth config used by StartServer
+// Overwritten by cmd/lllc-server
+var Auth *AuthConfig
+
+// Read a tokens file. The file is a json map of names to tokens
+func LoadTokens(file string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	if err := json.Unmarshal(b, &names); err != nil {
+		return nil, fmt.Errorf("Invalid tokens file %s: %s", file, err.Error())
+	}
+	tokens := make(map[string]string)
+	for name, token := range names {
+		if token == "" {
+			return nil, fmt.Errorf("Empty token for %s", name)
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// Set up limiters and open the audit log
+func (a *AuthConfig) Init() error {
+	a.tokenLimiter = newRateLimiter(a.TokenRate)
+	a.ipLimiter = newRateLimiter(a.IPRate)
+	if a.AuditLog != "" {
+		f, err := os.OpenFile(a.AuditLog, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		a.audit = log.New(f, "", log.LstdFlags)
+	}
+	return nil
+}
+
+// Martini handler to reject unauthenticated or rate limited requests.
+// Writing a response stops the martini handler chain
+func (a *AuthConfig) Handler(w http.ResponseWriter, r *http.Request) {
+	LimitBody(w, r)
+
+	ip := remoteIP(r)
+	if !a.ipLimiter.Allow(ip) {
+		logger.Warnln("rate limited ip", ip)
+		http.Error(w, "Too many requests", statusTooManyRequests)
+		return
+	}
+
+	if len(a.Tokens) == 0 {
+		return
+	}
+	token := requestToken(r)
+	if _, ok := a.Tokens[token]; !ok {
+		logger.Warnln("rejected request with bad token from", ip)
+		http.Error(w, "Invalid or missing api token", http.StatusUnauthorized)
+		return
+	}
+	if !a.tokenLimiter.Allow(token) {
+		logger.Warnln("rate limited token", a.Tokens[token])
+		http.Error(w, "Too many requests", statusTooManyRequests)
+		return
+	}
+}
+
+// Take n more requests from the rate limits of the request's ip and token.
+// A batch costs one request per script, and Handler only takes the first
+func (a *AuthConfig) Charge(r *http.Request, n int) bool {
+	if a == nil {
+		return true
+	}
+	ip := remoteIP(r)
+	if !a.ipLimiter.AllowN(ip, n) {
+		logger.Warnln("rate limited ip", ip)
+		return false
+	}
+	if len(a.Tokens) == 0 {
+		return true
+	}
+	token := requestToken(r)
+	if !a.tokenLimiter.AllowN(token, n) {
+		logger.Warnln("rate limited token", a.Tokens[token])
+		return false
+	}
+	return true
+}
+
+// Log the hash of a script about to be compiled
+func (a *AuthConfig) Audit(r *http.Request, lang string, script []byte) {
+	if a == nil || a.audit == nil {
+		return
+	}
+	name := a.Tokens[requestToken(r)]
+	if name == "" {
+		name = "-"
+	}
+	hash := sha256.Sum256(script)
+	a.audit.Printf("%s %s %s %s\n", remoteIP(r), name, lang, hex.EncodeToString(hash[:]))
+}
+
+// Martini handler to cap request body sizes when there is no auth.
+// readBody reads one byte past the limit to tell a body is too large,
+// so that byte is let through
+func LimitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize+1)
+}
+
+// Read the request body, responding with the right status if it's too large
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		logger.Errorln("err on read http request body", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if int64(len(body)) > MaxBodySize {
+		http.Error(w, fmt.Sprintf("request body too large (max %d bytes)", MaxBodySize), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return body, true
+}
+
+// Clients send their token as "Authorization: Bearer <token>"
+func requestToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(h[len("Bearer "):])
+	}
+	return ""
+}
+
+func setRequestToken(r *http.Request, token string) {
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Token bucket rate limiter keyed by string.
+// Each key gets rate requests per minute, with bursts up to rate
+type rateLimiter struct {
+	mtx       sync.Mutex
+	rate      int
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// how often we drop the full buckets. A bucket refills in a minute,
+// so the map holds at most the keys seen in the last two
+var pruneInterval = time.Minute
+
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{
+		rate:      rate,
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Take a token from key's bucket if there is one
+func (l *rateLimiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// Take n tokens from key's bucket if there are that many
+func (l *rateLimiter) AllowN(key string, n int) bool {
+	if l == nil || l.rate <= 0 {
+		return true
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if now.Sub(l.lastPrune) >= pruneInterval {
+			l.prune(now)
+			l.lastPrune = now
+		}
+		b = &bucket{tokens: float64(l.rate), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.rate)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// drop buckets that have refilled completely
+func (l *rateLimiter) prune(now time.Time) {
+	for k, b := range l.buckets {
+		b.refill(now, l.rate)
+		if b.tokens >= float64(l.rate) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time, rate int) {
+	b.tokens += now.Sub(b.last).Minutes() * float64(rate)
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+	b.last = now
+}
@@ -17,14 +17,30 @@ var DefaultUrl = "http://ps.erisindustries.com:8090/compile"
 // Each element in IncludeReplaces is a pair of strings, between which is placed the filename
 // CompileCmd is a list of what would be white-space separated tokens on the
 // command line, with a `_` to denote the place of the filename
+// Token is sent to the server as a bearer token if it is set
+// If Compiler is set it is used in place of the command templates and regexes
+// OpCodes names the opcodes of the vm the language compiles for (EVMOpCodes if nil)
 type LangConfig struct {
 	URL             string     `json:"url"`
 	Net             bool       `json:"net"`
+	Token           string     `json:"token,omitempty"`
 	Extensions      []string   `json:"extensions"`
 	IncludeRegexes  []string   `json:"regexes"`
 	IncludeReplaces [][]string `json:"replaces"`
 	CompileCmd      []string   `json:"cmd"`
 	AbiCmd          []string   `json:"abi"`
+	VersionCmd      []string   `json:"version,omitempty"`
+
+	Compiler Compiler `json:"-"`
+	OpCodes  OpCodes  `json:"-"`
+}
+
+// Return the compiler backend for the language
+func (l LangConfig) Backend() Compiler {
+	if l.Compiler != nil {
+		return l.Compiler
+	}
+	return NewCmdCompiler(l)
 }
 
 // Append the language extension to the filename
@@ -75,6 +91,10 @@ var Languages = map[string]LangConfig{
 			path.Join(homeDir(), "eris-cpp/build/lllc/lllc"),
 			"_",
 		},
+		VersionCmd: []string{
+			path.Join(homeDir(), "eris-cpp/build/lllc/lllc"),
+			"--version",
+		},
 	},
 
 	"se": LangConfig{
@@ -122,6 +142,10 @@ var Languages = map[string]LangConfig{
 			"--json-abi", "stdout", "|",
 			"awk", "NR >= 4",
 		},
+		VersionCmd: []string{
+			path.Join(homeDir(), "cpp-ethereum/build/solc/solc"),
+			"--version",
+		},
 	},
 }
 
@@ -158,6 +182,15 @@ func checkConfig(f string) error {
 		return err
 	}
 
+	// compilers and opcodes can't be configured from file, keep the registered ones
+	for lang, l := range Languages {
+		if cl, ok := (*c)[lang]; ok {
+			cl.Compiler = l.Compiler
+			cl.OpCodes = l.OpCodes
+			(*c)[lang] = cl
+		}
+	}
+
 	Languages = *c
 	return nil
 }
@@ -185,10 +218,22 @@ func SetLanguageNet(lang string, net bool) error {
 
 }
 
+// Set the api token sent to the languages server
+func SetLanguageToken(lang, token string) error {
+	l, ok := Languages[lang]
+	if !ok {
+		return UnknownLang(lang)
+	}
+	l.Token = token
+	Languages[lang] = l
+	return nil
+}
+
 // Main client struct to wrap a compiler interface and its configuration data
 type CompileClient struct {
-	config LangConfig
-	lang   string
+	config   LangConfig
+	compiler Compiler
+	lang     string
 }
 
 // Return the language name
@@ -201,17 +246,6 @@ func (c *CompileClient) Ext(h string) string {
 	return c.config.Ext(h)
 }
 
-// Return the regex string to match include statements
-func (c *CompileClient) IncludeRegexes() []string {
-	return c.config.IncludeRegexes
-}
-
-// Return the string to replace matched regex expressions
-func (c *CompileClient) IncludeReplace(h string, i int) string {
-	s := c.config.IncludeReplaces[i]
-	return s[0] + h + s[1]
-}
-
 // Unknown language error
 func UnknownLang(lang string) error {
 	return fmt.Errorf("Unknown language %s", lang)
@@ -224,8 +258,9 @@ func NewCompileClient(lang string) (*CompileClient, error) {
 		return nil, UnknownLang(lang)
 	}
 	cc := &CompileClient{
-		config: l,
-		lang:   lang,
+		config:   l,
+		compiler: l.Backend(),
+		lang:     lang,
 	}
 	return cc, nil
 }
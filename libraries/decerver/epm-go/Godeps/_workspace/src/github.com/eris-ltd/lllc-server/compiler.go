@@ -0,0 +1,137 @@
+package lllcserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+)
+
+// A compiler backend for a language.
+// Register one in Languages (see RegisterCompiler) to add a language
+// that can't be described by command templates and include regexes
+type Compiler interface {
+	// Compile a file to bytecode.
+	// Includes have already been written next to the file,
+	// and we are in the file's directory
+	Compile(filename string) ([]byte, error)
+
+	// Produce the json abi for a file, or "" if the language has none
+	Abi(filename string) (string, error)
+
+	// Find every include in code and call resolve with the included filename.
+	// resolve returns the name (without extension) the include will have on the
+	// server, and the include should be rewritten to point at it
+	ResolveIncludes(code []byte, resolve IncludeResolver) ([]byte, error)
+
+	// Version of the underlying compiler
+	Version() (string, error)
+}
+
+// Given the filename in an include statement, load the file
+// and return the name it should be replaced with
+type IncludeResolver func(name string) (string, error)
+
+// cache compiled regex expressions
+var regexCache = make(map[string]*regexp.Regexp)
+
+// The default compiler backend.
+// Runs the CompileCmd and AbiCmd templates through a shell pipeline
+// and finds includes with IncludeRegexes
+type CmdCompiler struct {
+	config LangConfig
+}
+
+// New command template compiler for a language config
+func NewCmdCompiler(l LangConfig) *CmdCompiler {
+	return &CmdCompiler{l}
+}
+
+func (c *CmdCompiler) Compile(filename string) ([]byte, error) {
+	tokens := c.config.Cmd(filename)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("No compile command configured")
+	}
+	hexCode, err := commandWrapper(tokens...)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(hexCode)
+}
+
+func (c *CmdCompiler) Abi(filename string) (string, error) {
+	tokens := c.config.Abi(filename)
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	return commandWrapper(tokens...)
+}
+
+// Replace all matches to the include regexes
+func (c *CmdCompiler) ResolveIncludes(code []byte, resolve IncludeResolver) ([]byte, error) {
+	for i, regPattern := range c.config.IncludeRegexes {
+		r, ok := regexCache[regPattern]
+		if !ok {
+			// cache the compiled regex
+			var err error
+			if r, err = regexp.Compile(regPattern); err != nil {
+				return nil, err
+			}
+			regexCache[regPattern] = r
+		}
+		var rerr error
+		code = r.ReplaceAllFunc(code, func(s []byte) []byte {
+			m := r.FindSubmatch(s)
+			name, err := resolve(string(m[1]))
+			if err != nil {
+				if rerr == nil {
+					rerr = err
+				}
+				return s
+			}
+			return []byte(c.includeReplace(name, i))
+		})
+		if rerr != nil {
+			return nil, rerr
+		}
+	}
+	return code, nil
+}
+
+func (c *CmdCompiler) Version() (string, error) {
+	if len(c.config.VersionCmd) == 0 {
+		return "", fmt.Errorf("No version command configured")
+	}
+	return commandWrapper(c.config.VersionCmd...)
+}
+
+// the string to replace the i'th include regex with
+func (c *CmdCompiler) includeReplace(h string, i int) string {
+	s := c.config.IncludeReplaces[i]
+	return s[0] + h + s[1]
+}
+
+// Register a compiler backend for a language.
+// New languages compile locally by default
+func RegisterCompiler(lang string, extensions []string, compiler Compiler) {
+	l, ok := Languages[lang]
+	if !ok {
+		l = LangConfig{
+			URL: DefaultUrl,
+			Net: false,
+		}
+	}
+	if len(extensions) > 0 {
+		l.Extensions = extensions
+	}
+	l.Compiler = compiler
+	Languages[lang] = l
+}
+
+// Version of the compiler for a language
+func CompilerVersion(lang string) (string, error) {
+	l, ok := Languages[lang]
+	if !ok {
+		return "", UnknownLang(lang)
+	}
+	return l.Backend().Version()
+}
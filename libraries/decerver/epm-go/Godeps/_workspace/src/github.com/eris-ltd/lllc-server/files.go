@@ -8,83 +8,53 @@ import (
 	"log"
 	"os"
 	"path"
-	"regexp"
 	"strings"
 )
 
-// cache compiled regex expressions
-var regexCache = make(map[string]*regexp.Regexp)
-
-// Find all matches to the include regex
+// Find all includes with the language's compiler
 // Replace filenames with hashes
 func (c *CompileClient) replaceIncludes(code []byte, dir string, includes map[string][]byte, includeNames map[string]string) ([]byte, error) {
-	// find includes, load those as well
-	regexPatterns := c.IncludeRegexes()
-	for i, regPattern := range regexPatterns {
-		r, ok := regexCache[regPattern]
-		if !ok {
-			// cache the compiled regex
-			var err error
-			if r, err = regexp.Compile(regPattern); err != nil {
-				return nil, err
-			}
-			regexCache[regPattern] = r
-		}
-		// replace all includes with hash of included lll
-		//  make sure to return hashes of includes so we can cache check them too
-		// do it recursively
-		code = r.ReplaceAllFunc(code, func(s []byte) []byte {
-			s, err := c.includeReplacer(r, i, s, dir, includes, includeNames)
-			if err != nil {
-				fmt.Println("ERR!:", err)
-				// panic (catch)
-			}
-			return s
-		})
-	}
-
-	return code, nil
+	// replace all includes with hash of included code
+	//  make sure to return hashes of includes so we can cache check them too
+	// do it recursively
+	return c.compiler.ResolveIncludes(code, func(name string) (string, error) {
+		return c.includeReplacer(name, dir, includes, includeNames)
+	})
 }
 
-// read the included file, hash it; if we already have it, return include replacement
+// read the included file, hash it; if we already have it, return the hash
 // if we don't, run replaceIncludes on it (recursive)
 // modifies the "includes" map
-func (c *CompileClient) includeReplacer(r *regexp.Regexp, i int, s []byte, dir string, included map[string][]byte, includeNames map[string]string) ([]byte, error) {
-	m := r.FindSubmatch(s)
-	match := m[1]
+func (c *CompileClient) includeReplacer(name string, dir string, included map[string][]byte, includeNames map[string]string) (string, error) {
 	// load the file
-	p := path.Join(dir, string(match))
+	p := path.Join(dir, name)
 	incl_code, err := ioutil.ReadFile(p)
 	if err != nil {
 		logger.Errorln("failed to read include file", err)
-		return nil, fmt.Errorf("Failed to read include file: %s", err.Error())
+		return "", fmt.Errorf("Failed to read include file: %s", err.Error())
 	}
 
 	// take hash before replacing includes to see if we've already parsed this file
 	hash := sha256.Sum256(incl_code)
 	hpre := hex.EncodeToString(hash[:])
-	if h, ok := includeNames[hpre]; ok{
-		replaces := c.IncludeReplace(h, i)
-		ret := []byte(replaces)
-		return ret, nil
+	if h, ok := includeNames[hpre]; ok {
+		return h, nil
 	}
 
 	// recursively replace the includes for this file
 	this_dir := path.Dir(p)
 	incl_code, err = c.replaceIncludes(incl_code, this_dir, included, includeNames)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	// compute hash
 	hash = sha256.Sum256(incl_code)
 	h := hex.EncodeToString(hash[:])
 
-	replaces := c.IncludeReplace(h, i)
-	ret := []byte(replaces)
 	included[h] = incl_code
 	includeNames[hpre] = h
-	return ret, nil
+	return h, nil
 }
 
 // check the cache for all includes, cache those not cached yet
@@ -78,7 +78,7 @@ func ProxyHandler(w http.ResponseWriter, r *http.Request) {
 // Main http request handler
 // Read request, compile, build response object, write
 func CompileHandler(w http.ResponseWriter, r *http.Request) {
-	resp := compileResponse(w, r)
+	_, resp := compileResponse(w, r)
 	if resp == nil {
 		return
 	}
@@ -92,7 +92,7 @@ func CompileHandler(w http.ResponseWriter, r *http.Request) {
 
 // Convenience wrapper for javascript frontend
 func CompileHandlerJs(w http.ResponseWriter, r *http.Request) {
-	resp := compileResponse(w, r)
+	_, resp := compileResponse(w, r)
 	if resp == nil {
 		return
 	}
@@ -102,13 +102,13 @@ func CompileHandlerJs(w http.ResponseWriter, r *http.Request) {
 }
 
 // read in the files from the request, compile them
-func compileResponse(w http.ResponseWriter, r *http.Request) *Response {
+func compileResponse(w http.ResponseWriter, r *http.Request) (*Request, *Response) {
 	// read the request body
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		logger.Errorln("err on read http request body", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return nil
+		return nil, nil
 	}
 
 	// unmarshall body into req struct
@@ -117,11 +117,11 @@ func compileResponse(w http.ResponseWriter, r *http.Request) *Response {
 	if err != nil {
 		logger.Errorln("err on json unmarshal of request", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return nil
+		return nil, nil
 	}
 
 	resp := compileServerCore(req)
-	return resp
+	return req, resp
 }
 
 // core compile functionality. used by the server and locally to mimic the server
@@ -218,26 +218,19 @@ func CompileWrapper(filename string, lang string) ([]byte, string, error) {
 		os.Chdir(cur)
 	}()
 
-	tokens := Languages[lang].Cmd(filename)
-	hexCode, err := commandWrapper(tokens...)
+	compiler := Languages[lang].Backend()
+	b, err := compiler.Compile(filename)
 	if err != nil {
 		logger.Errorln("Couldn't compile!!", err)
 		return nil, "", err
 	}
 
-	tokens = Languages[lang].Abi(filename)
-	jsonAbi, err := commandWrapper(tokens...)
+	jsonAbi, err := compiler.Abi(filename)
 	if err != nil {
 		logger.Errorln("Couldn't produce abi doc!!", err)
 		// we swallow this error, but maybe we shouldnt...
 	}
 
-	b, err := hex.DecodeString(hexCode)
-	if err != nil {
-
-		return nil, "", err
-	}
-
 	return b, jsonAbi, nil
 }
 
@@ -251,6 +244,7 @@ func StartServer(addr string) {
 
 	srv.Post("/compile", CompileHandler)
 	srv.Post("/compile2", CompileHandlerJs)
+	srv.Post("/compile/batch", BatchCompileHandler)
 
 	// new relic for error reporting
 	if NEWRELIC_KEY != "" {
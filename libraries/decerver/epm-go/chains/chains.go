@@ -112,9 +112,11 @@ func ResolveChainId(chainType, chainId string) (string, error) {
 
 // Resolve a chain's type and id from a a reference
 // Reference is either blank (head), a ref name, or <type>/<id>
+// The head is the one in the workspace, if there is one (see CurrentHead)
 func ResolveChain(ref string) (chainType string, chainId string, err error) {
 	if ref == "" {
-		return GetHead()
+		chainType, chainId, _, err = CurrentHead()
+		return
 	}
 
 	chainType, chainId, err = SplitRef(ref)
@@ -169,6 +171,12 @@ var MaxHead = 100
 // Add a new entry (type/chainId) to the top of the HEAD file
 // Expects the chain type and head (id) to be full (already resolved)
 func changeHead(typ, head string) error {
+	unlock, err := lockFile(utils.HEAD)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// read in the entire head file and clip
 	// if we have reached the max length
 	b, err := ioutil.ReadFile(utils.HEAD)
@@ -190,7 +198,7 @@ func changeHead(typ, head string) error {
 		s = typ + "/"
 	}
 	s = s + head + "\n" + bsp
-	err = ioutil.WriteFile(utils.HEAD, []byte(s), 0666)
+	err = writeFileAtomic(utils.HEAD, []byte(s), 0666)
 	if err != nil {
 		return err
 	}
@@ -219,7 +227,7 @@ func ChangeHead(typ, id string) error {
 	return changeHead(typ, id)
 }
 
-func addRef(typ, id, ref string) error {
+func addRef(typ, id, ref string, force bool) error {
 	typ, err := ResolveChainType(typ)
 	if err != nil {
 		return err
@@ -234,21 +242,38 @@ func addRef(typ, id, ref string) error {
 		}
 	}
 
+	refPath := path.Join(utils.Refs, ref)
+	unlock, err := lockFile(refPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(refPath); err == nil && !force {
+		return fmt.Errorf("Ref %s already exists", ref)
+	}
+
 	refid := path.Join(typ, id)
-	return ioutil.WriteFile(path.Join(utils.Refs, ref), []byte(refid), 0644)
+	return writeFileAtomic(refPath, []byte(refid), 0644)
 }
 
 // Add a reference name to a chainId
 func AddRef(typ, id, ref string) error {
-	_, err := os.Stat(path.Join(utils.Refs, ref))
-	if err == nil {
-		return fmt.Errorf("Ref %s already exists", ref)
-	}
-	return addRef(typ, id, ref)
+	return addRef(typ, id, ref, false)
 }
 
 func AddRefForce(typ, id, ref string) error {
-	return addRef(typ, id, ref)
+	return addRef(typ, id, ref, true)
+}
+
+// Remove a reference name
+func RemoveRef(ref string) error {
+	refPath := path.Join(utils.Refs, ref)
+	unlock, err := lockFile(refPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return os.Remove(refPath)
 }
 
 // Return a list of chain references
@@ -260,6 +285,9 @@ func GetRefs() (map[string]string, error) {
 	m := make(map[string]string)
 	for _, f := range fs {
 		name := f.Name()
+		if isLockOrTemp(name) {
+			continue
+		}
 		b, err := ioutil.ReadFile(path.Join(utils.Refs, name))
 		if err != nil {
 			return nil, err
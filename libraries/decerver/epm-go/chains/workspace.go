@@ -0,0 +1,181 @@
+package chains
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eris-ltd/epm-go/utils"
+)
+
+// A project can check out its own chain by putting a workspace file in
+// its directory. Like HEAD, it holds <type>/<id>. Anything run from the
+// directory (or below it) uses that chain instead of the global HEAD.
+var WorkspaceFileName = ".epm"
+
+// Find the workspace file for a directory by walking up the tree.
+// Returns the empty string if there is none.
+func FindWorkspace(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		p := filepath.Join(dir, WorkspaceFileName)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Get the chain checked out in the workspace of the working directory.
+// Returns the workspace file too, which is empty if there is no workspace.
+func GetLocalHead() (string, string, string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", "", "", err
+	}
+	file, err := FindWorkspace(wd)
+	if err != nil || file == "" {
+		return "", "", "", err
+	}
+	// workspaces made before there was a registry get in when they're used
+	if err := registerWorkspace(file); err != nil {
+		return "", "", "", err
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", "", "", err
+	}
+	head := strings.TrimSpace(strings.Split(string(b), "\n")[0])
+	if head == "" {
+		return "", "", "", fmt.Errorf("There is no chain checked out in %s", file)
+	}
+	typ, id, err := SplitRef(head)
+	return typ, id, file, err
+}
+
+// Check out a chain in a directory's workspace (the file is created if
+// there isn't one).
+func ChangeLocalHead(dir, typ, id string) (string, error) {
+	var err error
+	typ, err = ResolveChainType(typ)
+	if err != nil {
+		return "", err
+	}
+	id, err = ResolveChainId(typ, id)
+	if err != nil {
+		return "", err
+	}
+	file, err := filepath.Abs(filepath.Join(dir, WorkspaceFileName))
+	if err != nil {
+		return "", err
+	}
+	unlock, err := lockFile(file)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if err := writeFileAtomic(file, []byte(typ+"/"+id+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return file, registerWorkspace(file)
+}
+
+// Every workspace file is recorded in the registry (utils.Workspaces), one
+// path per line, so that gc keeps the chains that any of them checks out.
+func registerWorkspace(file string) error {
+	files, err := readWorkspaces()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f == file {
+			return nil
+		}
+	}
+	unlock, err := lockFile(utils.Workspaces)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if files, err = readWorkspaces(); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f == file {
+			return nil
+		}
+	}
+	files = append(files, file)
+	return writeFileAtomic(utils.Workspaces, []byte(strings.Join(files, "\n")+"\n"), 0644)
+}
+
+func readWorkspaces() ([]string, error) {
+	b, err := ioutil.ReadFile(utils.Workspaces)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, f := range strings.Split(string(b), "\n") {
+		if f = strings.TrimSpace(f); f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// Get the chains checked out in the registered workspaces, as <type>/<id>
+// by workspace file. Workspace files that are gone are dropped from the
+// registry.
+func Workspaces() (map[string]string, error) {
+	unlock, err := lockFile(utils.Workspaces)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	files, err := readWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]string)
+	kept := []string{}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		kept = append(kept, f)
+		if head := strings.TrimSpace(strings.Split(string(b), "\n")[0]); head != "" {
+			heads[f] = head
+		}
+	}
+	if len(kept) < len(files) {
+		if err := writeFileAtomic(utils.Workspaces, []byte(strings.Join(kept, "\n")+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return heads, nil
+}
+
+// Get the current chain: the one in the workspace if there is one,
+// otherwise the global HEAD. Also returns the file it came from.
+func CurrentHead() (string, string, string, error) {
+	typ, id, file, err := GetLocalHead()
+	if err != nil || file != "" {
+		return typ, id, file, err
+	}
+	typ, id, err = GetHead()
+	return typ, id, utils.HEAD, err
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"syscall"
 
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/codegangsta/cli"
 	"github.com/eris-ltd/epm-go/chains"
@@ -14,15 +15,30 @@ import (
 var standAlones = map[string]struct{}{
 	"checkout": struct{}{},
 	"clean":    struct{}{},
+	"down":     struct{}{},
+	"export":   struct{}{},
+	"gc":       struct{}{},
 	"head":     struct{}{},
 	"init":     struct{}{},
 	"keys":     struct{}{}, // codegangsta/cli doesnt let you reference the super command :(
+	"logs":     struct{}{},
 	"ls":       struct{}{},
 	"gen":      struct{}{},
 	"pub":      struct{}{},
 	"":         struct{}{},
 	"rm":       struct{}{},
 	"refs":     struct{}{},
+	"status":   struct{}{},
+}
+
+// subcommands of genesis, which run with the binary of --type (or of the
+// chain, if there is one)
+var genesisCmds = map[string]struct{}{
+	"render":    struct{}{},
+	"validate":  struct{}{},
+	"diff":      struct{}{},
+	"templates": struct{}{},
+	"save":      struct{}{},
 }
 
 // wraps a epm-go/commands function in a closure that accepts cli.Context
@@ -34,11 +50,27 @@ func cliCall(f func(*commands.Context)) func(*cli.Context) {
 		} else {
 			var err error
 			var typ string
-			if c.Command.Name == "new" {
+			if c.Command.Name == "new" || (c.Command.Name == "up" && len(c2.Args()) == 0) {
 				typ, err = chains.ResolveChainType(c2.String("type"))
 				ifExit(err)
+			} else if c.Command.Name == "up" {
+				// restarting a testnet
+				typ, _, err = chains.ResolveChain(c2.Args()[0])
+				ifExit(err)
+			} else if _, ok := genesisCmds[c.Command.Name]; ok || (c.Command.Name == "config" && c2.Bool("list")) {
+				// listing config keys needs no chain
+				typ, err = commands.GenesisChainType(c2)
+				ifExit(err)
 			} else if c.Command.Name == "fetch" {
 				//
+			} else if c.Command.Name == "import" {
+				// the chain type is in the archive
+				if len(c2.Args()) > 0 {
+					m, err := commands.ReadArchiveManifest(c2.Args()[0])
+					ifExit(err)
+					typ, err = chains.ResolveChainType(m.ChainType)
+					ifExit(err)
+				}
 			} else {
 				// ensure we are using the correct binary
 				_, typ, _, err = commands.ResolveRootFlag(c2)
@@ -70,7 +102,15 @@ func cliCall(f func(*commands.Context)) func(*cli.Context) {
 				cmd.Stdin = os.Stdin
 				cmd.Stdout = os.Stdout
 				cmd.Stderr = os.Stderr
-				cmd.Run()
+				if err := cmd.Run(); err != nil {
+					// pass on the binary's exit status
+					if e, ok := err.(*exec.ExitError); ok {
+						if s, ok := e.Sys().(syscall.WaitStatus); ok {
+							os.Exit(s.ExitStatus())
+						}
+					}
+					exit(err)
+				}
 			} else {
 				// go for it
 				f(c2)
@@ -86,7 +126,7 @@ var (
 	//
 	headCmd = cli.Command{
 		Name:   "head",
-		Usage:  "display the current working blockchain",
+		Usage:  "display the current working blockchain (and whether it comes from a workspace or the global HEAD)",
 		Action: cliCall(commands.Head),
 	}
 
@@ -141,6 +181,9 @@ var (
 		Name:   "checkout",
 		Usage:  "change the currently used blockchain",
 		Action: cliCall(commands.Checkout),
+		Flags: []cli.Flag{
+			localFlag,
+		},
 	}
 
 	//
@@ -166,6 +209,70 @@ var (
 			editConfigFlag,
 			noEditFlag,
 			editGenesisFlag,
+			templateFlag,
+			setFlag,
+		},
+	}
+
+	genesisCmd = cli.Command{
+		Name:  "genesis",
+		Usage: "render, check and compare genesis files, and keep named templates",
+		Subcommands: []cli.Command{
+			genesisRenderCmd,
+			genesisValidateCmd,
+			genesisDiffCmd,
+			genesisTemplatesCmd,
+			genesisSaveCmd,
+		},
+	}
+
+	genesisRenderCmd = cli.Command{
+		Name:   "render",
+		Usage:  "print a genesis (default, file, template or chain) with overrides applied: epm genesis render [genesis] --set difficulty=10",
+		Action: cliCall(commands.GenesisRender),
+		Flags: []cli.Flag{
+			typeFlag,
+			setFlag,
+			genesisOutputFlag,
+		},
+	}
+
+	genesisValidateCmd = cli.Command{
+		Name:   "validate",
+		Usage:  "check a genesis (file, template or chain), or the chain's: epm genesis validate [genesis]",
+		Action: cliCall(commands.GenesisValidate),
+		Flags: []cli.Flag{
+			typeFlag,
+			chainFlag,
+		},
+	}
+
+	genesisDiffCmd = cli.Command{
+		Name:   "diff",
+		Usage:  "compare two genesis (files, templates or chains), or one with the default: epm genesis diff <genesis> [genesis]",
+		Action: cliCall(commands.GenesisDiff),
+		Flags: []cli.Flag{
+			typeFlag,
+		},
+	}
+
+	genesisTemplatesCmd = cli.Command{
+		Name:   "templates",
+		Usage:  "list the genesis templates",
+		Action: cliCall(commands.GenesisTemplatesList),
+		Flags: []cli.Flag{
+			typeFlag,
+		},
+	}
+
+	genesisSaveCmd = cli.Command{
+		Name:   "save",
+		Usage:  "save a genesis (default, file, template or chain) with overrides applied as a template: epm genesis save <name> [genesis] --set difficulty=10",
+		Action: cliCall(commands.GenesisSave),
+		Flags: []cli.Flag{
+			typeFlag,
+			setFlag,
+			forceTemplateFlag,
 		},
 	}
 
@@ -180,6 +287,115 @@ var (
 		},
 	}
 
+	exportCmd = cli.Command{
+		Name:   "export",
+		Usage:  "pack up a chain (genesis, config, abis, vars and blocks) into an archive: epm export <chain> -o chain.tar.gz",
+		Action: cliCall(commands.Export),
+		Flags: []cli.Flag{
+			outputFlag,
+			multiFlag,
+		},
+	}
+
+	importCmd = cli.Command{
+		Name:   "import",
+		Usage:  "install a chain from an archive made by export: epm import chain.tar.gz",
+		Action: cliCall(commands.Import),
+		Flags: []cli.Flag{
+			nameFlag,
+			forceNameFlag,
+			newCheckoutFlag,
+			multiFlag,
+		},
+	}
+
+	lsCmd = cli.Command{
+		Name:   "ls",
+		Usage:  "list the blockchain refs, or with --all every chain root with its refs, size and last use",
+		Action: cliCall(commands.Ls),
+		Flags: []cli.Flag{
+			allFlag,
+		},
+	}
+
+	gcCmd = cli.Command{
+		Name:   "gc",
+		Usage:  "remove chains with no refs (that aren't checked out) and stale scratch directories",
+		Action: cliCall(commands.GC),
+		Flags: []cli.Flag{
+			dryRunFlag,
+			olderThanFlag,
+		},
+	}
+
+	testnetCmd = cli.Command{
+		Name:  "testnet",
+		Usage: "run a chain on several nodes on this machine",
+		Subcommands: []cli.Command{
+			testnetUpCmd,
+			testnetStatusCmd,
+			testnetDownCmd,
+			testnetLogsCmd,
+			testnetSuperviseCmd,
+		},
+	}
+
+	testnetUpCmd = cli.Command{
+		Name:   "up",
+		Usage:  "create a chain with a testnet of n nodes and start them: epm testnet up -n 4 --type thel, or restart one: epm testnet up <chain>",
+		Action: cliCall(commands.TestnetUp),
+		Flags: []cli.Flag{
+			nodesFlag,
+			typeFlag,
+			testnetPortFlag,
+			newConfigFlag,
+			newGenesisFlag,
+			testnetNameFlag,
+			newCheckoutFlag,
+		},
+	}
+
+	testnetStatusCmd = cli.Command{
+		Name:   "status",
+		Usage:  "show the nodes of a testnet and whether they are up: epm testnet status [chain]",
+		Action: cliCall(commands.TestnetStatus),
+		Flags: []cli.Flag{
+			chainFlag,
+		},
+	}
+
+	testnetDownCmd = cli.Command{
+		Name:   "down",
+		Usage:  "stop the nodes of a testnet: epm testnet down [chain]",
+		Action: cliCall(commands.TestnetDown),
+		Flags: []cli.Flag{
+			chainFlag,
+			rmFlag,
+		},
+	}
+
+	testnetLogsCmd = cli.Command{
+		Name:   "logs",
+		Usage:  "print the logs of a testnet's nodes: epm testnet logs [chain]",
+		Action: cliCall(commands.TestnetLogs),
+		Flags: []cli.Flag{
+			chainFlag,
+			nodeFlag,
+			linesFlag,
+			followFlag,
+		},
+	}
+
+	// started in the background by up
+	testnetSuperviseCmd = cli.Command{
+		Name:   "supervise",
+		Usage:  "run the nodes of a testnet and restart them when they exit (used by up)",
+		Action: cliCall(commands.TestnetSupervise),
+		Flags: []cli.Flag{
+			chainFlag,
+		},
+	}
+
 	//
 	// OTHER BLOCKCHAIN WORKING COMMANDS
 	//
@@ -206,6 +422,9 @@ var (
 			chainFlag,
 			multiFlag,
 			viFlag,
+			configListFlag,
+			configDiffFlag,
+			typeFlag,
 		},
 	}
 
@@ -274,6 +493,7 @@ var (
 			diffFlag,
 			dontClearFlag,
 			contractPathFlag,
+			prefetchFlag,
 		},
 	}
 
@@ -301,6 +521,7 @@ var (
 			forceNameFlag,
 			editConfigFlag,
 			noNewChainFlag,
+			prefetchFlag,
 		},
 	}
 
@@ -373,6 +594,16 @@ var (
 		Flags: []cli.Flag{
 			chainFlag,
 			contractPathFlag,
+			prefetchFlag,
+		},
+	}
+
+	inspectCmd = cli.Command{
+		Name:   "inspect",
+		Usage:  "disassemble a contract file or the code at an address, and list its functions",
+		Action: cliCall(commands.Inspect),
+		Flags: []cli.Flag{
+			codeDiffFlag,
 		},
 	}
 
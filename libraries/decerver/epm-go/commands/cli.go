@@ -8,6 +8,7 @@ import (
 	"fmt"
 	color "github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/daviddengcn/go-colortext"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/decerver/interfaces/dapps"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/lllc-server"
 	"github.com/eris-ltd/epm-go/chains"
 	"github.com/eris-ltd/epm-go/epm" // ed25519 key generation
 	"github.com/eris-ltd/epm-go/utils"
@@ -115,7 +116,7 @@ func Plop(c *Context) {
 // list the refs
 func Refs(c *Context) {
 	r, err := chains.GetRefs()
-	_, h, _ := chains.GetHead()
+	_, h, _, _ := chains.CurrentHead()
 	fmt.Printf("%-20s%-60s%-20s\n", "Name:", "Blockchain:", "Address:")
 	for rk, rv := range r {
 		// loop through the known blockchains
@@ -176,10 +177,17 @@ func LsKeys(c *Context) {
 }
 
 // print current head
+// The chain is printed on stdout and where it came from (the workspace
+// file or the global HEAD) on stderr, so scripts can still use the output.
 func Head(c *Context) {
-	typ, id, err := chains.GetHead()
+	typ, id, file, err := chains.CurrentHead()
 	if err == nil {
 		fmt.Println(path.Join(typ, id))
+		if file == utils.HEAD {
+			fmt.Fprintln(os.Stderr, "(global HEAD: "+file+")")
+		} else {
+			fmt.Fprintln(os.Stderr, "(local workspace: "+file+")")
+		}
 	}
 	exit(err)
 }
@@ -200,7 +208,7 @@ func Cp(c *Context) {
 	} else if len(args) == 1 {
 		multi = args[0]
 		// copy the checked out chain
-		typ, id, err = chains.GetHead()
+		typ, id, _, err = chains.CurrentHead()
 		ifExit(err)
 		if id == "" {
 			log.Fatal(`No chain is checked out. To copy a chain, specify a chainId and an new name, \n eg. "cp thel/14c32 chaincopy"`)
@@ -278,8 +286,19 @@ func New(c *Context) {
 	editCfg := c.Bool("edit-config")
 	noEdit := c.Bool("no-edit")
 	editGen := c.Bool("edit")
-	// if we provide genesis, dont open editor for genesis
-	noEditor := c.IsSet("genesis")
+	sets := c.StringSlice("set")
+
+	// or a named template
+	if template := c.String("template"); template != "" {
+		if c.IsSet("genesis") {
+			exit(fmt.Errorf("Specify a genesis or a template, not both"))
+		}
+		deployGen, err = GenesisTemplate(chainType, template)
+		ifExit(err)
+	}
+
+	// if we provide genesis, a template or overrides, dont open editor for genesis
+	noEditor := c.IsSet("genesis") || c.IsSet("template") || len(sets) > 0
 	// but maybe the user wants different behaviour
 	if noEdit {
 		noEditor = true
@@ -287,7 +306,7 @@ func New(c *Context) {
 		noEditor = false
 	}
 
-	chainId := deployInstallChain(tmpRoot, deployConf, deployGen, tempConf, chainType, rpc, editCfg, noEditor)
+	chainId := deployInstallChain(tmpRoot, deployConf, deployGen, tempConf, chainType, sets, rpc, editCfg, noEditor)
 
 	if c.Bool("checkout") {
 		ifExit(chains.ChangeHead(chainType, chainId))
@@ -315,7 +334,7 @@ func updateRefs(chainType, chainId, forceName, name string) {
 	}
 }
 
-func deployInstallChain(tmpRoot, deployConf, deployGen, tempConf, chainType string, rpc, editCfg, noEditor bool) string {
+func deployInstallChain(tmpRoot, deployConf, deployGen, tempConf, chainType string, sets []string, rpc, editCfg, noEditor bool) string {
 	if deployConf == "" {
 		if rpc {
 			deployConf = path.Join(utils.Blockchains, chainType, "rpc", "config.json")
@@ -336,6 +355,28 @@ func deployInstallChain(tmpRoot, deployConf, deployGen, tempConf, chainType stri
 	}
 	// copy and edit temp
 	ifExit(utils.Copy(deployConf, tempConf))
+
+	// apply overrides to the config, and to a copy of the genesis
+	if len(sets) > 0 {
+		overrides, err := ParseOverrides(sets)
+		ifExit(err)
+		gen, err := ReadGenesis(chainType, deployGen)
+		ifExit(err)
+		conf, err := ioutil.ReadFile(tempConf)
+		ifExit(err)
+		genOverrides, confOverrides, err := splitOverrides(gen, conf, overrides)
+		ifExit(err)
+		ifExit(applyConfigOverrides(chain, tempConf, confOverrides))
+		if len(genOverrides) > 0 {
+			gen, err = RenderGenesis(gen, genOverrides)
+			ifExit(err)
+			deployGen = tmpRoot + "-genesis.json"
+			ifExit(utils.InitDataDir(path.Dir(deployGen)))
+			ifExit(ioutil.WriteFile(deployGen, gen, 0600))
+			defer os.Remove(deployGen)
+		}
+	}
+
 	if editCfg {
 		ifExit(utils.Editor(tempConf))
 	}
@@ -369,10 +410,22 @@ func Checkout(c *Context) {
 	typ, id, err := chains.ResolveChain(head)
 	ifExit(err)
 
+	if c.Bool("local") {
+		wd, err := os.Getwd()
+		ifExit(err)
+		file, err := chains.ChangeLocalHead(wd, typ, id)
+		ifExit(err)
+		logger.Infof("Checked out new head in %s: %s\n", file, path.Join(typ, id))
+		exit(nil)
+	}
+
 	if err := chains.ChangeHead(typ, id); err != nil {
 		exit(err)
 	}
 	logger.Infoln("Checked out new head: ", path.Join(typ, id))
+	if _, _, file, err := chains.GetLocalHead(); err == nil && file != "" {
+		logger.Warnf("The workspace in %s overrides the global HEAD here\n", file)
+	}
 	exit(nil)
 }
 
@@ -386,7 +439,7 @@ func RmRef(c *Context) {
 
 	_, _, err := chains.ResolveChain(ref)
 	ifExit(err)
-	err = os.Remove(path.Join(utils.Refs, ref))
+	err = chains.RemoveRef(ref)
 	ifExit(err)
 }
 
@@ -401,7 +454,7 @@ func AddRef(c *Context) {
 		log.Fatal("Must at least enter a ref name")
 	} else if len(args) == 1 {
 		ref = args[0]
-		typ, id, err = chains.GetHead()
+		typ, id, _, err = chains.CurrentHead()
 		ifExit(err)
 		if id == "" {
 			log.Fatal(`No chain is checked out. To add a ref, specify both a chainId and a name, \n eg. "epm add thel/14c32 mychain"`)
@@ -458,49 +511,6 @@ func RunDapp(c *Context) {
 	chain.WaitForShutdown()
 }
 
-// edit a config value
-func Config(c *Context) {
-	var (
-		root      string
-		chainType string
-		err       error
-	)
-	rpc := c.Bool("rpc")
-	root, chainType, _, err = ResolveRootFlag(c)
-	ifExit(err)
-
-	if rpc {
-		ifExit(makeRPCDir(root))
-	}
-
-	configPath := path.Join(root, "config.json")
-	if c.Bool("vi") {
-		ifExit(utils.Editor(configPath))
-	} else {
-		m := mod.NewChain(chainType, rpc)
-		if m == nil {
-			ifExit(fmt.Errorf("Got nil chain. Is this the correct type: %s", chainType))
-		}
-		err = m.ReadConfig(configPath)
-		ifExit(err)
-
-		args := c.Args()
-		for _, a := range args {
-			sp := strings.Split(a, ":")
-			if len(sp) != 2 {
-				logger.Errorln("Invalid arg")
-				continue
-			}
-			key := sp[0]
-			value := sp[1]
-			if err := m.SetProperty(key, value); err != nil {
-				logger.Errorln(err)
-			}
-		}
-		m.WriteConfig(path.Join(root, "config.json"))
-	}
-}
-
 // remove a chain
 func Remove(c *Context) {
 	if len(c.Args()) < 1 {
@@ -676,6 +686,7 @@ func Test(c *Context) {
 		if diffStorage {
 			e.Diff = true
 		}
+		e.Prefetch = c.Bool("prefetch")
 
 		// epm execute jobs
 		e.ExecuteJobs()
@@ -761,6 +772,7 @@ func Deploy(c *Context) {
 	if diffStorage {
 		e.Diff = true
 	}
+	e.Prefetch = c.Bool("prefetch")
 
 	// epm execute jobs
 	e.ExecuteJobs()
@@ -1047,7 +1059,7 @@ func Install(c *Context) {
 		}
 
 		// install chain
-		chainId = deployInstallChain(tmpRoot, deployConf, deployGen, tempConf, chainType, rpc, editCfg, noEditor)
+		chainId = deployInstallChain(tmpRoot, deployConf, deployGen, tempConf, chainType, nil, rpc, editCfg, noEditor)
 
 		ifExit(chains.ChangeHead(chainType, chainId))
 		logger.Warnf("Checked out chain: %s/%s", chainType, chainId)
@@ -1102,6 +1114,7 @@ func Install(c *Context) {
 	if diffStorage {
 		e.Diff = true
 	}
+	e.Prefetch = c.Bool("prefetch")
 
 	// epm execute jobs
 	e.ExecuteJobs()
@@ -1211,3 +1224,78 @@ func Accounts(c *Context) {
 
 	}
 }
+
+// Disassemble a contract file, or the code at an address on the chain.
+// With --diff, compile a contract and compare it to the code at the address
+func Inspect(c *Context) {
+	if len(c.Args()) == 0 {
+		exit(fmt.Errorf("Specify a contract file or address to inspect"))
+	}
+	target := c.Args()[0]
+	ops := mod.VMOpCodes()
+
+	if _, err := os.Stat(target); err == nil {
+		code, abi, err := lllcserver.Compile(target)
+		ifExit(err)
+		printInspection(code, abi, ops)
+		return
+	}
+
+	root, chainType, _, err := ResolveRootFlag(c)
+	ifExit(err)
+	chain, err := LoadChain(c, chainType, root)
+	ifExit(err)
+
+	e, err := epm.NewEPM(chain, epm.LogFile)
+	ifExit(err)
+	e.ReadVars(path.Join(root, EPMVars))
+	addr := target
+	if epm.IsVar(addr) {
+		addr, err = e.VarSub(addr)
+		ifExit(err)
+	}
+
+	account := chain.Account(addr)
+	if account == nil || !account.IsScript {
+		exit(fmt.Errorf("No contract at %s", addr))
+	}
+	code, err := hex.DecodeString(utils.StripHex(account.Script))
+	ifExit(err)
+
+	if contract := c.String("diff"); contract != "" {
+		local, _, err := lllcserver.Compile(contract)
+		ifExit(err)
+		same, diff := lllcserver.DiffCode(local, code, ops)
+		if same {
+			fmt.Printf("%s matches the code at %s\n", contract, addr)
+			return
+		}
+		fmt.Printf("%s does not match the code at %s (- local, + chain)\n", contract, addr)
+		for _, l := range diff {
+			fmt.Println(l)
+		}
+		return
+	}
+
+	// abi is saved on deploy
+	abi, _ := ioutil.ReadFile(path.Join(root, "abi", utils.StripHex(addr)))
+	printInspection(code, string(abi), ops)
+}
+
+func printInspection(code []byte, abi string, ops lllcserver.OpCodes) {
+	ins, err := lllcserver.Inspect(code, abi, ops)
+	ifExit(err)
+	fmt.Printf("Size: %d bytes\n", ins.Size)
+	fmt.Println("Selectors:")
+	for _, s := range ins.Selectors {
+		m := s.Method
+		if m == "" {
+			m = "(not in abi)"
+		}
+		fmt.Printf("  %s %s\n", s.Id, m)
+	}
+	fmt.Println("Code:")
+	for _, l := range ins.Code {
+		fmt.Println("  " + l)
+	}
+}
@@ -0,0 +1,191 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/eris-ltd/epm-go/utils"
+
+	//epm-binary-generator:IMPORT
+	mod "github.com/eris-ltd/epm-go/commands/modules/thelonious"
+)
+
+// configure a chain's config.json: epm config <key>:<value> ...
+// Every key and value is checked against the module's config schema
+// before anything is written.
+func Config(c *Context) {
+	rpc := c.Bool("rpc")
+	schema := mod.ConfigSchema(rpc)
+	if c.Bool("list") {
+		ConfigList(schema)
+		return
+	}
+
+	root, chainType, _, err := ResolveRootFlag(c)
+	ifExit(err)
+	if rpc {
+		ifExit(makeRPCDir(root))
+	}
+	configPath := path.Join(root, "config.json")
+	before, err := ioutil.ReadFile(configPath)
+	ifExit(err)
+
+	if c.Bool("diff") {
+		diffs, err := schema.Diff(before)
+		ifExit(err)
+		ifExit(printConfigDiff(diffs))
+		return
+	}
+
+	if c.Bool("vi") {
+		ifExit(utils.Editor(configPath))
+		after, err := ioutil.ReadFile(configPath)
+		ifExit(err)
+		diffs, err := schema.Diff(after)
+		ifExit(err)
+		if problems := configProblems(diffs); len(problems) > 0 {
+			// put the config back the way it was
+			ifExit(ioutil.WriteFile(configPath, before, 0600))
+			exit(fmt.Errorf("%s has problems, it was not changed:\n%s", configPath, strings.Join(problems, "\n")))
+		}
+		warnRestart(root, schema, before, after)
+		return
+	}
+
+	sets, err := ParseConfigArgs(schema, c.Args())
+	ifExit(err)
+
+	m := mod.NewChain(chainType, rpc)
+	if m == nil {
+		ifExit(fmt.Errorf("Got nil chain. Is this the correct type: %s", chainType))
+	}
+	ifExit(m.ReadConfig(configPath))
+	for _, s := range sets {
+		ifExit(m.SetProperty(s.Key.Key, s.Value))
+	}
+	ifExit(m.WriteConfig(configPath))
+
+	after, err := ioutil.ReadFile(configPath)
+	ifExit(err)
+	warnRestart(root, schema, before, after)
+}
+
+// A checked config value, from key:value
+type ConfigSet struct {
+	Key   *utils.ConfigKey
+	Value string
+}
+
+// Parse and check key:value args. All of them are checked, and every
+// problem is reported.
+func ParseConfigArgs(schema utils.ConfigSchema, args []string) ([]*ConfigSet, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Specify the config to set as <key>:<value>. See epm config --list")
+	}
+	sets := []*ConfigSet{}
+	problems := []string{}
+	for _, a := range args {
+		sp := strings.SplitN(a, ":", 2)
+		if len(sp) != 2 || sp[0] == "" {
+			problems = append(problems, fmt.Sprintf("Invalid arg %q. Expected <key>:<value>", a))
+			continue
+		}
+		k, _, err := schema.Parse(sp[0], sp[1])
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if k.Managed {
+			logger.Warnf("%s is set by epm. Changing it may break the chain\n", k.Key)
+		}
+		sets = append(sets, &ConfigSet{k, sp[1]})
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Nothing was written:\n%s", strings.Join(problems, "\n"))
+	}
+	return sets, nil
+}
+
+// print the keys of a config schema
+func ConfigList(schema utils.ConfigSchema) {
+	fmt.Printf("%-20s%-8s%-24s%-14s%-10s%s\n", "Key:", "Type:", "Default:", "Allowed:", "Restart:", "Description:")
+	for _, k := range schema {
+		restart := "no"
+		if k.Restart {
+			restart = "yes"
+		}
+		desc := k.Description
+		if k.Managed {
+			desc += " (set by epm)"
+		}
+		def, _ := json.Marshal(k.Default)
+		fmt.Printf("%-20s%-8s%-24s%-14s%-10s%s\n", k.Key, k.Type, def, k.Allowed(), restart, desc)
+	}
+}
+
+// print how a config differs from the defaults, and what is wrong with it
+func printConfigDiff(diffs []*utils.ConfigDiff) error {
+	for _, d := range diffs {
+		v, _ := json.Marshal(d.Value)
+		switch {
+		case d.Unknown:
+			fmt.Printf("? %s: %s (unknown key)\n", d.Key, v)
+		case d.Err != nil:
+			fmt.Printf("! %s: %s (%v)\n", d.Key, v, d.Err)
+		default:
+			def, _ := json.Marshal(d.Default)
+			fmt.Printf("  %s: %s -> %s\n", d.Key, def, v)
+		}
+	}
+	if n := len(configProblems(diffs)); n > 0 {
+		return fmt.Errorf("The config has %d invalid or unknown keys", n)
+	}
+	return nil
+}
+
+func configProblems(diffs []*utils.ConfigDiff) []string {
+	problems := []string{}
+	for _, d := range diffs {
+		if d.Unknown {
+			problems = append(problems, fmt.Sprintf("Unknown config key %s", d.Key))
+		} else if d.Err != nil {
+			problems = append(problems, d.Err.Error())
+		}
+	}
+	return problems
+}
+
+// Warn about changes that won't take effect until a running chain restarts.
+func warnRestart(root string, schema utils.ConfigSchema, before, after []byte) {
+	if !isRunning(root) {
+		return
+	}
+	changed := ConfigChanges(schema, before, after)
+	if len(changed) > 0 {
+		logger.Warnf("The chain is running. Restart it for %s to take effect\n", strings.Join(changed, ", "))
+	}
+}
+
+// The keys taking a restart whose values differ between two configs.
+func ConfigChanges(schema utils.ConfigSchema, before, after []byte) []string {
+	var a, b map[string]interface{}
+	if json.Unmarshal(before, &a) != nil || json.Unmarshal(after, &b) != nil {
+		return nil
+	}
+	changed := []string{}
+	for _, k := range schema {
+		if k.Restart && !bytes.Equal(compactValue(a[k.Key]), compactValue(b[k.Key])) {
+			changed = append(changed, k.Key)
+		}
+	}
+	return changed
+}
+
+func compactValue(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	//epm-binary-generator:IMPORT
+	mod "github.com/eris-ltd/epm-go/commands/modules/thelonious"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := mod.ConfigSchema(false)
+
+	sets, err := ParseConfigArgs(schema, []string{"local_port:30305", "mining:true", "client:epm:test", "key_store:db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 4 || sets[2].Value != "epm:test" {
+		t.Fatalf("unexpected sets: %v", sets)
+	}
+
+	// every problem is reported, with suggestions for typos
+	_, err = ParseConfigArgs(schema, []string{"minning:true", "local_port:abc", "log_level:9", "key_store:cloud", "mining:maybe", "rpc_port"})
+	if err == nil {
+		t.Fatal("expected bad config to fail")
+	}
+	for _, s := range []string{"Did you mean mining?", "expected an integer", "out of range [0, 5]", "expected one of file, db", "expected true or false", `Invalid arg "rpc_port"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected %q in %v", s, err)
+		}
+	}
+
+	diffs, err := schema.Diff([]byte(`{"local_port": 30303, "max_peers": 20, "log_level": "loud", "colour": "blue", "chain_id": "abc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 3 || diffs[0].Key != "max_peers" || diffs[1].Err == nil || !diffs[2].Unknown {
+		t.Fatalf("unexpected diff: %v", diffs)
+	}
+	if n := len(configProblems(diffs)); n != 2 {
+		t.Fatalf("expected 2 problems, got %d", n)
+	}
+
+	changed := ConfigChanges(schema, []byte(`{"mining": false, "contract_path": "a"}`), []byte(`{"mining": true, "contract_path": "b"}`))
+	if len(changed) != 1 || changed[0] != "mining" {
+		t.Fatalf("expected only mining to take a restart, got %v", changed)
+	}
+
+	// the rpc client's config is a different struct
+	if _, ok := mod.ConfigSchema(true).Lookup("lll_path"); !ok {
+		t.Fatal("expected lll_path in the rpc schema")
+	}
+}
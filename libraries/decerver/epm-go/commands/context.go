@@ -24,10 +24,11 @@ var (
 )
 
 type Context struct {
-	Arguments []string
-	Strings   map[string]string
-	Integers  map[string]int
-	Booleans  map[string]bool
+	Arguments    []string
+	Strings      map[string]string
+	Integers     map[string]int
+	Booleans     map[string]bool
+	StringSlices map[string][]string
 
 	HasSet map[string]struct{}
 }
@@ -44,6 +45,10 @@ func (c *Context) Bool(s string) bool {
 	return c.Booleans[s]
 }
 
+func (c *Context) StringSlice(s string) []string {
+	return c.StringSlices[s]
+}
+
 func (c *Context) Args() []string {
 	return c.Arguments
 }
@@ -69,6 +74,12 @@ func setFields(c *Context, flags []string, generic func(s string) interface{}, H
 			c.Integers[f] = int(elem.Int())
 		case reflect.Bool:
 			c.Booleans[f] = elem.Bool()
+		case reflect.Slice:
+			ss, ok := elem.Interface().(cli.StringSlice)
+			if !ok {
+				panic(fmt.Sprintf("Unknown type! %v", elem.Type()))
+			}
+			c.StringSlices[f] = []string(ss)
 		default:
 			panic(fmt.Sprintf("Unknown type! %v", ty))
 		}
@@ -80,11 +91,12 @@ func setFields(c *Context, flags []string, generic func(s string) interface{}, H
 
 func TransformContext(c *cli.Context) *Context {
 	c2 := &Context{
-		Arguments: []string{},
-		Strings:   make(map[string]string),
-		Integers:  make(map[string]int),
-		Booleans:  make(map[string]bool),
-		HasSet:    make(map[string]struct{}),
+		Arguments:    []string{},
+		Strings:      make(map[string]string),
+		Integers:     make(map[string]int),
+		Booleans:     make(map[string]bool),
+		StringSlices: make(map[string][]string),
+		HasSet:       make(map[string]struct{}),
 	}
 	for _, a := range c.Args() {
 		c2.Arguments = append(c2.Arguments, string(a))
@@ -0,0 +1,263 @@
+package commands
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/eris-ltd/epm-go/chains"
+	"github.com/eris-ltd/epm-go/utils"
+)
+
+// Unreferenced chains and scratch dirs are only collected if they haven't
+// been used for this long, unless told otherwise.
+var DefaultGCAge = "168h"
+
+// A chain root in the blockchains tree.
+type ChainInfo struct {
+	Type  string
+	Id    string
+	Multi string
+	Root  string
+	// Refs to the chain id (shown on the default root).
+	Refs []string
+	Size int64
+	// Last time anything in the root was written.
+	LastUsed time.Time
+	// "global" if it's the HEAD, "local" if it's checked out in the
+	// workspace of the working directory, "workspace" if it's checked out
+	// in another workspace.
+	Head    string
+	Running bool
+}
+
+// Find every chain root in the blockchains tree.
+func Inventory() ([]*ChainInfo, error) {
+	refs, err := chains.GetRefs()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	refsById := make(map[string][]string)
+	for name, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		refsById[ref] = append(refsById[ref], name)
+	}
+	gTyp, gId, _ := chains.GetHead()
+	lTyp, lId, lFile, _ := chains.GetLocalHead()
+	// a chain checked out in any workspace is in use, wherever epm runs
+	workspaces, err := chains.Workspaces()
+	if err != nil {
+		return nil, err
+	}
+	checkedOut := make(map[string]bool)
+	for _, head := range workspaces {
+		checkedOut[head] = true
+	}
+
+	infos := []*ChainInfo{}
+	for _, typ := range subDirs(utils.Blockchains) {
+		if typ == "refs" {
+			continue
+		}
+		for _, id := range subDirs(path.Join(utils.Blockchains, typ)) {
+			// rpc holds the default rpc config for the type
+			if id == "rpc" {
+				continue
+			}
+			for _, multi := range subDirs(path.Join(utils.Blockchains, typ, id)) {
+				root := chains.ComposeRootMulti(typ, id, multi)
+				info := &ChainInfo{Type: typ, Id: id, Multi: multi, Root: root}
+				if multi == chains.DefaultRefUnderId {
+					info.Refs = refsById[path.Join(typ, id)]
+					sort.Strings(info.Refs)
+					if typ == gTyp && id == gId {
+						info.Head = "global"
+					} else if checkedOut[path.Join(typ, id)] {
+						info.Head = "workspace"
+					}
+					if lFile != "" && typ == lTyp && id == lId {
+						info.Head = "local"
+					}
+				}
+				info.Size, info.LastUsed = diskUsage(root)
+				info.Running = isRunning(root)
+				infos = append(infos, info)
+			}
+		}
+	}
+	return infos, nil
+}
+
+// list the refs, or every chain in the tree
+func Ls(c *Context) {
+	if !c.Bool("all") {
+		Refs(c)
+		return
+	}
+	infos, err := Inventory()
+	ifExit(err)
+	format := "%-12s%-44s%-8s%-20s%-10s%-18s%-8s\n"
+	fmt.Printf(format, "Type:", "Chain:", "Multi:", "Refs:", "Size:", "Last used:", "Head:")
+	for _, info := range infos {
+		head := info.Head
+		if info.Running {
+			head = strings.TrimSpace(head + " running")
+		}
+		fmt.Printf(format, info.Type, info.Id, info.Multi, strings.Join(info.Refs, ","), humanSize(info.Size), info.LastUsed.Format("2006-01-02 15:04"), head)
+	}
+	exit(nil)
+}
+
+// remove unreferenced chains and stale scratch directories
+func GC(c *Context) {
+	age := c.String("older-than")
+	if age == "" {
+		age = DefaultGCAge
+	}
+	maxAge, err := time.ParseDuration(age)
+	ifExit(err)
+	dryRun := c.Bool("dry-run")
+	cutoff := time.Now().Add(-maxAge)
+
+	infos, err := Inventory()
+	ifExit(err)
+
+	var freed int64
+	remove := func(dir string, size int64) {
+		if dryRun {
+			fmt.Printf("Would remove %s (%s)\n", dir, humanSize(size))
+		} else {
+			fmt.Printf("Removing %s (%s)\n", dir, humanSize(size))
+			if err := os.RemoveAll(dir); err != nil {
+				logger.Errorln(err)
+				return
+			}
+		}
+		freed += size
+	}
+
+	for _, info := range collectable(infos, cutoff) {
+		remove(info.Root, info.Size)
+		if !dryRun {
+			// remove the chain id dir once its last copy is gone
+			idDir := path.Dir(info.Root)
+			if len(subDirs(idDir)) == 0 {
+				os.RemoveAll(idDir)
+			}
+		}
+	}
+
+	// scratch dirs of deploys that didn't finish (they have random hex names)
+	for _, name := range subDirs(utils.Epm) {
+		if b, err := hex.DecodeString(name); err != nil || len(b) != 8 {
+			continue
+		}
+		dir := path.Join(utils.Epm, name)
+		size, lastUsed := diskUsage(dir)
+		if lastUsed.Before(cutoff) {
+			remove(dir, size)
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("Would free %s\n", humanSize(freed))
+	} else {
+		fmt.Printf("Freed %s\n", humanSize(freed))
+	}
+	exit(nil)
+}
+
+// The chains gc removes: those with no refs that aren't checked out or
+// running, and haven't been used since the cutoff.
+func collectable(infos []*ChainInfo, cutoff time.Time) []*ChainInfo {
+	// refs and heads keep every copy (multi) of a chain
+	keep := make(map[string]bool)
+	for _, info := range infos {
+		if len(info.Refs) > 0 || info.Head != "" {
+			keep[path.Join(info.Type, info.Id)] = true
+		}
+	}
+	garbage := []*ChainInfo{}
+	for _, info := range infos {
+		if keep[path.Join(info.Type, info.Id)] || info.Running || info.LastUsed.After(cutoff) {
+			continue
+		}
+		garbage = append(garbage, info)
+	}
+	return garbage
+}
+
+func subDirs(dir string) []string {
+	fs, _ := ioutil.ReadDir(dir)
+	names := []string{}
+	for _, f := range fs {
+		if f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+// The size of a directory and the last time anything in it was modified.
+func diskUsage(dir string) (int64, time.Time) {
+	var size int64
+	var last time.Time
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+		return nil
+	})
+	return size, last
+}
+
+// Is a node running on the chain root (see Run)?
+func isRunning(root string) bool {
+	_, ok := runningPid(path.Join(root, "pid"))
+	return ok
+}
+
+// Read a pid file and check the process is alive.
+func runningPid(file string) (int, bool) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, false
+	}
+	return pid, p.Signal(syscall.Signal(0)) == nil
+}
+
+func humanSize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", n, units[0])
+	}
+	return fmt.Sprintf("%.1f%s", f, units[i])
+}
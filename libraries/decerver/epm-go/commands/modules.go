@@ -32,8 +32,8 @@ func configureRootDir(c *Context, m epm.Blockchain, chainRoot string) error {
 	root, _ := filepath.Abs(defaultDatabase)
 	m.SetProperty("RootDir", root)
 
-	// if the HEAD is set, it overrides the default
-	if typ, c, err := chains.GetHead(); err == nil && c != "" {
+	// if the HEAD (or the workspace head) is set, it overrides the default
+	if typ, c, _, err := chains.CurrentHead(); err == nil && c != "" {
 		root, _ = chains.ResolveChainDir(typ, c, c)
 		m.SetProperty("RootDir", root)
 	}
@@ -114,3 +114,36 @@ func setupModule(c *Context, m epm.Blockchain, chainRoot string) error {
 
 	return nil
 }
+
+// Load the chain in a root directory and get its chain id.
+func ChainIdFromRoot(chainType, root string) (string, error) {
+	chain := mod.NewChain(chainType, false)
+	if err := readChainRoot(chain, chainType, root); err != nil {
+		return "", err
+	}
+	if err := chain.Init(); err != nil {
+		return "", err
+	}
+	defer chain.Shutdown()
+	return chain.ChainId()
+}
+
+// Point the config of a chain at the root directory it was moved to.
+func SetChainRoot(chainType, root string) error {
+	chain := mod.NewChain(chainType, false)
+	if err := readChainRoot(chain, chainType, root); err != nil {
+		return err
+	}
+	return chain.WriteConfig(path.Join(root, "config.json"))
+}
+
+func readChainRoot(chain epm.Blockchain, chainType, root string) error {
+	if err := chain.ReadConfig(path.Join(root, "config.json")); err != nil {
+		return err
+	}
+	chain.SetProperty("RootDir", root)
+	if chainType == "thelonious" {
+		chain.SetProperty("GenesisConfig", path.Join(root, "genesis.json"))
+	}
+	return nil
+}
@@ -1,14 +1,25 @@
 package commands
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/lllc-server"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/modules/mint"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/tendermint/ed25519"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/tendermint/tendermint/account"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/tendermint/tendermint/binary"
 	mintconfig "github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/tendermint/tendermint/config"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/tendermint/tendermint/state"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/tendermint/tendermint/vm"
 	"github.com/eris-ltd/epm-go/epm"
 	"github.com/eris-ltd/epm-go/utils"
 )
@@ -56,12 +67,195 @@ func ChainSpecificDeploy(chain epm.Blockchain, deployGen, root string, novi bool
 			return err
 		}
 	}
+	b, err := ioutil.ReadFile(tempGen)
+	if err != nil {
+		return err
+	}
+	if err := ValidateGenesis(b); err != nil {
+		return err
+	}
 
 	tmint := chain.(*mint.MintModule)
 	setGenesisConfigMint(tmint, tempGen)
 	return nil
 }
 
+// The genesis used when none is given
+func DefaultGenesis() []byte {
+	return []byte(mintconfig.DefaultGenesis)
+}
+
+// Check a genesis.json is a sound state.GenesisDoc: it has validators,
+// and every address is 20 bytes and every amount positive.
+func ValidateGenesis(b []byte) (err error) {
+	// binary panics on some malformed json
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Invalid genesis: %v", r)
+		}
+	}()
+
+	var gen *state.GenesisDoc
+	binary.ReadJSON(&gen, b, &err)
+	if err != nil {
+		return fmt.Errorf("Invalid genesis: %v", err)
+	}
+	if gen == nil || len(gen.Validators) == 0 {
+		return fmt.Errorf("Invalid genesis: there are no validators")
+	}
+
+	seen := make(map[string]bool)
+	for i, acc := range gen.Accounts {
+		addr := hex.EncodeToString(acc.Address)
+		if len(acc.Address) != 20 {
+			return fmt.Errorf("Invalid genesis: account %d has an invalid address %q", i, addr)
+		}
+		if seen[addr] {
+			return fmt.Errorf("Invalid genesis: account %s is listed twice", addr)
+		}
+		seen[addr] = true
+	}
+	for i, val := range gen.Validators {
+		if len(val.PubKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("Invalid genesis: validator %d has an invalid pub_key", i)
+		}
+		if val.Amount == 0 {
+			return fmt.Errorf("Invalid genesis: validator %d has no bond amount", i)
+		}
+		if len(val.UnbondTo) == 0 {
+			return fmt.Errorf("Invalid genesis: validator %d has nowhere to unbond_to", i)
+		}
+		for _, to := range val.UnbondTo {
+			if len(to.Address) != 20 {
+				return fmt.Errorf("Invalid genesis: validator %d has an invalid unbond_to address %q", i, hex.EncodeToString(to.Address))
+			}
+		}
+	}
+	return nil
+}
+
+// The keys of a tendermint config.json. Keys not described here take a
+// restart and have no description.
+var configKeys = []*utils.ConfigKey{
+	{Key: "local_host", Description: "host to listen for peers on", Restart: true},
+	{Key: "local_port", Description: "port to listen for peers on", Restart: true, Min: 1, Max: 65535},
+	{Key: "listen", Description: "accept connections from peers", Restart: true},
+	{Key: "remote_host", Description: "host of a peer to connect to on start", Restart: true},
+	{Key: "remote_port", Description: "port of the peer to connect to on start", Restart: true, Min: 1, Max: 65535},
+	{Key: "use_seed", Description: "connect to the remote peer on start", Restart: true},
+	{Key: "rpc_host", Description: "host to serve rpc on", Restart: true},
+	{Key: "rpc_port", Description: "port to serve rpc on", Restart: true, Min: 1, Max: 65535},
+	{Key: "serve_rpc", Description: "serve rpc", Restart: true},
+	{Key: "fetch_port", Description: "port to serve the genesis on, for epm fetch", Restart: true, Min: 1, Max: 65535},
+	{Key: "chain_id", Description: "id of the chain (the hash of its genesis)", Restart: true, Managed: true},
+	{Key: "chain_name", Description: "name of the chain", Restart: true},
+	{Key: "fast_sync", Description: "sync blocks quickly before joining consensus", Restart: true},
+	{Key: "max_peers", Description: "most peers to connect to", Restart: true, Min: 0, Max: 1000},
+	{Key: "moniker", Description: "name of the node sent to peers", Restart: true},
+	{Key: "version", Description: "client version sent to peers", Restart: true},
+	{Key: "network", Description: "name of the network (the chain id if empty)", Restart: true},
+	{Key: "key_session", Description: "name of the key session", Restart: true},
+	{Key: "key_store", Description: "where keys are kept", Restart: true},
+	{Key: "key_cursor", Description: "index of the key to use", Restart: true, Min: 0, Max: 1 << 20},
+	{Key: "key_file", Description: "the priv_validator.json of the node", Restart: true},
+	{Key: "config_file", Description: "name of the config file", Restart: true, Managed: true},
+	{Key: "root_dir", Description: "directory of the chain", Restart: true, Managed: true},
+	{Key: "db_name", Description: "name of the database in the root", Restart: true},
+	{Key: "db_mem", Description: "keep the database in memory", Restart: true},
+	{Key: "contract_path", Description: "where epm deploy finds contracts", Restart: false},
+	{Key: "genesis_config", Description: "the genesis.json of the chain", Restart: true, Managed: true},
+	{Key: "log_file", Description: "file to log to (stdout if empty)", Restart: true},
+	{Key: "debug_file", Description: "file to write debug logs to", Restart: true},
+	{Key: "log_level", Description: "log level (0 silent to 5 debug detail)", Restart: true, Min: 0, Max: 5},
+}
+
+// The schema of the config.json of a chain. The rpc client reads the same
+// config, but only when it starts, so nothing takes a restart.
+func ConfigSchema(rpc bool) utils.ConfigSchema {
+	schema := utils.NewConfigSchema(mint.DefaultConfig, configKeys)
+	if rpc {
+		for _, k := range schema {
+			k.Restart = false
+		}
+	}
+	return schema
+}
+
 func Fetch(chainType, peerserver string) ([]byte, error) {
 	return nil, fmt.Errorf("Fetch not supported for mint")
 }
+
+// Coins for each testnet validator, and how many of them are bonded.
+var (
+	TestnetBalance uint64 = 1000000000000000
+	TestnetBond    uint64 = 100000000000
+)
+
+// Make the genesis for a testnet of n nodes from a template (the default
+// genesis if deployGen is empty), and write it to out. The nodes are the
+// validators (the template's are dropped, since nobody runs them). Returns
+// the nodes' keys.
+func TestnetGenesis(deployGen, out string, n int) ([]string, error) {
+	if deployGen == "" {
+		deployGen = path.Join(utils.Blockchains, "tendermint", "genesis.json")
+	}
+	b, err := ioutil.ReadFile(deployGen)
+	if err != nil {
+		b = []byte(mintconfig.DefaultGenesis)
+	}
+	gen := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&gen); err != nil {
+		return nil, fmt.Errorf("Error reading genesis %s: %v", deployGen, err)
+	}
+
+	accounts, _ := gen["accounts"].([]interface{})
+	validators := []interface{}{}
+	keys := make([]string, n)
+	for i := range keys {
+		priv := new([64]byte)
+		if _, err := rand.Read(priv[:32]); err != nil {
+			return nil, err
+		}
+		pub := ed25519.MakePublicKey(priv)
+		pubKey := account.PubKeyEd25519(pub[:])
+		addr := strings.ToUpper(hex.EncodeToString(pubKey.Address()))
+		keys[i] = hex.EncodeToString(priv[:])
+
+		accounts = append(accounts, map[string]interface{}{
+			"address": addr,
+			"amount":  TestnetBalance,
+		})
+		validators = append(validators, map[string]interface{}{
+			"pub_key": []interface{}{account.PubKeyTypeEd25519, strings.ToUpper(hex.EncodeToString(pubKey))},
+			"amount":  TestnetBond,
+			"unbond_to": []interface{}{
+				map[string]interface{}{"address": addr, "amount": TestnetBond},
+			},
+		})
+	}
+	gen["accounts"] = accounts
+	gen["validators"] = validators
+	// otherwise each node uses the time it starts, and they all get a
+	// different genesis state
+	gen["genesis_time"] = time.Now().Format("Mon Jan 02 15:04:05 -0700 2006")
+	return keys, utils.WriteJson(gen, out)
+}
+
+// Give a testnet node its validator key.
+func TestnetNode(chain epm.Blockchain, root, name, key string) error {
+	session := "testnet"
+	if err := ioutil.WriteFile(path.Join(root, session), []byte(key), 0600); err != nil {
+		return err
+	}
+	chain.SetProperty("KeySession", session)
+	chain.SetProperty("KeyFile", "")
+	chain.SetProperty("Moniker", name)
+	return nil
+}
+
+// Opcode names from the tendermint vm, for disassembling
+func VMOpCodes() lllcserver.OpCodes {
+	return lllcserver.VMOpCodes(func(op byte) string { return vm.OpCode(op).String() })
+}
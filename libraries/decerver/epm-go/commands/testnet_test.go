@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTestnetLogTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epm-testnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, TestnetNodeLog)
+	if err := ioutil.WriteFile(file, []byte("one\ntwo\nthree\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, offset, err := tailLines(file, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"two", "three"}) {
+		t.Fatalf("bad tail: %v", lines)
+	}
+
+	// a partial line is left for the next read
+	f, _ := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString("four\nfi")
+	lines, offset, err = readFrom(file, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"four"}) {
+		t.Fatalf("bad lines: %v", lines)
+	}
+	f.WriteString("ve\n")
+	f.Close()
+	lines, _, err = readFrom(file, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lines, ",") != "five" {
+		t.Fatalf("bad lines: %v", lines)
+	}
+}
@@ -45,6 +45,9 @@ func (e *EPM) ExecuteJobs() error {
 			return err
 		}
 	}
+	if e.Prefetch {
+		e.prefetchDeploys()
+	}
 
 	uncommited := false
 	for i, j := range e.jobs {
@@ -207,15 +210,8 @@ func (e *EPM) Assert(args []string) error {
 func (e *EPM) Deploy(args []string) error {
 	contract := args[0]
 	key := args[1]
-	contract = strings.Trim(contract, "\"")
 	logger.Debugln("Deploying contract:", contract)
-	var p string
-	// compile contract
-	if filepath.IsAbs(contract) {
-		p = contract
-	} else {
-		p = path.Join(ContractPath, contract)
-	}
+	p := resolveContractPath(contract)
 	logger.Debugln("Contract path:", p)
 	// compile
 	bytecode, abiSpec, err := lllcserver.Compile(p)
@@ -246,6 +242,60 @@ func (e *EPM) Deploy(args []string) error {
 	return nil
 }
 
+// Full path to a contract given as a deploy argument
+func resolveContractPath(contract string) string {
+	contract = strings.Trim(contract, "\"")
+	if filepath.IsAbs(contract) {
+		return contract
+	}
+	return path.Join(ContractPath, contract)
+}
+
+// The contracts deployed by the jobs, each once.
+// Jobs whose arguments depend on variables not yet set are skipped
+func (e *EPM) deployTargets() []string {
+	files := []string{}
+	seen := make(map[string]bool)
+	for _, j := range e.jobs {
+		if j.cmd != "deploy" {
+			continue
+		}
+		args, err := e.ResolveArgs(j.cmd, j.args)
+		if err != nil || len(args) == 0 || strings.Contains(args[0], "{{") {
+			continue
+		}
+		f := path.Clean(resolveContractPath(args[0]))
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Compile the targets of all deploy jobs in a single batch
+// so they are in the compiler cache by the time we run them.
+func (e *EPM) prefetchDeploys() {
+	files := e.deployTargets()
+	if len(files) == 0 {
+		return
+	}
+
+	logger.Infoln("Prefetching contracts:", files)
+	ch, err := lllcserver.CompileMany(files)
+	if err != nil {
+		logger.Errorln("failed to prefetch contracts:", err)
+		return
+	}
+	for r := range ch {
+		if r.Error != "" {
+			logger.Errorln("failed to prefetch", r.Name, r.Error)
+		} else {
+			logger.Debugln("Prefetched", r.Name)
+		}
+	}
+}
+
 // Modify lines in the contract prior to deploy, and save its address
 func (e *EPM) ModifyDeploy(args []string) error {
 	contract := args[0]
@@ -516,6 +566,13 @@ func SetCompilerServer(hostPort string) {
 	}
 }
 
+// Api token for compile servers that require one
+func SetCompilerToken(token string) {
+	for lang, _ := range lllcserver.Languages {
+		lllcserver.SetLanguageToken(lang, token)
+	}
+}
+
 // TODO: we should really only every copy what and when we need to
 func (e *EPM) existsModifyJob() bool {
 	for _, j := range e.jobs {
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epm-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "chain.log")
+	l, err := openRotatingLog(file, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd"} {
+		if _, err := l.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	for f, expected := range map[string]string{file: "dddddd", file + ".1": "cccccc", file + ".2": "bbbbbb"} {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("%s: got %q, expected %q", f, b, expected)
+		}
+	}
+	if _, err := os.Stat(file + ".3"); !os.IsNotExist(err) {
+		t.Fatal("expected only two old logs to be kept")
+	}
+}
+
+// A chain that keeps exiting is restarted, and stops when told to.
+func TestChainManagerRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epm-chains-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := path.Join(dir, "epm")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\"\nsleep 0.2\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer func(cmd, logDir string, backoff time.Duration) {
+		ChainCommand, ChainLogDir, ChainMinBackoff = cmd, logDir, backoff
+	}(ChainCommand, ChainLogDir, ChainMinBackoff)
+	ChainCommand = script
+	ChainLogDir = path.Join(dir, "logs")
+	ChainMinBackoff = 10 * time.Millisecond
+
+	cm := NewChainManager()
+	root := path.Join(dir, "root")
+	if err := cm.Start("test", "thelonious", "abc", root, ChainOptions{LogLevel: 3, Mine: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Start("other", "thelonious", "abc", root, ChainOptions{}); err == nil {
+		t.Fatal("expected an error starting a second chain on the same root")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, _ := cm.Status("test")
+		if s.Restarts >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chain was not restarted: %+v", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := cm.Stop("test"); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := cm.Status("test")
+	if s.State != ChainStopped || s.Pid != 0 || cm.IsRunning("test") {
+		t.Fatalf("chain was not stopped: %+v", s)
+	}
+	if err := cm.Stop("test"); err == nil {
+		t.Fatal("expected an error stopping a stopped chain")
+	}
+
+	b, err := ioutil.ReadFile(s.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "--log 3 run --chain thelonious/abc --mine") {
+		t.Fatalf("unexpected log: %s", b)
+	}
+}
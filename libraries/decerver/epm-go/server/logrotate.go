@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// A log file that is rotated when it gets too big. The file is moved to
+// file.1, file.1 to file.2 and so on, keeping the given number of old
+// files.
+type rotatingLog struct {
+	mtx     sync.Mutex
+	file    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingLog(file string, maxSize int64, keep int) (*rotatingLog, error) {
+	l := &rotatingLog{file: file, maxSize: maxSize, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	if l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *rotatingLog) rotate() error {
+	l.f.Close()
+	l.f = nil
+	if l.keep > 0 {
+		os.Remove(l.old(l.keep))
+		for i := l.keep - 1; i > 0; i-- {
+			os.Rename(l.old(i), l.old(i+1))
+		}
+		if err := os.Rename(l.file, l.old(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.file); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *rotatingLog) old(i int) string {
+	return fmt.Sprintf("%s.%d", l.file, i)
+}
+
+func (l *rotatingLog) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -29,6 +29,8 @@ var fetchIP string = "104.236.146.58"
 var fetchPort string = "15258"
 
 func init() {
+	// The commands are run by the installed epm, not this test binary.
+	ChainCommand = "epm"
 	rootPath, _ := filepath.Abs("/public")
 	srvr = NewServer(serverHost, uint16(serverPort), TEST_NUM, rootPath)
 	go func() {
@@ -0,0 +1,114 @@
+package lllcserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func authServer(a *AuthConfig) *httptest.Server {
+	if err := a.Init(); err != nil {
+		panic(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		a.Handler(rec, r)
+		if rec.Code != http.StatusOK {
+			w.WriteHeader(rec.Code)
+			return
+		}
+		if _, ok := readBody(w, r); ok {
+			w.Write([]byte("ok"))
+		}
+	}))
+}
+
+func authPost(t *testing.T, url, token string, body []byte) int {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setRequestToken(req, token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAuthTokens(t *testing.T) {
+	ts := authServer(&AuthConfig{Tokens: map[string]string{"secret": "bob"}})
+	defer ts.Close()
+
+	for token, expected := range map[string]int{
+		"":       http.StatusUnauthorized,
+		"wrong":  http.StatusUnauthorized,
+		"secret": http.StatusOK,
+	} {
+		if code := authPost(t, ts.URL, token, nil); code != expected {
+			t.Fatalf("token %q: got %d, expected %d", token, code, expected)
+		}
+	}
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	ts := authServer(&AuthConfig{
+		Tokens:    map[string]string{"a": "alice", "b": "bob"},
+		TokenRate: 2,
+	})
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		if code := authPost(t, ts.URL, "a", nil); code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, code)
+		}
+	}
+	if code := authPost(t, ts.URL, "a", nil); code != statusTooManyRequests {
+		t.Fatalf("expected rate limit, got %d", code)
+	}
+	// other tokens have their own bucket
+	if code := authPost(t, ts.URL, "b", nil); code != http.StatusOK {
+		t.Fatalf("second token was limited: %d", code)
+	}
+}
+
+func TestAuthMaxBody(t *testing.T) {
+	old := MaxBodySize
+	MaxBodySize = 16
+	defer func() { MaxBodySize = old }()
+
+	ts := authServer(&AuthConfig{})
+	defer ts.Close()
+
+	for size, expected := range map[int]int{
+		8:  http.StatusOK,
+		16: http.StatusOK,
+		17: http.StatusRequestEntityTooLarge,
+		64: http.StatusRequestEntityTooLarge,
+	} {
+		if code := authPost(t, ts.URL, "", make([]byte, size)); code != expected {
+			t.Fatalf("body of %d bytes: got %d, expected %d", size, code, expected)
+		}
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	l := newRateLimiter(1)
+	l.Allow("a")
+	l.Allow("b")
+	// not yet time to prune
+	if len(l.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(l.buckets))
+	}
+
+	// a has refilled since, b hasn't
+	l.buckets["a"].last = l.buckets["a"].last.Add(-2 * time.Minute)
+	l.lastPrune = l.lastPrune.Add(-pruneInterval)
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 2 {
+		t.Fatalf("expected only the full bucket to be pruned, got %v", l.buckets)
+	}
+}
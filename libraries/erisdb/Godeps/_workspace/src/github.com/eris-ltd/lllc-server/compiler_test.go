@@ -0,0 +1,141 @@
+package lllcserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// a go compiler for hex where a line "include <file>" pastes in another file.
+// not something a regex template can do
+type hexIncludeCompiler struct{}
+
+func (hexIncludeCompiler) Compile(filename string) ([]byte, error) {
+	code, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "include ") {
+			b, err := hexIncludeCompiler{}.Compile(strings.TrimPrefix(line, "include ") + ".hexi")
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, b...)
+			continue
+		}
+		b, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+func (hexIncludeCompiler) Abi(filename string) (string, error) {
+	return "[]", nil
+}
+
+func (hexIncludeCompiler) ResolveIncludes(code []byte, resolve IncludeResolver) ([]byte, error) {
+	lines := strings.Split(string(code), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "include ") {
+			continue
+		}
+		name, err := resolve(strings.TrimPrefix(line, "include "))
+		if err != nil {
+			return nil, err
+		}
+		lines[i] = "include " + name
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func (hexIncludeCompiler) Version() (string, error) {
+	return "0.0.1", nil
+}
+
+func init() {
+	RegisterCompiler("hexi", []string{"hexi"}, hexIncludeCompiler{})
+}
+
+func testGoCompiler(t *testing.T) {
+	ClearCaches()
+	dir, err := ioutil.TempDir("", "lllc-compiler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.hexi":   "6001\ninclude lib/a.hexi\n6003",
+		"lib/a.hexi":  "6002\ninclude b.hexi",
+		"lib/b.hexi":  "60ff",
+		"unused.hexi": "00",
+	}
+	os.Mkdir(path.Join(dir, "lib"), 0700)
+	for name, code := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(code), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, abi, err := Compile(path.Join(dir, "main.hexi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := hex.DecodeString("6001600260ff6003")
+	if !bytes.Equal(code, expected) {
+		t.Fatalf("got %x, expected %x", code, expected)
+	}
+	if abi != "[]" {
+		t.Fatalf("bad abi %s", abi)
+	}
+}
+
+func TestGoCompilerLocal(t *testing.T) {
+	SetLanguageNet("hexi", false)
+	testGoCompiler(t)
+}
+
+func TestGoCompilerRemote(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compile", CompileHandler)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	SetLanguageURL("hexi", ts.URL+"/compile")
+	SetLanguageNet("hexi", true)
+	testGoCompiler(t)
+}
+
+func TestCmdCompilerIncludes(t *testing.T) {
+	c := NewCmdCompiler(Languages["lll"])
+	code := []byte(`(include "a.lll") (include "b/c.lll")`)
+	got, err := c.ResolveIncludes(code, func(name string) (string, error) {
+		return strings.Replace(name, "/", "-", -1) + "-hash", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `(include "a.lll-hash.lll") (include "b-c.lll-hash.lll")`
+	if string(got) != expected {
+		t.Fatalf("got %s, expected %s", got, expected)
+	}
+}
+
+func TestCompilerVersion(t *testing.T) {
+	v, _ := CompilerVersion("hexi")
+	if v != "0.0.1" {
+		t.Fatalf("bad version %s", v)
+	}
+}
@@ -0,0 +1,83 @@
+package chains
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long to wait for a lock.
+var LockTimeout = 10 * time.Second
+
+const lockSuffix = ".lock"
+
+// Lock a file (HEAD, a ref or a workspace) so concurrent epm processes
+// don't clobber each other's changes. The lock is an flock on a file next
+// to it, so it goes away with the process that holds it however long
+// that runs, and the file is left in place (removing it would let two
+// processes lock different files). Returns the function that unlocks it.
+func lockFile(p string) (func(), error) {
+	lock := p + lockSuffix
+	f, err := os.OpenFile(lock, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			f.Close()
+			return nil, err
+		}
+		if time.Since(start) > LockTimeout {
+			pid, _ := ioutil.ReadFile(lock)
+			f.Close()
+			return nil, fmt.Errorf("Timed out waiting for the lock on %s (held by epm process %s)", p, pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the pid of the holder, for the error above
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// Write a file by writing a temporary file and moving it in place, so
+// readers never see half of it.
+func writeFileAtomic(p string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Lock and temporary files that live next to the refs.
+func isLockOrTemp(name string) bool {
+	return strings.HasSuffix(name, lockSuffix) || strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,154 @@
+package chains
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/epm-go/utils"
+)
+
+// Point the blockchains tree at a temporary directory with one chain.
+func setupTree(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "epm-chains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockchains, head, refs, workspaces := utils.Blockchains, utils.HEAD, utils.Refs, utils.Workspaces
+	utils.Blockchains = path.Join(dir, "blockchains")
+	utils.HEAD = path.Join(utils.Blockchains, "HEAD")
+	utils.Refs = path.Join(utils.Blockchains, "refs")
+	utils.Workspaces = path.Join(utils.Blockchains, "workspaces")
+	for _, id := range []string{"aaaa", "bbbb"} {
+		if err := os.MkdirAll(ComposeRoot("thelonious", id), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(utils.Refs, 0700)
+	ioutil.WriteFile(utils.HEAD, nil, 0666)
+	return dir, func() {
+		utils.Blockchains, utils.HEAD, utils.Refs, utils.Workspaces = blockchains, head, refs, workspaces
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWorkspaceHead(t *testing.T) {
+	dir, cleanup := setupTree(t)
+	defer cleanup()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	project := path.Join(dir, "project")
+	sub := path.Join(project, "contracts", "lib")
+	os.MkdirAll(sub, 0700)
+	ifErr(t, os.Chdir(sub))
+
+	ifErr(t, ChangeHead("thel", "aaaa"))
+	typ, id, file, err := CurrentHead()
+	ifErr(t, err)
+	if id != "aaaa" || file != utils.HEAD {
+		t.Fatalf("expected the global head, got %s/%s from %s", typ, id, file)
+	}
+
+	_, err = ChangeLocalHead(project, "thel", "bbb")
+	ifErr(t, err)
+	typ, id, file, err = CurrentHead()
+	ifErr(t, err)
+	if typ != "thelonious" || id != "bbbb" || file != path.Join(project, WorkspaceFileName) {
+		t.Fatalf("expected the workspace head, got %s/%s from %s", typ, id, file)
+	}
+	if _, id, _ = GetHead(); id != "aaaa" {
+		t.Fatalf("the global head changed to %s", id)
+	}
+	if _, id, err = ResolveChain(""); err != nil || id != "bbbb" {
+		t.Fatalf("resolved %s (%v)", id, err)
+	}
+}
+
+// Workspaces are registered when they're created, and dropped from the
+// registry when they're gone.
+func TestWorkspaceRegistry(t *testing.T) {
+	dir, cleanup := setupTree(t)
+	defer cleanup()
+	os.MkdirAll(path.Join(dir, "a"), 0700)
+	os.MkdirAll(path.Join(dir, "b"), 0700)
+
+	a, err := ChangeLocalHead(path.Join(dir, "a"), "thel", "aaaa")
+	ifErr(t, err)
+	b, err := ChangeLocalHead(path.Join(dir, "b"), "thel", "bbbb")
+	ifErr(t, err)
+	_, err = ChangeLocalHead(path.Join(dir, "a"), "thel", "bbbb")
+	ifErr(t, err)
+
+	heads, err := Workspaces()
+	ifErr(t, err)
+	if len(heads) != 2 || heads[a] != "thelonious/bbbb" || heads[b] != "thelonious/bbbb" {
+		t.Fatalf("got workspaces %v", heads)
+	}
+
+	ifErr(t, os.Remove(b))
+	heads, err = Workspaces()
+	ifErr(t, err)
+	if len(heads) != 1 || heads[a] != "thelonious/bbbb" {
+		t.Fatalf("got workspaces %v", heads)
+	}
+	files, err := readWorkspaces()
+	ifErr(t, err)
+	if len(files) != 1 || files[0] != a {
+		t.Fatalf("removed workspace is still registered: %v", files)
+	}
+}
+
+func TestRefLock(t *testing.T) {
+	_, cleanup := setupTree(t)
+	defer cleanup()
+
+	unlock, err := lockFile(path.Join(utils.Refs, "mychain"))
+	ifErr(t, err)
+	done := make(chan error)
+	go func() { done <- AddRef("thel", "aaaa", "mychain") }()
+	select {
+	case <-done:
+		t.Fatal("added a ref while it was locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	ifErr(t, <-done)
+
+	if err := AddRef("thel", "bbbb", "mychain"); err == nil {
+		t.Fatal("overwrote a ref")
+	}
+	refs, err := GetRefs()
+	ifErr(t, err)
+	if len(refs) != 1 || refs["mychain"] != "thelonious/aaaa" {
+		t.Fatalf("got refs %v", refs)
+	}
+}
+
+// A lock that is held for longer than the timeout is not taken over.
+func TestLongHeldLock(t *testing.T) {
+	_, cleanup := setupTree(t)
+	defer cleanup()
+	defer func(timeout time.Duration) { LockTimeout = timeout }(LockTimeout)
+	LockTimeout = 50 * time.Millisecond
+
+	p := path.Join(utils.Refs, "mychain")
+	unlock, err := lockFile(p)
+	ifErr(t, err)
+	time.Sleep(2 * LockTimeout)
+	if _, err := lockFile(p); err == nil {
+		t.Fatal("took over a lock that is still held")
+	}
+	unlock()
+	unlock, err = lockFile(p)
+	ifErr(t, err)
+	unlock()
+}
+
+func ifErr(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -27,6 +27,73 @@ var (
 		EnvVar: "",
 	}
 
+	outputFlag = cli.StringFlag{
+		Name:  "output, o",
+		Value: "",
+		Usage: "the archive to write (defaults to <chainId>.tar.gz)",
+	}
+
+	allFlag = cli.BoolFlag{
+		Name:  "all, a",
+		Usage: "list every chain in the tree, with its refs, size and last use",
+	}
+
+	dryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "show what would be removed without removing it",
+	}
+
+	olderThanFlag = cli.StringFlag{
+		Name:  "older-than",
+		Value: "168h",
+		Usage: "only remove chains and scratch directories unused for this long",
+	}
+
+	nodesFlag = cli.IntFlag{
+		Name:  "nodes, n",
+		Value: 4,
+		Usage: "number of nodes in the testnet",
+	}
+
+	testnetPortFlag = cli.IntFlag{
+		Name:  "port",
+		Value: 0,
+		Usage: "first port for the testnet nodes (defaults to the chain's listen port). Each node gets the next 10",
+	}
+
+	testnetNameFlag = cli.StringFlag{
+		Name:  "name",
+		Value: "",
+		Usage: "specify a ref name for the testnet's chain",
+	}
+
+	rmFlag = cli.BoolFlag{
+		Name:  "rm",
+		Usage: "remove the testnet's nodes once they are stopped",
+	}
+
+	nodeFlag = cli.StringFlag{
+		Name:  "node",
+		Value: "",
+		Usage: "only show the logs of this node (eg. node0)",
+	}
+
+	linesFlag = cli.IntFlag{
+		Name:  "lines",
+		Value: 20,
+		Usage: "number of lines to show from the end of each log",
+	}
+
+	followFlag = cli.BoolFlag{
+		Name:  "follow, f",
+		Usage: "keep printing the logs as they grow",
+	}
+
+	localFlag = cli.BoolFlag{
+		Name:  "local",
+		Usage: "check out the chain for this directory only (in a .epm workspace file)",
+	}
+
 	multiFlag = cli.StringFlag{
 		Name:  "multi",
 		Value: "",
@@ -52,6 +119,17 @@ var (
 		EnvVar: "",
 	}
 
+	codeDiffFlag = cli.StringFlag{
+		Name:  "diff",
+		Usage: "compile this contract and diff it against the code at the address",
+	}
+
+	prefetchFlag = cli.BoolFlag{
+		Name:   "prefetch",
+		Usage:  "compile all deploy targets in one batch before running jobs",
+		EnvVar: "",
+	}
+
 	dontClearFlag = cli.BoolFlag{
 		Name:   "dont-clear",
 		Usage:  "stop epm from clearing the epm cache on startup",
@@ -131,6 +209,16 @@ var (
 		Usage: "edit the config in a vim window",
 	}
 
+	configListFlag = cli.BoolFlag{
+		Name:  "list",
+		Usage: "list the config keys of the chain type, with their types, defaults and descriptions",
+	}
+
+	configDiffFlag = cli.BoolFlag{
+		Name:  "diff",
+		Usage: "show how the config differs from the defaults, and any invalid or unknown keys",
+	}
+
 	editConfigFlag = cli.BoolFlag{
 		Name:  "edit-config",
 		Usage: "open the config in an editor on epm new",
@@ -151,6 +239,27 @@ var (
 		Usage: "edit the genesis.json even if it is provided",
 	}
 
+	templateFlag = cli.StringFlag{
+		Name:  "template",
+		Usage: "use a genesis template (see epm genesis templates)",
+	}
+
+	setFlag = cli.StringSliceFlag{
+		Name:  "set",
+		Value: &cli.StringSlice{},
+		Usage: "override a genesis or config value: --set difficulty=10 --set rpc_port=8080 (keys may start with genesis. or config.)",
+	}
+
+	genesisOutputFlag = cli.StringFlag{
+		Name:  "output, o",
+		Usage: "write the genesis to a file",
+	}
+
+	forceTemplateFlag = cli.BoolFlag{
+		Name:  "force",
+		Usage: "replace the template if it exists",
+	}
+
 	importFlag = cli.BoolFlag{
 		Name:  "import",
 		Usage: "stop epm from importing the generated key into chain's config",
@@ -166,6 +275,12 @@ var (
 		Usage: "specify <host>:<port> to use for compile server",
 	}
 
+	compilerTokenFlag = cli.StringFlag{
+		Name:   "compiler-token",
+		Usage:  "api token for the compile server",
+		EnvVar: "EPM_COMPILER_TOKEN",
+	}
+
 	keyTypeFlag = cli.StringFlag{
 		Name:  "type",
 		Value: "secp256k1",
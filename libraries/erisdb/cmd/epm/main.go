@@ -38,6 +38,7 @@ func main() {
 
 		// languages
 		compilerFlag,
+		compilerTokenFlag,
 
 		// runtime configuration
 		runConfigFlag,
@@ -52,11 +53,17 @@ func main() {
 		consoleCmd,
 		cpCmd,
 		deployCmd,
+		exportCmd,
 		fetchCmd,
+		gcCmd,
+		genesisCmd,
 		headCmd,
+		importCmd,
 		initCmd,
+		inspectCmd,
 		installCmd,
 		keysCmd,
+		lsCmd,
 		newCmd,
 		plopCmd,
 		refsCmd,
@@ -65,6 +72,7 @@ func main() {
 		runDappCmd,
 		serveCmd,
 		testCmd,
+		testnetCmd,
 	}
 
 	run(app)
@@ -80,6 +88,9 @@ func before(c *cli.Context) error {
 	if c.GlobalIsSet("compiler") {
 		epm.SetCompilerServer(c.GlobalString("compiler"))
 	}
+	if token := c.GlobalString("compiler-token"); token != "" {
+		epm.SetCompilerToken(token)
+	}
 
 	return nil
 }
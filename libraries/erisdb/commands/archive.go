@@ -0,0 +1,322 @@
+package commands
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eris-ltd/epm-go/chains"
+	"github.com/eris-ltd/epm-go/utils"
+)
+
+// A chain archive is a gzipped tarball with the manifest first, followed
+// by the files of the chain root under ArchiveChainDir.
+var (
+	ArchiveManifestFile = "manifest.json"
+	ArchiveChainDir     = "chain"
+)
+
+type ArchiveManifest struct {
+	ChainType string `json:"chain_type"`
+	ChainId   string `json:"chain_id"`
+	// The ref the chain was exported by, if any. It's used on import
+	// when no name is given.
+	Ref string `json:"ref,omitempty"`
+	// Hash of the chain files (see contentHash).
+	ContentHash string    `json:"content_hash"`
+	Created     time.Time `json:"created"`
+}
+
+// export a chain root (with its genesis, config, abis, vars and blocks)
+func Export(c *Context) {
+	args := c.Args()
+	if len(args) == 0 {
+		exit(fmt.Errorf(`Please specify the chain to export, eg. "epm export mychain -o chain.tar.gz"`))
+	}
+	ref := args[0]
+	root, typ, id, err := resolveRoot(ref, false, c.String("multi"))
+	ifExit(err)
+	if _, err := os.Stat(root); err != nil {
+		exit(fmt.Errorf("Chain root %s does not exist", root))
+	}
+
+	out := c.String("output")
+	if out == "" {
+		out = id + ".tar.gz"
+	}
+
+	hash, err := contentHash(root)
+	ifExit(err)
+	m := &ArchiveManifest{
+		ChainType:   typ,
+		ChainId:     id,
+		ContentHash: hash,
+		Created:     time.Now(),
+	}
+	if _, _, err := chains.SplitRef(ref); err != nil {
+		m.Ref = ref
+	}
+	ifExit(writeArchive(out, root, m))
+	logger.Warnf("Exported chain %s/%s to %s\n", typ, id, out)
+}
+
+// import a chain from an archive and install it in the blockchains tree
+func Import(c *Context) {
+	args := c.Args()
+	if len(args) == 0 {
+		exit(fmt.Errorf(`Please specify the archive to import, eg. "epm import chain.tar.gz"`))
+	}
+
+	r := make([]byte, 8)
+	rand.Read(r)
+	tmpRoot := path.Join(utils.Scratch, "epm", hex.EncodeToString(r))
+
+	// ifExit doesn't run deferred calls, so clean up before checking the error
+	chainType, chainId, m, err := installArchive(args[0], tmpRoot, c.String("multi"))
+	os.RemoveAll(tmpRoot)
+	ifExit(err)
+	logger.Warnf("Imported chain %s/%s\n", chainType, chainId)
+
+	if c.Bool("checkout") {
+		ifExit(chains.ChangeHead(chainType, chainId))
+		logger.Warnf("Checked out chain: %s/%s", chainType, chainId)
+	}
+
+	name := c.String("name")
+	if name == "" && c.String("force-name") == "" && m.Ref != "" {
+		if _, _, err := chains.ChainFromName(m.Ref); err != nil {
+			name = m.Ref
+		} else {
+			logger.Warnf("Ref %s is taken, not adding a ref for the chain\n", m.Ref)
+		}
+	}
+	updateRefs(chainType, chainId, c.String("force-name"), name)
+}
+
+// Extract an archive into tmpRoot, check it and move the chain into the
+// blockchains tree.
+func installArchive(file, tmpRoot, multi string) (string, string, *ArchiveManifest, error) {
+	m, err := extractArchive(file, tmpRoot)
+	if err != nil {
+		return "", "", nil, err
+	}
+	chainType, err := chains.ResolveChainType(m.ChainType)
+	if err != nil {
+		return "", "", nil, err
+	}
+	chainRoot := path.Join(tmpRoot, ArchiveChainDir)
+
+	hash, err := contentHash(chainRoot)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if hash != m.ContentHash {
+		return "", "", nil, fmt.Errorf("Archive is corrupt: content hash is %s, manifest says %s", hash, m.ContentHash)
+	}
+
+	// load the chain to make sure it is the one the manifest says it is
+	chainId, err := ChainIdFromRoot(chainType, chainRoot)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if chainId != m.ChainId {
+		return "", "", nil, fmt.Errorf("Chain id mismatch: the chain in the archive is %s, manifest says %s", chainId, m.ChainId)
+	}
+
+	home := chains.ComposeRootMulti(chainType, chainId, multi)
+	if _, err := os.Stat(home); err == nil {
+		return "", "", nil, fmt.Errorf("Chain %s/%s is already installed at %s (use -multi to import another copy)", chainType, chainId, home)
+	}
+	if err := os.MkdirAll(path.Dir(home), 0700); err != nil {
+		return "", "", nil, err
+	}
+	if err := os.Rename(chainRoot, home); err != nil {
+		return "", "", nil, err
+	}
+	return chainType, chainId, m, SetChainRoot(chainType, home)
+}
+
+// Read the manifest of an archive (without extracting it).
+func ReadArchiveManifest(file string) (*ArchiveManifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != ArchiveManifestFile {
+		return nil, fmt.Errorf("%s is not a chain archive (no manifest)", file)
+	}
+	m := &ArchiveManifest{}
+	if err := json.NewDecoder(tr).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Files in a chain root that are not archived (they only make sense on
+// the machine they were made on).
+func skipArchive(name string) bool {
+	return name == "pid" || strings.HasSuffix(name, ".pid") || strings.HasSuffix(name, ".lock")
+}
+
+// Walk the regular files under root in lexical order, with paths relative
+// to root.
+func walkChainFiles(root string, fn func(rel string, fi os.FileInfo) error) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || skipArchive(fi.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), fi)
+	})
+}
+
+// The content hash of a chain root: the sha256 of the list of its files,
+// each with its path and the sha256 of its contents.
+func contentHash(root string) (string, error) {
+	h := sha256.New()
+	err := walkChainFiles(root, func(rel string, fi os.FileInfo) error {
+		f, err := os.Open(filepath.Join(root, rel))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fh := sha256.New()
+		if _, err := io.Copy(fh, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%x\n", rel, fh.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeArchive(out, root string, m *ArchiveManifest) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	mb, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: ArchiveManifestFile, Mode: 0644, Size: int64(len(mb)), ModTime: m.Created}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(mb); err != nil {
+		return err
+	}
+
+	err = walkChainFiles(root, func(rel string, fi os.FileInfo) error {
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(ArchiveChainDir, rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		cf, err := os.Open(filepath.Join(root, rel))
+		if err != nil {
+			return err
+		}
+		defer cf.Close()
+		_, err = io.Copy(tw, cf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Extract an archive into dir. Returns the manifest.
+func extractArchive(file, dir string) (*ArchiveManifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+
+	var m *ArchiveManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Name == ArchiveManifestFile {
+			m = &ArchiveManifest{}
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if !strings.HasPrefix(name, ArchiveChainDir+"/") {
+			return nil, fmt.Errorf("Unexpected file in archive: %s", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%s is not a chain archive (no manifest)", file)
+	}
+	return m, nil
+}
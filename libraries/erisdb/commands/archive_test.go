@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epm-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := path.Join(dir, "root")
+	files := map[string]string{
+		"config.json":        `{"chain_id": "abc"}`,
+		"genesis.json":       `{}`,
+		"epm.vars":           "a:1\n",
+		"abi/1234":           "[]",
+		"database/000001.db": "blocks",
+	}
+	for name, data := range files {
+		os.MkdirAll(path.Dir(path.Join(root, name)), 0700)
+		if err := ioutil.WriteFile(path.Join(root, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not archived
+	ioutil.WriteFile(path.Join(root, "pid"), []byte("123"), 0600)
+
+	hash, err := contentHash(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &ArchiveManifest{ChainType: "thelonious", ChainId: "abc", Ref: "mychain", ContentHash: hash, Created: time.Now()}
+	archive := path.Join(dir, "chain.tar.gz")
+	if err := writeArchive(archive, root, m); err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := ReadArchiveManifest(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.ChainId != "abc" || m2.Ref != "mychain" || m2.ContentHash != hash {
+		t.Fatalf("bad manifest: %v", m2)
+	}
+
+	out := path.Join(dir, "out")
+	if _, err := extractArchive(archive, out); err != nil {
+		t.Fatal(err)
+	}
+	hash2, err := contentHash(path.Join(out, ArchiveChainDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash2 != hash {
+		t.Fatalf("content hash changed: %s != %s", hash2, hash)
+	}
+	if _, err := os.Stat(path.Join(out, ArchiveChainDir, "pid")); err == nil {
+		t.Fatal("pid file was archived")
+	}
+
+	// any change shows up in the hash
+	ioutil.WriteFile(path.Join(out, ArchiveChainDir, "abi", "1234"), []byte("[{}]"), 0600)
+	if hash3, _ := contentHash(path.Join(out, ArchiveChainDir)); hash3 == hash {
+		t.Fatal("content hash did not change")
+	}
+}
@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/epm-go/chains"
+	"github.com/eris-ltd/epm-go/utils"
+)
+
+func TestInventoryAndCollectable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epm-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockchains, head, refs, workspaces := utils.Blockchains, utils.HEAD, utils.Refs, utils.Workspaces
+	defer func() {
+		utils.Blockchains, utils.HEAD, utils.Refs, utils.Workspaces = blockchains, head, refs, workspaces
+		os.RemoveAll(dir)
+	}()
+	utils.Blockchains = path.Join(dir, "blockchains")
+	utils.HEAD = path.Join(utils.Blockchains, "HEAD")
+	utils.Refs = path.Join(utils.Blockchains, "refs")
+	utils.Workspaces = path.Join(utils.Blockchains, "workspaces")
+
+	// aaaa is checked out, bbbb has a ref (and a copy), cccc has neither,
+	// dddd is checked out in a workspace somewhere else
+	roots := []string{
+		chains.ComposeRoot("thelonious", "aaaa"),
+		chains.ComposeRoot("thelonious", "bbbb"),
+		chains.ComposeRootMulti("thelonious", "bbbb", "copy"),
+		chains.ComposeRoot("thelonious", "cccc"),
+		chains.ComposeRoot("thelonious", "dddd"),
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for _, root := range roots {
+		os.MkdirAll(root, 0700)
+		ioutil.WriteFile(path.Join(root, "genesis.json"), []byte("{}"), 0600)
+		os.Chtimes(path.Join(root, "genesis.json"), old, old)
+		os.Chtimes(root, old, old)
+	}
+	os.MkdirAll(path.Join(utils.Blockchains, "thelonious", "rpc"), 0700)
+	os.MkdirAll(utils.Refs, 0700)
+	ioutil.WriteFile(path.Join(utils.Refs, "mychain"), []byte("thelonious/bbbb"), 0600)
+	ioutil.WriteFile(utils.HEAD, []byte("thelonious/aaaa\n"), 0600)
+	os.MkdirAll(path.Join(dir, "project"), 0700)
+	if _, err := chains.ChangeLocalHead(path.Join(dir, "project"), "thelonious", "dddd"); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := Inventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != len(roots) {
+		t.Fatalf("expected %d chains, got %d", len(roots), len(infos))
+	}
+	for _, info := range infos {
+		if info.Size != 2 {
+			t.Fatalf("bad size for %s: %d", info.Root, info.Size)
+		}
+		switch {
+		case info.Id == "aaaa" && info.Head != "global":
+			t.Fatal("aaaa should be the HEAD")
+		case info.Id == "dddd" && info.Head != "workspace":
+			t.Fatal("dddd should be checked out in a workspace")
+		case info.Id == "bbbb" && info.Multi == chains.DefaultRefUnderId && (len(info.Refs) != 1 || info.Refs[0] != "mychain"):
+			t.Fatalf("bad refs for bbbb: %v", info.Refs)
+		}
+	}
+
+	garbage := collectable(infos, time.Now().Add(-24*time.Hour))
+	if len(garbage) != 1 || garbage[0].Id != "cccc" {
+		t.Fatalf("expected only cccc to be collectable, got %v", garbage)
+	}
+	if garbage := collectable(infos, time.Now().Add(-72*time.Hour)); len(garbage) != 0 {
+		t.Fatalf("recently used chains should not be collectable, got %v", garbage)
+	}
+}
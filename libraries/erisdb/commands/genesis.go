@@ -0,0 +1,488 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eris-ltd/epm-go/chains"
+	"github.com/eris-ltd/epm-go/epm"
+	"github.com/eris-ltd/epm-go/utils"
+
+	//epm-binary-generator:IMPORT
+	mod "github.com/eris-ltd/epm-go/commands/modules/thelonious"
+)
+
+// Genesis templates are kept in utils.Blockchains/<type>/templates as
+// <name>.json
+var GenesisTemplatesDir = "templates"
+
+func GenesisTemplatesPath(chainType string) string {
+	return path.Join(utils.Blockchains, chainType, GenesisTemplatesDir)
+}
+
+// The names of the genesis templates for a chain type.
+func GenesisTemplates(chainType string) []string {
+	fs, _ := ioutil.ReadDir(GenesisTemplatesPath(chainType))
+	names := []string{}
+	for _, f := range fs {
+		if !f.IsDir() && path.Ext(f.Name()) == ".json" {
+			names = append(names, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The file of a named genesis template.
+func GenesisTemplate(chainType, name string) (string, error) {
+	file := path.Join(GenesisTemplatesPath(chainType), name+".json")
+	if _, err := os.Stat(file); err != nil {
+		return "", fmt.Errorf("Unknown %s genesis template %s. Have: %s", chainType, name, strings.Join(GenesisTemplates(chainType), ", "))
+	}
+	return file, nil
+}
+
+// Read a genesis, which is either a file, the name of a template, or a
+// chain ref (for the chain's genesis). An empty source is the default
+// genesis set by `epm init` (or the module's, if there isn't one).
+func ReadGenesis(chainType, src string) ([]byte, error) {
+	if src == "" {
+		b, err := ioutil.ReadFile(path.Join(utils.Blockchains, chainType, "genesis.json"))
+		if err != nil {
+			b = mod.DefaultGenesis()
+		}
+		if b == nil {
+			return nil, fmt.Errorf("There is no genesis for %s chains", chainType)
+		}
+		return b, nil
+	}
+	if _, err := os.Stat(src); err == nil {
+		return ioutil.ReadFile(src)
+	}
+	if file, err := GenesisTemplate(chainType, src); err == nil {
+		return ioutil.ReadFile(file)
+	}
+	if typ, id, err := chains.ResolveChain(src); err == nil {
+		return ioutil.ReadFile(path.Join(chains.ComposeRoot(typ, id), "genesis.json"))
+	}
+	return nil, fmt.Errorf("Could not find genesis %s. It is not a file, %s template or chain", src, chainType)
+}
+
+// An override of a genesis or config value (epm new --set key=value).
+// Keys are paths into the json, like accounts.0.balance, and may start
+// with genesis. or config. to pick the file. Otherwise the genesis is
+// used if it has the (first part of the) key, and the config if not.
+type Override struct {
+	File  string
+	Path  []string
+	Value string
+}
+
+func (o *Override) Key() string {
+	return strings.Join(o.Path, ".")
+}
+
+func ParseOverrides(sets []string) ([]*Override, error) {
+	overrides := []*Override{}
+	for _, s := range sets {
+		sp := strings.SplitN(s, "=", 2)
+		if len(sp) != 2 || sp[0] == "" {
+			return nil, fmt.Errorf("Invalid override %q. Expected key=value", s)
+		}
+		o := &Override{Path: strings.Split(sp[0], "."), Value: sp[1]}
+		if o.Path[0] == "genesis" || o.Path[0] == "config" {
+			o.File, o.Path = o.Path[0], o.Path[1:]
+		}
+		if len(o.Path) == 0 {
+			return nil, fmt.Errorf("Invalid override %q. Expected key=value", s)
+		}
+		if o.File == "config" && len(o.Path) > 1 {
+			return nil, fmt.Errorf("Invalid override %q. Config keys are not nested", s)
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+// Split overrides between a genesis and a config.
+func splitOverrides(gen, conf []byte, overrides []*Override) (genesis, config []*Override, err error) {
+	genDoc, err := decodeJSON(gen)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid genesis: %v", err)
+	}
+	confDoc, err := decodeJSON(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid config: %v", err)
+	}
+	genKeys, _ := genDoc.(map[string]interface{})
+	confKeys, _ := confDoc.(map[string]interface{})
+	for _, o := range overrides {
+		file := o.File
+		if file == "" {
+			if _, ok := genKeys[o.Path[0]]; ok {
+				file = "genesis"
+			} else if _, ok := confKeys[o.Path[0]]; ok && len(o.Path) == 1 {
+				file = "config"
+			} else {
+				return nil, nil, fmt.Errorf("Unknown key %s. It is not in the genesis or config", o.Key())
+			}
+		}
+		if file == "genesis" {
+			genesis = append(genesis, o)
+		} else {
+			config = append(config, o)
+		}
+	}
+	return
+}
+
+// Parse overrides that must all be for the genesis.
+func genesisOverrides(gen []byte, sets []string) ([]*Override, error) {
+	overrides, err := ParseOverrides(sets)
+	if err != nil {
+		return nil, err
+	}
+	genesis, config, err := splitOverrides(gen, []byte("{}"), overrides)
+	if err != nil {
+		return nil, err
+	}
+	if len(config) > 0 {
+		return nil, fmt.Errorf("%s is a config key, not a genesis key", config[0].Key())
+	}
+	return genesis, nil
+}
+
+// Apply overrides to a genesis, and make sure the result is valid.
+func RenderGenesis(gen []byte, overrides []*Override) ([]byte, error) {
+	if len(overrides) > 0 {
+		doc, err := decodeJSON(gen)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid genesis: %v", err)
+		}
+		for _, o := range overrides {
+			if o.File == "config" {
+				return nil, fmt.Errorf("%s is a config key, not a genesis key", o.Key())
+			}
+			if doc, err = setJSONPath(doc, o.Path, o.Value); err != nil {
+				return nil, fmt.Errorf("Invalid value for %s: %v", o.Key(), err)
+			}
+		}
+		if gen, err = json.MarshalIndent(doc, "", "\t"); err != nil {
+			return nil, err
+		}
+	}
+	if err := mod.ValidateGenesis(gen); err != nil {
+		return nil, err
+	}
+	return gen, nil
+}
+
+// Apply overrides to a config file, checked against the chain's config
+// schema.
+func applyConfigOverrides(chain epm.Blockchain, configFile string, overrides []*Override) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+	if err := chain.ReadConfig(configFile); err != nil {
+		return err
+	}
+	schema := mod.ConfigSchema(false)
+	for _, o := range overrides {
+		if _, _, err := schema.Parse(o.Path[0], o.Value); err != nil {
+			return err
+		}
+		if err := chain.SetProperty(o.Path[0], o.Value); err != nil {
+			return fmt.Errorf("Invalid value for config %s: %v", o.Key(), err)
+		}
+	}
+	return chain.WriteConfig(configFile)
+}
+
+// Decode json, keeping numbers as they are written.
+func decodeJSON(b []byte) (interface{}, error) {
+	var v, extra interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if err := dec.Decode(&extra); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the json")
+	}
+	return v, nil
+}
+
+// Set the value at a path in a json document. The value must fit what is
+// there: numbers for numbers, true or false for bools and json for objects
+// and lists. New keys and list elements (at the end) may be added.
+func setJSONPath(doc interface{}, keys []string, value string) (interface{}, error) {
+	if len(keys) == 0 {
+		return parseJSONValue(doc, value)
+	}
+	switch d := doc.(type) {
+	case nil:
+		return setJSONPath(make(map[string]interface{}), keys, value)
+	case map[string]interface{}:
+		v, err := setJSONPath(d[keys[0]], keys[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		d[keys[0]] = v
+		return d, nil
+	case []interface{}:
+		i, err := strconv.Atoi(keys[0])
+		if err != nil || i < 0 || i > len(d) {
+			return nil, fmt.Errorf("%s is not an index of the list (it has %d elements)", keys[0], len(d))
+		}
+		if i == len(d) {
+			d = append(d, nil)
+		}
+		if d[i], err = setJSONPath(d[i], keys[1:], value); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("%s can't be set inside a %s", keys[0], jsonKind(doc))
+}
+
+func parseJSONValue(old interface{}, value string) (interface{}, error) {
+	if _, ok := old.(string); ok {
+		return value, nil
+	}
+	v, err := decodeJSON([]byte(value))
+	if err != nil {
+		if old == nil {
+			// a new key
+			return value, nil
+		}
+		return nil, fmt.Errorf("expected a %s, got %q", jsonKind(old), value)
+	}
+	if old != nil && v != nil && jsonKind(v) != jsonKind(old) {
+		return nil, fmt.Errorf("expected a %s, got a %s", jsonKind(old), jsonKind(v))
+	}
+	return v, nil
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "list"
+	}
+	return "object"
+}
+
+// Compare two json documents, returning a line for every value that was
+// removed (-) or added (+), by path.
+func DiffJSON(a, b []byte) ([]string, error) {
+	docA, err := decodeJSON(a)
+	if err != nil {
+		return nil, err
+	}
+	docB, err := decodeJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	diffJSON("", docA, docB, &lines)
+	return lines, nil
+}
+
+func diffJSON(p string, a, b interface{}, lines *[]string) {
+	join := func(k string) string {
+		if p == "" {
+			return k
+		}
+		return p + "." + k
+	}
+	mA, okA := a.(map[string]interface{})
+	mB, okB := b.(map[string]interface{})
+	if okA && okB {
+		keys := []string{}
+		for k := range mA {
+			keys = append(keys, k)
+		}
+		for k := range mB {
+			if _, ok := mA[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			vA, inA := mA[k]
+			vB, inB := mB[k]
+			switch {
+			case !inA:
+				*lines = append(*lines, "+ "+join(k)+": "+compactJSON(vB))
+			case !inB:
+				*lines = append(*lines, "- "+join(k)+": "+compactJSON(vA))
+			default:
+				diffJSON(join(k), vA, vB, lines)
+			}
+		}
+		return
+	}
+	lA, okA := a.([]interface{})
+	lB, okB := b.([]interface{})
+	if okA && okB {
+		for i := 0; i < len(lA) || i < len(lB); i++ {
+			k := join(strconv.Itoa(i))
+			switch {
+			case i >= len(lA):
+				*lines = append(*lines, "+ "+k+": "+compactJSON(lB[i]))
+			case i >= len(lB):
+				*lines = append(*lines, "- "+k+": "+compactJSON(lA[i]))
+			default:
+				diffJSON(k, lA[i], lB[i], lines)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*lines = append(*lines, "- "+p+": "+compactJSON(a), "+ "+p+": "+compactJSON(b))
+	}
+}
+
+func compactJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// The chain type for genesis commands: --type if given, otherwise the
+// type of the chain (--chain or the head), otherwise the default type.
+func GenesisChainType(c *Context) (string, error) {
+	if !c.IsSet("type") {
+		if _, chainType, _, err := ResolveRootFlag(c); err == nil {
+			return chainType, nil
+		}
+	}
+	return chains.ResolveChainType(c.String("type"))
+}
+
+// print a genesis (the default, a file, template or chain) with
+// overrides applied, or write it to a file
+func GenesisRender(c *Context) {
+	chainType, err := GenesisChainType(c)
+	ifExit(err)
+	src := ""
+	if len(c.Args()) > 0 {
+		src = c.Args()[0]
+	}
+	gen, err := ReadGenesis(chainType, src)
+	ifExit(err)
+	overrides, err := genesisOverrides(gen, c.StringSlice("set"))
+	ifExit(err)
+	gen, err = RenderGenesis(gen, overrides)
+	ifExit(err)
+
+	if out := c.String("output"); out != "" {
+		ifExit(ioutil.WriteFile(out, gen, 0600))
+		logger.Warnf("Wrote %s genesis to %s\n", chainType, out)
+		exit(nil)
+	}
+	fmt.Println(string(gen))
+}
+
+// check a genesis (a file, template or chain, or the chain's)
+func GenesisValidate(c *Context) {
+	chainType, err := GenesisChainType(c)
+	ifExit(err)
+	var gen []byte
+	src := ""
+	if len(c.Args()) > 0 {
+		src = c.Args()[0]
+		gen, err = ReadGenesis(chainType, src)
+	} else {
+		var root string
+		root, _, _, err = ResolveRootFlag(c)
+		ifExit(err)
+		src = path.Join(root, "genesis.json")
+		gen, err = ioutil.ReadFile(src)
+	}
+	ifExit(err)
+	if err := mod.ValidateGenesis(gen); err != nil {
+		exit(fmt.Errorf("%s: %v", src, err))
+	}
+	fmt.Printf("%s is a valid %s genesis\n", src, chainType)
+}
+
+// compare two genesis (files, templates or chains), or one with the
+// default genesis
+func GenesisDiff(c *Context) {
+	chainType, err := GenesisChainType(c)
+	ifExit(err)
+	args := c.Args()
+	if len(args) < 1 || len(args) > 2 {
+		exit(fmt.Errorf("Specify one or two genesis (files, templates or chains) to compare"))
+	}
+	srcs := []string{"", args[0]}
+	if len(args) == 2 {
+		srcs = args
+	}
+	a, err := ReadGenesis(chainType, srcs[0])
+	ifExit(err)
+	b, err := ReadGenesis(chainType, srcs[1])
+	ifExit(err)
+	lines, err := DiffJSON(a, b)
+	ifExit(err)
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+}
+
+// list the genesis templates
+func GenesisTemplatesList(c *Context) {
+	chainType, err := GenesisChainType(c)
+	ifExit(err)
+	for _, name := range GenesisTemplates(chainType) {
+		fmt.Println(name)
+	}
+}
+
+// render a genesis and save it as a named template
+func GenesisSave(c *Context) {
+	args := c.Args()
+	if len(args) < 1 || len(args) > 2 {
+		exit(fmt.Errorf("Specify the template name, and optionally the genesis to save (a file, template or chain)"))
+	}
+	name := args[0]
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		exit(fmt.Errorf("Invalid template name %s", name))
+	}
+	chainType, err := GenesisChainType(c)
+	ifExit(err)
+	src := ""
+	if len(args) > 1 {
+		src = args[1]
+	}
+	gen, err := ReadGenesis(chainType, src)
+	ifExit(err)
+	overrides, err := genesisOverrides(gen, c.StringSlice("set"))
+	ifExit(err)
+	gen, err = RenderGenesis(gen, overrides)
+	ifExit(err)
+
+	file := path.Join(GenesisTemplatesPath(chainType), name+".json")
+	if _, err := os.Stat(file); err == nil && !c.Bool("force") {
+		exit(fmt.Errorf("Template %s already exists. Use --force to replace it", name))
+	}
+	ifExit(utils.InitDataDir(GenesisTemplatesPath(chainType)))
+	ifExit(ioutil.WriteFile(file, gen, 0600))
+	logger.Warnf("Saved %s genesis template %s\n", chainType, name)
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenesisOverrides(t *testing.T) {
+	gen := []byte(`{
+	"address": "0000000000THISISDOUG",
+	"no-gendoug": true,
+	"difficulty": 15,
+	"accounts": [
+		{"address": "0xbbbd0256041f7aed3ce278c56ee61492de96d001", "balance": "100"}
+	]
+}`)
+	conf := []byte(`{"rpc_port": 30304, "mining": false}`)
+
+	overrides, err := ParseOverrides([]string{
+		"difficulty=20",
+		"accounts.0.balance=5",
+		`accounts.1={"address": "0x26e9497c94d52f898efc5c107567ab4f50aad551", "balance": "7"}`,
+		"rpc_port=8080",
+		"config.mining=true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genOverrides, confOverrides, err := splitOverrides(gen, conf, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(genOverrides) != 3 || len(confOverrides) != 2 {
+		t.Fatalf("expected 3 genesis and 2 config overrides, got %d and %d", len(genOverrides), len(confOverrides))
+	}
+
+	rendered, err := RenderGenesis(gen, genOverrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g struct {
+		Difficulty int
+		Accounts   []struct{ Address, Balance string }
+	}
+	if err := json.Unmarshal(rendered, &g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Difficulty != 20 || len(g.Accounts) != 2 || g.Accounts[0].Balance != "5" || g.Accounts[1].Balance != "7" {
+		t.Fatalf("overrides not applied: %s", rendered)
+	}
+
+	lines, err := DiffJSON(gen, rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`- accounts.0.balance: "100"`,
+		`+ accounts.0.balance: "5"`,
+		`+ accounts.1: {"address":"0x26e9497c94d52f898efc5c107567ab4f50aad551","balance":"7"}`,
+		`- difficulty: 15`,
+		`+ difficulty: 20`,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("got diff\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// values that don't fit, and keys nobody has
+	for _, set := range []string{"difficulty=lots", "accounts.5.balance=1", "no-gendoug=maybe", "colour=blue"} {
+		overrides, err := ParseOverrides([]string{set})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if genOverrides, _, err = splitOverrides(gen, conf, overrides); err == nil {
+			_, err = RenderGenesis(gen, genOverrides)
+		}
+		if err == nil {
+			t.Fatalf("expected %s to fail", set)
+		}
+	}
+
+	// the genesis is validated
+	overrides, _ = ParseOverrides([]string{"accounts.0.address=0xabc"})
+	if _, err := RenderGenesis(gen, overrides); err == nil {
+		t.Fatal("expected an invalid address to fail")
+	}
+}
@@ -3,8 +3,11 @@ package commands
 import (
 	"fmt"
 	"github.com/eris-ltd/epm-go/epm"
+	"github.com/eris-ltd/epm-go/utils"
 	"log"
 
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/go-ethereum/vm"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/lllc-server"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/modules/eth"
 )
 
@@ -26,7 +29,60 @@ func ChainSpecificDeploy(chain epm.Blockchain, deployGen, root string, novi bool
 	return nil
 }
 
+// There are no genesis files for eth
+func DefaultGenesis() []byte {
+	return nil
+}
+
+func ValidateGenesis(b []byte) error {
+	return fmt.Errorf("Genesis files not supported for eth")
+}
+
+// The keys of an ethereum config.json. Keys not described here take a
+// restart and have no description.
+var configKeys = []*utils.ConfigKey{
+	{Key: "port", Description: "port to listen for peers on", Restart: true, Min: 1, Max: 65535},
+	{Key: "mining", Description: "mine blocks", Restart: true},
+	{Key: "max_peers", Description: "most peers to connect to", Restart: true, Min: 0, Max: 1000},
+	{Key: "config_file", Description: "name of the config file", Restart: true, Managed: true},
+	{Key: "root_dir", Description: "directory of the chain", Restart: true, Managed: true},
+	{Key: "log_file", Description: "file to log to (stdout if empty)", Restart: true},
+	{Key: "db_name", Description: "name of the database in the root", Restart: true},
+	{Key: "contract_path", Description: "where epm deploy finds contracts", Restart: false},
+	{Key: "client", Description: "client name sent to peers", Restart: true},
+	{Key: "version", Description: "client version sent to peers", Restart: true},
+	{Key: "id", Description: "node id sent to peers", Restart: true},
+	{Key: "key_session", Description: "name of the key session", Restart: true},
+	{Key: "key_store", Description: "where keys are kept", Restart: true, Values: []string{"file", "db"}},
+	{Key: "key_cursor", Description: "index of the key to use", Restart: true, Min: 0, Max: 1 << 20},
+	{Key: "key_file", Description: "key file to import on start", Restart: true},
+	{Key: "difficulty", Description: "mining difficulty", Restart: true},
+	{Key: "log_level", Description: "log level (0 silent to 5 debug detail)", Restart: true, Min: 0, Max: 5},
+	{Key: "use_seed", Description: "connect to the seed on start", Restart: true},
+	{Key: "seed_address", Description: "host:port of the seed", Restart: true},
+	{Key: "adversary", Description: "misbehave, for testing", Restart: true, Min: 0, Max: 10},
+}
+
+// The schema of the config.json of a chain. There is no rpc client.
+func ConfigSchema(rpc bool) utils.ConfigSchema {
+	return utils.NewConfigSchema(eth.DefaultConfig, configKeys)
+}
+
 // This is invalid
 func Fetch(chainType, peerserver string) ([]byte, error) {
 	return nil, fmt.Errorf("Fetch not defined for eth")
 }
+
+// Testnets are not supported for eth
+func TestnetGenesis(deployGen, out string, n int) ([]string, error) {
+	return nil, fmt.Errorf("Testnets not supported for eth")
+}
+
+func TestnetNode(chain epm.Blockchain, root, name, key string) error {
+	return fmt.Errorf("Testnets not supported for eth")
+}
+
+// Opcode names from the ethereum vm, for disassembling
+func VMOpCodes() lllcserver.OpCodes {
+	return lllcserver.VMOpCodes(func(op byte) string { return vm.OpCode(op).String() })
+}
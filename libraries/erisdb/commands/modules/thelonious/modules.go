@@ -1,22 +1,29 @@
 package commands
 
 import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/eris-ltd/epm-go/chains"
 	"github.com/eris-ltd/epm-go/epm"
 	"github.com/eris-ltd/epm-go/utils"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"os"
 	"path"
 	"strings"
 
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/lllc-server"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/modules/monkrpc"
 	mutils "github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/modules/monkutils"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/thelonious"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/thelonious/monk"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/thelonious/monkcrypto"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/thelonious/monkdoug"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/thelonious/monkutil"
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/thelonious/monkvm"
 )
 
 func NewChain(chainType string, rpc bool) epm.Blockchain {
@@ -54,8 +61,9 @@ func copyEditGenesisConfig(deployGen, tmpRoot string, novi bool) (string, error)
 		deployGen = path.Join(utils.Blockchains, "thelonious", "genesis.json")
 	}
 	if _, err := os.Stat(deployGen); err != nil {
-		err := utils.WriteJson(monkdoug.DefaultGenesis, deployGen)
-		return "", err
+		if err := utils.WriteJson(monkdoug.DefaultGenesis, deployGen); err != nil {
+			return "", err
+		}
 	}
 	if err := utils.Copy(deployGen, tempGen); err != nil {
 		return "", err
@@ -65,6 +73,13 @@ func copyEditGenesisConfig(deployGen, tmpRoot string, novi bool) (string, error)
 			return "", err
 		}
 	}
+	b, err := ioutil.ReadFile(tempGen)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateGenesis(b); err != nil {
+		return "", err
+	}
 	return tempGen, nil
 }
 
@@ -78,6 +93,145 @@ func ChainSpecificDeploy(chain epm.Blockchain, deployGen, root string, novi bool
 	return nil
 }
 
+// The genesis used when none is given
+func DefaultGenesis() []byte {
+	b, _ := json.MarshalIndent(monkdoug.DefaultGenesis, "", "\t")
+	return b
+}
+
+// Gendoug access models (see monkdoug.NewPermModel)
+var GenesisModels = []string{"std", "vm", "yes", "no", "eth"}
+
+// Check a genesis.json is a sound monkdoug.GenesisConfig. Unknown fields
+// are errors, since they are usually typos.
+func ValidateGenesis(b []byte) error {
+	g := new(monkdoug.GenesisConfig)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(g); err != nil {
+		return fmt.Errorf("Invalid genesis: %v", err)
+	}
+
+	if g.Address == "" || len(g.Address) > 20 {
+		return fmt.Errorf("Invalid genesis: address must be 1 to 20 characters")
+	}
+	if g.ModelName != "" && !oneOf(g.ModelName, GenesisModels) {
+		return fmt.Errorf("Invalid genesis: unknown model %s (expected one of %s)", g.ModelName, strings.Join(GenesisModels, ", "))
+	}
+	if !g.NoGenDoug && g.DougPath == "" {
+		return fmt.Errorf("Invalid genesis: doug is required unless no-gendoug is set")
+	}
+	if !g.NoGenDoug && g.ModelName == "vm" && g.Vm == nil {
+		return fmt.Errorf("Invalid genesis: the vm model requires vm")
+	}
+	for name, v := range map[string]int{"difficulty": g.Difficulty, "tapow": g.TaPoW, "blocktime": g.BlockTime} {
+		if v < 0 {
+			return fmt.Errorf("Invalid genesis: %s must not be negative", name)
+		}
+	}
+	if g.MaxGasTx != "" && !isNatural(g.MaxGasTx) {
+		return fmt.Errorf("Invalid genesis: maxgastx must be a positive integer")
+	}
+
+	seen := make(map[string]bool)
+	for i, acc := range g.Accounts {
+		addr := strings.ToLower(strings.TrimPrefix(acc.Address, "0x"))
+		if a, err := hex.DecodeString(addr); err != nil || len(a) != 20 {
+			return fmt.Errorf("Invalid genesis: account %d has an invalid address %q", i, acc.Address)
+		}
+		if seen[addr] {
+			return fmt.Errorf("Invalid genesis: account %s is listed twice", acc.Address)
+		}
+		seen[addr] = true
+		if acc.Balance != "" && !isNatural(acc.Balance) {
+			return fmt.Errorf("Invalid genesis: account %s has an invalid balance %q", acc.Address, acc.Balance)
+		}
+		if acc.Stake < 0 {
+			return fmt.Errorf("Invalid genesis: account %s has a negative stake", acc.Address)
+		}
+	}
+	return nil
+}
+
+func oneOf(s string, list []string) bool {
+	for _, l := range list {
+		if s == l {
+			return true
+		}
+	}
+	return false
+}
+
+// an integer >= 0, in decimal or hex (as monkutil.Big reads them)
+func isNatural(s string) bool {
+	n, ok := new(big.Int).SetString(s, 0)
+	return ok && n.Sign() >= 0
+}
+
+// The keys of a thelonious config.json. Keys not described here take a
+// restart and have no description.
+var configKeys = []*utils.ConfigKey{
+	{Key: "local_host", Description: "host to listen for peers on", Restart: true},
+	{Key: "local_port", Description: "port to listen for peers on", Restart: true, Min: 1, Max: 65535},
+	{Key: "listen", Description: "accept connections from peers", Restart: true},
+	{Key: "remote_host", Description: "host of a peer to connect to on start", Restart: true},
+	{Key: "remote_port", Description: "port of the peer to connect to on start", Restart: true, Min: 1, Max: 65535},
+	{Key: "use_seed", Description: "connect to the remote peer on start", Restart: true},
+	{Key: "rpc_host", Description: "host to serve rpc on", Restart: true},
+	{Key: "rpc_port", Description: "port to serve rpc on", Restart: true, Min: 1, Max: 65535},
+	{Key: "serve_rpc", Description: "serve rpc", Restart: true},
+	{Key: "fetch_port", Description: "port to serve the genesis block on, for epm fetch", Restart: true, Min: 1, Max: 65535},
+	{Key: "chain_id", Description: "id of the chain (its signed genesis block)", Restart: true, Managed: true},
+	{Key: "chain_name", Description: "name of the chain", Restart: true},
+	{Key: "mining", Description: "mine blocks", Restart: true},
+	{Key: "max_peers", Description: "most peers to connect to", Restart: true, Min: 0, Max: 1000},
+	{Key: "client", Description: "client name sent to peers", Restart: true},
+	{Key: "version", Description: "client version sent to peers", Restart: true},
+	{Key: "id", Description: "node id sent to peers", Restart: true},
+	{Key: "key_session", Description: "name of the key session (the key file in the root is <key_session>.prv)", Restart: true},
+	{Key: "key_store", Description: "where keys are kept", Restart: true, Values: []string{"file", "db"}},
+	{Key: "key_cursor", Description: "index of the key to use", Restart: true, Min: 0, Max: 1 << 20},
+	{Key: "key_file", Description: "key file to import on start", Restart: true},
+	{Key: "adversary", Description: "misbehave, for testing", Restart: true, Min: 0, Max: 10},
+	{Key: "use_checkpoint", Description: "sync from the latest checkpoint", Restart: true},
+	{Key: "latest_checkpoint", Description: "hash of the block to sync from", Restart: true},
+	{Key: "config_file", Description: "name of the config file", Restart: true, Managed: true},
+	{Key: "root_dir", Description: "directory of the chain", Restart: true, Managed: true},
+	{Key: "db_name", Description: "name of the database in the root", Restart: true},
+	{Key: "db_mem", Description: "keep the database in memory", Restart: true},
+	{Key: "contract_path", Description: "where epm deploy finds contracts", Restart: false},
+	{Key: "genesis_config", Description: "the genesis.json of the chain", Restart: true, Managed: true},
+	{Key: "log_file", Description: "file to log to (stdout if empty)", Restart: true},
+	{Key: "debug_file", Description: "file to write debug logs to", Restart: true},
+	{Key: "log_level", Description: "log level (0 silent to 5 debug detail)", Restart: true, Min: 0, Max: 5},
+}
+
+// The keys of a thelonious rpc client config.json
+var rpcConfigKeys = []*utils.ConfigKey{
+	{Key: "rpc_host", Description: "host of the rpc server", Restart: false},
+	{Key: "rpc_port", Description: "port of the rpc server", Restart: false, Min: 1, Max: 65535},
+	{Key: "local", Description: "let the rpc server sign transactions with its keys", Restart: false},
+	{Key: "key_session", Description: "name of the key session (when not local)", Restart: false},
+	{Key: "key_store", Description: "where keys are kept (when not local)", Restart: false, Values: []string{"file", "db"}},
+	{Key: "key_cursor", Description: "index of the key to use (when not local)", Restart: false, Min: 0, Max: 1 << 20},
+	{Key: "key_file", Description: "key file to use (when not local)", Restart: false},
+	{Key: "root_dir", Description: "directory of the chain", Restart: false, Managed: true},
+	{Key: "db_name", Description: "name of the database in the root", Restart: false},
+	{Key: "lll_path", Description: "lll compiler to use (NETCALL for the compile server)", Restart: false},
+	{Key: "contract_path", Description: "where epm deploy finds contracts", Restart: false},
+	{Key: "log_file", Description: "file to log to (stdout if empty)", Restart: false},
+	{Key: "debug_file", Description: "file to write debug logs to", Restart: false},
+	{Key: "log_level", Description: "log level (0 silent to 5 debug detail)", Restart: false, Min: 0, Max: 5},
+}
+
+// The schema of the config.json of a chain (or of its rpc client)
+func ConfigSchema(rpc bool) utils.ConfigSchema {
+	if rpc {
+		return utils.NewConfigSchema(monkrpc.DefaultConfig, rpcConfigKeys)
+	}
+	return utils.NewConfigSchema(monk.DefaultConfig, configKeys)
+}
+
 func Fetch(chainType, peerserver string) ([]byte, error) {
 	peerip, _, err := net.SplitHostPort(peerserver)
 	if err != nil {
@@ -143,3 +297,60 @@ func Fetch(chainType, peerserver string) ([]byte, error) {
 
 	return chainId, nil
 }
+
+// Make the genesis for a testnet of n nodes from a template (the default
+// genesis if deployGen is empty), and write it to out. Every node gets an
+// account that may mine. Returns the nodes' keys.
+func TestnetGenesis(deployGen, out string, n int) ([]string, error) {
+	if deployGen == "" {
+		deployGen = path.Join(utils.Blockchains, "thelonious", "genesis.json")
+	}
+	g := new(monkdoug.GenesisConfig)
+	if _, err := os.Stat(deployGen); err == nil {
+		if err := utils.ReadJson(g, deployGen); err != nil {
+			return nil, err
+		}
+	} else {
+		// copy, so the default isn't changed
+		b, err := json.Marshal(monkdoug.DefaultGenesis)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, g); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, n)
+	for i := range keys {
+		kp := monkcrypto.GenerateNewKeyPair()
+		keys[i] = monkutil.Bytes2Hex(kp.PrivateKey)
+		g.Accounts = append(g.Accounts, &monkdoug.Account{
+			Address:     "0x" + monkutil.Bytes2Hex(kp.Address()),
+			Name:        fmt.Sprintf("testnet-%d", i),
+			Balance:     "1000000000000000000000000000000",
+			Permissions: map[string]int{"mine": 1, "transact": 1, "create": 1},
+		})
+	}
+	return keys, utils.WriteJson(g, out)
+}
+
+// Give a testnet node its key, and make it mine.
+func TestnetNode(chain epm.Blockchain, root, name, key string) error {
+	session := "testnet"
+	if err := ioutil.WriteFile(path.Join(root, session+".prv"), []byte(key), 0600); err != nil {
+		return err
+	}
+	chain.SetProperty("KeyStore", "file")
+	chain.SetProperty("KeySession", session)
+	chain.SetProperty("KeyFile", "")
+	chain.SetProperty("ChainName", name)
+	chain.SetProperty("Mining", true)
+	chain.SetProperty("ServeRpc", true)
+	return nil
+}
+
+// Opcode names from the thelonious vm, for disassembling
+func VMOpCodes() lllcserver.OpCodes {
+	return lllcserver.VMOpCodes(func(op byte) string { return monkvm.OpCode(op).String() })
+}
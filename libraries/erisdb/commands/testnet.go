@@ -0,0 +1,495 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eris-ltd/epm-go/chains"
+	"github.com/eris-ltd/epm-go/utils"
+
+	//epm-binary-generator:IMPORT
+	mod "github.com/eris-ltd/epm-go/commands/modules/thelonious"
+)
+
+// A testnet is a set of copies (multis) of one chain that peer with each
+// other on this machine. It is described by a file next to the copies,
+// and its nodes are run by a supervisor process that restarts them when
+// they crash.
+var (
+	TestnetFile           = "testnet.json"
+	TestnetSupervisorPid  = "testnet.pid"
+	TestnetSupervisorLog  = "testnet.log"
+	TestnetNodeLog        = "node.log"
+	TestnetHost           = "127.0.0.1"
+	TestnetPortStride     = 10
+	TestnetMinBackoff     = time.Second
+	TestnetMaxBackoff     = time.Minute
+	TestnetStopTimeout    = 10 * time.Second
+	TestnetFollowInterval = 500 * time.Millisecond
+)
+
+type Testnet struct {
+	ChainType string         `json:"chain_type"`
+	ChainId   string         `json:"chain_id"`
+	Nodes     []*TestnetNode `json:"nodes"`
+	Created   time.Time      `json:"created"`
+}
+
+type TestnetNode struct {
+	// The node's multi
+	Name       string `json:"name"`
+	Root       string `json:"root"`
+	ListenPort int    `json:"listen_port"`
+	RpcPort    int    `json:"rpc_port"`
+	FetchPort  int    `json:"fetch_port"`
+}
+
+func (tn *Testnet) chain() string {
+	return path.Join(tn.ChainType, tn.ChainId)
+}
+
+// The directory of the chain id, which holds the copies.
+func (tn *Testnet) dir() string {
+	return path.Dir(chains.ComposeRoot(tn.ChainType, tn.ChainId))
+}
+
+// create a testnet of n nodes on a new chain and start it (or restart an
+// existing testnet)
+func TestnetUp(c *Context) {
+	if len(c.Args()) > 0 {
+		tn, err := loadTestnet(c.Args()[0])
+		ifExit(err)
+		ifExit(startSupervisor(tn))
+		printTestnet(tn)
+		exit(nil)
+	}
+
+	chainType, err := chains.ResolveChainType(c.String("type"))
+	ifExit(err)
+	n := c.Int("nodes")
+	if n < 1 {
+		exit(fmt.Errorf("A testnet needs at least one node"))
+	}
+
+	r := make([]byte, 8)
+	rand.Read(r)
+	tmpRoot := path.Join(utils.Scratch, "epm", hex.EncodeToString(r))
+	tmpGen := tmpRoot + "-genesis.json"
+	ifExit(os.MkdirAll(path.Dir(tmpGen), 0700))
+	defer os.Remove(tmpGen)
+
+	// one genesis with a key for every node, so they all mine/validate
+	keys, err := mod.TestnetGenesis(c.String("genesis"), tmpGen, n)
+	ifExit(err)
+	chainId := deployInstallChain(tmpRoot, c.String("config"), tmpGen, ".config.json", chainType, nil, false, false, true)
+
+	tn, err := newTestnet(chainType, chainId, keys, c.Int("port"))
+	ifExit(err)
+
+	if c.Bool("checkout") {
+		ifExit(chains.ChangeHead(chainType, chainId))
+		logger.Warnf("Checked out chain: %s/%s", chainType, chainId)
+	}
+	updateRefs(chainType, chainId, "", c.String("name"))
+
+	ifExit(startSupervisor(tn))
+	printTestnet(tn)
+}
+
+// Copy the chain for each node, and configure the copies to peer with the
+// first one. Ports start at the given port (or the chain's listen port).
+func newTestnet(chainType, chainId string, keys []string, port int) (*Testnet, error) {
+	root := chains.ComposeRoot(chainType, chainId)
+	chain := mod.NewChain(chainType, false)
+	if err := readChainRoot(chain, chainType, root); err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		port, _ = chain.Property("ListenPort").(int)
+	}
+
+	tn := &Testnet{ChainType: chainType, ChainId: chainId, Created: time.Now()}
+	for i, key := range keys {
+		base := port + i*TestnetPortStride
+		node := &TestnetNode{
+			Name:       fmt.Sprintf("node%d", i),
+			ListenPort: base,
+			RpcPort:    base + 1,
+			FetchPort:  base + 2,
+		}
+		node.Root = chains.ComposeRootMulti(chainType, chainId, node.Name)
+		if err := utils.Copy(root, node.Root); err != nil {
+			return nil, err
+		}
+
+		chain := mod.NewChain(chainType, false)
+		if err := readChainRoot(chain, chainType, node.Root); err != nil {
+			return nil, err
+		}
+		chain.SetProperty("ListenHost", TestnetHost)
+		chain.SetProperty("ListenPort", node.ListenPort)
+		chain.SetProperty("RpcHost", TestnetHost)
+		chain.SetProperty("RpcPort", node.RpcPort)
+		chain.SetProperty("FetchPort", node.FetchPort)
+		if i == 0 {
+			chain.SetProperty("UseSeed", false)
+		} else {
+			chain.SetProperty("RemoteHost", TestnetHost)
+			chain.SetProperty("RemotePort", port)
+			chain.SetProperty("UseSeed", true)
+		}
+		if err := mod.TestnetNode(chain, node.Root, node.Name, key); err != nil {
+			return nil, err
+		}
+		if err := chain.WriteConfig(path.Join(node.Root, "config.json")); err != nil {
+			return nil, err
+		}
+		tn.Nodes = append(tn.Nodes, node)
+	}
+	return tn, utils.WriteJson(tn, path.Join(tn.dir(), TestnetFile))
+}
+
+// Get the testnet on a chain (a ref or <type>/<id>, or the current chain
+// if empty).
+func loadTestnet(ref string) (*Testnet, error) {
+	typ, id, err := chains.ResolveChain(ref)
+	if err != nil {
+		return nil, err
+	}
+	file := path.Join(path.Dir(chains.ComposeRoot(typ, id)), TestnetFile)
+	tn := new(Testnet)
+	if err := utils.ReadJson(tn, file); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("There is no testnet on chain %s/%s", typ, id)
+		}
+		return nil, err
+	}
+	return tn, nil
+}
+
+func testnetArg(c *Context) string {
+	if len(c.Args()) > 0 {
+		return c.Args()[0]
+	}
+	return c.String("chain")
+}
+
+// Start the supervisor in the background. It outlives epm, and writes its
+// output to the testnet's log.
+func startSupervisor(tn *Testnet) error {
+	if pid, ok := supervisorPid(tn); ok {
+		return fmt.Errorf("The testnet is already running (supervisor pid %d)", pid)
+	}
+	bin, err := epmBinary()
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(path.Join(tn.dir(), TestnetSupervisorLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(bin, "testnet", "supervise", "--chain", tn.chain())
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	if err := ioutil.WriteFile(path.Join(tn.dir(), TestnetSupervisorPid), []byte(strconv.Itoa(pid)), 0600); err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	logger.Warnf("Started testnet %s with %d nodes (supervisor pid %d)\n", tn.chain(), len(tn.Nodes), pid)
+	return cmd.Process.Release()
+}
+
+// The path of the running epm binary, for starting more of it.
+func epmBinary() (string, error) {
+	bin, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(bin)
+}
+
+// Get the pid of the testnet's supervisor, if it's running.
+func supervisorPid(tn *Testnet) (int, bool) {
+	return runningPid(path.Join(tn.dir(), TestnetSupervisorPid))
+}
+
+// run the nodes of a testnet, restarting them (with backoff) when they
+// exit, until interrupted
+func TestnetSupervise(c *Context) {
+	tn, err := loadTestnet(c.String("chain"))
+	ifExit(err)
+	bin, err := epmBinary()
+	ifExit(err)
+
+	stop := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for _, node := range tn.Nodes {
+		wg.Add(1)
+		go superviseNode(tn, node, bin, stop, wg)
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	<-ch
+	logger.Warnln("Stopping testnet", tn.chain())
+	close(stop)
+	wg.Wait()
+	os.Remove(path.Join(tn.dir(), TestnetSupervisorPid))
+	exit(nil)
+}
+
+func superviseNode(tn *Testnet, node *TestnetNode, bin string, stop chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	backoff := TestnetMinBackoff
+	for {
+		logFile, err := os.OpenFile(path.Join(node.Root, TestnetNodeLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		cmd := exec.Command(bin, "run", "--chain", tn.chain(), "--multi", node.Name)
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		start := time.Now()
+		if err = cmd.Start(); err == nil {
+			logger.Infof("Started %s (pid %d)\n", node.Name, cmd.Process.Pid)
+			exited := make(chan error, 1)
+			go func() { exited <- cmd.Wait() }()
+			select {
+			case err = <-exited:
+			case <-stop:
+				// nodes shut down cleanly on an interrupt (see Run)
+				cmd.Process.Signal(os.Interrupt)
+				select {
+				case <-exited:
+				case <-time.After(TestnetStopTimeout):
+					logger.Warnf("%s did not stop, killing it\n", node.Name)
+					cmd.Process.Kill()
+					<-exited
+				}
+				logFile.Close()
+				return
+			}
+		}
+		logFile.Close()
+		logger.Warnf("%s exited (%v), restarting in %v\n", node.Name, err, backoff)
+
+		// a node that ran for a while gets a fresh backoff
+		if time.Since(start) > TestnetMaxBackoff {
+			backoff = TestnetMinBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+		if backoff *= 2; backoff > TestnetMaxBackoff {
+			backoff = TestnetMaxBackoff
+		}
+	}
+}
+
+// show the nodes of a testnet and whether they are up
+func TestnetStatus(c *Context) {
+	tn, err := loadTestnet(testnetArg(c))
+	ifExit(err)
+	printTestnet(tn)
+}
+
+func printTestnet(tn *Testnet) {
+	fmt.Printf("Testnet %s\n", tn.chain())
+	if pid, ok := supervisorPid(tn); ok {
+		fmt.Printf("Supervisor: running (pid %d)\n", pid)
+	} else {
+		fmt.Println("Supervisor: stopped")
+	}
+	format := "%-10s%-10s%-24s%-24s%-10s\n"
+	fmt.Printf(format, "Node:", "Pid:", "Peer address:", "Rpc address:", "Status:")
+	for _, node := range tn.Nodes {
+		addr := net.JoinHostPort(TestnetHost, strconv.Itoa(node.ListenPort))
+		rpcAddr := net.JoinHostPort(TestnetHost, strconv.Itoa(node.RpcPort))
+		pid, running := runningPid(path.Join(node.Root, "pid"))
+		status, pidS := "down", "-"
+		if running {
+			pidS = strconv.Itoa(pid)
+			status = "starting"
+			if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+				conn.Close()
+				status = "up"
+			}
+		}
+		fmt.Printf(format, node.Name, pidS, addr, rpcAddr, status)
+	}
+}
+
+// stop a testnet (and remove its nodes)
+func TestnetDown(c *Context) {
+	tn, err := loadTestnet(testnetArg(c))
+	ifExit(err)
+
+	if pid, ok := supervisorPid(tn); ok {
+		p, _ := os.FindProcess(pid)
+		ifExit(p.Signal(syscall.SIGTERM))
+		// the supervisor waits this long for each node to stop
+		deadline := time.Now().Add(TestnetStopTimeout + 5*time.Second)
+		for time.Now().Before(deadline) {
+			if _, ok := supervisorPid(tn); !ok {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	// nodes left behind by a supervisor that died
+	for _, node := range tn.Nodes {
+		if pid, ok := runningPid(path.Join(node.Root, "pid")); ok {
+			p, _ := os.FindProcess(pid)
+			p.Signal(os.Interrupt)
+		}
+	}
+	os.Remove(path.Join(tn.dir(), TestnetSupervisorPid))
+	logger.Warnf("Stopped testnet %s\n", tn.chain())
+
+	if c.Bool("rm") {
+		for _, node := range tn.Nodes {
+			ifExit(os.RemoveAll(node.Root))
+		}
+		ifExit(os.Remove(path.Join(tn.dir(), TestnetFile)))
+		logger.Warnf("Removed the nodes of testnet %s\n", tn.chain())
+	}
+}
+
+// print the logs of a testnet's nodes (and follow them)
+func TestnetLogs(c *Context) {
+	tn, err := loadTestnet(testnetArg(c))
+	ifExit(err)
+	nodes := tn.Nodes
+	if name := c.String("node"); name != "" {
+		nodes = nil
+		for _, node := range tn.Nodes {
+			if node.Name == name {
+				nodes = append(nodes, node)
+			}
+		}
+		if nodes == nil {
+			exit(fmt.Errorf("Testnet %s has no node %s", tn.chain(), name))
+		}
+	}
+
+	offsets := make(map[string]int64)
+	for _, node := range nodes {
+		file := path.Join(node.Root, TestnetNodeLog)
+		lines, offset, err := tailLines(file, c.Int("lines"))
+		if err != nil && !os.IsNotExist(err) {
+			ifExit(err)
+		}
+		printLogLines(node.Name, lines)
+		offsets[node.Name] = offset
+	}
+	if !c.Bool("follow") {
+		exit(nil)
+	}
+	for {
+		time.Sleep(TestnetFollowInterval)
+		for _, node := range nodes {
+			lines, offset, err := readFrom(path.Join(node.Root, TestnetNodeLog), offsets[node.Name])
+			if err != nil {
+				continue
+			}
+			printLogLines(node.Name, lines)
+			offsets[node.Name] = offset
+		}
+	}
+}
+
+func printLogLines(name string, lines []string) {
+	for _, l := range lines {
+		fmt.Printf("%-6s| %s\n", name, l)
+	}
+}
+
+// The last n lines of a file, and its size.
+func tailLines(file string, n int) ([]string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+	// don't read all of a big log: lines are rarely longer than this
+	start := size - int64(n)*1024
+	if start < 0 {
+		start = 0
+	}
+	if _, err := f.Seek(start, 0); err != nil {
+		return nil, 0, err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	lines := splitLines(b)
+	if start > 0 && len(lines) > 0 {
+		// the first line is probably cut
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, start + int64(len(b)), nil
+}
+
+// The complete lines of a file after offset, and the offset after them.
+func readFrom(file string, offset int64) ([]string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, offset, err
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(f, 1<<20))
+	if err != nil {
+		return nil, offset, err
+	}
+	// leave a partial line for next time
+	i := bytes.LastIndex(b, []byte{'\n'})
+	if i < 0 {
+		return nil, offset, nil
+	}
+	return splitLines(b[:i+1]), offset + int64(i+1), nil
+}
+
+func splitLines(b []byte) []string {
+	s := strings.TrimRight(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -91,6 +91,9 @@ type EPM struct {
 	Diff   bool
 	states map[string]types.State
 
+	// compile all deploy targets in one batch before running jobs
+	Prefetch bool
+
 	//map job numbers to names of diffs invoked before a job
 	diffSched map[int][]string
 
@@ -0,0 +1,105 @@
+package epm
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/eris-ltd/lllc-server"
+)
+
+// a compiler for hex that counts what it compiles
+type countingCompiler struct {
+	mtx   sync.Mutex
+	count int
+}
+
+func (c *countingCompiler) Compile(filename string) ([]byte, error) {
+	c.mtx.Lock()
+	c.count += 1
+	c.mtx.Unlock()
+	code, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(string(code))
+}
+
+func (c *countingCompiler) Abi(filename string) (string, error) {
+	return "[]", nil
+}
+
+func (c *countingCompiler) ResolveIncludes(code []byte, resolve lllcserver.IncludeResolver) ([]byte, error) {
+	return code, nil
+}
+
+func (c *countingCompiler) Version() (string, error) {
+	return "1", nil
+}
+
+var prefetchText = `
+deploy:
+	"a.hexc" => "a"
+
+deploy:
+	"b.hexc" => "b"
+
+deploy:
+	"./a.hexc" => "a2"
+
+deploy:
+	"{{unset}}.hexc" => "c"
+
+transact:
+	{{a}} => "ok"
+`
+
+func TestPrefetchDeploys(t *testing.T) {
+	compiler := new(countingCompiler)
+	lllcserver.RegisterCompiler("hexc", []string{"hexc"}, compiler)
+	lllcserver.SetLanguageNet("hexc", false)
+	lllcserver.ClearCaches()
+
+	dir, err := ioutil.TempDir("", "epm-prefetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, code := range map[string]string{"a.hexc": "6000", "b.hexc": "60016002"} {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(code), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(p string) { ContractPath = p }(ContractPath)
+	ContractPath = dir
+
+	e, _ := NewEPM(nil, "")
+	p := Parse(prefetchText)
+	if err := p.run(); err != nil {
+		t.Fatal(err)
+	}
+	e.jobs = p.jobs
+
+	targets := e.deployTargets()
+	if len(targets) != 2 || targets[0] != path.Join(dir, "a.hexc") || targets[1] != path.Join(dir, "b.hexc") {
+		t.Fatalf("expected each deployed contract once, got %v", targets)
+	}
+
+	e.prefetchDeploys()
+	if compiler.count != 2 {
+		t.Fatalf("expected 2 compiles, got %d", compiler.count)
+	}
+
+	// deploying them now hits the cache
+	for _, f := range targets {
+		if _, _, err := lllcserver.Compile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if compiler.count != 2 {
+		t.Fatalf("expected prefetched contracts to be cached, got %d compiles", compiler.count)
+	}
+}
@@ -0,0 +1,600 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eris-ltd/epm-go/utils"
+)
+
+// The states of a managed chain.
+const (
+	ChainStarting   = "starting"
+	ChainRunning    = "running"
+	ChainRestarting = "restarting"
+	ChainStopping   = "stopping"
+	ChainStopped    = "stopped"
+)
+
+// Chains are run with `epm run` of the epm binary that is serving, each
+// in its own process (group). These can be changed for testing.
+var (
+	ChainCommand = epmBinary()
+
+	// Restart backoff. A chain that ran for longer than the maximum gets
+	// the minimum again.
+	ChainMinBackoff = time.Second
+	ChainMaxBackoff = 2 * time.Minute
+
+	// How long a chain has to shut down before it is killed.
+	ChainStopTimeout = 15 * time.Second
+
+	// Health probes start after the grace period. A chain that fails
+	// ChainMaxFailures probes in a row is restarted. A mining chain whose
+	// latest block hasn't changed for ChainStallTimeout is failing.
+	ChainProbeInterval = 10 * time.Second
+	ChainProbeTimeout  = 5 * time.Second
+	ChainStartupGrace  = 30 * time.Second
+	ChainStallTimeout  = 5 * time.Minute
+	ChainMaxFailures   = 3
+
+	// Each chain logs to <name>.log here, rotated when it gets too big.
+	ChainLogDir      = path.Join(utils.Logs, "chains")
+	ChainLogMaxSize  = int64(10 * 1024 * 1024)
+	ChainLogMaxFiles = 5
+)
+
+// The path of the running epm binary, so chains are run by the same build
+// as the server. Falls back to the epm on the PATH.
+func epmBinary() string {
+	bin, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return "epm"
+	}
+	if abs, err := filepath.Abs(bin); err == nil {
+		return abs
+	}
+	return bin
+}
+
+// Options for running a chain.
+type ChainOptions struct {
+	LogLevel int
+	Mine     bool
+}
+
+// What the health probe found out.
+type ChainHealth struct {
+	Healthy        bool `json:"healthy"`
+	RpcResponding  bool `json:"rpc_responding"`
+	BlockAdvancing bool `json:"block_advancing"`
+	// Hash (or height) of the latest block, from the rpc
+	LatestBlock     string    `json:"latest_block"`
+	LastBlockChange time.Time `json:"last_block_change"`
+	Checked         time.Time `json:"checked"`
+	// Failed probes in a row
+	Failures int    `json:"failures"`
+	Error    string `json:"error,omitempty"`
+}
+
+// The status of a managed chain, as served at /chains/:chainName/status.
+type ChainStatus struct {
+	Name      string `json:"name"`
+	ChainType string `json:"chain_type"`
+	ChainId   string `json:"chain_id"`
+	Root      string `json:"root"`
+	State     string `json:"state"`
+	Pid       int    `json:"pid"`
+	// Written by `epm run`
+	PidFile  string      `json:"pid_file"`
+	Mining   bool        `json:"mining"`
+	Started  time.Time   `json:"started"`
+	Restarts int         `json:"restarts"`
+	LastExit string      `json:"last_exit,omitempty"`
+	LogFile  string      `json:"log_file"`
+	RpcAddr  string      `json:"rpc_addr,omitempty"`
+	Health   ChainHealth `json:"health"`
+}
+
+// Runs named chains as child processes, restarts them when they exit or
+// stop being healthy, and keeps track of their status.
+type ChainManager struct {
+	mtx    *sync.Mutex
+	chains map[string]*managedChain
+}
+
+func NewChainManager() *ChainManager {
+	return &ChainManager{
+		mtx:    &sync.Mutex{},
+		chains: make(map[string]*managedChain),
+	}
+}
+
+// Start running a chain under a name. The root must not be in use by
+// another managed chain.
+func (cm *ChainManager) Start(name, chainType, chainId, root string, opts ChainOptions) error {
+	cm.mtx.Lock()
+	defer cm.mtx.Unlock()
+	if mc, ok := cm.chains[name]; ok && mc.active() {
+		return fmt.Errorf("Chain %s is already running.", name)
+	}
+	for n, mc := range cm.chains {
+		if mc.root == root && mc.active() {
+			return fmt.Errorf("Chain %s is already running as %s.", name, n)
+		}
+	}
+
+	if err := os.MkdirAll(ChainLogDir, 0700); err != nil {
+		return err
+	}
+	logFile := path.Join(ChainLogDir, name+".log")
+	l, err := openRotatingLog(logFile, ChainLogMaxSize, ChainLogMaxFiles)
+	if err != nil {
+		return err
+	}
+	mc := &managedChain{
+		name:      name,
+		chainType: chainType,
+		chainId:   chainId,
+		root:      root,
+		opts:      opts,
+		logFile:   logFile,
+		log:       l,
+		rpcAddr:   rpcAddr(root),
+		state:     ChainStarting,
+		mtx:       &sync.Mutex{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	cm.chains[name] = mc
+	go mc.run()
+	return nil
+}
+
+// Stop a chain, and wait for it to shut down.
+func (cm *ChainManager) Stop(name string) error {
+	cm.mtx.Lock()
+	mc, ok := cm.chains[name]
+	cm.mtx.Unlock()
+	if !ok || !mc.active() {
+		return fmt.Errorf("Chain %s is not running.", name)
+	}
+	mc.halt()
+	return nil
+}
+
+// Stop a chain and start it again with the same options.
+func (cm *ChainManager) Restart(name string) error {
+	cm.mtx.Lock()
+	mc, ok := cm.chains[name]
+	cm.mtx.Unlock()
+	if !ok || !mc.active() {
+		return fmt.Errorf("Chain %s is not running.", name)
+	}
+	mc.halt()
+	return cm.Start(name, mc.chainType, mc.chainId, mc.root, mc.opts)
+}
+
+// Stop every chain.
+func (cm *ChainManager) StopAll() {
+	cm.mtx.Lock()
+	chains := make([]*managedChain, 0, len(cm.chains))
+	for _, mc := range cm.chains {
+		chains = append(chains, mc)
+	}
+	cm.mtx.Unlock()
+
+	wg := &sync.WaitGroup{}
+	for _, mc := range chains {
+		wg.Add(1)
+		go func(mc *managedChain) {
+			mc.halt()
+			wg.Done()
+		}(mc)
+	}
+	wg.Wait()
+}
+
+// Is a chain running (or being restarted)?
+func (cm *ChainManager) IsRunning(name string) bool {
+	cm.mtx.Lock()
+	mc, ok := cm.chains[name]
+	cm.mtx.Unlock()
+	return ok && mc.active()
+}
+
+// Get the status of a chain.
+func (cm *ChainManager) Status(name string) (*ChainStatus, bool) {
+	cm.mtx.Lock()
+	mc, ok := cm.chains[name]
+	cm.mtx.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return mc.status(), true
+}
+
+// Get the status of every chain, by name.
+func (cm *ChainManager) All() []*ChainStatus {
+	cm.mtx.Lock()
+	names := make([]string, 0, len(cm.chains))
+	for name := range cm.chains {
+		names = append(names, name)
+	}
+	cm.mtx.Unlock()
+	sort.Strings(names)
+
+	all := []*ChainStatus{}
+	for _, name := range names {
+		if s, ok := cm.Status(name); ok {
+			all = append(all, s)
+		}
+	}
+	return all
+}
+
+type managedChain struct {
+	name      string
+	chainType string
+	chainId   string
+	root      string
+	opts      ChainOptions
+	logFile   string
+	log       *rotatingLog
+	rpcAddr   string
+
+	mtx      *sync.Mutex
+	state    string
+	pid      int
+	started  time.Time
+	restarts int
+	lastExit string
+	health   ChainHealth
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func (mc *managedChain) active() bool {
+	select {
+	case <-mc.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Stop the chain and wait for it.
+func (mc *managedChain) halt() {
+	mc.stopOnce.Do(func() { close(mc.stop) })
+	<-mc.done
+}
+
+func (mc *managedChain) setState(state string) {
+	mc.mtx.Lock()
+	mc.state = state
+	mc.mtx.Unlock()
+}
+
+func (mc *managedChain) status() *ChainStatus {
+	mc.mtx.Lock()
+	defer mc.mtx.Unlock()
+	return &ChainStatus{
+		Name:      mc.name,
+		ChainType: mc.chainType,
+		ChainId:   mc.chainId,
+		Root:      mc.root,
+		State:     mc.state,
+		Pid:       mc.pid,
+		PidFile:   path.Join(mc.root, "pid"),
+		Mining:    mc.opts.Mine,
+		Started:   mc.started,
+		Restarts:  mc.restarts,
+		LastExit:  mc.lastExit,
+		LogFile:   mc.logFile,
+		RpcAddr:   mc.rpcAddr,
+		Health:    mc.health,
+	}
+}
+
+func (mc *managedChain) args() []string {
+	args := []string{"--log", strconv.Itoa(mc.opts.LogLevel), "run", "--chain", path.Join(mc.chainType, mc.chainId)}
+	if mc.opts.Mine {
+		args = append(args, "--mine")
+	}
+	return args
+}
+
+// Run the chain until it is stopped, restarting it (with backoff) when it
+// exits or fails its health probes.
+func (mc *managedChain) run() {
+	defer close(mc.done)
+	defer mc.log.Close()
+	backoff := ChainMinBackoff
+	for {
+		start := time.Now()
+		stopped, err := mc.runOnce()
+		mc.mtx.Lock()
+		mc.pid = 0
+		if err != nil {
+			mc.lastExit = err.Error()
+		}
+		mc.mtx.Unlock()
+		mc.removeStalePid()
+		if stopped {
+			logger.Infof("Chain %s stopped\n", mc.name)
+			mc.setState(ChainStopped)
+			return
+		}
+
+		if time.Since(start) > ChainMaxBackoff {
+			backoff = ChainMinBackoff
+		}
+		logger.Warnf("Chain %s exited (%v), restarting in %v\n", mc.name, err, backoff)
+		mc.setState(ChainRestarting)
+		select {
+		case <-time.After(backoff):
+		case <-mc.stop:
+			mc.setState(ChainStopped)
+			return
+		}
+		if backoff *= 2; backoff > ChainMaxBackoff {
+			backoff = ChainMaxBackoff
+		}
+		mc.mtx.Lock()
+		mc.restarts += 1
+		mc.mtx.Unlock()
+	}
+}
+
+// Run the chain's process once. Returns whether it was stopped, and why it
+// exited otherwise.
+func (mc *managedChain) runOnce() (bool, error) {
+	fmt.Fprintf(mc.log, "\n---- %s: starting chain %s (%s/%s) ----\n", time.Now().Format(time.RFC3339), mc.name, mc.chainType, mc.chainId)
+	cmd := newChainCmd(mc.args())
+	cmd.Stdout = mc.log
+	cmd.Stderr = mc.log
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	pid := cmd.Process.Pid
+	now := time.Now()
+	mc.mtx.Lock()
+	mc.pid = pid
+	mc.started = now
+	mc.state = ChainRunning
+	mc.health = ChainHealth{LastBlockChange: now}
+	mc.mtx.Unlock()
+	logger.Infof("Started chain %s (pid %d)\n", mc.name, pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	ticker := time.NewTicker(ChainProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			// the chain binary run by epm may still be going
+			stopGroup(pid)
+			if err == nil {
+				err = fmt.Errorf("exited")
+			}
+			return false, err
+		case <-ticker.C:
+			if time.Since(now) < ChainStartupGrace {
+				continue
+			}
+			if h := mc.probe(); h.Failures >= ChainMaxFailures {
+				logger.Warnf("Chain %s failed %d health probes, restarting it\n", mc.name, h.Failures)
+				mc.setState(ChainRestarting)
+				mc.kill(pid, exited)
+				return false, fmt.Errorf("unhealthy: %s", h.Error)
+			}
+		case <-mc.stop:
+			mc.setState(ChainStopping)
+			mc.kill(pid, exited)
+			return true, nil
+		}
+	}
+}
+
+// The chain is run in its own process group, so that signals reach the
+// chain binary epm runs as well as epm itself.
+func newChainCmd(args []string) *exec.Cmd {
+	cmd := exec.Command(ChainCommand, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// Interrupt the chain's process group so the node shuts down cleanly, and
+// kill it if it takes too long.
+func (mc *managedChain) kill(pgid int, exited chan error) {
+	syscall.Kill(-pgid, syscall.SIGINT)
+	select {
+	case <-exited:
+	case <-time.After(ChainStopTimeout):
+		logger.Warnf("Chain %s did not shut down, killing it\n", mc.name)
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		<-exited
+	}
+	stopGroup(pgid)
+}
+
+// Stop whatever is left of a process group.
+func stopGroup(pgid int) {
+	if syscall.Kill(-pgid, syscall.SIGINT) != nil {
+		return
+	}
+	deadline := time.Now().Add(ChainStopTimeout)
+	for syscall.Kill(-pgid, 0) == nil {
+		if time.Now().After(deadline) {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// `epm run` removes its pid file when it shuts down, but not when it is
+// killed.
+func (mc *managedChain) removeStalePid() {
+	pidFile := path.Join(mc.root, "pid")
+	b, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return
+	}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+		if syscall.Kill(pid, 0) == nil {
+			return
+		}
+	}
+	os.Remove(pidFile)
+}
+
+// Check the chain's rpc responds and, if it is mining, that its latest
+// block changes.
+func (mc *managedChain) probe() ChainHealth {
+	mc.mtx.Lock()
+	h := mc.health
+	mc.mtx.Unlock()
+
+	h.Checked = time.Now()
+	h.Error = ""
+	if mc.rpcAddr == "" {
+		// nothing to ask
+		h.Healthy = true
+	} else if latest, err := probeRpc(mc.chainType, mc.rpcAddr); err != nil {
+		h.RpcResponding = false
+		h.Healthy = false
+		h.Error = "rpc: " + err.Error()
+	} else {
+		h.RpcResponding = true
+		if latest != h.LatestBlock {
+			h.LatestBlock = latest
+			h.LastBlockChange = h.Checked
+		}
+		h.BlockAdvancing = h.Checked.Sub(h.LastBlockChange) < ChainStallTimeout
+		h.Healthy = h.BlockAdvancing || !mc.opts.Mine
+		if !h.Healthy {
+			h.Error = fmt.Sprintf("no new block since %s", h.LastBlockChange.Format(time.RFC3339))
+		}
+	}
+	if h.Healthy {
+		h.Failures = 0
+	} else {
+		h.Failures += 1
+	}
+
+	mc.mtx.Lock()
+	mc.health = h
+	mc.mtx.Unlock()
+	return h
+}
+
+// The rpc address in a chain's config, if it serves rpc.
+func rpcAddr(root string) string {
+	configRaw, err := ioutil.ReadFile(path.Join(root, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config ChainConfig
+	if err := json.Unmarshal(configRaw, &config); err != nil || !config.ServeRPC || config.RPCPort == 0 {
+		return ""
+	}
+	host := config.RPCIp
+	if host == "" || host == "0.0.0.0" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(config.RPCPort))
+}
+
+// Ask a chain's rpc for its latest block. Chains we don't know how to ask
+// only have to accept connections.
+func probeRpc(chainType, addr string) (string, error) {
+	switch chainType {
+	case "thelonious":
+		return probeThelonious(addr)
+	case "tendermint":
+		return probeTendermint(addr)
+	}
+	conn, err := net.DialTimeout("tcp", addr, ChainProbeTimeout)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return "", nil
+}
+
+func probeThelonious(addr string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, ChainProbeTimeout)
+	if err != nil {
+		return "", err
+	}
+	conn.SetDeadline(time.Now().Add(ChainProbeTimeout))
+	client := jsonrpc.NewClient(conn)
+	defer client.Close()
+	var hash string
+	err = client.Call("TheloniousApi.LatestBlockHash", struct{}{}, &hash)
+	return hash, err
+}
+
+func probeTendermint(addr string) (string, error) {
+	client := &http.Client{Timeout: ChainProbeTimeout}
+	resp, err := client.Get("http://" + addr + "/status")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Result interface{} `json:"result"`
+		Error  string      `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if res.Error != "" {
+		return "", errors.New(res.Error)
+	}
+	// the result may be wrapped in a [type, value] pair
+	if height, ok := findField(res.Result, "latest_block_height"); ok {
+		return fmt.Sprintf("%v", height), nil
+	}
+	return "", fmt.Errorf("No latest block in status")
+}
+
+func findField(v interface{}, key string) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if f, ok := v[key]; ok {
+			return f, true
+		}
+		for _, e := range v {
+			if f, ok := findField(e, key); ok {
+				return f, true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if f, ok := findField(e, key); ok {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -3,11 +3,10 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/eris-ltd/epm-go/Godeps/_workspace/src/github.com/go-martini/martini"
 	"github.com/eris-ltd/epm-go/chains"
-	"github.com/eris-ltd/epm-go/commands"
-	"github.com/eris-ltd/epm-go/epm"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -20,7 +19,6 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"time"
 )
 
 // The default return when a requested URL does not match one of the handlers
@@ -28,14 +26,9 @@ const EPM_HELP = "That API endpoint does not exist. Please see the epm documenta
 
 // The HttpService object.
 type HttpService struct {
-	Router               *martini.Router
-	ChainIsRunning       bool
-	chainIsRestarting    bool
-	ChainRunningName     string
-	ChainRunningConfig   ChainConfig
-	ChainShutDownChannel chan bool
-	ChainIsShutDown      chan bool
-	Chain                epm.Blockchain
+	Router *martini.Router
+	// The chains started by the server.
+	Chains *ChainManager
 }
 
 type ChainConfig struct {
@@ -50,11 +43,7 @@ func NewHttpService(cm martini.Router) *HttpService {
 	h := &HttpService{}
 
 	h.Router = &cm
-	h.ChainIsRunning = false
-	h.chainIsRestarting = false
-
-	h.ChainShutDownChannel = make(chan bool, 1)
-	h.ChainIsShutDown = make(chan bool, 1)
+	h.Chains = NewChainManager()
 
 	chainShutDownViaOS := make(chan os.Signal, 1)
 
@@ -128,7 +117,13 @@ func (this *HttpService) handleConfig(params martini.Params, w http.ResponseWrit
 		cmdRaw = append(cmdRaw, toAdd)
 	}
 
-	this.executeCommand(cmdRaw, w)
+	// epm checks the keys and values, and writes nothing if any are wrong
+	out, err := runCommand(cmdRaw)
+	if err != nil {
+		this.logError(w, 400, err)
+		return
+	}
+	this.writeMsg(w, 200, out)
 }
 
 // This API endpoint is equivalent to `epm checkout`.
@@ -224,170 +219,102 @@ func (this *HttpService) handleNewChain(params martini.Params, w http.ResponseWr
 	this.executeCommand(cmdRaw, w)
 }
 
-// This API endpoint is equivalent to `epm run`.
+// This API endpoint is equivalent to `epm run`. The chain is run in its
+// own process and restarted if it exits or stops being healthy.
 func (this *HttpService) handleStartChain(params martini.Params, w http.ResponseWriter, r *http.Request) {
 	this.logIncoming("Starting Chain Runner")
 
-	if !this.ChainIsRunning {
-
-		c := &commands.Context{
-			Arguments: []string{},
-			Strings:   make(map[string]string),
-			Integers:  make(map[string]int),
-			Booleans:  make(map[string]bool),
-			HasSet:    make(map[string]struct{}),
-		}
-
-		root, chainType, _, err := commands.ResolveRootFlag(c)
-		if err != nil {
-			this.logError(w, 500, err)
-			return
-		}
-
-		if !this.chainIsRestarting {
-			this.setupRPC(root, w, r)
-		}
-
-		logLevel := r.URL.Query().Get("log")
-		if logLevel == "" {
-			logLevel = "2"
-		}
-		toCommit := r.URL.Query().Get("commit")
-
-		go func() {
-			c.Integers["log"], _ = strconv.Atoi(logLevel)
-			c.Set("log")
-			if toCommit == "true" {
-				c.Booleans["mine"] = true
-				c.Set("mine")
-			}
-			this.ChainRunningName = params["chainName"]
-
-			this.logInfo("Starting Blockchain with log level: " + logLevel)
-			var err error
-			this.Chain, err = commands.LoadChain(c, chainType, root)
-
-			if err == nil {
-				<-this.ChainShutDownChannel
-
-				this.logInfo("Shutting Down Chain")
-				this.Chain.Shutdown()
-				this.Chain.WaitForShutdown()
-			} else {
-				this.logInfo("Chain Could Not Be Started.")
-			}
-
-			this.ChainIsShutDown <- true
-		}()
-
-		this.ChainIsRunning = true
+	chainName := params["chainName"]
+	if this.Chains.IsRunning(chainName) {
+		this.writeMsg(w, 500, "That blockchain is already running.")
+		return
+	}
 
-	} else {
+	chainType, chainId, err := chains.ResolveChain(chainName)
+	if err != nil {
+		this.logError(w, 500, err)
+		return
+	}
+	root := chains.ComposeRoot(chainType, chainId)
 
-		this.writeMsg(w, 500, "A blockchain is already running.")
+	if err := this.setupRPC(chainName, root, r); err != nil {
+		this.logError(w, 500, err)
 		return
+	}
 
+	opts := ChainOptions{LogLevel: 2, Mine: r.URL.Query().Get("commit") == "true"}
+	if logLevel := r.URL.Query().Get("log"); logLevel != "" {
+		if opts.LogLevel, err = strconv.Atoi(logLevel); err != nil {
+			this.logError(w, 400, err)
+			return
+		}
 	}
 
-	if !this.chainIsRestarting {
-		this.writeMsg(w, 200, "Blockchain started.")
+	this.logInfo(fmt.Sprintf("Starting Blockchain with log level: %d", opts.LogLevel))
+	if err := this.Chains.Start(chainName, chainType, chainId, root, opts); err != nil {
+		this.logError(w, 500, err)
+		return
 	}
+	this.writeMsg(w, 200, "Blockchain started.")
 }
 
-// This API endpoint is equivalent to `kill -SIGTERM $(epm plop chainid)`.
+// This API endpoint is equivalent to `kill -SIGTERM $(epm plop pid)`.
 func (this *HttpService) handleStopChain(params martini.Params, w http.ResponseWriter, r *http.Request) {
 	this.logIncoming("Stopping Chain Runner")
 
-	// First check if there is a running chain via in process check.
-	if this.ChainIsRunning && this.ChainRunningName == params["chainName"] {
-
-		this.ChainShutDownChannel <- true
-		<-this.ChainIsShutDown
-		this.ChainIsRunning = false
-
-		if !this.chainIsRestarting {
-			this.writeMsg(w, 200, "Blockchain stopped.")
+	chainName := params["chainName"]
+	if this.Chains.IsRunning(chainName) {
+		if err := this.Chains.Stop(chainName); err != nil {
+			this.logError(w, 500, err)
+			return
 		}
-
-		return
-
-	} else if this.ChainIsRunning {
-
-		this.writeMsg(w, 400, "Improper chain name")
+		this.writeMsg(w, 200, "Blockchain stopped.")
 		return
-
 	}
 
-	// If `epm serve` did not start a blockchain, check if there
-	// is a pid file in the checked out blockchain's folder
-	// which would mean that there is a running blockchain which
-	// was started by the cli.
-	cmdRaw := []string{"plop", "chainid"}
-	toTrim, err := this.executeCommandRaw(cmdRaw, w)
+	// If `epm serve` did not start the blockchain, check if there
+	// is a pid file in its folder which would mean that there is
+	// a running blockchain which was started by the cli.
+	chainType, chainId, err := chains.ResolveChain(chainName)
 	if err != nil {
 		this.logError(w, 400, err)
 		return
 	}
-	chainId := strings.TrimSpace(toTrim)
 
-	chainType := r.URL.Query().Get("type")
-	if chainType == "" {
-		chainType = "thelonious"
-	}
-
-	chainDir := chains.ComposeRoot(chainType, chainId)
-	pidFile := path.Join(chainDir, "pid")
-	if _, err := os.Stat(pidFile); err != nil {
+	pidFile := path.Join(chains.ComposeRoot(chainType, chainId), "pid")
+	pid, err := ioutil.ReadFile(pidFile)
+	if err != nil {
 		err := fmt.Errorf("There was no blockchain running.")
 		this.logError(w, 500, err)
 		return
 	}
 
-	var pidInt int
-	var chainProcess *os.Process
-
-	pid, err := ioutil.ReadFile(pidFile)
+	pidInt, err := strconv.Atoi(strings.TrimSpace(string(pid)))
 	if err != nil {
 		this.logError(w, 500, err)
 		return
 	}
 
-	pidInt, err = strconv.Atoi(string(pid))
+	chainProcess, err := os.FindProcess(pidInt)
 	if err != nil {
 		this.logError(w, 500, err)
 		return
 	}
-
-	chainProcess, err = os.FindProcess(pidInt)
-	if err != nil {
+	if err := chainProcess.Signal(os.Interrupt); err != nil {
 		this.logError(w, 500, err)
 		return
 	}
-	chainProcess.Signal(os.Interrupt)
 
-	if !this.chainIsRestarting {
-		this.writeMsg(w, 200, "Blockchain stopped.")
-	}
+	this.writeMsg(w, 200, "Blockchain stopped.")
 }
 
-// This API endpoint is equivalent to `kill -SIGTERM $(epm plop chainid) && sleep 5 && epm run`
+// This API endpoint is equivalent to `kill -SIGTERM $(epm plop pid) && epm run`
 func (this *HttpService) handleRestartChain(params martini.Params, w http.ResponseWriter, r *http.Request) {
 	this.logIncoming("Restarting Chain Runner")
 
-	if this.ChainIsRunning {
-
-		this.chainIsRestarting = true
-		this.handleStopChain(params, w, r)
-		time.Sleep(5 * time.Second)
-		this.handleStartChain(params, w, r)
-		this.chainIsRestarting = false
-
-	} else {
-
-		err := fmt.Errorf("There was no blockchain running.")
+	if err := this.Chains.Restart(params["chainName"]); err != nil {
 		this.logError(w, 500, err)
 		return
-
 	}
 
 	this.writeMsg(w, 200, "Blockchain restarted.")
@@ -397,16 +324,34 @@ func (this *HttpService) handleRestartChain(params martini.Params, w http.Respon
 func (this *HttpService) handleChainStatus(params martini.Params, w http.ResponseWriter, r *http.Request) {
 	this.logIncoming("Chain Running Status")
 
-	if this.ChainIsRunning {
-		if this.ChainRunningName == params["chainName"] {
-			this.writeMsg(w, 200, "true")
-			return
-		}
+	if this.Chains.IsRunning(params["chainName"]) {
+		this.writeMsg(w, 200, "true")
+		return
 	}
 
 	this.writeMsg(w, 200, "false")
 }
 
+// This API endpoint has no equivalent in the cli. Returns the status
+// of a chain started by the server as json.
+func (this *HttpService) handleChainStatusJSON(params martini.Params, w http.ResponseWriter, r *http.Request) {
+	this.logIncoming("Chain Status")
+
+	status, ok := this.Chains.Status(params["chainName"])
+	if !ok {
+		this.writeMsg(w, 404, "That blockchain was not started by the server.")
+		return
+	}
+	this.writeJSON(w, 200, status)
+}
+
+// This API endpoint has no equivalent in the cli. Returns the status
+// of every chain started by the server as json.
+func (this *HttpService) handleChainsStatus(w http.ResponseWriter, r *http.Request) {
+	this.logIncoming("Chains Status")
+	this.writeJSON(w, 200, this.Chains.All())
+}
+
 // -----------------------------------------------------------------
 // ------------------- KEYS HANDLERS -------------------------------
 // -----------------------------------------------------------------
@@ -441,14 +386,11 @@ func (this *HttpService) handleKeyImport(params martini.Params, w http.ResponseW
 // ------------------- HELPER FUNCTIONS ----------------------------
 // -----------------------------------------------------------------
 
-// Helper function to ensure if a chain is running that it has the time to shut
+// Helper function to ensure the running chains have the time to shut
 // down before the parent process exits.
 func (this *HttpService) CleanUpAndExit() {
 	logger.Errorln("Shutdown Signal Received")
-	if this.ChainIsRunning {
-		this.ChainShutDownChannel <- true
-		<-this.ChainIsShutDown
-	}
+	this.Chains.StopAll()
 	os.Exit(0)
 }
 
@@ -481,29 +423,33 @@ func (this *HttpService) executeCommand(cmdRaw []string, w http.ResponseWriter)
 
 // Assembles the command.
 func (this *HttpService) executeCommandRaw(cmdRaw []string, w http.ResponseWriter) (string, error) {
-
-	var cmd *exec.Cmd
-	cmd = exec.Command("epm")
-
-	for _, ele := range cmdRaw {
-		cmd.Args = append(cmd.Args, ele)
+	out, err := runCommand(cmdRaw)
+	if err != nil {
+		this.logError(w, 500, err)
+		return "", err
 	}
+	return out, nil
+}
+
+// Run an epm command, returning its output. Anything written to stderr
+// is an error, and so is the output of a command that fails.
+func runCommand(cmdRaw []string) (string, error) {
+	cmd := exec.Command(ChainCommand, cmdRaw...)
 
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &errOut
 
-	err := cmd.Run()
-	if err != nil {
-		this.logError(w, 500, err)
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String() + errOut.String()); msg != "" {
+			return "", errors.New(msg)
+		}
 		return "", err
 	}
 
 	if errOut.String() != "" {
-		err := fmt.Errorf(errOut.String())
-		this.logError(w, 500, err)
-		return "", err
+		return "", errors.New(errOut.String())
 	}
 
 	return out.String(), nil
@@ -519,80 +465,50 @@ func copyHeader(dst, src http.Header) {
 }
 
 // Setup the rpc
-func (this *HttpService) setupRPC(root string, w http.ResponseWriter, r *http.Request) {
+func (this *HttpService) setupRPC(chainName, root string, r *http.Request) error {
 	// rpc override?
 	if r.URL.Query().Get("no-rpc") == "true" {
-		var configParsed ChainConfig
-		configParsed.ServeRPC = false
-		this.ChainRunningConfig = configParsed
-		return
+		return nil
 	}
 
 	// else turn it on
 	configRaw, err := ioutil.ReadFile(path.Join(root, "config.json"))
 	if err != nil {
-		this.logError(w, 500, err)
-		return
+		return err
 	}
 	var configParsed ChainConfig
-	err = json.Unmarshal(configRaw, &configParsed)
-	if err != nil {
-		this.logError(w, 500, err)
-		return
+	if err := json.Unmarshal(configRaw, &configParsed); err != nil {
+		return err
 	}
 
+	configs := []string{}
+
 	// make sure the RPC server is turned on
 	if !configParsed.ServeRPC {
 		this.logInfo("Turning on RPC Server.")
-		_, err = this.executeCommandRaw([]string{"config", "serve_rpc:true"}, w)
-		if err != nil {
-			this.logError(w, 500, err)
-			return
-		}
+		configs = append(configs, "serve_rpc:true")
 	}
 
 	// set the RPC host
 	if r.URL.Query().Get("rpc-host") != "" {
 		this.logInfo("Making sure RPC Host is set.")
-		rpcHost := "rpc_host:" + r.URL.Query().Get("rpc-host")
-		_, err = this.executeCommandRaw([]string{"config", rpcHost}, w)
-		if err != nil {
-			this.logError(w, 500, err)
-			return
-		}
+		configs = append(configs, "rpc_host:"+r.URL.Query().Get("rpc-host"))
 	} else if configParsed.RPCIp == "" {
 		this.logInfo("Making sure RPC Host is set to localhost.")
-		_, err = this.executeCommandRaw([]string{"config", "rpc_host:localhost"}, w)
-		if err != nil {
-			this.logError(w, 500, err)
-			return
-		}
+		configs = append(configs, "rpc_host:localhost")
 	}
 
 	// set the RPC port
 	if r.URL.Query().Get("rpc-port") != "" {
 		this.logInfo("Making sure RPC Port is set.")
-		rpcPort := "rpc_port:" + r.URL.Query().Get("rpc-port")
-		_, err = this.executeCommandRaw([]string{"config", rpcPort}, w)
-		if err != nil {
-			this.logError(w, 500, err)
-			return
-		}
+		configs = append(configs, "rpc_port:"+r.URL.Query().Get("rpc-port"))
 	}
 
-	// Now set the vars in the object
-	configRaw, err = ioutil.ReadFile(root + "/config.json")
-	if err != nil {
-		this.logError(w, 500, err)
-		return
-	}
-	err = json.Unmarshal(configRaw, &configParsed)
-	if err != nil {
-		this.logError(w, 500, err)
-		return
+	if len(configs) == 0 {
+		return nil
 	}
-
-	this.ChainRunningConfig = configParsed
+	_, err = runCommand(append([]string{"config", "--chain", chainName}, configs...))
+	return err
 }
 
 // Handler for not found.
@@ -622,3 +538,15 @@ func (this *HttpService) writeMsg(w http.ResponseWriter, status int, msg string)
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	fmt.Fprint(w, msg)
 }
+
+// Utility method for responding with json.
+func (this *HttpService) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		this.logError(w, 500, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
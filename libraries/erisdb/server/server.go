@@ -82,7 +82,10 @@ format, namely:
 
 	- http://IP:PORT/eris/config/:chainName?key=val
 
-Any number of variables can be sent in the same POST call.
+Any number of variables can be sent in the same POST call. They are
+checked against the chain type's config schema (see epm config --list),
+and if any key is unknown or any value invalid, nothing is written and
+the response is a 400 saying what is wrong.
 
 	POST http://IP:PORT/eris/checkout/:chainName
 
@@ -131,7 +134,16 @@ before the server will seek to instatiate the chain.
 
 	POST http://IP:PORT/eris/start/:chainName
 
-Will start running the currently checked out blockchain.
+Will start running the named blockchain. Any number of chains may be
+run at once. Each is run with epm run in its own process, and its output
+is written to ~/.decerver/logs/chains/:chainName.log (rotated when it
+gets too big).
+
+A chain which exits is restarted, backing off up to two minutes between
+attempts. Its rpc is probed every ten seconds once it has been up for
+thirty; after three failed probes in a row the chain is restarted. A
+probe fails if the rpc does not respond or, if the chain is committing,
+its latest block has not changed for five minutes.
 
 Optional Parameters:
 
@@ -145,19 +157,31 @@ The default log level which is set is 2.
 
 	POST http://IP:PORT/eris/stop/:chainName
 
-Will stop a running blockchain.
+Will stop a running blockchain. Blockchains not started by the server
+are interrupted if they have a pid file.
 
 	POST http://IP:PORT/eris/restart/:chainName
 
-Will restart a running blockchain. The same optional parameters
-as for the start API endpoint may be passed to restart.
+Will restart a running blockchain with the options it was started
+with.
 
-	GET http://IP:PORT/eris/status
+	GET http://IP:PORT/eris/status/:chainName
 
 Will query whether a blockchain is running or not. Returns a plain
 text string of true if a blockchain is running or false if a
 blockchain is not running.
 
+	GET http://IP:PORT/chains/:chainName/status
+
+Will return the status of a blockchain started by the server as json:
+its state (starting, running, restarting, stopping or stopped), pid,
+pid file, start time, number of restarts, why it last exited, log file
+and the result of the last health probe.
+
+	GET http://IP:PORT/chains
+
+Will return the status of every blockchain started by the server.
+
 --------------------------------------------------------------
 
 Keys handlers
@@ -269,6 +293,8 @@ func (this *Server) Start() error {
 	cm.Post("/eris/stop/:chainName", this.httpService.handleStopChain)
 	cm.Post("/eris/restart/:chainName", this.httpService.handleRestartChain)
 	cm.Get("/eris/status/:chainName", this.httpService.handleChainStatus)
+	cm.Get("/chains", this.httpService.handleChainsStatus)
+	cm.Get("/chains/:chainName/status", this.httpService.handleChainStatusJSON)
 
 	// Keys handlers
 	cm.Post("/eris/importkey/:keyName", this.httpService.handleKeyImport)
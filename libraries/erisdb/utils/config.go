@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A key in a chain module's config.json
+type ConfigKey struct {
+	// The json key
+	Key string `json:"key"`
+	// The field of the module's config struct
+	Field string `json:"field"`
+	// string, int or bool
+	Type        string      `json:"type"`
+	Default     interface{} `json:"default"`
+	Description string      `json:"description"`
+	// Whether a running chain must be restarted for a change to take effect
+	Restart bool `json:"restart_required"`
+	// Set by epm when a chain is installed (chain_id, root_dir, ...)
+	Managed bool `json:"managed,omitempty"`
+	// Allowed range of an int (unbounded if both are zero)
+	Min int `json:"min,omitempty"`
+	Max int `json:"max,omitempty"`
+	// Allowed values of a string (any if empty)
+	Values []string `json:"values,omitempty"`
+}
+
+// The described keys of a chain module's config.
+type ConfigSchema []*ConfigKey
+
+// Make the schema of a config struct from its defaults (a pointer to the
+// struct) and descriptions of its keys. Keys are in the struct's order.
+func NewConfigSchema(defaults interface{}, described []*ConfigKey) ConfigSchema {
+	byKey := make(map[string]*ConfigKey)
+	for _, k := range described {
+		byKey[k.Key] = k
+	}
+
+	v := reflect.ValueOf(defaults).Elem()
+	t := v.Type()
+	schema := ConfigSchema{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		k := &ConfigKey{Key: name, Restart: true}
+		if d, ok := byKey[name]; ok {
+			c := *d
+			k = &c
+		}
+		k.Field = f.Name
+		k.Type = f.Type.Kind().String()
+		k.Default = v.Field(i).Interface()
+		schema = append(schema, k)
+	}
+	return schema
+}
+
+// Find a key by its json key or field name.
+func (s ConfigSchema) Lookup(key string) (*ConfigKey, bool) {
+	for _, k := range s {
+		if k.Key == key || k.Field == key {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Parse and check a value for a key, by its name.
+func (s ConfigSchema) Parse(key, value string) (*ConfigKey, interface{}, error) {
+	k, ok := s.Lookup(key)
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown config key %s%s", key, s.suggest(key))
+	}
+	v, err := k.Parse(value)
+	return k, v, err
+}
+
+// Suggest keys that look like a typo'd one.
+func (s ConfigSchema) suggest(key string) string {
+	like := []string{}
+	for _, k := range s {
+		if editDistance(k.Key, key) <= 2 || (len(key) > 3 && strings.Contains(k.Key, key)) {
+			like = append(like, k.Key)
+		}
+	}
+	if len(like) == 0 {
+		return ". See epm config --list"
+	}
+	return ". Did you mean " + strings.Join(like, " or ") + "?"
+}
+
+// Parse and check a value given as a string.
+func (k *ConfigKey) Parse(value string) (interface{}, error) {
+	switch k.Type {
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for %s: expected true or false, got %q", k.Key, value)
+		}
+		return b, nil
+	case "int":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for %s: expected an integer, got %q", k.Key, value)
+		}
+		return i, k.check(i)
+	case "string":
+		return value, k.check(value)
+	}
+	return nil, fmt.Errorf("Config key %s has type %s, which can't be set", k.Key, k.Type)
+}
+
+// Check a value (as decoded from json) fits the key.
+func (k *ConfigKey) Check(value interface{}) error {
+	switch v := value.(type) {
+	case bool:
+		if k.Type == "bool" {
+			return nil
+		}
+	case string:
+		if k.Type == "string" {
+			return k.check(v)
+		}
+	case json.Number:
+		if i, err := strconv.Atoi(v.String()); err == nil && k.Type == "int" {
+			return k.check(i)
+		}
+	case int:
+		if k.Type == "int" {
+			return k.check(v)
+		}
+	}
+	return fmt.Errorf("Invalid value for %s: expected %s %s, got %v", k.Key, article(k.Type), k.Type, value)
+}
+
+func (k *ConfigKey) check(value interface{}) error {
+	switch v := value.(type) {
+	case int:
+		if (k.Min != 0 || k.Max != 0) && (v < k.Min || v > k.Max) {
+			return fmt.Errorf("Invalid value for %s: %d is out of range [%d, %d]", k.Key, v, k.Min, k.Max)
+		}
+	case string:
+		if len(k.Values) > 0 {
+			for _, val := range k.Values {
+				if v == val {
+					return nil
+				}
+			}
+			return fmt.Errorf("Invalid value for %s: expected one of %s, got %q", k.Key, strings.Join(k.Values, ", "), v)
+		}
+	}
+	return nil
+}
+
+// What values a key takes, for people.
+func (k *ConfigKey) Allowed() string {
+	if len(k.Values) > 0 {
+		return strings.Join(k.Values, "|")
+	}
+	if k.Min != 0 || k.Max != 0 {
+		return fmt.Sprintf("%d-%d", k.Min, k.Max)
+	}
+	return ""
+}
+
+// A difference between a config and the defaults, or a problem with it.
+type ConfigDiff struct {
+	Key     string
+	Value   interface{}
+	Default interface{}
+	// The key is not in the schema
+	Unknown bool
+	// The value doesn't fit the key
+	Err error
+}
+
+// Compare a config (the contents of a config.json) with the defaults,
+// checking its values. Managed keys are left out, unless they are invalid.
+func (s ConfigSchema) Diff(config []byte) ([]*ConfigDiff, error) {
+	values := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, err
+	}
+
+	diffs := []*ConfigDiff{}
+	for _, k := range s {
+		v, ok := values[k.Key]
+		if !ok {
+			continue
+		}
+		d := &ConfigDiff{Key: k.Key, Value: v, Default: k.Default, Err: k.Check(v)}
+		if d.Err != nil || (!k.Managed && fmt.Sprint(v) != fmt.Sprint(k.Default)) {
+			diffs = append(diffs, d)
+		}
+	}
+
+	unknown := []string{}
+	for key := range values {
+		if _, ok := s.Lookup(key); !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		diffs = append(diffs, &ConfigDiff{Key: key, Value: values[key], Unknown: true})
+	}
+	return diffs, nil
+}
+
+func article(s string) string {
+	if strings.IndexAny(s[:1], "aeiou") == 0 {
+		return "an"
+	}
+	return "a"
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
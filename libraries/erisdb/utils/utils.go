@@ -42,6 +42,7 @@ var (
 	Scratch     = path.Join(Decerver, "scratch")
 	HEAD        = path.Join(Blockchains, "HEAD")
 	Refs        = path.Join(Blockchains, "refs")
+	Workspaces  = path.Join(Blockchains, "workspaces") // every workspace file in use
 	Epm         = path.Join(Scratch, "epm")
 	Lllc        = path.Join(Scratch, "lllc")
 	Keys        = path.Join(Decerver, "keys") // temporary solution to an age old problem